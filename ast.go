@@ -8,7 +8,7 @@ import (
 	"go/token"
 	"go/types"
 	"log"
-	"math/big"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -156,6 +156,15 @@ retry:
 	return nil
 }
 
+// TypeExpr returns the ast type expression of typ in the context of pkg. It
+// imports packages of named types referenced by typ into the current file of
+// pkg. Names of imported packages are decided when pkg is written (eg. by
+// WriteTo or ASTFile), so qualifiers of the returned expression are correct
+// only after that.
+func TypeExpr(pkg *Package, typ types.Type) ast.Expr {
+	return toType(pkg, typ)
+}
+
 func toBasicType(pkg *Package, t *types.Basic) ast.Expr {
 	if t.Kind() == types.UnsafePointer {
 		return toObjectExpr(pkg, pkg.Import("unsafe").Ref("Pointer"))
@@ -220,6 +229,9 @@ func toMapType(pkg *Package, t *types.Map) ast.Expr {
 
 func toInterface(pkg *Package, t *types.Interface) ast.Expr {
 	var flds []*ast.Field
+	for i, n := 0, t.NumEmbeddeds(); i < n; i++ { // embedded interfaces first, eg. interface{io.Reader; M()}
+		flds = append(flds, &ast.Field{Type: toType(pkg, t.EmbeddedType(i))})
+	}
 	for i, n := 0, t.NumExplicitMethods(); i < n; i++ {
 		fn := t.ExplicitMethod(i)
 		name := ident(fn.Name())
@@ -227,9 +239,6 @@ func toInterface(pkg *Package, t *types.Interface) ast.Expr {
 		fld := &ast.Field{Names: []*ast.Ident{name}, Type: typ}
 		flds = append(flds, fld)
 	}
-	for i, n := 0, t.NumEmbeddeds(); i < n; i++ {
-		panic("TODO: interface embedded")
-	}
 	return &ast.InterfaceType{Methods: &ast.FieldList{List: flds}}
 }
 
@@ -238,30 +247,34 @@ func toInterface(pkg *Package, t *types.Interface) ast.Expr {
 
 func toExpr(pkg *Package, val interface{}, src ast.Node) *internal.Elem {
 	if val == nil {
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  identNil,
 			Type: types.Typ[types.UntypedNil],
 			Src:  src,
-		}
+		})
 	}
 	switch v := val.(type) {
 	case *ast.BasicLit:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  v,
 			Type: types.Typ[toBasicKind(v.Kind)],
 			CVal: constant.MakeFromLiteral(v.Value, v.Kind, 0),
 			Src:  src,
-		}
+		})
 	case *types.Builtin:
-		if o := pkg.builtin.Scope().Lookup(v.Name()); o != nil {
+		if at := v.Pkg(); at != nil && at.Path() == "unsafe" {
+			if o := unsafeBuiltin(pkg, v.Name()); o != nil {
+				return toObject(pkg, o, src)
+			}
+		} else if o := pkg.builtin.Scope().Lookup(v.Name()); o != nil {
 			return toObject(pkg, o, src)
 		}
 		log.Panicln("TODO: unsupported builtin -", v.Name())
 	case *types.TypeName:
 		if typ := v.Type(); isType(typ) {
-			return &internal.Elem{
+			return pkg.newElem(internal.Elem{
 				Val: toType(pkg, typ), Type: NewTypeType(typ), Src: src,
-			}
+			})
 		} else {
 			return toObject(pkg, v, src)
 		}
@@ -270,40 +283,40 @@ func toExpr(pkg *Package, val interface{}, src ast.Node) *internal.Elem {
 	case *Element:
 		return v
 	case int:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(v)},
 			Type: types.Typ[types.UntypedInt],
 			CVal: constant.MakeInt64(int64(v)),
 			Src:  src,
-		}
+		})
 	case string:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(v)},
 			Type: types.Typ[types.UntypedString],
 			CVal: constant.MakeString(v),
 			Src:  src,
-		}
+		})
 	case bool:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  boolean(v),
 			Type: types.Typ[types.UntypedBool],
 			CVal: constant.MakeBool(v),
 			Src:  src,
-		}
+		})
 	case rune:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  &ast.BasicLit{Kind: token.CHAR, Value: strconv.QuoteRune(v)},
 			Type: types.Typ[types.UntypedRune],
 			CVal: constant.MakeInt64(int64(v)),
 			Src:  src,
-		}
+		})
 	case float64:
-		return &internal.Elem{
+		return pkg.newElem(internal.Elem{
 			Val:  &ast.BasicLit{Kind: token.FLOAT, Value: strconv.FormatFloat(v, 'g', -1, 64)},
 			Type: types.Typ[types.UntypedFloat],
 			CVal: constant.MakeFloat64(v),
 			Src:  src,
-		}
+		})
 	}
 	panic("unexpected: unsupport value type")
 }
@@ -323,9 +336,13 @@ var (
 )
 
 func toObject(pkg *Package, v types.Object, src ast.Node) *internal.Elem {
-	return &internal.Elem{
-		Val: toObjectExpr(pkg, v), Type: realType(v.Type()), Src: src,
+	var cval constant.Value
+	if c, ok := v.(*types.Const); ok {
+		cval = c.Val()
 	}
+	return pkg.newElem(internal.Elem{
+		Val: toObjectExpr(pkg, v), Type: realType(v.Type()), CVal: cval, Src: src,
+	})
 }
 
 func toObjectExpr(pkg *Package, v types.Object) ast.Expr {
@@ -333,7 +350,7 @@ func toObjectExpr(pkg *Package, v types.Object) ast.Expr {
 	if atPkg == nil || atPkg == pkg.Types { // at universe or at this package
 		return ident(name)
 	}
-	if atPkg == pkg.builtin { // at builtin package
+	if pkg.isBuiltin(atPkg) { // at builtin package
 		if strings.HasPrefix(name, pkg.prefix) {
 			opName := name[len(pkg.prefix):]
 			if op, ok := nameToOps[opName]; ok {
@@ -347,8 +364,13 @@ func toObjectExpr(pkg *Package, v types.Object) ast.Expr {
 		}
 		return ident(name)
 	}
+	if at := atPkg.Path(); at != "" && at == pkg.Types.Path() { // at this package, loaded as a dependency
+		return ident(name)
+	}
 	importPkg := pkg.Import(atPkg.Path())
-	importPkg.EnsureImported()
+	if importPkg.Types == nil { // not loaded: import atPkg itself, so objects of it are kept identical
+		importPkg.adopt(atPkg)
+	}
 	x := ident(atPkg.Name())
 	importPkg.nameRefs = append(importPkg.nameRefs, x)
 	return &ast.SelectorExpr{
@@ -396,13 +418,57 @@ var (
 )
 
 func toFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFuncCall bool, flags InstrFlags) *internal.Elem {
+	if pkg.cb.tolerant && hasInvalid(fn, args) { // don't report errors caused by an invalid expression again
+		return toInvalidCall(fn, args, flags)
+	}
 	ret, err := matchFuncCall(pkg, fn, args, VarFuncCall, flags)
 	if err != nil {
-		panic(err)
+		pkg.cb.handleTypeErr(err)
+		return toInvalidCall(fn, args, flags)
 	}
 	return ret
 }
 
+func hasInvalid(fn *internal.Elem, args []*internal.Elem) bool {
+	if fn.Type == types.Typ[types.Invalid] {
+		return true
+	}
+	for _, arg := range args {
+		if arg.Type == types.Typ[types.Invalid] {
+			return true
+		}
+	}
+	return false
+}
+
+// toInvalidCall returns an invalid expression (in tolerant mode) of a call,
+// which may be an operator of the builtin package.
+func toInvalidCall(fn *internal.Elem, args []*internal.Elem, flags InstrFlags) *internal.Elem {
+	var val ast.Expr
+	switch v := fn.Val.(type) {
+	case *ast.BinaryExpr:
+		if v.X == nil && len(args) == 2 {
+			val = &ast.BinaryExpr{X: args[0].Val, Op: v.Op, Y: args[1].Val}
+		}
+	case *ast.UnaryExpr:
+		if v.X == nil && len(args) == 1 {
+			val = &ast.UnaryExpr{Op: v.Op, X: args[0].Val}
+		}
+	}
+	if val == nil {
+		valArgs := make([]ast.Expr, len(args))
+		for i, arg := range args {
+			valArgs[i] = arg.Val
+		}
+		call := &ast.CallExpr{Fun: fn.Val, Args: valArgs}
+		if flags&InstrFlagEllipsis != 0 {
+			call.Ellipsis = 1
+		}
+		val = call
+	}
+	return &internal.Elem{Val: val, Type: types.Typ[types.Invalid]}
+}
+
 func unaryOp(tok token.Token, args []*internal.Elem) constant.Value {
 	if len(args) == 1 {
 		if a := args[0].CVal; a != nil {
@@ -454,8 +520,8 @@ var (
 		token.SHL:     binaryOpShift, // <<
 		token.SHR:     binaryOpShift, // >>
 
-		token.LAND: binaryOpCompare, // &&
-		token.LOR:  binaryOpCompare, // ||
+		token.LAND: 0, // &&
+		token.LOR:  0, // ||
 
 		token.LSS: binaryOpCompare,
 		token.LEQ: binaryOpCompare,
@@ -508,6 +574,11 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 	var it *instantiated
 	var sig *types.Signature
 	var cval constant.Value
+	if t, ok := fnType.(*types.Named); ok { // eg. context.CancelFunc
+		if sig, ok := getUnderlying(pkg, t).(*types.Signature); ok {
+			fnType = sig
+		}
+	}
 	switch t := fnType.(type) {
 	case *types.Signature:
 		if funcs, ok := CheckOverloadMethod(t); ok {
@@ -531,10 +602,10 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 		for i, v := range args { // TODO: type check
 			valArgs[i] = v.Val
 		}
-		ret = &internal.Elem{
+		ret = pkg.newElem(internal.Elem{
 			Val:  &ast.CallExpr{Fun: fn.Val, Args: valArgs, Ellipsis: flags & InstrFlagEllipsis},
 			Type: t.Type(),
-		}
+		})
 		return
 	case *TemplateSignature: // template function
 		sig, it = t.instantiate()
@@ -544,6 +615,11 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 			cval = binaryOp(t.tokFlag, args)
 		}
 	case *overloadFuncType:
+		if max := pkg.conf.maxOverloadFuncs(); len(t.funcs) > max {
+			src, pos := pkg.cb.loadExpr(fn.Src)
+			return nil, pkg.cb.newCodeError(
+				&pos, fmt.Sprintf("%s has too many overload funcs (%d > %d)", src, len(t.funcs), max))
+		}
 		backup := backupArgs(args)
 		for _, o := range t.funcs {
 			if ret, err = matchFuncCall(pkg, toObject(pkg, o, fn.Src), args, false, flags); err == nil {
@@ -557,8 +633,9 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 	default:
 		log.Panicln("TODO: call to non function -", t)
 	}
+	fnSrc := fn.Src // fn may be reused before the error message is formatted
 	at := func() string {
-		src, _ := pkg.cb.loadExpr(fn.Src)
+		src, _ := pkg.cb.loadExpr(fnSrc)
 		return "argument to " + src
 	}
 	if err = matchFuncType(pkg, args, (flags&InstrFlagEllipsis) != token.NoPos, sig, at); err != nil {
@@ -567,17 +644,17 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 	tyRet := toRetType(sig.Results(), it)
 	if cval != nil { // untyped bigint/bigrat
 		if ret, ok := untypeBig(pkg, cval, tyRet); ok {
-			pkg.files[pkg.testingFile].removedExprs = true
+			pkg.files[pkg.curFile].removedExprs = true
 			return ret, nil
 		}
 	}
 	switch t := fn.Val.(type) {
 	case *ast.BinaryExpr:
 		t.X, t.Y = args[0].Val, args[1].Val
-		return &internal.Elem{Val: t, Type: tyRet, CVal: cval}, nil
+		return pkg.newElem(internal.Elem{Val: t, Type: tyRet, CVal: cval}), nil
 	case *ast.UnaryExpr:
 		t.X = args[0].Val
-		return &internal.Elem{Val: t, Type: tyRet, CVal: cval}, nil
+		return pkg.newElem(internal.Elem{Val: t, Type: tyRet, CVal: cval}), nil
 	}
 
 	var argStartIndex int = getParam1st(sig)
@@ -591,10 +668,10 @@ func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, VarFu
 			valArgs[i-argStartIndex] = args[i].Val
 		}
 	}
-	return &internal.Elem{
+	return pkg.newElem(internal.Elem{
 		Type: tyRet, CVal: cval,
 		Val: &ast.CallExpr{Fun: fn.Val, Args: valArgs, Ellipsis: flags & InstrFlagEllipsis},
-	}, nil
+	}), nil
 }
 
 func backupArgs(args []*internal.Elem) []ast.Expr {
@@ -614,29 +691,9 @@ func restoreArgs(args []*internal.Elem, backup []ast.Expr) {
 func untypeBig(pkg *Package, cval constant.Value, tyRet types.Type) (*internal.Elem, bool) {
 	switch tyRet {
 	case pkg.utBigInt:
-		var val *big.Int
-		switch v := constant.Val(cval).(type) {
-		case int64:
-			val = big.NewInt(v)
-		case *big.Int:
-			val = v
-		default:
-			panic("unexpected constant")
-		}
-		return pkg.cb.UntypedBigInt(val).stk.Pop(), true
+		return pkg.cb.UntypedBigInt(toBigInt(cval)).stk.Pop(), true
 	case pkg.utBigRat:
-		var val *big.Rat
-		switch v := constant.Val(cval).(type) {
-		case int64:
-			val = big.NewRat(v, 1)
-		case *big.Rat:
-			val = v
-		case *big.Int:
-			val = new(big.Rat).SetInt(v)
-		default:
-			panic("unexpected constant")
-		}
-		return pkg.cb.UntypedBigRat(val).stk.Pop(), true
+		return pkg.cb.UntypedBigRat(toBigRat(cval)).stk.Pop(), true
 	case types.Typ[types.Bool]:
 		return &internal.Elem{
 			Val: boolean(constant.BoolVal(cval)), Type: tyRet, CVal: cval,
@@ -656,6 +713,15 @@ func toRetType(t *types.Tuple, it *instantiated) types.Type {
 
 func matchFuncType(
 	pkg *Package, args []*internal.Elem, ellipsis bool, sig *types.Signature, at interface{}) error {
+	if len(args) == 1 && !ellipsis {
+		if t, ok := args[0].Type.(*types.Tuple); ok { // f(g()), g returns multiple values
+			src := args[0].Src
+			args = make([]*internal.Elem, t.Len())
+			for i := range args {
+				args[i] = &internal.Elem{Type: t.At(i).Type(), Src: src}
+			}
+		}
+	}
 	n := len(args)
 	if sig.Variadic() {
 		if !ellipsis {
@@ -716,7 +782,7 @@ func checkFuncResults(pkg *Package, rets []*internal.Elem, results *types.Tuple,
 			for i := 0; i < need; i++ {
 				arg := &internal.Elem{Type: t.At(i).Type(), Src: src}
 				if err := matchType(pkg, arg, results.At(i).Type(), "return argument"); err != nil {
-					panic(err)
+					pkg.cb.handleTypeErr(err)
 				}
 			}
 			return
@@ -725,7 +791,7 @@ func checkFuncResults(pkg *Package, rets []*internal.Elem, results *types.Tuple,
 	if n == need {
 		for i := 0; i < need; i++ {
 			if err := matchType(pkg, rets[i], results.At(i).Type(), "return argument"); err != nil {
-				panic(err)
+				pkg.cb.handleTypeErr(err)
 			}
 		}
 		return
@@ -771,7 +837,7 @@ func matchElemType(pkg *Package, vals []*internal.Elem, elt types.Type, at inter
 func checkAssignType(pkg *Package, varRef types.Type, val *internal.Elem) {
 	if rt, ok := varRef.(*refType); ok {
 		if err := matchType(pkg, val, rt.typ, "assignment"); err != nil {
-			panic(err)
+			pkg.cb.handleTypeErr(err)
 		}
 	} else if varRef == nil { // underscore
 		// do nothing
@@ -785,7 +851,7 @@ func checkAssign(pkg *Package, ref *internal.Elem, val types.Type, at string) {
 		elem := &internal.Elem{Type: val}
 		if err := matchType(pkg, elem, rt.typ, at); err != nil {
 			src, pos := pkg.cb.loadExpr(ref.Src)
-			pkg.cb.panicCodeErrorf(
+			pkg.cb.typeErrorf(
 				&pos, "cannot assign type %v to %s (type %v) in %s", val, src, rt.typ, at)
 		}
 	} else if ref.Type == nil { // underscore
@@ -831,6 +897,9 @@ func matchType(pkg *Package, arg *internal.Elem, param types.Type, at interface{
 	if debugMatch {
 		log.Printf("==> MatchType %v, %v\n", arg.Type, param)
 	}
+	if arg.Type == types.Typ[types.Invalid] && pkg.cb.tolerant { // error is reported
+		return nil
+	}
 	switch t := param.(type) {
 	case *unboundType: // variable to bound type
 		if t2, ok := arg.Type.(*unboundType); ok {
@@ -871,12 +940,96 @@ func matchType(pkg *Package, arg *internal.Elem, param types.Type, at interface{
 		}
 	}
 	if AssignableConv(pkg, arg.Type, param, &arg.Val) {
-		return nil
+		return checkRepresentable(pkg, arg, param)
 	}
 	return &MatchError{
 		Src: arg.Src, Arg: arg.Type, Param: param, At: at, cb: &pkg.cb, fstmt: arg.Val == nil}
 }
 
+// checkRepresentable checks if an untyped constant arg can be implicitly
+// converted to typ without overflow or truncation.
+func checkRepresentable(pkg *Package, arg *internal.Elem, typ types.Type) error {
+	if arg.CVal == nil {
+		return nil
+	}
+	if v, ok := arg.Type.(*types.Basic); !ok || v.Info()&types.IsUntyped == 0 {
+		return nil
+	}
+	t, ok := typ.Underlying().(*types.Basic)
+	if !ok || t.Info()&types.IsUntyped != 0 {
+		return nil
+	}
+	var msg string
+	cval := arg.CVal
+	switch {
+	case t.Info()&types.IsInteger != 0:
+		if x := constant.ToInt(cval); x.Kind() != constant.Int {
+			msg = "truncated to integer"
+		} else if !intRepresentable(pkg, x, t.Kind()) {
+			msg = "overflows " + typ.String()
+		}
+	case t.Info()&types.IsFloat != 0:
+		if x := constant.ToFloat(cval); x.Kind() != constant.Float {
+			msg = "truncated to real"
+		} else if !floatRepresentable(x, t.Kind()) {
+			msg = "overflows " + typ.String()
+		}
+	case t.Info()&types.IsComplex != 0:
+		if x := constant.ToComplex(cval); x.Kind() != constant.Complex {
+			msg = "truncated to complex"
+		} else if !floatRepresentable(constant.Real(x), t.Kind()-types.Complex64+types.Float32) ||
+			!floatRepresentable(constant.Imag(x), t.Kind()-types.Complex64+types.Float32) {
+			msg = "overflows " + typ.String()
+		}
+	}
+	if msg == "" {
+		return nil
+	}
+	src, pos := pkg.cb.loadExpr(arg.Src)
+	if src == "" {
+		src = cval.String()
+	}
+	return pkg.cb.newCodeError(&pos, fmt.Sprintf("constant %s %s", src, msg))
+}
+
+func intRepresentable(pkg *Package, x constant.Value, kind types.BasicKind) bool {
+	var bits uint
+	var unsigned bool
+	switch kind {
+	case types.Int8, types.Uint8:
+		bits = 8
+	case types.Int16, types.Uint16:
+		bits = 16
+	case types.Int32, types.Uint32:
+		bits = 32
+	case types.Int, types.Uint, types.Uintptr: // depends on the target platform
+		bits = uint(8 * pkg.sizes.Sizeof(types.Typ[kind]))
+	default:
+		bits = 64
+	}
+	switch kind {
+	case types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64, types.Uintptr:
+		unsigned = true
+	}
+	one := constant.MakeInt64(1)
+	if unsigned {
+		max := constant.Shift(one, token.SHL, bits)
+		return constant.Sign(x) >= 0 && constant.Compare(x, token.LSS, max)
+	}
+	max := constant.Shift(one, token.SHL, bits-1)
+	min := constant.UnaryOp(token.SUB, max, 0)
+	return constant.Compare(x, token.GEQ, min) && constant.Compare(x, token.LSS, max)
+}
+
+func floatRepresentable(x constant.Value, kind types.BasicKind) bool {
+	if kind == types.Float32 {
+		f, _ := constant.Float32Val(x)
+		return !math.IsInf(float64(f), 0)
+	}
+	f, _ := constant.Float64Val(x)
+	return !math.IsInf(f, 0)
+}
+
 // -----------------------------------------------------------------------------
 
 func boundElementType(pkg *Package, elts []*internal.Elem, base, max, step int) types.Type {
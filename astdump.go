@@ -0,0 +1,235 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/token"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// ----------------------------------------------------------------------------
+
+// JSONPrinter is a Printer which dumps the AST of a file as JSON. Each node is
+// an object whose "Node" is the node type (eg. "CallExpr"), followed by its
+// non-empty fields:
+//
+//	{"Node":"CallExpr","Fun":{"Node":"Ident","Name":"f"},"Args":[...]}
+//
+// Positions, objects and scopes of nodes are omitted. Tokens are written as
+// strings, eg. "+".
+type JSONPrinter struct {
+	Indent string // indent of each level (no indent if it is empty)
+}
+
+// Print dumps f as JSON.
+func (p JSONPrinter) Print(dst io.Writer, f *ast.File) (err error) {
+	var b bytes.Buffer
+	writeJSON(&b, new(astDumper).dump(reflect.ValueOf(f)))
+	if p.Indent != "" {
+		var out bytes.Buffer
+		if err = json.Indent(&out, b.Bytes(), "", p.Indent); err != nil {
+			return
+		}
+		b = out
+	}
+	b.WriteByte('\n')
+	_, err = dst.Write(b.Bytes())
+	return
+}
+
+// SExprPrinter is a Printer which dumps the AST of a file as an s-expression
+// for debugging. Each node is written as `(Type field1 field2 ...)` without
+// empty fields, and lists are written as `[elem1 elem2 ...]`. Idents and basic
+// literals are written as they are:
+//
+//	(ExprStmt (CallExpr (SelectorExpr fmt Println) ["Hello"]))
+type SExprPrinter struct{}
+
+// Print dumps f as an s-expression.
+func (p SExprPrinter) Print(dst io.Writer, f *ast.File) error {
+	var b bytes.Buffer
+	writeSExpr(&b, new(astDumper).dump(reflect.ValueOf(f)))
+	b.WriteByte('\n')
+	_, err := dst.Write(b.Bytes())
+	return err
+}
+
+// ----------------------------------------------------------------------------
+
+// astNode is the dumped form of a node: values of fields are *astNode,
+// []interface{} or atoms (string, bool or int).
+type astNode struct {
+	typ    string
+	fields []astField
+}
+
+type astField struct {
+	name string
+	val  interface{}
+}
+
+var (
+	tyTokenPos = reflect.TypeOf(token.NoPos)
+	tyToken    = reflect.TypeOf(token.ILLEGAL)
+	tyASTObj   = reflect.TypeOf((*ast.Object)(nil))
+	tyASTScope = reflect.TypeOf((*ast.Scope)(nil))
+	tyASTFile  = reflect.TypeOf(ast.File{})
+)
+
+type astDumper struct {
+	onNode func(node ast.Node, dump *astNode) // called after a node is dumped
+}
+
+// dump dumps a node (or a list of nodes). It returns nil if v is empty.
+func (p *astDumper) dump(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		ret := p.dump(v.Elem())
+		if dump, ok := ret.(*astNode); ok && p.onNode != nil {
+			if node, ok := v.Interface().(ast.Node); ok {
+				p.onNode(node, dump)
+			}
+		}
+		return ret
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return p.dump(v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = p.dump(v.Index(i))
+		}
+		return list
+	case reflect.Struct:
+		t := v.Type()
+		node := &astNode{typ: t.Name()}
+		for i, n := 0, t.NumField(); i < n; i++ {
+			ft := t.Field(i)
+			switch ft.Type {
+			case tyTokenPos, tyASTObj, tyASTScope:
+				continue
+			}
+			if t == tyASTFile && (ft.Name == "Imports" || ft.Name == "Unresolved") { // duplicated
+				continue
+			}
+			if val := p.dump(v.Field(i)); val != nil {
+				node.fields = append(node.fields, astField{ft.Name, val})
+			}
+		}
+		return node
+	case reflect.String:
+		if s := v.String(); s != "" {
+			return s
+		}
+	case reflect.Bool:
+		if v.Bool() {
+			return true
+		}
+	case reflect.Int:
+		if v.Type() == tyToken {
+			if tok := token.Token(v.Int()); tok != token.ILLEGAL {
+				return tok.String()
+			}
+		} else if i := v.Int(); i != 0 {
+			return i
+		}
+	}
+	return nil
+}
+
+func writeJSON(b *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case *astNode:
+		sep := "{"
+		if v.typ != "" {
+			b.WriteString(`{"Node":` + strconv.Quote(v.typ))
+			sep = ","
+		}
+		for _, f := range v.fields {
+			b.WriteString(sep + `"` + f.name + `":`)
+			writeJSON(b, f.val)
+			sep = ","
+		}
+		if sep == "{" {
+			b.WriteByte('{')
+		}
+		b.WriteByte('}')
+	case []interface{}:
+		b.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, elem)
+		}
+		b.WriteByte(']')
+	case string:
+		data, _ := json.Marshal(v)
+		b.Write(data)
+	case bool:
+		b.WriteString("true")
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	default:
+		b.WriteString("null")
+	}
+}
+
+func writeSExpr(b *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case *astNode:
+		if (v.typ == "Ident" || v.typ == "BasicLit") && len(v.fields) > 0 {
+			writeSExpr(b, v.fields[len(v.fields)-1].val) // Name or Value
+			return
+		}
+		b.WriteString("(" + v.typ)
+		for _, f := range v.fields {
+			b.WriteByte(' ')
+			writeSExpr(b, f.val)
+		}
+		b.WriteByte(')')
+	case []interface{}:
+		b.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeSExpr(b, elem)
+		}
+		b.WriteByte(']')
+	case string:
+		b.WriteString(v)
+	case bool:
+		b.WriteString("true")
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	default:
+		b.WriteString("nil")
+	}
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,171 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox_test
+
+import (
+	"bytes"
+	"go/token"
+	"go/types"
+	"strconv"
+	"testing"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// newBigPackage generates a package of nfn functions, each of which has nstmt
+// statements of arithmetic expressions, calls and assignments.
+func newBigPackage(nfn, nstmt int) *gox.Package {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	fmt := pkg.Import("fmt")
+	for i := 0; i < nfn; i++ {
+		x := pkg.NewParam(token.NoPos, "x", tyInt)
+		ret := pkg.NewParam(token.NoPos, "", tyInt)
+		cb := pkg.NewFunc(nil, "f"+strconv.Itoa(i), types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg)
+		cb.NewVarStart(tyInt, "a", "b").Val(x).Val(1).BinaryOp(token.ADD).Val(x).Val(2).BinaryOp(token.MUL).EndInit(2)
+		a, b := ctxRef(pkg, "a"), ctxRef(pkg, "b")
+		for j := 0; j < nstmt; j++ {
+			cb.VarRef(a).Val(a).Val(b).BinaryOp(token.ADD).Val(j).BinaryOp(token.SUB).Assign(1).EndStmt()
+			cb.If().Val(a).Val(b).BinaryOp(token.GTR).Then().
+				Val(fmt.Ref("Println")).Val(a).Val("a > b").Call(2).EndStmt().
+				End()
+		}
+		cb.Val(a).Val(b).BinaryOp(token.ADD).Return(1).End()
+	}
+	return pkg
+}
+
+func benchmarkBuild(b *testing.B, write bool) {
+	benchmarkWorkload(b, write, func() *gox.Package {
+		return newBigPackage(20, 50)
+	})
+}
+
+// benchmarkWorkload builds (and writes if write is true) the package generated
+// by build b.N times. build is called once before the timer starts, so that
+// imported packages are loaded.
+func benchmarkWorkload(b *testing.B, write bool, build func() *gox.Package) {
+	gox.SetDebug(0) // don't benchmark logging
+	defer gox.SetDebug(gox.DbgFlagAll &^ gox.DbgFlagSetDebug) // keep output benchstat-friendly
+	build()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkg := build()
+		if write {
+			var buf bytes.Buffer
+			if err := gox.WriteTo(&buf, pkg, false); err != nil {
+				b.Fatal("WriteTo:", err)
+			}
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	benchmarkBuild(b, false)
+}
+
+func BenchmarkBuildAndWrite(b *testing.B) {
+	benchmarkBuild(b, true)
+}
+
+// ----------------------------------------------------------------------------
+
+// newDeepExprPackage generates `func f(x int) int` which returns an expression
+// of depth n: `x + 1*x - 2*x + ... `.
+func newDeepExprPackage(n int) *gox.Package {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	ret := pkg.NewParam(token.NoPos, "", tyInt)
+	cb := pkg.NewFunc(nil, "f", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg)
+	cb.Val(x)
+	for i := 1; i < n; i++ {
+		op := token.ADD
+		if i%2 == 0 {
+			op = token.SUB
+		}
+		cb.Val(i).Val(x).BinaryOp(token.MUL).BinaryOp(op)
+	}
+	cb.Return(1).End()
+	return pkg
+}
+
+var benchImports = [...][2]string{
+	{"bufio", "NewReader"}, {"bytes", "NewBuffer"}, {"container/list", "New"},
+	{"encoding/json", "Marshal"}, {"errors", "New"}, {"fmt", "Println"},
+	{"hash/crc32", "ChecksumIEEE"}, {"io", "Copy"}, {"math", "Sqrt"},
+	{"net/url", "Parse"}, {"os", "Exit"}, {"path", "Join"},
+	{"path/filepath", "Join"}, {"regexp", "MustCompile"}, {"sort", "Ints"},
+	{"strconv", "Itoa"}, {"strings", "ToUpper"}, {"text/tabwriter", "NewWriter"},
+	{"time", "Now"}, {"unicode", "IsSpace"},
+}
+
+// newManyImportsPackage generates nfn functions, each of which references an
+// object of every package in benchImports: `_ = strings.ToUpper`.
+func newManyImportsPackage(nfn int) *gox.Package {
+	pkg := newMainPackage()
+	refs := make([]types.Object, len(benchImports))
+	for i, imp := range benchImports {
+		refs[i] = pkg.Import(imp[0]).Ref(imp[1])
+	}
+	for i := 0; i < nfn; i++ {
+		cb := pkg.NewFunc(nil, "f"+strconv.Itoa(i), nil, nil, false).BodyStart(pkg)
+		for _, ref := range refs {
+			cb.VarRef(nil).Val(ref).Assign(1).EndStmt()
+		}
+		cb.End()
+	}
+	return pkg
+}
+
+// newBigSwitchPackage generates `func f(x int) string` of a switch statement
+// with n cases: `case i: return "i"`.
+func newBigSwitchPackage(n int) *gox.Package {
+	pkg := newMainPackage()
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	cb := pkg.NewFunc(nil, "f", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg)
+	cb.Switch().Val(x).Then()
+	for i := 0; i < n; i++ {
+		cb.Val(i).Case(1).Val(strconv.Itoa(i)).Return(1).End()
+	}
+	cb.Case(0).Val("").Return(1).End()
+	cb.End().Val("").Return(1).End()
+	return pkg
+}
+
+// BenchmarkWorkloads builds and writes packages of typical workloads. Results
+// of each workload are reported as a sub-benchmark, eg.
+// `BenchmarkWorkloads/funcs=1000`, so they can be compared by benchstat.
+func BenchmarkWorkloads(b *testing.B) {
+	workloads := []struct {
+		name  string
+		build func() *gox.Package
+	}{
+		{"funcs=1000", func() *gox.Package { return newBigPackage(1000, 2) }},
+		{"expr-depth=1000", func() *gox.Package { return newDeepExprPackage(1000) }},
+		{"imports=20", func() *gox.Package { return newManyImportsPackage(100) }},
+		{"switch-cases=1000", func() *gox.Package { return newBigSwitchPackage(1000) }},
+	}
+	for _, w := range workloads {
+		b.Run(w.name, func(b *testing.B) {
+			benchmarkWorkload(b, true, w.build)
+		})
+	}
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,178 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// ----------------------------------------------------------------------------
+//
+// An untyped bigint/bigrat constant (see CodeBuilder.UntypedBigInt) is a
+// placeholder expression until a file is generated. Constants are folded by
+// go/constant, so only constants which remain in the generated code (ie. they
+// reach a typed context, eg. `var a = 1r << 65`) are materialized by
+// Config.UntypedBigConst.
+
+// bigConstExpr is the placeholder expression of an untyped big constant.
+type bigConstExpr struct {
+	ast.BadExpr
+	cval constant.Value
+	typ  *types.Named // UntypedBigInt or UntypedBigRat
+}
+
+func (p *Package) newBigConst(cval constant.Value, typ *types.Named) ast.Expr {
+	p.bigConsts = true
+	return &bigConstExpr{cval: cval, typ: typ}
+}
+
+// materializeBigs replaces placeholders of untyped big constants in file f.
+func (p *Package) materializeBigs(f *file) {
+	if !p.bigConsts {
+		return
+	}
+	for i, at := range p.files {
+		if at == f {
+			old := p.curFile
+			p.curFile = i // imports required by constants are added to f
+			p.replaceBigs(reflect.ValueOf(f.decls))
+			p.curFile = old
+			return
+		}
+	}
+}
+
+var tyBigConstExpr = reflect.TypeOf((*bigConstExpr)(nil))
+
+func (p *Package) replaceBigs(val reflect.Value) {
+	switch val.Kind() {
+	case reflect.Slice:
+		for i, n := 0, val.Len(); i < n; i++ {
+			p.replaceBigs(val.Index(i))
+		}
+	case reflect.Ptr:
+		if !val.IsNil() && val.Type().Implements(tyAstNode) { // ast.Node
+			elem := val.Elem()
+			for i, n := 0, elem.NumField(); i < n; i++ {
+				p.replaceBigs(elem.Field(i))
+			}
+		}
+	case reflect.Interface:
+		if elem := val.Elem(); elem.IsValid() {
+			if elem.Type() == tyBigConstExpr {
+				v := elem.Interface().(*bigConstExpr)
+				val.Set(reflect.ValueOf(p.bigConstExpr(v.cval, v.typ)))
+				return
+			}
+			p.replaceBigs(elem)
+		}
+	}
+}
+
+func (p *Package) bigConstExpr(cval constant.Value, typ *types.Named) ast.Expr {
+	if fn := p.conf.UntypedBigConst; fn != nil {
+		return fn(p, cval, typ)
+	}
+	if typ == p.utBigRat {
+		return p.bigRatExpr(toBigRat(cval))
+	}
+	return p.bigIntExpr(toBigInt(cval))
+}
+
+func toBigInt(cval constant.Value) *big.Int {
+	switch v := constant.Val(cval).(type) {
+	case int64:
+		return big.NewInt(v)
+	case *big.Int:
+		return v
+	}
+	panic("unexpected constant")
+}
+
+func toBigRat(cval constant.Value) *big.Rat {
+	switch v := constant.Val(cval).(type) {
+	case int64:
+		return big.NewRat(v, 1)
+	case *big.Rat:
+		return v
+	case *big.Int:
+		return new(big.Rat).SetInt(v)
+	}
+	panic("unexpected constant")
+}
+
+// bigIntExpr returns `big.NewInt(v)`, or if v overflows int64:
+//
+//	func() *big.Int {
+//		v, _ := new(big.Int).SetString(strVal, 10)
+//		return v
+//	}()
+func (p *Package) bigIntExpr(v *big.Int) ast.Expr {
+	big := p.big()
+	if v.IsInt64() {
+		return &ast.CallExpr{Fun: toObjectExpr(p, big.Ref("NewInt")), Args: []ast.Expr{intLit(v.Int64())}}
+	}
+	typ := big.Ref("Int").Type()
+	newInt := &ast.CallExpr{Fun: ident("new"), Args: []ast.Expr{toType(p, typ)}}
+	identV := ident("v")
+	body := []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{identV, underscore},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{
+				Fun:  &ast.SelectorExpr{X: newInt, Sel: ident("SetString")},
+				Args: []ast.Expr{stringLit(v.String()), intLit(10)},
+			}},
+		},
+		&ast.ReturnStmt{Results: []ast.Expr{identV}},
+	}
+	fnType := &ast.FuncType{
+		Params:  &ast.FieldList{},
+		Results: &ast.FieldList{List: []*ast.Field{{Type: toType(p, types.NewPointer(typ))}}},
+	}
+	return &ast.CallExpr{Fun: &ast.FuncLit{Type: fnType, Body: &ast.BlockStmt{List: body}}}
+}
+
+// bigRatExpr returns `big.NewRat(a, b)`, or `new(big.Rat).SetFrac(a, b)` if a
+// or b overflows int64.
+func (p *Package) bigRatExpr(v *big.Rat) ast.Expr {
+	big := p.big()
+	a, b := v.Num(), v.Denom()
+	if a.IsInt64() && b.IsInt64() {
+		return &ast.CallExpr{
+			Fun: toObjectExpr(p, big.Ref("NewRat")), Args: []ast.Expr{intLit(a.Int64()), intLit(b.Int64())},
+		}
+	}
+	newRat := &ast.CallExpr{Fun: ident("new"), Args: []ast.Expr{toType(p, big.Ref("Rat").Type())}}
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: newRat, Sel: ident("SetFrac")},
+		Args: []ast.Expr{p.bigIntExpr(a), p.bigIntExpr(b)},
+	}
+}
+
+func intLit(v int64) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(v, 10)}
+}
+
+func stringLit(v string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(v)}
+}
+
+// ----------------------------------------------------------------------------
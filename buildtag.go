@@ -0,0 +1,165 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+
+// FuncVariants is a func declared in two variants guarded by a build tag. See
+// Package.NewFuncVariants.
+type FuncVariants struct {
+	On  *Func // the variant built with the tag, eg. `//go:build debug`
+	Off *Func // the variant built without the tag, eg. `//go:build !debug`
+}
+
+// NewFuncVariants declares a func in two variants, eg. debug and release
+// implementations of a generated function. The variants are written to two
+// tagged files, which are guarded by build constraints tag and !tag (see
+// WriteTaggedTo).
+//
+// Both variants share the same object in the package scope, so they always
+// have the same signature. Their bodies are built by On.BodyStart and
+// Off.BodyStart, and imports they require are added to their own files.
+func (p *Package) NewFuncVariants(
+	tag string, recv *Param, name string, params, results *Tuple, variadic bool) *FuncVariants {
+	if debugInstr {
+		log.Println("NewFuncVariants", tag, name)
+	}
+	old := p.curFile
+	defer func() { p.curFile = old }()
+	p.curFile = p.taggedFile(tag)
+	sig := types.NewSignature(recv, params, results, variadic)
+	on, err := p.NewFuncWith(token.NoPos, name, sig, nil)
+	if err != nil {
+		panic(err)
+	}
+	p.curFile = p.taggedFile("!" + tag)
+	off := p.newFuncDecl(on.Func)
+	return &FuncVariants{On: on, Off: off}
+}
+
+// taggedFile returns index of the file guarded by the build constraint tag,
+// which is created the first time it is required.
+func (p *Package) taggedFile(tag string) int {
+	for i := 2; i < len(p.files); i++ {
+		if p.files[i].tag == tag {
+			return i
+		}
+	}
+	p.files = append(p.files, &file{importPkgs: make(map[string]*PkgRef), tag: tag})
+	return len(p.files) - 1
+}
+
+// BuildTags returns build constraints of tagged files (eg. "debug" and
+// "!debug"), in the order they are created.
+func (p *Package) BuildTags() []string {
+	tags := make([]string, 0, len(p.files)-2)
+	for _, f := range p.files[2:] {
+		tags = append(tags, f.tag)
+	}
+	return tags
+}
+
+// ASTTaggedFile returns the in-progress ast.File guarded by the build
+// constraint tag (eg. "debug" or "!debug"). It returns nil if there is no such
+// file. See ASTFile for how the returned file can be modified.
+func (p *Package) ASTTaggedFile(tag string) *ast.File {
+	for _, f := range p.files[2:] {
+		if f.tag == tag {
+			return p.astFileOf(f)
+		}
+	}
+	return nil
+}
+
+// WriteTaggedTo writes the file of pkg guarded by the build constraint tag
+// (eg. "debug" or "!debug"). See WriteToWith for how the constraint is written.
+func WriteTaggedTo(dst io.Writer, pkg *Package, tag string, syntax ...Syntax) (err error) {
+	for _, f := range pkg.files[2:] {
+		if f.tag == tag {
+			printer := pkg.printer(syntax)
+			if err = pkg.writeHeader(dst, f, printer); err != nil {
+				return
+			}
+			return printer.Print(dst, pkg.astFile(f))
+		}
+	}
+	return fmt.Errorf("no file tagged %s", tag)
+}
+
+// WriteTaggedFile writes the file of pkg guarded by the build constraint tag
+// to file. See WriteTaggedTo.
+func WriteTaggedFile(file string, pkg *Package, tag string, syntax ...Syntax) (err error) {
+	if debugWriteFile {
+		log.Println("WriteTaggedFile", file, tag)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return WriteTaggedTo(f, pkg, tag, syntax...)
+}
+
+// SetBuildConstraint sets the build constraint of the normal (or testing) file,
+// eg. "linux && (amd64 || arm64)". See WriteToWith for how it is written.
+func (p *Package) SetBuildConstraint(testingFile bool, expr string) {
+	p.files[getInTestingFile(testingFile)].tag = expr
+}
+
+// writeHeader writes the header of a file above the package clause: the
+// generated code comment (see Config.GeneratedBy) and the build constraint of
+// the file. Nothing is written if printer doesn't write Go code.
+func (p *Package) writeHeader(dst io.Writer, f *file, printer Printer) (err error) {
+	switch printer.(type) {
+	case Syntax, *FormatOptions:
+	default:
+		return
+	}
+	var b bytes.Buffer
+	if gen := p.conf.GeneratedBy; gen != "" {
+		fmt.Fprintf(&b, "// Code generated by %s; DO NOT EDIT.\n\n", gen)
+	}
+	if f.tag != "" {
+		expr, err := constraint.Parse("//go:build " + f.tag)
+		if err != nil {
+			return err
+		}
+		lines, err := constraint.PlusBuildLines(expr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "//go:build %v\n", expr)
+		for _, line := range lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	_, err = dst.Write(b.Bytes())
+	return
+}
+
+// ----------------------------------------------------------------------------
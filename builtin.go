@@ -19,6 +19,7 @@ import (
 	"go/token"
 	"go/types"
 	"log"
+	"sync"
 	"syscall"
 )
 
@@ -34,6 +35,48 @@ func newBuiltinDefault(pkg PkgImporter, prefix string, conf *Config) *types.Pack
 	return builtin
 }
 
+type builtinKey struct {
+	prefix   string
+	utBigInt *types.Named
+	utBigRat *types.Named
+}
+
+// maxSharedBuiltins is the max number of builtin packages kept by
+// sharedBuiltin. Untyped bigint/bigrat types are compared by pointers, so
+// packages which load them (eg. once per request of a server) don't share
+// builtin packages, and the least recently used ones are evicted.
+const maxSharedBuiltins = 8
+
+var (
+	sharedBuiltins     = make(map[builtinKey]*types.Package)
+	sharedBuiltinKeys  []builtinKey // keys of sharedBuiltins, the least recently used first
+	sharedBuiltinMutex sync.Mutex
+)
+
+// sharedBuiltin returns the default builtin package shared by all packages
+// with the same prefix and untyped bigint/bigrat types. It is immutable after
+// it is created (see Package.Builtin and InsertBuiltin), so it is safe to be
+// used by packages in different goroutines.
+func sharedBuiltin(prefix string, conf *Config) *types.Package {
+	key := builtinKey{prefix, conf.UntypedBigInt, conf.UntypedBigRat}
+	sharedBuiltinMutex.Lock()
+	defer sharedBuiltinMutex.Unlock()
+	for i, k := range sharedBuiltinKeys {
+		if k == key {
+			sharedBuiltinKeys = append(append(sharedBuiltinKeys[:i:i], sharedBuiltinKeys[i+1:]...), key)
+			return sharedBuiltins[key]
+		}
+	}
+	builtin := newBuiltinDefault(nil, prefix, conf)
+	if len(sharedBuiltinKeys) == maxSharedBuiltins {
+		delete(sharedBuiltins, sharedBuiltinKeys[0])
+		sharedBuiltinKeys = sharedBuiltinKeys[1:]
+	}
+	sharedBuiltins[key] = builtin
+	sharedBuiltinKeys = append(sharedBuiltinKeys, key)
+	return builtin
+}
+
 // ----------------------------------------------------------------------------
 
 type typeTParam struct {
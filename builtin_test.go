@@ -15,6 +15,7 @@ package gox
 
 import (
 	"go/ast"
+	"go/parser"
 	"go/token"
 	"go/types"
 	"reflect"
@@ -93,7 +94,7 @@ func TestCheckOverloadMethod(t *testing.T) {
 func TestCheckUdt(t *testing.T) {
 	o := types.NewNamed(types.NewTypeName(token.NoPos, nil, "foo", nil), types.Typ[types.Int], nil)
 	var frs forRangeStmt
-	if _, ok := frs.checkUdt(o); ok {
+	if _, ok := frs.checkUdt(NewPackage("", "foo", nil), o); ok {
 		t.Fatal("findMethod failed: bar exists?")
 	}
 }
@@ -268,3 +269,70 @@ func TestToVariadic(t *testing.T) {
 }
 
 // ----------------------------------------------------------------------------
+
+func TestLoadDocs(t *testing.T) {
+	const src = `// Package foo is a test package.
+package foo
+
+// T is a type.
+type T struct {
+	// X is a field.
+	X, Y int
+}
+
+// M is a method.
+func (p *T) M() {}
+
+const (
+	// A is a constant.
+	A = 1
+	B = 2
+)
+
+// V is a variable.
+var V int
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "foo.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal("ParseFile:", err)
+	}
+	docs := loadDocs([]*ast.File{f})
+	expected := map[string]string{
+		"":    "Package foo is a test package.\n",
+		"T":   "T is a type.\n",
+		"T.X": "X is a field.\n",
+		"T.Y": "X is a field.\n",
+		"T.M": "M is a method.\n",
+		"A":   "A is a constant.\n",
+		"V":   "V is a variable.\n",
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Fatal("loadDocs:", docs)
+	}
+}
+
+func TestIsEffectful(t *testing.T) {
+	cases := map[string]bool{
+		"x":             false,
+		"1 + x.y":       false,
+		"-(x)":          false,
+		"T{A: 1, B: x}": false,
+		"func() {}":     false,
+		"f()":           true,
+		"<-ch":          true,
+		"a[i]":          true,
+		"*p":            true,
+		"x.(T)":         true,
+		"T{A: f()}":     true,
+		"x + g().y":     true,
+	}
+	for src, effectful := range cases {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal("ParseExpr:", err)
+		}
+		if ret := isEffectful(expr); ret != effectful {
+			t.Fatal("isEffectful:", src, ret)
+		}
+	}
+}
@@ -14,6 +14,7 @@
 package gox
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/constant"
@@ -24,6 +25,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/goplus/gox/internal"
 	"github.com/goplus/gox/internal/go/printer"
@@ -48,7 +50,9 @@ type codeBlockCtx struct {
 	base  int
 	stmts []ast.Stmt
 	label *ast.LabeledStmt
-	flows int // flow flags
+	flows int           // flow flags
+	pos   token.Pos     // source position of the block statement
+	outer *codeBlockCtx // the enclosing block
 }
 
 const (
@@ -131,6 +135,14 @@ type CodeBuilder struct {
 	interp    NodeInterpreter
 	loadNamed LoadNamedFunc
 	handleErr func(err error)
+	errs      []error // errors recorded in tolerant mode
+	tolerant  bool
+	progress  *progress // nil if progress isn't reported
+	stmtPos   token.Pos // source position of next statement
+
+	offsetof int                               // number of unsafe.Offsetof calls being built
+	offsets  map[*ast.SelectorExpr]fieldOffset // fields selected by args of Offsetof calls
+	scopes   map[*types.Scope]*types.Scope     // scopes replaced by Rollback
 	closureParamInsts
 	commentOnce bool
 }
@@ -138,10 +150,16 @@ type CodeBuilder struct {
 func (p *CodeBuilder) init(pkg *Package) {
 	conf := pkg.conf
 	p.pkg = pkg
+	p.tolerant = conf.TolerantMode
 	p.handleErr = conf.HandleErr
 	if p.handleErr == nil {
-		p.handleErr = defaultHandleErr
+		if p.tolerant {
+			p.handleErr = p.recordErr
+		} else {
+			p.handleErr = defaultHandleErr
+		}
 	}
+	p.progress = newProgress(conf)
 	p.interp = conf.NodeInterpreter
 	if p.interp == nil {
 		p.interp = nodeInterp{}
@@ -163,6 +181,10 @@ func defaultHandleErr(err error) {
 	panic(err)
 }
 
+func (p *CodeBuilder) recordErr(err error) {
+	p.errs = append(p.errs, err)
+}
+
 type nodeInterp struct{}
 
 func (p nodeInterp) Position(pos token.Pos) (ret token.Position) {
@@ -232,11 +254,77 @@ func (p *CodeBuilder) panicCodePosErrorf(pos token.Pos, format string, args ...i
 	panic(p.newCodePosError(pos, fmt.Sprintf(format, args...)))
 }
 
+// handleTypeErr handles a type error: it panics by default, and is reported by
+// HandleErr in tolerant mode (see Config.TolerantMode).
+func (p *CodeBuilder) handleTypeErr(err error) {
+	if !p.tolerant {
+		panic(err)
+	}
+	p.handleErr(err)
+}
+
+func (p *CodeBuilder) typeErrorf(pos *token.Position, format string, args ...interface{}) {
+	p.handleTypeErr(p.newCodeError(pos, fmt.Sprintf(format, args...)))
+}
+
+// Errors returns errors recorded in tolerant mode (see Config.TolerantMode).
+func (p *CodeBuilder) Errors() []error {
+	return p.errs
+}
+
 // Scope returns current scope.
 func (p *CodeBuilder) Scope() *types.Scope {
 	return p.current.scope
 }
 
+// Lookup looks up an object by name in the current scope and its parents (up to
+// the package scope), and then in the builtin package and the universe scope.
+// It returns the scope where the object is found, or (nil, nil) if not found.
+func (p *CodeBuilder) Lookup(name string) (scope *types.Scope, obj types.Object) {
+	for scope = p.current.scope; scope != nil && scope != types.Universe; scope = scope.Parent() {
+		if obj = scope.Lookup(name); obj != nil {
+			return
+		}
+	}
+	for _, scope = range [...]*types.Scope{p.pkg.ownBuiltin().Scope(), types.Universe} { // see Builtin
+		if obj = scope.Lookup(name); obj != nil {
+			return
+		}
+	}
+	return nil, nil
+}
+
+// VisibleObjects returns objects visible in the current scope, innermost scope
+// first. Objects shadowed by inner scopes are excluded. Objects of the builtin
+// package and the universe scope are included only if builtin is true, and
+// builtin operators (eg. Gop_Add) are excluded.
+func (p *CodeBuilder) VisibleObjects(builtin bool) []types.Object {
+	var objs []types.Object
+	names := make(map[string]bool)
+	add := func(scope *types.Scope) {
+		for _, name := range scope.Names() {
+			if !names[name] {
+				names[name] = true
+				objs = append(objs, scope.Lookup(name))
+			}
+		}
+	}
+	for scope := p.current.scope; scope != nil && scope != types.Universe; scope = scope.Parent() {
+		add(scope)
+	}
+	if builtin {
+		prefix := p.pkg.prefix
+		for _, name := range p.pkg.builtin.Scope().Names() {
+			if strings.HasPrefix(name, prefix) {
+				names[name] = true
+			}
+		}
+		add(p.pkg.builtin.Scope())
+		add(types.Universe)
+	}
+	return objs
+}
+
 // Func returns current func (nil means in global scope).
 func (p *CodeBuilder) Func() *Func {
 	return p.current.fn
@@ -276,9 +364,27 @@ func (p *CodeBuilder) endFuncBody(old funcBodyCtx) []ast.Stmt {
 	return stmts
 }
 
+// atPackageLevel calls decl to declare package-level funcs (eg. shims of a
+// generated type), even if a func body is open. The funcs are built in a new
+// package-level block, which is linked to the open block by outer (so elements
+// aren't reused when the funcs end, see Func.End), and state of the open body
+// (eg. comments of its next statement) is kept untouched.
+func (p *CodeBuilder) atPackageLevel(decl func()) {
+	top := &p.current.codeBlockCtx
+	for top.outer != nil {
+		top = top.outer
+	}
+	old, comments, once, pos, varDecl := p.current, p.comments, p.commentOnce, p.stmtPos, p.varDecl
+	p.current = funcBodyCtx{codeBlockCtx: codeBlockCtx{scope: top.scope, base: p.stk.Len(), outer: &old.codeBlockCtx}}
+	p.comments, p.commentOnce, p.stmtPos, p.varDecl = nil, false, token.NoPos, nil
+	decl()
+	p.current, p.comments, p.commentOnce, p.stmtPos, p.varDecl = old, comments, once, pos, varDecl
+}
+
 func (p *CodeBuilder) startBlockStmt(current codeBlock, comment string, old *codeBlockCtx) *CodeBuilder {
 	scope := types.NewScope(p.current.scope, token.NoPos, token.NoPos, comment)
-	p.current.codeBlockCtx, *old = codeBlockCtx{current, scope, p.stk.Len(), nil, nil, 0}, p.current.codeBlockCtx
+	p.current.codeBlockCtx, *old = codeBlockCtx{current, scope, p.stk.Len(), nil, nil, 0, p.stmtPos, old}, p.current.codeBlockCtx
+	p.stmtPos = token.NoPos
 	return p
 }
 
@@ -289,6 +395,7 @@ func (p *CodeBuilder) endBlockStmt(old codeBlockCtx) ([]ast.Stmt, int) {
 	}
 	stmts := p.current.stmts
 	p.stk.SetLen(p.current.base)
+	p.stmtPos = p.current.pos // the block statement is emitted next
 	p.current.codeBlockCtx = old
 	return stmts, flows
 }
@@ -328,6 +435,15 @@ func (p *CodeBuilder) commitStmt(idx int) {
 }
 
 func (p *CodeBuilder) emitStmt(stmt ast.Stmt) {
+	if p.pkg.temps != nil {
+		p.trackTemps(stmt)
+	}
+	if p.stmtPos != token.NoPos {
+		if p.pkg.conf.LineDirectives {
+			stmt = p.lineDirective(stmt)
+		}
+		p.stmtPos = token.NoPos
+	}
 	if p.comments != nil {
 		stmt = &printer.CommentedStmt{Comments: p.comments, Stmt: stmt}
 		if p.commentOnce {
@@ -339,6 +455,9 @@ func (p *CodeBuilder) emitStmt(stmt ast.Stmt) {
 		stmt, p.current.label = p.current.label, nil
 	}
 	p.current.stmts = append(p.current.stmts, stmt)
+	if p.progress != nil {
+		p.progress.emitted()
+	}
 }
 
 func (p *CodeBuilder) startInitExpr(current codeBlock) (old codeBlock) {
@@ -366,6 +485,61 @@ func (p *CodeBuilder) SetComments(comments *ast.CommentGroup, once bool) *CodeBu
 	return p
 }
 
+// StmtPos returns the source position of next statement.
+func (p *CodeBuilder) StmtPos() token.Pos {
+	return p.stmtPos
+}
+
+// SetStmtPos sets the source position of next statement. If it is set before
+// a block statement (eg. If or For) starts, it is the position of the block
+// statement. See Config.LineDirectives for how it is used.
+func (p *CodeBuilder) SetStmtPos(pos token.Pos) *CodeBuilder {
+	p.stmtPos = pos
+	return p
+}
+
+// BlankLine emits an empty statement, which is printed as a blank line to
+// separate statements around it, eg. to keep blank lines of the source.
+func (p *CodeBuilder) BlankLine() *CodeBuilder {
+	if debugInstr {
+		log.Println("BlankLine")
+	}
+	p.current.stmts = append(p.current.stmts, printer.BlankLine)
+	return p
+}
+
+// BlankLineBetween emits a blank line (see BlankLine) if there are blank lines
+// between end and next in the source, eg. the end of the previous statement and
+// the start of the next one, so that the generated code keeps the structure of
+// the source.
+func (p *CodeBuilder) BlankLineBetween(end, next token.Pos) *CodeBuilder {
+	if hasBlankLine(p.position(end), p.position(next)) {
+		p.BlankLine()
+	}
+	return p
+}
+
+// hasBlankLine reports whether the source line of next is more than one line
+// after the source line of end.
+func hasBlankLine(end, next token.Position) bool {
+	return end.IsValid() && next.IsValid() && end.Filename == next.Filename && next.Line > end.Line+1
+}
+
+// lineDirective returns stmt with a `/*line file:line:col*/` directive of the
+// source position of next statement. A directive of this form applies to the
+// position right after it, so it can be indented (unlike `//line`).
+func (p *CodeBuilder) lineDirective(stmt ast.Stmt) ast.Stmt {
+	pos := p.position(p.stmtPos)
+	if !pos.IsValid() {
+		return stmt
+	}
+	text := fmt.Sprintf("/*line %s:%d:%d*/", pos.Filename, pos.Line, pos.Column)
+	if pos.Column == 0 {
+		text = fmt.Sprintf("/*line %s:%d*/", pos.Filename, pos.Line)
+	}
+	return &printer.LineStmt{Directive: text, Stmt: stmt}
+}
+
 // ReturnErr func
 func (p *CodeBuilder) ReturnErr(outer bool) *CodeBuilder {
 	if debugInstr {
@@ -439,7 +613,7 @@ func (p *CodeBuilder) Call(n int, ellipsis ...bool) *CodeBuilder {
 }
 
 // CallWith func
-func (p *CodeBuilder) CallWith(n int, ellipsis bool,  VarFuncCall bool,  src ...ast.Node) *CodeBuilder {
+func (p *CodeBuilder) CallWith(n int, ellipsis bool, VarFuncCall bool, src ...ast.Node) *CodeBuilder {
 	args := p.stk.GetArgs(n)
 	n++
 	fn := p.stk.Get(-n)
@@ -456,6 +630,33 @@ func (p *CodeBuilder) CallWith(n int, ellipsis bool,  VarFuncCall bool,  src ...
 	return p
 }
 
+// MultiLine requests the call or composite literal on the top of the stack to be
+// printed one arg (or element) per line with a terminating comma, eg. to make
+// generated configuration code readable:
+//
+//	cfg := Config{
+//		Name: "foo",
+//		Size: 10,
+//	}
+func (p *CodeBuilder) MultiLine() *CodeBuilder {
+	if debugInstr {
+		log.Println("MultiLine")
+	}
+	x := p.stk.Get(-1).Val
+	if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.AND { // &T{...}
+		x = u.X
+	}
+	switch v := x.(type) {
+	case *ast.CallExpr:
+		v.Rparen = printer.MultiLine
+	case *ast.CompositeLit:
+		v.Rbrace = printer.MultiLine
+	default:
+		panic("TODO: MultiLine - not a call or composite literal")
+	}
+	return p
+}
+
 type closureParamInst struct {
 	inst  *Func
 	param *types.Var
@@ -642,6 +843,33 @@ func (p *CodeBuilder) NewAutoVar(pos token.Pos, name string, pv **types.Var) *Co
 	return p
 }
 
+// NewTempVar func: declares a temporary variable `var _gop_tmpN T` in the
+// current block and pushes a reference to it (as VarRef does), so it can be
+// assigned immediately. The name is unique in the current scope (including
+// outer scopes), so it never collides with or shadows names of users. The
+// variable is returned by pv.
+func (p *CodeBuilder) NewTempVar(typ types.Type, pv **types.Var) *CodeBuilder {
+	name := p.tempName()
+	if debugInstr {
+		log.Println("NewTempVar", name, typ)
+	}
+	p.NewVar(typ, name)
+	*pv = p.current.scope.Lookup(name).(*types.Var)
+	p.newTemp(*pv)
+	return p.VarRef(*pv)
+}
+
+func (p *CodeBuilder) tempName() string {
+	for {
+		p.pkg.tmpIdx++
+		name := "_gop_tmp" + strconv.Itoa(p.pkg.tmpIdx)
+		if _, o := p.current.scope.LookupParent(name, token.NoPos); o == nil {
+			p.pkg.tempNameUsed(name)
+			return name
+		}
+	}
+}
+
 // VarRef func: p.VarRef(nil) means underscore (_)
 func (p *CodeBuilder) VarRef(ref interface{}, src ...ast.Node) *CodeBuilder {
 	return p.doVarRef(ref, getSrc(src), true)
@@ -796,12 +1024,16 @@ func (p *CodeBuilder) MapLit(typ types.Type, arity int) *CodeBuilder {
 		if check {
 			if !AssignableTo(pkg, args[i].Type, key) {
 				src, pos := p.loadExpr(args[i].Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in map key", src, args[i].Type, key)
 			} else if !AssignableTo(pkg, args[i+1].Type, val) {
 				src, pos := p.loadExpr(args[i+1].Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in map value", src, args[i+1].Type, val)
+			} else if err := checkRepresentable(pkg, args[i], key); err != nil {
+				p.handleTypeErr(err)
+			} else if err := checkRepresentable(pkg, args[i+1], val); err != nil {
+				p.handleTypeErr(err)
 			}
 		}
 	}
@@ -886,8 +1118,10 @@ func (p *CodeBuilder) SliceLit(typ types.Type, arity int, keyVal ...bool) *CodeB
 		for i := 0; i < arity; i += 2 {
 			if !AssignableTo(pkg, args[i+1].Type, val) {
 				src, pos := p.loadExpr(args[i+1].Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in slice literal", src, args[i+1].Type, val)
+			} else if err := checkRepresentable(pkg, args[i+1], val); err != nil {
+				p.handleTypeErr(err)
 			}
 			elts[i>>1] = p.indexElemExpr(args, i)
 		}
@@ -918,8 +1152,10 @@ func (p *CodeBuilder) SliceLit(typ types.Type, arity int, keyVal ...bool) *CodeB
 			if check {
 				if !AssignableTo(pkg, arg.Type, val) {
 					src, pos := p.loadExpr(arg.Src)
-					p.panicCodeErrorf(
+					p.typeErrorf(
 						&pos, "cannot use %s (type %v) as type %v in slice literal", src, arg.Type, val)
+				} else if err := checkRepresentable(pkg, arg, val); err != nil {
+					p.handleTypeErr(err)
 				}
 			}
 		}
@@ -964,8 +1200,10 @@ func (p *CodeBuilder) ArrayLit(typ types.Type, arity int, keyVal ...bool) *CodeB
 		for i := 0; i < arity; i += 2 {
 			if !AssignableTo(pkg, args[i+1].Type, val) {
 				src, pos := p.loadExpr(args[i+1].Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in array literal", src, args[i+1].Type, val)
+			} else if err := checkRepresentable(pkg, args[i+1], val); err != nil {
+				p.handleTypeErr(err)
 			}
 			elts[i>>1] = p.indexElemExpr(args, i)
 		}
@@ -984,8 +1222,10 @@ func (p *CodeBuilder) ArrayLit(typ types.Type, arity int, keyVal ...bool) *CodeB
 			elts[i] = arg.Val
 			if !AssignableTo(pkg, arg.Type, val) {
 				src, pos := p.loadExpr(arg.Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in array literal", src, arg.Type, val)
+			} else if err := checkRepresentable(pkg, arg, val); err != nil {
+				p.handleTypeErr(err)
 			}
 		}
 	}
@@ -1028,9 +1268,11 @@ func (p *CodeBuilder) StructLit(typ types.Type, arity int, keyVal bool) *CodeBui
 			eltTy, eltName := elt.Type(), elt.Name()
 			if !AssignableTo(pkg, args[i+1].Type, eltTy) {
 				src, pos := p.loadExpr(args[i+1].Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in value of field %s",
 					src, args[i+1].Type, eltTy, eltName)
+			} else if err := checkRepresentable(pkg, args[i+1], eltTy); err != nil {
+				p.handleTypeErr(err)
 			}
 			elts[i>>1] = &ast.KeyValueExpr{Key: ident(eltName), Value: args[i+1].Val}
 		}
@@ -1050,9 +1292,11 @@ func (p *CodeBuilder) StructLit(typ types.Type, arity int, keyVal bool) *CodeBui
 			eltTy := t.Field(i).Type()
 			if !AssignableTo(pkg, arg.Type, eltTy) {
 				src, pos := p.loadExpr(arg.Src)
-				p.panicCodeErrorf(
+				p.typeErrorf(
 					&pos, "cannot use %s (type %v) as type %v in value of field %s",
 					src, arg.Type, eltTy, t.Field(i).Name())
+			} else if err := checkRepresentable(pkg, arg, eltTy); err != nil {
+				p.handleTypeErr(err)
 			}
 		}
 	}
@@ -1060,7 +1304,8 @@ func (p *CodeBuilder) StructLit(typ types.Type, arity int, keyVal bool) *CodeBui
 	return p
 }
 
-// Slice func
+// Slice func: if a is of a type with a Gop_Slice method, a[lo:hi] is lowered to
+// `a.Gop_Slice(lo, hi)`, where a missing lo is 0 and a missing hi is -1.
 func (p *CodeBuilder) Slice(slice3 bool, src ...ast.Node) *CodeBuilder { // a[i:j:k]
 	if debugInstr {
 		log.Println("Slice", slice3)
@@ -1073,6 +1318,22 @@ func (p *CodeBuilder) Slice(slice3 bool, src ...ast.Node) *CodeBuilder { // a[i:
 	args := p.stk.GetArgs(n)
 	x := args[0]
 	typ := x.Type
+	if op := p.pkg.opMethod(OpSlice, typ, nil, false); op != nil {
+		if slice3 {
+			code, pos := p.loadExpr(srcExpr)
+			p.panicCodeErrorf(&pos, "invalid operation %s (3-index slice of %v)", code, typ)
+		}
+		if args[1].Val == nil { // a[:hi] => a.Gop_Slice(0, hi)
+			args[1] = toExpr(p.pkg, 0, nil)
+		}
+		if args[2].Val == nil { // a[lo:] => a.Gop_Slice(lo, -1)
+			args[2] = toExpr(p.pkg, -1, nil)
+		}
+		ret := callOpMethod(p.pkg, op, args[:3])
+		ret.Src = srcExpr
+		p.stk.Ret(n, ret)
+		return p
+	}
 	switch t := typ.(type) {
 	case *types.Slice:
 		// nothing to do
@@ -1111,7 +1372,8 @@ func (p *CodeBuilder) Slice(slice3 bool, src ...ast.Node) *CodeBuilder { // a[i:
 	return p
 }
 
-// Index func
+// Index func: if a is of a type with a Gop_Index method, a[i] is lowered to
+// `a.Gop_Index(i)`.
 func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Index", nidx, twoValue)
@@ -1121,6 +1383,16 @@ func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuild
 	}
 	args := p.stk.GetArgs(2)
 	srcExpr := getSrc(src)
+	if op := p.pkg.opMethod(OpIndex, args[0].Type, args[1].Type, false); op != nil {
+		if twoValue {
+			_, pos := p.loadExpr(srcExpr)
+			p.panicCodeError(&pos, "assignment mismatch: 2 variables but 1 values")
+		}
+		ret := callOpMethod(p.pkg, op, args)
+		ret.Src = srcExpr
+		p.stk.Ret(2, ret)
+		return p
+	}
 	typs, allowTwoValue := p.getIdxValTypes(args[0].Type, false, srcExpr)
 	var tyRet types.Type
 	if twoValue { // elem, ok = a[key]
@@ -1143,7 +1415,8 @@ func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuild
 	return p
 }
 
-// IndexRef func
+// IndexRef func: if a is of a type with a Gop_SetIndex method, the assignment
+// a[i] = v is lowered to `a.Gop_SetIndex(i, v)`.
 func (p *CodeBuilder) IndexRef(nidx int, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("IndexRef", nidx)
@@ -1157,7 +1430,14 @@ func (p *CodeBuilder) IndexRef(nidx int, src ...ast.Node) *CodeBuilder {
 		Val: &ast.IndexExpr{X: args[0].Val, Index: args[1].Val},
 		Src: getSrc(src),
 	}
-	if t, ok := typ.(*unboundType); ok {
+	if op := p.pkg.opMethod(OpSetIndex, typ, args[1].Type, false); op != nil {
+		sig := op.Type().(*types.Signature)
+		if sig.Params().Len() != 2 {
+			log.Panicln("TODO: invalid method -", op)
+		}
+		setIndex := []*internal.Elem{args[0], args[1]}
+		elemRef.Type = &refType{typ: sig.Params().At(1).Type(), setIndex: setIndex}
+	} else if t, ok := typ.(*unboundType); ok {
 		tyMapElem := &unboundMapElemType{key: args[1].Type, typ: t}
 		elemRef.Type = &refType{typ: tyMapElem}
 	} else {
@@ -1169,6 +1449,16 @@ func (p *CodeBuilder) IndexRef(nidx int, src ...ast.Node) *CodeBuilder {
 	return p
 }
 
+// callOpMethod calls the operator method op of args[0] with args[1:], eg.
+// `a.Gop_Index(i)`.
+func callOpMethod(pkg *Package, op types.Object, args []*internal.Elem) *internal.Elem {
+	fn := &internal.Elem{
+		Val:  &ast.SelectorExpr{X: args[0].Val, Sel: ident(op.Name())},
+		Type: realType(op.Type()),
+	}
+	return toFuncCall(pkg, fn, args, false, 0)
+}
+
 func (p *CodeBuilder) getIdxValTypes(typ types.Type, ref bool, idxSrc ast.Node) ([]types.Type, bool) {
 	switch t := typ.(type) {
 	case *types.Slice:
@@ -1211,51 +1501,25 @@ func (p *CodeBuilder) Typ(typ types.Type) *CodeBuilder {
 	return p
 }
 
-// UntypedBigInt func
+// UntypedBigInt func: it pushes an untyped bigint constant, which is folded by
+// go/constant with other constants, and is materialized (see
+// Config.UntypedBigConst) only if it remains in the generated code.
 func (p *CodeBuilder) UntypedBigInt(v *big.Int, src ...ast.Node) *CodeBuilder {
 	pkg := p.pkg
-	big := pkg.big()
-	if v.IsInt64() {
-		val := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(v.Int64(), 10)}
-		p.Val(big.Ref("NewInt")).Val(val).Call(1)
-	} else {
-		/*
-			func() *typ {
-				v, _ := new(typ).SetString(strVal, 10)
-				return v
-			}()
-		*/
-		typ := big.Ref("Int").Type()
-		retTyp := types.NewPointer(typ)
-		ret := pkg.NewParam(token.NoPos, "", retTyp)
-		p.NewClosure(nil, types.NewTuple(ret), false).BodyStart(pkg).
-			DefineVarStart(token.NoPos, "v", "_").
-			Val(pkg.builtin.Scope().Lookup("new")).Typ(typ).Call(1).
-			MemberVal("SetString").Val(v.String()).Val(10).Call(2).EndInit(1).
-			Val(p.Scope().Lookup("v")).Return(1).
-			End().Call(0)
-	}
-	ret := p.stk.Get(-1)
-	ret.Type, ret.CVal, ret.Src = pkg.utBigInt, constant.Make(v), getSrc(src)
-	return p
-}
-
-// UntypedBigRat func
+	cval := constant.Make(v)
+	p.stk.Push(&internal.Elem{
+		Val: pkg.newBigConst(cval, pkg.utBigInt), Type: pkg.utBigInt, CVal: cval, Src: getSrc(src),
+	})
+	return p
+}
+
+// UntypedBigRat func: see UntypedBigInt.
 func (p *CodeBuilder) UntypedBigRat(v *big.Rat, src ...ast.Node) *CodeBuilder {
 	pkg := p.pkg
-	big := pkg.big()
-	a, b := v.Num(), v.Denom()
-	if a.IsInt64() && b.IsInt64() {
-		va := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(a.Int64(), 10)}
-		vb := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(b.Int64(), 10)}
-		p.Val(big.Ref("NewRat")).Val(va).Val(vb).Call(2)
-	} else {
-		// new(big.Rat).SetFrac(a, b)
-		p.Val(p.pkg.builtin.Scope().Lookup("new")).Typ(big.Ref("Rat").Type()).Call(1).
-			MemberVal("SetFrac").UntypedBigInt(a).UntypedBigInt(b).Call(2)
-	}
-	ret := p.stk.Get(-1)
-	ret.Type, ret.CVal, ret.Src = pkg.utBigRat, constant.Make(v), getSrc(src)
+	cval := constant.Make(v)
+	p.stk.Push(&internal.Elem{
+		Val: pkg.newBigConst(cval, pkg.utBigRat), Type: pkg.utBigRat, CVal: cval, Src: getSrc(src),
+	})
 	return p
 }
 
@@ -1380,6 +1644,13 @@ func (p *CodeBuilder) fieldRef(x ast.Expr, struc *types.Struct, name string) boo
 		})
 		return true
 	}
+	for i, n := 0, struc.NumFields(); i < n; i++ { // promoted fields
+		if fld := struc.Field(i); fld.Embedded() {
+			if p.refMember(fld.Type(), name, x) == MemberField {
+				return true
+			}
+		}
+	}
 	return false
 }
 
@@ -1450,10 +1721,16 @@ func (p *CodeBuilder) findMember(typ types.Type, name string, argVal ast.Expr, s
 			if p.method(t, name, argVal, false, srcExpr) {
 				return MemberMethod
 			}
-			if struc, ok := u.(*types.Struct); ok {
-				if kind := p.field(struc, name, argVal, srcExpr); kind != 0 {
+			switch tu := u.(type) {
+			case *types.Struct:
+				if kind := p.field(tu, name, argVal, srcExpr); kind != 0 {
 					return kind
 				}
+			case *types.Interface: // pointer to interface: (*x).name
+				tu.Complete()
+				if p.method(tu, name, derefExpr(argVal), false, srcExpr) {
+					return MemberMethod
+				}
 			}
 		case *types.Struct:
 			if kind := p.field(t, name, argVal, srcExpr); kind != 0 {
@@ -1477,7 +1754,13 @@ func (p *CodeBuilder) findMember(typ types.Type, name string, argVal ast.Expr, s
 			}
 		}
 	case *TypeType:
-		if named_type, ok := o.Type().(*types.Named); ok {
+		if t, ok := o.Type().(*types.Pointer); ok { // method expression: (*T).name
+			if named, ok := t.Elem().(*types.Named); ok {
+				if p.method(named, name, &ast.ParenExpr{X: argVal}, true, srcExpr) {
+					return MemberMethod
+				}
+			}
+		} else if named_type, ok := o.Type().(*types.Named); ok {
 			u := p.getUnderlying(named_type)
 			switch t := u.(type) {
 			case *types.Struct:
@@ -1513,6 +1796,17 @@ func (p *CodeBuilder) method(o methodList, name string, argVal ast.Expr, needRec
 	for i, n := 0, o.NumMethods(); i < n; i++ {
 		method := o.Method(i)
 		if method.Name() == name {
+			if _, ok := o.(*types.Named); ok && hasPtrRecv(method) {
+				switch v := argVal.(type) {
+				case *ast.CompositeLit: // T{...}.name => (&T{...}).name
+					argVal = &ast.ParenExpr{X: &ast.UnaryExpr{Op: token.AND, X: v}}
+				case *ast.ParenExpr: // (*T).name
+				default:
+					if needRecv { // T.name => (*T).name
+						argVal = derefExpr(v)
+					}
+				}
+			}
 			p.stk.Ret(1, &internal.Elem{
 				Val:  &ast.SelectorExpr{X: argVal, Sel: ident(name)},
 				Type: methodTypeOf(method.Type(), needRecv),
@@ -1528,14 +1822,23 @@ func (p *CodeBuilder) field(o *types.Struct, name string, argVal ast.Expr, src a
 	for i, n := 0, o.NumFields(); i < n; i++ {
 		fld := o.Field(i)
 		if fld.Name() == name {
+			sel := &ast.SelectorExpr{X: argVal, Sel: ident(name)}
 			p.stk.Ret(1, &internal.Elem{
-				Val:  &ast.SelectorExpr{X: argVal, Sel: ident(name)},
+				Val:  sel,
 				Type: fld.Type(),
 				Src:  src,
 			})
+			if p.offsetof > 0 {
+				p.recordOffset(sel, o, i, false)
+			}
 			return MemberField
 		} else if fld.Embedded() {
 			if kind := p.findMember(fld.Type(), name, argVal, src); kind != 0 {
+				if kind == MemberField && p.offsetof > 0 {
+					if sel, ok := p.stk.Get(-1).Val.(*ast.SelectorExpr); ok {
+						p.recordOffset(sel, o, i, true)
+					}
+				}
 				return kind
 			}
 		}
@@ -1556,6 +1859,18 @@ func methodTypeOf(typ types.Type, needRecv bool) types.Type {
 
 }
 
+func hasPtrRecv(method *types.Func) bool {
+	if recv := method.Type().(*types.Signature).Recv(); recv != nil {
+		_, ok := recv.Type().(*types.Pointer)
+		return ok
+	}
+	return false
+}
+
+func derefExpr(x ast.Expr) ast.Expr {
+	return &ast.ParenExpr{X: &ast.StarExpr{X: x}}
+}
+
 func indirect(typ types.Type) types.Type {
 	if t, ok := typ.(*types.Pointer); ok {
 		typ = t.Elem()
@@ -1577,19 +1892,16 @@ func callAssignOp(pkg *Package, tok token.Token, args []*internal.Elem) ast.Stmt
 	if debugInstr {
 		log.Println("AssignOp", tok, name)
 	}
-	if t, ok := args[0].Type.(*refType).typ.(*types.Named); ok {
-		op := lookupMethod(t, name)
-		if op != nil {
-			fn := &internal.Elem{
-				Val:  &ast.SelectorExpr{X: args[0].Val, Sel: ident(name)},
-				Type: realType(op.Type()),
-			}
-			ret := toFuncCall(pkg, fn, args, false, 0)
-			if ret.Type != nil {
-				log.Panicf("TODO: AssignOp %s should return no results\n", name)
-			}
-			return &ast.ExprStmt{X: ret.Val}
+	if op := pkg.opMethod(tok, args[0].Type.(*refType).typ, args[1].Type, false); op != nil {
+		fn := &internal.Elem{
+			Val:  &ast.SelectorExpr{X: args[0].Val, Sel: ident(op.Name())},
+			Type: realType(op.Type()),
 		}
+		ret := toFuncCall(pkg, fn, args, false, 0)
+		if ret.Type != nil {
+			log.Panicf("TODO: AssignOp %s should return no results\n", op.Name())
+		}
+		return &ast.ExprStmt{X: ret.Val}
 	}
 	op := pkg.builtin.Scope().Lookup(name)
 	if op == nil {
@@ -1662,7 +1974,7 @@ func (p *CodeBuilder) doAssignWith(lhs, rhs int, src ast.Node) *CodeBuilder {
 					lhs, caller, rhsVals.Len())
 			}
 			for i := 0; i < lhs; i++ {
-				val := &internal.Elem{Type: rhsVals.At(i).Type()}
+				val := &internal.Elem{Type: rhsVals.At(i).Type(), Src: args[lhs].Src}
 				checkAssignType(p.pkg, args[i].Type, val)
 				stmt.Lhs[i] = args[i].Val
 			}
@@ -1670,8 +1982,24 @@ func (p *CodeBuilder) doAssignWith(lhs, rhs int, src ast.Node) *CodeBuilder {
 			goto done
 		}
 	}
+	if lhs == 1 && rhs == 1 {
+		if rt, ok := args[0].Type.(*refType); ok && rt.setIndex != nil { // a[i] = v => a.Gop_SetIndex(i, v)
+			p.setIndex(rt, args[1])
+			p.stk.PopN(2)
+			return p
+		}
+	}
+	for i := 0; i < lhs; i++ {
+		if rt, ok := args[i].Type.(*refType); ok && rt.setIndex != nil {
+			log.Panicln("TODO: can't assign to an element of", rt.setIndex[0].Type, "in a tuple assignment")
+		}
+	}
 	if lhs == rhs {
 		for i := 0; i < lhs; i++ {
+			if _, ok := args[lhs+i].Type.(*types.Tuple); ok {
+				src, pos := p.loadExpr(args[lhs+i].Src)
+				p.panicCodeErrorf(&pos, "multiple-value %s in single-value context", src)
+			}
 			checkAssignType(p.pkg, args[i].Type, args[lhs+i])
 			stmt.Lhs[i] = args[i].Val
 			stmt.Rhs[i] = args[lhs+i].Val
@@ -1687,7 +2015,15 @@ done:
 	return p
 }
 
-func lookupMethod(t *types.Named, name string) types.Object {
+// setIndex emits `x.Gop_SetIndex(i, v)` for the assignment `x[i] = v`.
+func (p *CodeBuilder) setIndex(ref *refType, v *internal.Elem) {
+	x := ref.setIndex[0]
+	op := p.pkg.opMethod(OpSetIndex, x.Type, ref.setIndex[1].Type, false)
+	ret := callOpMethod(p.pkg, op, []*internal.Elem{x, ref.setIndex[1], v})
+	p.emitStmt(&ast.ExprStmt{X: ret.Val})
+}
+
+func lookupMethod(t *types.Named, name string) *types.Func {
 	for i, n := 0, t.NumMethods(); i < n; i++ {
 		m := t.Method(i)
 		if m.Name() == name {
@@ -1697,16 +2033,15 @@ func lookupMethod(t *types.Named, name string) types.Object {
 	return nil
 }
 
-func callOpFunc(pkg *Package, name string, args []*internal.Elem, flags InstrFlags) (ret *internal.Elem) {
-	if t, ok := args[0].Type.(*types.Named); ok {
-		op := lookupMethod(t, name)
-		if op != nil {
-			fn := &internal.Elem{
-				Val:  &ast.SelectorExpr{X: args[0].Val, Sel: ident(name)},
-				Type: realType(op.Type()),
-			}
-			return toFuncCall(pkg, fn, args, false, flags)
+// callOpFunc calls the operator method of args[0] which implements tok (see
+// OperatorResolver), or the builtin operator name if there is no such method.
+func callOpFunc(pkg *Package, tok token.Token, name string, args []*internal.Elem, flags InstrFlags) (ret *internal.Elem) {
+	if op := pkg.opMethod(tok, args[0].Type, otherOperand(args), false); op != nil {
+		fn := &internal.Elem{
+			Val:  &ast.SelectorExpr{X: args[0].Val, Sel: ident(op.Name())},
+			Type: realType(op.Type()),
 		}
+		return toFuncCall(pkg, fn, args, false, flags)
 	}
 	op := pkg.builtin.Scope().Lookup(name)
 	if op == nil {
@@ -1730,12 +2065,65 @@ func (p *CodeBuilder) BinaryOp(op token.Token, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("BinaryOp", op, name)
 	}
-	ret := callOpFunc(p.pkg, name, args, 0)
+	var ret *internal.Elem
+	switch op {
+	case token.SHL, token.SHR, token.LAND, token.LOR:
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		convOpArg(p.pkg, op, args)
+	default:
+		if ret = callROpMethod(p.pkg, op, args); ret == nil {
+			convOpArg(p.pkg, op, args)
+		}
+	}
+	if ret == nil {
+		ret = callOpFunc(p.pkg, op, name, args, 0)
+	}
 	ret.Src = getSrc(src)
 	p.stk.Ret(2, ret)
 	return p
 }
 
+// convOpArg converts args[0] to the type of args[1] for a binary operator, if
+// only the type of args[1] has the operator method (eg. `1 < a` where a is a
+// Gop_bigint). So the method is called as `Gop_bigint_Init__0(1).Gop_LT(a)`,
+// and operands are still evaluated from left to right.
+func convOpArg(pkg *Package, op token.Token, args []*internal.Elem) {
+	if pkg.opMethod(op, args[0].Type, args[1].Type, false) != nil {
+		return
+	}
+	t, ok := args[1].Type.(*types.Named)
+	if !ok || pkg.opMethod(op, t, args[0].Type, false) == nil {
+		return
+	}
+	expr := args[0].Val
+	if !AssignableConv(pkg, args[0].Type, t, &expr) {
+		return
+	}
+	if expr == args[0].Val { // eg. T(1).Gop_LT(a)
+		expr = &ast.CallExpr{Fun: toType(pkg, t), Args: []ast.Expr{expr}}
+	}
+	args[0] = &internal.Elem{Val: expr, Type: t, Src: args[0].Src}
+}
+
+// callROpMethod calls the right-operand method of an arithmetic operator (eg.
+// Gop_Radd of Gop_Add) as `b.Gop_Radd(a)`, if only the type of args[1] has the
+// method. It returns nil if there is no such method, or both operands are
+// effectful (b is evaluated before a, so they can't be swapped).
+func callROpMethod(pkg *Package, tok token.Token, args []*internal.Elem) *internal.Elem {
+	if pkg.opMethod(tok, args[0].Type, args[1].Type, false) != nil {
+		return nil
+	}
+	op := pkg.opMethod(tok, args[1].Type, args[0].Type, true)
+	if op == nil || (isEffectful(args[0].Val) && isEffectful(args[1].Val)) {
+		return nil
+	}
+	fn := &internal.Elem{
+		Val:  &ast.SelectorExpr{X: args[1].Val, Sel: ident(op.Name())},
+		Type: realType(op.Type()),
+	}
+	return toFuncCall(pkg, fn, []*internal.Elem{args[1], args[0]}, false, 0)
+}
+
 var (
 	binaryOps = [...]string{
 		token.ADD: "Add", // +
@@ -1791,7 +2179,7 @@ func (p *CodeBuilder) UnaryOp(op token.Token, twoValue ...bool) *CodeBuilder {
 	if debugInstr {
 		log.Println("UnaryOp", op, flags, name)
 	}
-	ret := callOpFunc(p.pkg, name, p.stk.GetArgs(1), flags)
+	ret := callOpFunc(p.pkg, op, name, p.stk.GetArgs(1), flags)
 	p.stk.Ret(1, ret)
 	return p
 }
@@ -1877,6 +2265,172 @@ func (p *CodeBuilder) Go() *CodeBuilder {
 	return p
 }
 
+// Block func: starts a bare block statement `{ ... }`, which has its own
+// scope. Call EndBlock (or End) to end it.
+func (p *CodeBuilder) Block() *CodeBuilder {
+	if debugInstr {
+		log.Println("Block")
+	}
+	stmt := &blockStmt{}
+	p.startBlockStmt(stmt, "block statement", &stmt.old)
+	return p
+}
+
+// EndBlock func: ends a block statement started by Block.
+func (p *CodeBuilder) EndBlock() *CodeBuilder {
+	if _, ok := p.current.codeBlock.(*blockStmt); ok {
+		return p.End()
+	}
+	panic("EndBlock: current block is " + codeBlockName(p.current.codeBlock) + ", not a block statement")
+}
+
+// StmtMark represents a position in a block, where statements can be inserted
+// later by InsertAt.
+type StmtMark struct {
+	scope *types.Scope
+	idx   int
+}
+
+// Mark func: records the current position of the current block, that is,
+// before the next statement.
+func (p *CodeBuilder) Mark() *StmtMark {
+	return &StmtMark{scope: p.current.scope, idx: len(p.current.stmts)}
+}
+
+// InsertAt func: starts a vblock (virtual block), whose statements are inserted
+// at mark when it ends (by End). A vblock has no braces and shares the scope
+// of the current block, so variables declared in it (eg. temporary variables
+// hoisted above the expression that uses them) are visible after the mark:
+//
+//	mark := cb.Mark()
+//	cb.DefineVarStart(pos, "x") ... // in the middle of an expression
+//	cb.InsertAt(mark).NewVar(typ, "tmp").End()
+//
+// Statements inserted at the same mark are kept in order. The mark must be in
+// the current block.
+func (p *CodeBuilder) InsertAt(mark *StmtMark) *CodeBuilder {
+	if debugInstr {
+		log.Println("InsertAt", mark.idx)
+	}
+	mark.scope = p.liveScope(mark.scope)
+	if mark.scope != p.current.scope || mark.idx > len(p.current.stmts) {
+		panic("InsertAt: mark isn't in the current block")
+	}
+	stmt := &vblockStmt{mark: mark}
+	p.current.codeBlockCtx, stmt.old = codeBlockCtx{stmt, p.current.scope, p.stk.Len(), nil, nil, 0, p.stmtPos, &stmt.old}, p.current.codeBlockCtx
+	p.stmtPos = token.NoPos
+	return p
+}
+
+// Checkpoint represents a state of a CodeBuilder, which can be restored later
+// by Rollback.
+type Checkpoint struct {
+	current  funcBodyCtx
+	stmts    []ast.Stmt
+	stk      []*internal.Elem
+	labels   map[string]*label
+	varDecl  *ValueDecl
+	comments *ast.CommentGroup
+	objs     []types.Object // objects declared in the scope
+	once     bool
+}
+
+// Checkpoint func: records the current state of the code builder, so that a
+// parser can build code speculatively, and discard it by Rollback if it turns
+// out to be a wrong guess.
+func (p *CodeBuilder) Checkpoint() *Checkpoint {
+	cp := &Checkpoint{
+		current:  p.current,
+		stmts:    append([]ast.Stmt(nil), p.current.stmts...),
+		stk:      append([]*internal.Elem(nil), p.stk.GetArgs(p.stk.Len())...),
+		varDecl:  p.varDecl,
+		comments: p.comments,
+		once:     p.commentOnce,
+	}
+	scope := p.current.scope
+	cp.objs = make([]types.Object, 0, scope.Len())
+	for _, name := range scope.Names() {
+		cp.objs = append(cp.objs, scope.Lookup(name))
+	}
+	if p.current.labels != nil {
+		cp.labels = make(map[string]*label, len(p.current.labels))
+		for name, l := range p.current.labels {
+			cp.labels[name] = l
+		}
+	}
+	return cp
+}
+
+// Rollback func: restores the code builder to the state recorded by cp. It
+// discards expressions, statements and blocks (and objects declared in them)
+// which are built after cp. The block where cp was recorded must not have
+// been ended.
+//
+// Objects can't be removed from a scope, so if names were declared in the block
+// where cp was recorded after cp, its scope is replaced by a new one with the
+// objects declared before cp (marks in the block are still valid). Rollback
+// panics if names were declared at package level after cp.
+func (p *CodeBuilder) Rollback(cp *Checkpoint) *CodeBuilder {
+	if debugInstr {
+		log.Println("Rollback")
+	}
+	cp.current.scope = p.liveScope(cp.current.scope)
+	scope := p.current.scope
+	for scope != nil && scope != cp.current.scope {
+		scope = scope.Parent()
+	}
+	if scope == nil {
+		panic("Rollback: checkpoint isn't in the current block")
+	}
+	changed := scope.Len() != len(cp.objs)
+	for i := 0; !changed && i < len(cp.objs); i++ {
+		changed = scope.Lookup(cp.objs[i].Name()) != cp.objs[i]
+	}
+	if changed && scope == p.pkg.Types.Scope() {
+		panic("Rollback: can't undo package-level declarations")
+	}
+	p.current = cp.current
+	p.current.stmts = append(p.current.stmts[:0], cp.stmts...)
+	p.current.labels = cp.labels
+	p.stk.SetLen(0)
+	for _, e := range cp.stk {
+		p.stk.Push(e)
+	}
+	p.varDecl, p.comments, p.commentOnce = cp.varDecl, cp.comments, cp.once
+	if changed {
+		p.replaceScope(scope, cp.objs)
+	}
+	return p
+}
+
+// replaceScope replaces scope (of the current block) by a new scope, which has
+// objects objs and no child scopes.
+func (p *CodeBuilder) replaceScope(scope *types.Scope, objs []types.Object) {
+	newScope := types.NewScope(scope.Parent(), scope.Pos(), scope.End(), "rollback")
+	for _, o := range objs {
+		newScope.Insert(o)
+	}
+	p.current.scope = newScope
+	for v := p.varDecl; v != nil; v = v.oldv {
+		if v.scope == scope {
+			v.scope = newScope
+		}
+	}
+	if p.scopes == nil {
+		p.scopes = make(map[*types.Scope]*types.Scope)
+	}
+	p.scopes[scope] = newScope
+}
+
+// liveScope returns the scope which replaces scope by Rollback (or scope itself
+// if it isn't replaced).
+func (p *CodeBuilder) liveScope(scope *types.Scope) *types.Scope {
+	for s, ok := p.scopes[scope]; ok; s, ok = p.scopes[s] {
+		scope = s
+	}
+	return scope
+}
+
 // If func
 func (p *CodeBuilder) If() *CodeBuilder {
 	if debugInstr {
@@ -1893,7 +2447,10 @@ func (p *CodeBuilder) Then() *CodeBuilder {
 		log.Println("Then")
 	}
 	if p.stk.Len() == p.current.base {
-		panic("use None() for empty expr")
+		if _, ok := p.current.codeBlock.(*forStmt); !ok {
+			panic("use None() for empty expr")
+		}
+		p.stk.Push(elemNone) // for statement without a condition
 	}
 	if flow, ok := p.current.codeBlock.(controlFlow); ok {
 		flow.Then(p)
@@ -1924,6 +2481,103 @@ func (p *CodeBuilder) TypeSwitch(name string) *CodeBuilder {
 	return p
 }
 
+// Conv func: T(x)
+//
+// If T is an extension type (eg. Gop_bigint) which has a typecast function
+// T_Cast, T(x) is routed to T_Cast(x). If x is of an extension type which has
+// a Gop_Rcast method returning T, T(x) is routed to x.Gop_Rcast().
+//
+// A conversion of a constant is folded, eg. string(65) is the constant "A",
+// except []byte(x) and []rune(x) of a constant string x, which aren't
+// constants and are converted at runtime.
+func (p *CodeBuilder) Conv(typ types.Type, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("Conv", typ)
+	}
+	pkg := p.pkg
+	arg := p.stk.Get(-1)
+	if t, ok := typ.(*types.Named); ok {
+		o := t.Obj()
+		if at := o.Pkg(); at != nil {
+			if cast := at.Scope().Lookup(o.Name() + "_Cast"); cast != nil {
+				fn := toObject(pkg, cast, getSrc(src))
+				if ret, err := matchFuncCall(pkg, fn, []*internal.Elem{arg}, false, 0); err == nil {
+					ret.Src = getSrc(src)
+					p.stk.Ret(1, ret)
+					return p
+				}
+			}
+		}
+	}
+	if rcast := pkg.opMethod(OpConv, arg.Type, typ, false); rcast != nil {
+		p.stk.Ret(1, &internal.Elem{
+			Val:  &ast.CallExpr{Fun: &ast.SelectorExpr{X: arg.Val, Sel: ident(rcast.Name())}},
+			Type: typ,
+			Src:  getSrc(src),
+		})
+		return p
+	}
+	cval := arg.CVal
+	if !types.ConvertibleTo(realType(arg.Type), typ) {
+		srcExpr, pos := p.loadExpr(arg.Src)
+		p.typeErrorf(&pos, "cannot convert %s (type %v) to type %v", srcExpr, arg.Type, typ)
+		cval = nil
+	}
+	if cval != nil {
+		if err := checkRepresentable(pkg, arg, typ); err != nil {
+			p.handleTypeErr(err)
+			cval = nil
+		} else {
+			cval = convConst(cval, typ)
+		}
+	}
+	p.stk.Ret(1, &internal.Elem{
+		Val:  toConvExpr(pkg, typ, arg.Val),
+		Type: typ,
+		CVal: cval,
+		Src:  getSrc(src),
+	})
+	return p
+}
+
+// toConvExpr returns the conversion `T(x)`.
+func toConvExpr(pkg *Package, typ types.Type, x ast.Expr) ast.Expr {
+	typExpr := toType(pkg, typ)
+	switch typExpr.(type) {
+	case *ast.StarExpr, *ast.FuncType, *ast.ChanType: // (*T)(x)
+		typExpr = &ast.ParenExpr{X: typExpr}
+	}
+	return &ast.CallExpr{Fun: typExpr, Args: []ast.Expr{x}}
+}
+
+// convConst returns the constant T(x) of a constant x, or nil if T(x) isn't a
+// constant, eg. []byte(x) or []rune(x) of a constant string x, which is
+// converted at runtime.
+func convConst(cval constant.Value, typ types.Type) constant.Value {
+	if t, ok := typ.Underlying().(*types.Basic); ok {
+		switch info := t.Info(); {
+		case info&types.IsInteger != 0:
+			return constant.ToInt(cval)
+		case info&types.IsFloat != 0:
+			return constant.ToFloat(cval)
+		case info&types.IsComplex != 0:
+			return constant.ToComplex(cval)
+		case info&(types.IsString|types.IsBoolean) != 0:
+			switch cval.Kind() {
+			case constant.String, constant.Bool:
+				return cval
+			case constant.Int: // string(x) of an integer x is the UTF-8 encoding of rune x
+				r := unicode.ReplacementChar // x isn't a valid code point
+				if v, ok := constant.Uint64Val(cval); ok && v <= unicode.MaxRune {
+					r = rune(v)
+				}
+				return constant.MakeString(string(r))
+			}
+		}
+	}
+	return nil
+}
+
 // TypeAssert func
 func (p *CodeBuilder) TypeAssert(typ types.Type, twoValue bool) *CodeBuilder {
 	arg := p.stk.Get(-1)
@@ -2018,6 +2672,19 @@ func (p *CodeBuilder) Case(n int) *CodeBuilder { // n=0 means default case
 	panic("use switch..case please")
 }
 
+// SetSwitchHint sets how the current switch statement is emitted (see
+// SwitchHint). It can be called anywhere before End of the switch statement.
+func (p *CodeBuilder) SetSwitchHint(hint SwitchHint) *CodeBuilder {
+	if debugInstr {
+		log.Println("SetSwitchHint", hint)
+	}
+	if flow, ok := p.current.codeBlock.(*switchStmt); ok {
+		flow.hint = hint
+		return p
+	}
+	panic("use switch..SetSwitchHint please")
+}
+
 // Label func
 func (p *CodeBuilder) Label(name string, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
@@ -2044,9 +2711,11 @@ func (p *CodeBuilder) Break(name string, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Break", name)
 	}
+	pos := p.nodePosition(getSrc(src))
+	p.checkBranch(token.BREAK, name, pos)
 	if name != "" {
 		p.current.flows |= (flowFlagBreak | flowFlagWithLabel)
-		p.current.useLabel(p, name, p.nodePosition(getSrc(src)))
+		p.current.useLabel(p, name, pos)
 	} else {
 		p.current.flows |= flowFlagBreak
 	}
@@ -2059,9 +2728,11 @@ func (p *CodeBuilder) Continue(name string, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Continue", name)
 	}
+	pos := p.nodePosition(getSrc(src))
+	p.checkBranch(token.CONTINUE, name, pos)
 	if name != "" {
 		p.current.flows |= (flowFlagContinue | flowFlagWithLabel)
-		p.current.useLabel(p, name, p.nodePosition(getSrc(src)))
+		p.current.useLabel(p, name, pos)
 	} else {
 		p.current.flows |= flowFlagContinue
 	}
@@ -2069,6 +2740,39 @@ func (p *CodeBuilder) Continue(name string, src ...ast.Node) *CodeBuilder {
 	return p
 }
 
+// checkBranch checks a break or continue statement (tok) labeled by name (if
+// it isn't empty): it must be in a loop (or a switch or select for a break)
+// of the current func, which is labeled by name.
+func (p *CodeBuilder) checkBranch(tok token.Token, name string, pos token.Position) {
+loop:
+	for ctx := &p.current.codeBlockCtx; ctx.outer != nil; ctx = ctx.outer {
+		var target bool
+		switch ctx.codeBlock.(type) {
+		case *Func: // break and continue can't leave a func
+			break loop
+		case *forStmt, *forRangeStmt, *doWhileStmt:
+			target = true
+		case *switchStmt, *typeSwitchStmt, *selectStmt:
+			target = tok == token.BREAK
+		}
+		if !target {
+			continue
+		}
+		// the label of a statement is in the enclosing block when it starts
+		if l := ctx.outer.label; name == "" || l != nil && l.Label.Name == name {
+			return
+		}
+	}
+	switch {
+	case name != "":
+		p.typeErrorf(&pos, "invalid %v label %s", tok, name)
+	case tok == token.BREAK:
+		p.typeErrorf(&pos, "break is not in a loop, switch, or select")
+	default:
+		p.typeErrorf(&pos, "continue is not in a loop")
+	}
+}
+
 // Fallthrough func
 func (p *CodeBuilder) Fallthrough() *CodeBuilder {
 	if debugInstr {
@@ -2081,7 +2785,8 @@ func (p *CodeBuilder) Fallthrough() *CodeBuilder {
 	panic("please use fallthrough in case statement")
 }
 
-// For func
+// For func. If there is no cond (ie. Then is called with an empty stack, or
+// None is pushed), it's an infinite loop `for { ... }`.
 func (p *CodeBuilder) For() *CodeBuilder {
 	if debugInstr {
 		log.Println("For")
@@ -2091,12 +2796,92 @@ func (p *CodeBuilder) For() *CodeBuilder {
 	return p
 }
 
+// While starts a while-style for statement:
+//
+//	while cond then
+//	  body
+//	end
+//
+// It is emitted as `for cond { body }`. Unlike For, statements emitted before
+// Then (eg. to compute cond) are evaluated before each iteration, so it's
+// emitted as `for { stmts; if !cond { break }; body }` if there are any. If
+// there is no cond (ie. Then is called with an empty stack), it's an infinite
+// loop `for { body }`.
+func (p *CodeBuilder) While() *CodeBuilder {
+	if debugInstr {
+		log.Println("While")
+	}
+	stmt := &forStmt{while: true}
+	p.startBlockStmt(stmt, "for statement", &stmt.old)
+	return p
+}
+
+// DoWhile starts a do-while statement, whose body runs before its condition
+// is checked:
+//
+//	doWhile
+//	  body
+//	doCond cond
+//	end
+//
+// It is lowered to `for { body; if !cond { break } }`, or to a for statement
+// with a run-once flag if a continue statement applies to it (so that cond is
+// checked before the next iteration). Names declared in body are out of scope
+// in cond.
+func (p *CodeBuilder) DoWhile() *CodeBuilder {
+	if debugInstr {
+		log.Println("DoWhile")
+	}
+	stmt := &doWhileStmt{}
+	p.startBlockStmt(stmt, "do-while statement", &stmt.old)
+	return p
+}
+
+// DoCond ends the body of a do-while statement, and starts its condition (see
+// DoWhile).
+func (p *CodeBuilder) DoCond() *CodeBuilder {
+	if debugInstr {
+		log.Println("DoCond")
+	}
+	if flow, ok := p.current.codeBlock.(*doWhileStmt); ok {
+		flow.DoCond(p)
+		return p
+	}
+	panic("please use DoCond() in do-while statement")
+}
+
+// LoopElse ends the body of a for (or for range) statement, and starts its else
+// body, which runs if the loop completes without a break:
+//
+//	for init; cond then
+//	  body
+//	loopElse
+//	  elseBody
+//	end
+//
+// Break statements which apply to the loop are lowered to `goto` a label after
+// elseBody.
+func (p *CodeBuilder) LoopElse() *CodeBuilder {
+	if debugInstr {
+		log.Println("LoopElse")
+	}
+	switch p.current.codeBlock.(type) {
+	case *forStmt, *forRangeStmt:
+	default:
+		panic("please use LoopElse() in for statement")
+	}
+	p.End()
+	stmt := &loopElseStmt{}
+	stmt.start(p)
+	return p
+}
+
 // Post func
 func (p *CodeBuilder) Post() *CodeBuilder {
 	if debugInstr {
 		log.Println("Post")
 	}
-	if flow, ok := p.current.codeBlock.(*forStmt); ok {
+	if flow, ok := p.current.codeBlock.(*forStmt); ok && !flow.while {
 		flow.Post(p)
 		return p
 	}
@@ -2113,6 +2898,19 @@ func (p *CodeBuilder) ForRange(names ...string) *CodeBuilder {
 	return p
 }
 
+// ForRangeEx func: it is the same as ForRange, except that:
+//   - `for v := range X` means `for _, v := range X` (v is a value variable,
+//     unless X is a channel which has no key).
+//   - `for i := range n` means `for i := 0; i < n; i++` if n is an integer.
+func (p *CodeBuilder) ForRangeEx(names ...string) *CodeBuilder {
+	if debugInstr {
+		log.Println("ForRangeEx", names)
+	}
+	stmt := &forRangeStmt{names: names, ex: true}
+	p.startBlockStmt(stmt, "for range statement", &stmt.old)
+	return p
+}
+
 // RangeAssignThen func
 func (p *CodeBuilder) RangeAssignThen(pos token.Pos) *CodeBuilder {
 	if debugInstr {
@@ -2149,12 +2947,7 @@ func (p *CodeBuilder) EndStmt() *CodeBuilder {
 // End func
 func (p *CodeBuilder) End() *CodeBuilder {
 	if debugInstr {
-		typ := reflect.TypeOf(p.current.codeBlock)
-		if typ.Kind() == reflect.Ptr {
-			typ = typ.Elem()
-		}
-		name := strings.TrimSuffix(strings.Title(typ.Name()), "Stmt")
-		log.Println("End //", name)
+		log.Println("End //", codeBlockName(p.current.codeBlock))
 		if p.stk.Len() > p.current.base {
 			panic("forget to call EndStmt()?")
 		}
@@ -2163,6 +2956,19 @@ func (p *CodeBuilder) End() *CodeBuilder {
 	return p
 }
 
+// codeBlockName returns the name of the kind of block (eg. If, For), which is
+// used in debug logs and error messages.
+func codeBlockName(block codeBlock) string {
+	if block == nil {
+		return "package"
+	}
+	typ := reflect.TypeOf(block)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return strings.TrimSuffix(strings.Title(typ.Name()), "Stmt")
+}
+
 // ResetInit resets the variable init state of CodeBuilder.
 func (p *CodeBuilder) ResetInit() {
 	if debugInstr {
@@ -2186,11 +2992,115 @@ func (p *CodeBuilder) Debug(dbg func(cb *CodeBuilder)) *CodeBuilder {
 	return p
 }
 
-// Get func
+// Get returns the element at idx of the stack. If Config.ReuseElems is true,
+// the element is reused after the current top-level func ends, so it must not
+// be used after then.
 func (p *CodeBuilder) Get(idx int) *Element {
 	return p.stk.Get(idx)
 }
 
+// SetData attaches user data to the top element of the stack, eg. a node of the
+// front-end IR which isn't an ast.Node (see Element.Src). It is retrievable by
+// Get or Peek, eg. in a hook. Note that data isn't propagated to results of
+// operations on the element.
+func (p *CodeBuilder) SetData(data interface{}) *CodeBuilder {
+	p.stk.Get(-1).Data = data
+	return p
+}
+
+// StackLen returns count of elements in the stack (including elements pushed
+// before the current block).
+func (p *CodeBuilder) StackLen() int {
+	return p.stk.Len()
+}
+
+// Peek returns the i-th element from the top of the stack (0 means the top). It
+// returns nil if i is out of range.
+func (p *CodeBuilder) Peek(i int) *Element {
+	if i < 0 || i >= p.stk.Len() {
+		return nil
+	}
+	return p.stk.Get(-1 - i)
+}
+
+// DebugString returns a readable dump of the stack for debugging, from the top
+// to the bottom, with types and constant values of elements:
+//
+//	stack: 3 elements (2 in the current block)
+//	  -1: a + 1 (int)
+//	  -2: 1 (untyped int = 1)
+//	  --- block base
+//	  -3: fmt.Println (func(a ...interface{}) (n int, err error))
+func (p *CodeBuilder) DebugString() string {
+	var b strings.Builder
+	n, base := p.stk.Len(), p.current.base
+	fmt.Fprintf(&b, "stack: %d elements (%d in the current block)\n", n, n-base)
+	for i := n - 1; i >= 0; i-- {
+		if i == base-1 {
+			b.WriteString("  --- block base\n")
+		}
+		e := p.stk.Get(i - n)
+		fmt.Fprintf(&b, "  %d: %s (%v", i-n, debugExpr(e.Val), e.Type)
+		if e.CVal != nil {
+			fmt.Fprintf(&b, " = %v", e.CVal)
+		}
+		b.WriteString(")\n")
+	}
+	return b.String()
+}
+
+// ElemInfo represents information of an element, for editor tooling (eg. hover
+// information).
+type ElemInfo struct {
+	Type   types.Type     // type of the element
+	CVal   constant.Value // constant value of the element (or of Object if it is a constant)
+	Object types.Object   // the declaring object (nil if the element doesn't refer to an object)
+	Doc    string         // doc comment of Object in an imported package (see PkgRef.Doc)
+}
+
+// TopInfo returns information of the element on the top of the stack. It
+// returns nil if the stack is empty.
+//
+// Object is found if the element is a name (eg. `x`) or a qualified name of an
+// imported package (eg. `fmt.Println`).
+func (p *CodeBuilder) TopInfo() *ElemInfo {
+	if p.stk.Len() == 0 {
+		return nil
+	}
+	e := p.stk.Get(-1)
+	info := &ElemInfo{Type: e.Type, CVal: e.CVal}
+	switch v := e.Val.(type) {
+	case *ast.Ident:
+		_, info.Object = p.Lookup(v.Name)
+	case *ast.SelectorExpr:
+		if x, ok := v.X.(*ast.Ident); ok {
+			if ref := p.pkg.importedBy(x); ref != nil {
+				info.Object, info.Doc = ref.Types.Scope().Lookup(v.Sel.Name), ref.docs[v.Sel.Name]
+			}
+		}
+	}
+	if c, ok := info.Object.(*types.Const); ok && info.CVal == nil {
+		info.CVal = c.Val()
+	}
+	return info
+}
+
+func debugExpr(expr ast.Expr) (ret string) {
+	if expr == nil {
+		return "<nil>"
+	}
+	defer func() {
+		if e := recover(); e != nil { // eg. operators of the builtin package
+			ret = fmt.Sprintf("<%T>", expr)
+		}
+	}()
+	var b bytes.Buffer
+	if err := printer.Fprint(&b, token.NewFileSet(), expr); err != nil {
+		return fmt.Sprintf("<%T>", expr)
+	}
+	return b.String()
+}
+
 // ----------------------------------------------------------------------------
 
 type InternalStack = internal.Stack
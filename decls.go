@@ -0,0 +1,90 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+//
+// Two-pass build: a front-end may declare all types (NewType) and func
+// signatures (NewFunc) of a package in pass one, so they are in the package
+// scope before any body refers to them. In pass two, it initializes the types
+// (TypeDecl.InitType) and builds the bodies (Func.BodyStart) by the stored
+// handles, in any order. Declarations are written in the order of pass one.
+// EndDecls checks that every declaration of pass one is completed.
+
+// IncompleteDeclsError is returned by EndDecls if some types aren't
+// initialized or some funcs have no (ended) bodies.
+type IncompleteDeclsError struct {
+	Types []*types.TypeName
+	Funcs []*types.Func
+}
+
+func (p *IncompleteDeclsError) Error() string {
+	msgs := make([]string, 0, len(p.Types)+len(p.Funcs))
+	for _, t := range p.Types {
+		msgs = append(msgs, "type "+t.Name()+" isn't initialized")
+	}
+	for _, fn := range p.Funcs {
+		msgs = append(msgs, "func "+funcName(fn)+" has no body")
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func funcName(fn *types.Func) string {
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		return "(" + types.TypeString(recv.Type(), types.RelativeTo(fn.Pkg())) + ")." + fn.Name()
+	}
+	return fn.Name()
+}
+
+// EndDecls ends a two-pass build: it returns an *IncompleteDeclsError if any
+// type declared by NewType isn't initialized, or any func declared by NewFunc
+// has no body (a func without body isn't written).
+func (p *Package) EndDecls() error {
+	objs := make(map[ast.Decl][]types.Object, len(p.objDecls))
+	for obj, decl := range p.objDecls {
+		objs[decl] = append(objs[decl], obj)
+	}
+	var err IncompleteDeclsError
+	for _, f := range p.files {
+		for _, decl := range f.decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Name == nil {
+					for _, obj := range objs[d] {
+						err.Funcs = append(err.Funcs, obj.(*types.Func))
+					}
+				}
+			case *ast.GenDecl:
+				if d.Tok == token.TYPE && d.Specs[0].(*ast.TypeSpec).Type == nil {
+					for _, obj := range objs[d] {
+						err.Types = append(err.Types, obj.(*types.TypeName))
+					}
+				}
+			}
+		}
+	}
+	if err.Types != nil || err.Funcs != nil {
+		return &err
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
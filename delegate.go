@@ -0,0 +1,82 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/types"
+)
+
+// ----------------------------------------------------------------------------
+
+// NewForwardMethods declares methods of the interface type intf (including
+// methods of its embedded interfaces) on *typ, which forward calls to the
+// field named field of typ:
+//
+//	func (w *W) M(a int, b ...string) (int, error) {
+//		return w.inner.M(a, b...)
+//	}
+//
+// The field may be an embedded field (eg. `Reader` of an embedded io.Reader),
+// or a field promoted from an embedded struct. Methods which are already
+// declared on typ (eg. to override some methods of the delegate) are skipped,
+// so NewForwardMethods should be called after them. Signatures of the methods
+// are copied by CopySignature, as NewDelegates does.
+func (p *Package) NewForwardMethods(typ *types.Named, field string, intf types.Type) (methods []*Func, err error) {
+	name := typ.Obj().Name()
+	pos := typ.Obj().Pos()
+	t, ok := intf.Underlying().(*types.Interface)
+	if !ok {
+		return nil, p.cb.newCodePosErrorf(pos, "%v is not an interface", intf)
+	}
+	obj, _, _ := types.LookupFieldOrMethod(typ, true, p.Types, field)
+	fld, ok := obj.(*types.Var)
+	if !ok || !fld.IsField() {
+		return nil, p.cb.newCodePosErrorf(pos, "%s.%s undefined (type %s has no field %s)", name, field, name, field)
+	}
+	declared := make(map[string]bool, typ.NumMethods())
+	for i, n := 0, typ.NumMethods(); i < n; i++ {
+		declared[typ.Method(i).Name()] = true
+	}
+	var forwards []*types.Func
+	for i, n := 0, t.NumMethods(); i < n; i++ {
+		m := t.Method(i)
+		mname := m.Name()
+		if declared[mname] {
+			continue
+		}
+		if !m.Exported() && m.Pkg() != p.Types {
+			return nil, p.cb.newCodePosErrorf(
+				pos, "cannot forward unexported method %s of %v", mname, intf)
+		}
+		if obj, index, _ := types.LookupFieldOrMethod(typ, true, p.Types, mname); obj != nil && len(index) == 1 {
+			return nil, p.cb.newCodePosErrorf(
+				pos, "type %s has both field and method named %s", name, mname)
+		}
+		if obj, _, _ := types.LookupFieldOrMethod(fld.Type(), true, p.Types, mname); obj == nil {
+			return nil, p.cb.newCodePosErrorf(
+				pos, "%s.%s undefined (type %v has no field or method %s)", field, mname, fld.Type(), mname)
+		}
+		forwards = append(forwards, m)
+	}
+	for _, m := range forwards {
+		fn, err := p.newDelegate(typ, field, m)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, fn)
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,147 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+)
+
+// ----------------------------------------------------------------------------
+
+// EnumImpl represents how the functions generated by NewEnumFuncs are
+// implemented.
+type EnumImpl int
+
+const (
+	// EnumSwitch implements the functions by switch statements.
+	EnumSwitch EnumImpl = iota
+
+	// EnumMap implements the functions by lookups of two package variables
+	// `_X_values map[string]X` and `_X_names map[X]string`.
+	EnumMap
+)
+
+// NewEnumFuncs generates the parse function and the String method of the enum
+// type X (typ), whose values are the constants consts. Names of the constants
+// are used as their string forms:
+//
+//	func ParseX(s string) (X, error) {
+//		switch s {
+//		case "A":
+//			return A, nil
+//		...
+//		}
+//		return 0, fmt.Errorf("invalid X: %q", s)
+//	}
+//
+//	func (v X) String() string {
+//		switch v {
+//		case A:
+//			return "A"
+//		...
+//		}
+//		return fmt.Sprintf("X(%d)", v)
+//	}
+//
+// The underlying type of X must be an integer type. If several constants have
+// the same value, the first one is used by String.
+func (p *Package) NewEnumFuncs(typ *types.Named, impl EnumImpl, consts ...*types.Const) (parse, str *Func, err error) {
+	name := typ.Obj().Name()
+	if !isInteger(typ) {
+		return nil, nil, p.cb.newCodePosErrorf(
+			typ.Obj().Pos(), "enum type %s isn't an integer type", name)
+	}
+	var uniques []*types.Const // constants with unique values
+	seen := make(map[string]bool, len(consts))
+	for _, c := range consts {
+		if !types.Identical(c.Type(), typ) {
+			return nil, nil, p.cb.newCodePosErrorf(
+				c.Pos(), "cannot use %s (type %v) as enum value of type %s", c.Name(), c.Type(), name)
+		}
+		if v := c.Val().ExactString(); !seen[v] {
+			seen[v] = true
+			uniques = append(uniques, c)
+		}
+	}
+	var values, names types.Object
+	if impl == EnumMap {
+		values, names = p.newEnumMaps(typ, consts, uniques)
+	}
+	tyStr := types.Typ[types.String]
+	fmt := p.Import("fmt")
+
+	s := p.NewParam(token.NoPos, "s", tyStr)
+	rets := NewTuple(p.NewParam(token.NoPos, "", typ), p.NewParam(token.NoPos, "", TyError))
+	if parse, err = p.NewFuncWith(token.NoPos, "Parse"+name, types.NewSignature(nil, NewTuple(s), rets, false), nil); err != nil {
+		return
+	}
+	cb := parse.BodyStart(p)
+	if impl == EnumMap {
+		cb.If().DefineVarStart(token.NoPos, "v", "ok").Val(values).Val(s).Index(1, true).EndInit(1)
+		v, ok := cb.Scope().Lookup("v"), cb.Scope().Lookup("ok")
+		cb.Val(ok).Then().Val(v).Val(nil).Return(2).End()
+	} else {
+		cb.Switch().Val(s).Then()
+		for _, c := range consts {
+			cb.Val(c.Name()).Case(1).Val(c).Val(nil).Return(2).End()
+		}
+		cb.End()
+	}
+	cb.ZeroLit(typ).
+		Val(fmt.Ref("Errorf")).Val("invalid " + name + ": %q").Val(s).Call(2).
+		Return(2).
+		End()
+
+	v := p.NewParam(token.NoPos, "v", typ)
+	ret := NewTuple(p.NewParam(token.NoPos, "", tyStr))
+	if str, err = p.NewFuncWith(token.NoPos, "String", types.NewSignature(v, nil, ret, false), nil); err != nil {
+		return
+	}
+	cb = str.BodyStart(p)
+	if impl == EnumMap {
+		cb.If().DefineVarStart(token.NoPos, "s", "ok").Val(names).Val(v).Index(1, true).EndInit(1)
+		s, ok := cb.Scope().Lookup("s"), cb.Scope().Lookup("ok")
+		cb.Val(ok).Then().Val(s).Return(1).End()
+	} else {
+		cb.Switch().Val(v).Then()
+		for _, c := range uniques {
+			cb.Val(c).Case(1).Val(c.Name()).Return(1).End()
+		}
+		cb.End()
+	}
+	cb.Val(fmt.Ref("Sprintf")).Val(name + "(%d)").Val(v).Call(2).
+		Return(1).
+		End()
+	return
+}
+
+func (p *Package) newEnumMaps(typ *types.Named, consts, uniques []*types.Const) (values, names types.Object) {
+	name := typ.Obj().Name()
+	tyStr := types.Typ[types.String]
+	scope := p.Types.Scope()
+	cb := p.NewVarStart(token.NoPos, nil, "_"+name+"_values")
+	for _, c := range consts {
+		cb.Val(c.Name()).Val(c)
+	}
+	cb.MapLit(types.NewMap(tyStr, typ), len(consts)*2).EndInit(1)
+	cb = p.NewVarStart(token.NoPos, nil, "_"+name+"_names")
+	for _, c := range uniques {
+		cb.Val(c).Val(c.Name())
+	}
+	cb.MapLit(types.NewMap(typ, tyStr), len(uniques)*2).EndInit(1)
+	return scope.Lookup("_" + name + "_values"), scope.Lookup("_" + name + "_names")
+}
+
+// ----------------------------------------------------------------------------
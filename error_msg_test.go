@@ -159,6 +159,37 @@ func TestErrDefineVar(t *testing.T) {
 		})
 }
 
+func TestErrDefineVar2(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:1 assignment mismatch: 2 variables but bar returns 3 values",
+		func(pkg *gox.Package) {
+			ret := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
+			newFunc(pkg, 1, 5, 1, 7, nil, "bar", nil, types.NewTuple(ret, ret, ret), false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 1), "a", "b").
+				Val(ctxRef(pkg, "bar")).CallWith(0, false, false, source("bar()", 2, 9)).
+				EndInit(1).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:1 assignment mismatch: 2 variables but 3 values",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 1), "a", "b").Val(1).Val(2).Val(3).EndInit(3).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:1 use of untyped nil in assignment",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 1), "a").Val(nil).EndInit(1).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:1 no new variables on left side of :=",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 1), "_").Val(1).EndInit(1).
+				End()
+		})
+}
+
 func TestErrForRange(t *testing.T) {
 	codeErrorTest(t, `./foo.gop:1:17 can't use return/continue/break/goto in for range of udt.Gop_Enum(callback)`,
 		func(pkg *gox.Package) {
@@ -280,6 +311,36 @@ func TestErrAssign(t *testing.T) {
 		})
 }
 
+func TestErrAssignTuple(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:8 cannot use error value as type int in assignment",
+		func(pkg *gox.Package) {
+			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
+			retErr := pkg.NewParam(position(1, 15), "", gox.TyError)
+			newFunc(pkg, 3, 5, 3, 7, nil, "bar", nil, types.NewTuple(retInt, retErr), false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "x", "y").
+				VarRef(ctxRef(pkg, "x")).VarRef(ctxRef(pkg, "y")).
+				Val(ctxRef(pkg, "bar")).
+				CallWith(0, false, false, source("bar()", 1, 8)).
+				AssignWith(2, 1, source("x, y = bar()", 1, 3)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:11 multiple-value bar() in single-value context",
+		func(pkg *gox.Package) {
+			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
+			retErr := pkg.NewParam(position(1, 15), "", gox.TyError)
+			newFunc(pkg, 3, 5, 3, 7, nil, "bar", nil, types.NewTuple(retInt, retErr), false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "x", "y").
+				VarRef(ctxRef(pkg, "x")).VarRef(ctxRef(pkg, "y")).
+				Val(1).
+				Val(ctxRef(pkg, "bar")).
+				CallWith(0, false, false, source("bar()", 1, 11)).
+				AssignWith(2, 2, source("x, y = 1, bar()", 1, 3)).
+				End()
+		})
+}
+
 func TestErrReturn(t *testing.T) {
 	codeErrorTest(t, `./foo.gop:2:9 cannot use "Hi" (type untyped string) as type error in return argument`,
 		func(pkg *gox.Package) {
@@ -357,6 +418,124 @@ func TestErrReturn(t *testing.T) {
 		})
 }
 
+func TestErrConstOverflow(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:7 constant 300 overflows byte",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(gox.TyByte, "x").
+				VarRef(ctxRef(pkg, "x")).
+				Val(300, source("300", 1, 7)).
+				AssignWith(1, 1, source("x = 300", 1, 3)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:7 constant 200 overflows int8",
+		func(pkg *gox.Package) {
+			arg := pkg.NewParam(position(1, 10), "v", types.Typ[types.Int8])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", types.NewTuple(arg), nil, false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "foo")).
+				Val(200, source("200", 2, 7)).
+				CallWith(1, false, false, source("foo(200)", 2, 3)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:12 constant -1 overflows uint",
+		func(pkg *gox.Package) {
+			ret := pkg.NewParam(position(1, 10), "", types.Typ[types.Uint])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				Val(-1, source("-1", 2, 12)).
+				Return(1, source("return -1", 2, 5)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:14 constant 128 overflows int8",
+		func(pkg *gox.Package) {
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int8], false),
+			}
+			tyStruc := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(128, source("128", 1, 14)).
+				StructLit(tyStruc, 1, false).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrUnsafeOffsetof(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:21 invalid argument: x is not a selector expression",
+		func(pkg *gox.Package) {
+			pkg.NewVar(token.NoPos, types.Typ[types.Int], "x")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Import("unsafe").Ref("Offsetof")).
+				Val(ctxRef(pkg, "x"), source("x", 2, 21)).
+				CallWith(1, false, false, source("unsafe.Offsetof(x)", 2, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:21 invalid argument: field y.a is embedded via a pointer",
+		func(pkg *gox.Package) {
+			e := pkg.NewType("E").InitType(pkg, types.NewStruct([]*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "a", types.Typ[types.Int], false),
+			}, nil))
+			y := pkg.NewType("Y").InitType(pkg, types.NewStruct([]*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "E", types.NewPointer(e), true),
+			}, nil))
+			pkg.NewVar(token.NoPos, y, "y")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Import("unsafe").Ref("Offsetof")).
+				Val(ctxRef(pkg, "y")).
+				MemberVal("a", source("y.a", 2, 21)).
+				CallWith(1, false, false, source("unsafe.Offsetof(y.a)", 2, 5)).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrConv(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:1:9 cannot convert "Hi" (type untyped string) to type int`,
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("Hi", source(`"Hi"`, 1, 9)).
+				Conv(types.Typ[types.Int], source(`int("Hi")`, 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:10 constant 300 overflows int8",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(300, source("300", 1, 10)).
+				Conv(types.Typ[types.Int8], source("int8(300)", 1, 5)).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrErrWrap(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:1:5 can't use strconv.Atoi("1")? in a function whose last result isn't an error`,
+		func(pkg *gox.Package) {
+			strconv := pkg.Import("strconv")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(strconv.Ref("Atoi")).Val("1").CallWith(1, false, false, source(`strconv.Atoi("1")`, 1, 5)).
+				ErrWrap(false).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5 len("Hi") (type int) doesn't return an error`,
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Builtin().Ref("len")).Val("Hi").CallWith(1, false, false, source(`len("Hi")`, 1, 5)).
+				ErrAssert().EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5 os.Remove("a") (no value) used as value`,
+		func(pkg *gox.Package) {
+			os := pkg.Import("os")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(os.Ref("Remove")).Val("a").CallWith(1, false, false, source(`os.Remove("a")`, 1, 5)).
+				Val(nil).ErrWrap(true).EndStmt().
+				End()
+		})
+}
+
 func TestErrInitFunc(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:1:5 func init must have no arguments and no return values", func(pkg *gox.Package) {
 		v := pkg.NewParam(token.NoPos, "v", gox.TyByte)
@@ -364,6 +543,41 @@ func TestErrInitFunc(t *testing.T) {
 	})
 }
 
+func TestErrMainFunc(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:5 func main must have no arguments and no return values", func(pkg *gox.Package) {
+		ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+		newFunc(pkg, 1, 5, 1, 7, nil, "main", nil, types.NewTuple(ret), false).BodyStart(pkg).End()
+	})
+	codeErrorTest(t, "function main is undeclared in the main package", func(pkg *gox.Package) {
+		pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).End()
+		if err := pkg.CheckMain(); err != nil {
+			panic(err)
+		}
+	})
+	codeErrorTest(t, "./foo.gop:1:5 cannot declare main - must be func", func(pkg *gox.Package) {
+		pkg.NewVar(position(1, 5), types.Typ[types.Int], "main")
+		if err := pkg.CheckMain(); err != nil {
+			panic(err)
+		}
+	})
+	codeErrorTest(t, "./foo.gop:1:5 cannot declare Main in the main package - the entry is func main",
+		func(pkg *gox.Package) {
+			newFunc(pkg, 1, 5, 1, 7, nil, "Main", nil, nil, false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+			if err := pkg.CheckMain(); err != nil {
+				panic(err)
+			}
+		})
+}
+
+func TestErrRecvName(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:7 receiver name foo shadows a package-level identifier", func(pkg *gox.Package) {
+		foo := pkg.NewType("foo").InitType(pkg, types.Typ[types.Int])
+		recv := pkg.NewParam(position(2, 7), "foo", foo)
+		newFunc(pkg, 2, 1, 2, 11, recv, "Bar", nil, nil, false).BodyStart(pkg).End()
+	})
+}
+
 func TestErrRecv(t *testing.T) {
 	tySlice := types.NewSlice(gox.TyByte)
 	codeErrorTest(t, "./foo.gop:1:9 invalid receiver type []byte ([]byte is not a defined type)", func(pkg *gox.Package) {
@@ -792,3 +1006,165 @@ func TestErrMemberRef(t *testing.T) {
 				End()
 		})
 }
+
+func TestErrInitStmts(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:1 can't emit init statements of labeled statement L",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Label("L").SetStmtPos(position(2, 1)).
+				/**/ For().DefineVarStart(0, "x").Val(1).EndInit(1).
+				/******/ DefineVarStart(0, "y").Val(2).EndInit(1).
+				/******/ None().Then().
+				/******/ Break("L").
+				/**/ End().
+				End()
+		})
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		Fset: gblFset, LoadPkgs: gblLoadPkgs, NodeInterpreter: nodeInterp{}, MaxInitStmts: 1})
+	defer func() {
+		e, ok := recover().(*gox.CodeError)
+		if !ok || e.Error() != "./foo.gop:3:1 switch statement has too many init statements (2 > 1)" {
+			t.Fatal("TestErrInitStmts:", e)
+		}
+	}()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		SetStmtPos(position(3, 1)).
+		/**/ Switch().DefineVarStart(0, "x").Val(1).EndInit(1).
+		/******/ DefineVarStart(0, "y").Val(2).EndInit(1).
+		/******/ Val(ctxRef(pkg, "y")).Then()
+}
+
+func TestErrBreakContinue(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:3 break is not in a loop, switch, or select",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				If().Val(true).Then().
+				/**/ Break("", source("break", 2, 3)).
+				End().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:3 continue is not in a loop",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Switch().None().Then().
+				/**/ Val(true).Case(1).
+				/**/ Continue("", source("continue", 2, 3)).
+				/**/ End().
+				End().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:3:5 break is not in a loop, switch, or select",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				For().Then().
+				/**/ NewClosure(nil, nil, false).BodyStart(pkg).
+				/******/ Break("", source("break", 3, 5)).
+				/******/ End().
+				/**/ EndStmt().
+				End().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:3:3 invalid continue label L",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Label("L").Switch().None().Then().
+				/**/ Val(true).Case(1).
+				/******/ For().Then().
+				/******/ Continue("L", source("continue L", 3, 3)).
+				/******/ End().
+				/**/ End().
+				End().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:3:3 invalid break label L",
+		func(pkg *gox.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Label("L").For().Then().
+				/**/ End().
+				For().Then().
+				/**/ Break("L", source("break L", 3, 3)).
+				/**/ End().
+				End()
+		})
+}
+
+func TestErrRecvOrDone(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:9 can't return ctx.Err() in a function whose last result isn't an error",
+		func(pkg *gox.Package) {
+			ctx := pkg.NewParam(token.NoPos, "ctx", pkg.Import("context").Ref("Context").Type())
+			ch := pkg.NewParam(token.NoPos, "ch", types.NewChan(types.SendRecv, types.Typ[types.Int]))
+			pkg.NewFunc(nil, "recv", types.NewTuple(ctx, ch), nil, false).BodyStart(pkg).
+				Val(ctx, source("ctx", 2, 9)).Val(ch).RecvOrDone("v", nil, nil).
+				End()
+		})
+}
+
+func TestErrMaxOverloadFuncs(t *testing.T) {
+	pos2Positions = map[token.Pos]token.Position{}
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		Fset: gblFset, LoadPkgs: gblLoadPkgs, NodeInterpreter: nodeInterp{}, MaxOverloadFuncs: 1})
+	fmt := pkg.Import("fmt")
+	println := gox.NewOverloadFunc(token.NoPos, pkg.Types, "println", fmt.Ref("Println"), fmt.Ref("Print"))
+	defer func() {
+		e, ok := recover().(*gox.CodeError)
+		if !ok || e.Error() != "./foo.gop:2:1 println has too many overload funcs (2 > 1)" {
+			t.Fatal("TestErrMaxOverloadFuncs:", e)
+		}
+	}()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(println, source("println", 2, 1)).Val(1).Call(1)
+}
+
+func TestErrTolerantMode(t *testing.T) {
+	pos2Positions = map[token.Pos]token.Position{}
+	conf := &gox.Config{
+		Fset:            gblFset,
+		LoadPkgs:        gblLoadPkgs,
+		NodeInterpreter: nodeInterp{},
+		TolerantMode:    true,
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	pkg.NewFunc(nil, "f", types.NewTuple(x), nil, false).BodyStart(pkg).End()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "a").
+		VarRef(ctxRef(pkg, "a")).Val("Hi", source(`"Hi"`, 2, 5)).Assign(1).
+		DefineVarStart(0, "b").
+		Val(ctxRef(pkg, "a"), source("a", 3, 6)).Val("x", source(`"x"`, 3, 10)).BinaryOp(token.ADD).
+		EndInit(1).
+		DefineVarStart(0, "c").Val(ctxRef(pkg, "b")).Val(1).BinaryOp(token.ADD).EndInit(1).
+		VarRef(ctxRef(pkg, "a")).Val(ctxRef(pkg, "c")).Assign(1).
+		Val(ctxRef(pkg, "f"), source("f", 5, 1)).Val("s", source(`"s"`, 5, 3)).CallWith(1, false, false, source(`f("s")`, 5, 1)).EndStmt().
+		DefineVarStart(0, "d").Val(300, source("300", 6, 13)).SliceLit(types.NewSlice(types.Typ[types.Int8]), 1).EndInit(1).
+		DefineVarStart(0, "e").Val(300, source("300", 7, 11)).Conv(types.Typ[types.Int8]).EndInit(1).
+		DefineVarStart(0, "g").Val("Hi", source(`"Hi"`, 8, 10)).Conv(types.Typ[types.Int]).EndInit(1).
+		End()
+	var msgs []string
+	for _, err := range cb.Errors() {
+		msgs = append(msgs, err.Error())
+	}
+	if ret := strings.Join(msgs, "\n"); ret != `./foo.gop:2:5 cannot use "Hi" (type untyped string) as type int in assignment
+TODO: boundType untyped string => int failed
+./foo.gop:5:3 cannot use "s" (type untyped string) as type int in argument to f
+./foo.gop:6:13 constant 300 overflows int8
+./foo.gop:7:11 constant 300 overflows int8
+./foo.gop:8:10 cannot convert "Hi" (type untyped string) to type int` {
+		t.Fatal("TestErrTolerantMode:", ret)
+	}
+	domTest(t, pkg, `package main
+
+func f(x int) {
+}
+func main() {
+	var a int
+	a = "Hi"
+	b := a + "x"
+	c := b + 1
+	a = c
+	f("s")
+	d := []int8{300}
+	e := int8(300)
+	g := int("Hi")
+}
+`)
+}
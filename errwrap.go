@@ -0,0 +1,145 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"log"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// ErrAssert func: expands the Go+ expression `expr!` (expr is on the top of
+// the stack), which panics if expr returns a non-nil error:
+//
+//	_autoGo_1, _autoGo_2 := expr
+//	if _autoGo_2 != nil {
+//		panic(_autoGo_2)
+//	}
+//
+// and then _autoGo_1 is pushed as the value of `expr!`. If the only result of
+// expr is an error, nothing is pushed.
+func (p *CodeBuilder) ErrAssert() *CodeBuilder {
+	if debugInstr {
+		log.Println("ErrAssert")
+	}
+	val, err := p.errWrapStart(p.stk.Pop())
+	p.Val(p.pkg.builtin.Scope().Lookup("panic")).Val(err).Call(1).EndStmt()
+	return p.errWrapEnd(val)
+}
+
+// ErrWrap func: expands the Go+ expression `expr?` or `expr?:defaultVal` (if
+// hasDefault is true, defaultVal is on the top of the stack, and expr is below
+// it). `expr?` returns the error to the caller, with zero values of other
+// results of the current function:
+//
+//	_autoGo_1, _autoGo_2 := expr
+//	if _autoGo_2 != nil {
+//		return 0, _autoGo_2
+//	}
+//
+// The last result of the current function must be an error. And
+// `expr?:defaultVal` uses defaultVal instead if expr returns a non-nil error:
+//
+//	_autoGo_1, _autoGo_2 := expr
+//	if _autoGo_2 != nil {
+//		_autoGo_1 = defaultVal
+//	}
+//
+// and then _autoGo_1 is pushed as the value of the expression. If the only
+// result of expr is an error, nothing is pushed (and a default value isn't
+// allowed).
+func (p *CodeBuilder) ErrWrap(hasDefault bool) *CodeBuilder {
+	if debugInstr {
+		log.Println("ErrWrap", hasDefault)
+	}
+	var defaultVal *internal.Elem
+	if hasDefault {
+		defaultVal = p.stk.Pop()
+	}
+	x := p.stk.Pop()
+	if !hasDefault {
+		if fn := p.current.fn; fn == nil || !lastIsError(fn.Type().(*types.Signature).Results()) {
+			src, pos := p.loadExpr(x.Src)
+			p.panicCodeErrorf(&pos, "can't use %s? in a function whose last result isn't an error", src)
+		}
+	}
+	val, err := p.errWrapStart(x)
+	if hasDefault {
+		if val == nil {
+			src, pos := p.loadExpr(x.Src)
+			p.panicCodeErrorf(&pos, "%s (no value) used as value", src)
+		}
+		p.VarRef(val).Val(defaultVal).Assign(1)
+	} else {
+		p.Val(err).ReturnErr(false)
+	}
+	return p.errWrapEnd(val)
+}
+
+// errWrapStart defines auto variables for results of x, and starts the body of
+// `if err != nil {...}`. It returns the value variable (nil if x returns an
+// error only) and the error variable.
+func (p *CodeBuilder) errWrapStart(x *internal.Elem) (val, err types.Object) {
+	var names []string
+	switch t := x.Type.(type) {
+	case *types.Tuple:
+		if !lastIsError(t) {
+			break
+		}
+		switch t.Len() {
+		case 2:
+			names = []string{p.pkg.autoName(), p.pkg.autoName()}
+		default:
+			src, pos := p.loadExpr(x.Src)
+			p.panicCodeErrorf(&pos, "multiple-value %s in single-value context", src)
+		}
+	default:
+		if types.Identical(t, TyError) {
+			names = []string{p.pkg.autoName()}
+		}
+	}
+	if names == nil {
+		src, pos := p.loadExpr(x.Src)
+		p.panicCodeErrorf(&pos, "%s (type %v) doesn't return an error", src, x.Type)
+	}
+	p.DefineVarStart(token.NoPos, names...)
+	p.stk.Push(x)
+	p.EndInit(1)
+	scope := p.current.scope
+	if len(names) > 1 {
+		val = scope.Lookup(names[0])
+	}
+	err = scope.Lookup(names[len(names)-1])
+	p.If().Val(err).CompareNil(token.NEQ).Then()
+	return
+}
+
+func (p *CodeBuilder) errWrapEnd(val types.Object) *CodeBuilder {
+	p.End()
+	if val != nil {
+		p.Val(val)
+	}
+	return p
+}
+
+func lastIsError(results *types.Tuple) bool {
+	n := results.Len()
+	return n > 0 && types.Identical(results.At(n-1).Type(), TyError)
+}
+
+// ----------------------------------------------------------------------------
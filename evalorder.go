@@ -0,0 +1,136 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+//
+// Lowering helpers which take multiple operands (eg. OptionalPair and
+// ResultPair) evaluate each operand exactly once and in source order, even if
+// an operand isn't used by the lowered expression. An unused operand is
+// dropped only if it isn't effectful (see isEffectful). Otherwise operands are
+// passed to a closure, whose parameters are temporaries of them:
+//
+//	func(_ T, ok bool) bool { return ok }(f(), true)
+
+// ExprClass classifies an expression by whether it is safe to duplicate or
+// reorder. See CodeBuilder.Classify.
+type ExprClass int
+
+const (
+	// ExprConst is a constant expression, eg. `1 << 10` or `math.Pi`.
+	ExprConst ExprClass = iota
+
+	// ExprPure is an expression without effects, eg. `x.y + 1`. It can be
+	// duplicated, but it can't be moved across an assignment to a variable it
+	// reads.
+	ExprPure
+
+	// ExprEffectful is an expression which may have effects, eg. `f()` or
+	// `<-ch`. It must be evaluated exactly once, and in source order.
+	ExprEffectful
+)
+
+// Classify reports whether elem (eg. an element of the stack) is a constant,
+// a pure expression, or an effectful one: calls (including conversions) and
+// receives, or expressions which may panic (indexing, slicing, type
+// assertions and dereferences).
+func (p *CodeBuilder) Classify(elem *Element) ExprClass {
+	if elem.CVal != nil {
+		return ExprConst
+	}
+	if isEffectful(elem.Val) {
+		return ExprEffectful
+	}
+	return ExprPure
+}
+
+// isEffectful reports whether evaluating expr may have observable effects:
+// calls (including conversions, which can't be told apart from calls
+// syntactically) and receives, or expressions which may panic (indexing,
+// slicing, type assertions and dereferences).
+func isEffectful(expr ast.Expr) bool {
+	switch v := expr.(type) {
+	case *ast.Ident, *ast.BasicLit, *ast.FuncLit, *bigConstExpr:
+		return false
+	case *ast.ParenExpr:
+		return isEffectful(v.X)
+	case *ast.SelectorExpr:
+		return isEffectful(v.X)
+	case *ast.UnaryExpr:
+		return v.Op == token.ARROW || isEffectful(v.X)
+	case *ast.BinaryExpr:
+		return isEffectful(v.X) || isEffectful(v.Y)
+	case *ast.KeyValueExpr:
+		return isEffectful(v.Key) || isEffectful(v.Value)
+	case *ast.CompositeLit:
+		for _, elt := range v.Elts {
+			if isEffectful(elt) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// pickValue replaces values (of typs) at the stack top by the i-th value. The
+// values may also be one tuple value (a call of a Go function).
+func (p *CodeBuilder) pickValue(typs []types.Type, i int) {
+	n := len(typs)
+	if t, ok := p.stk.Get(-1).Type.(*types.Tuple); ok && t.Len() == n {
+		n = 1
+	} else {
+		args := p.stk.GetArgs(n)
+		effectful := false
+		for j, arg := range args {
+			if j != i && isEffectful(arg.Val) {
+				effectful = true
+				break
+			}
+		}
+		if !effectful {
+			p.stk.Ret(n, args[i])
+			return
+		}
+	}
+	pkg := p.pkg
+	params := make([]*types.Var, len(typs))
+	for j, typ := range typs {
+		name := "_"
+		if j == i {
+			name = "v"
+		}
+		params[j] = pkg.NewParam(token.NoPos, name, typ)
+	}
+	ret := pkg.NewParam(token.NoPos, "", typs[i])
+	args := append([]*internal.Elem(nil), p.stk.GetArgs(n)...)
+	p.stk.PopN(n)
+	p.NewClosure(types.NewTuple(params...), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(params[i]).Return(1).
+		End()
+	for _, arg := range args {
+		p.stk.Push(arg)
+	}
+	p.Call(n)
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,181 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// ----------------------------------------------------------------------------
+
+// JSONVersion is the version of the schema used by ExportJSON. It is increased
+// when the schema changes incompatibly.
+const JSONVersion = 1
+
+// ExportJSON exports the normal (or testing) file of pkg as a typed AST in
+// JSON, for tools which aren't written in Go (eg. visualizers or playgrounds):
+//
+//	{"Version":1,"Package":"main","File":"gop_autogen.go","AST":{...}}
+//
+// Nodes of the AST are encoded as JSONPrinter does, with extra fields:
+//
+//	"Pos", "End"  [line, column] of the node in the Go source written by WriteTo
+//	"TypeOf"      type of an expression, eg. "[]fmt.Stringer"
+//	"ConstValue"  value of a constant expression, eg. "3"
+//	"Object"      the object an ident defines or refers to, eg.
+//	              {"Kind":"var","Type":"int","Def":true}
+//
+// Types are written in qualified form with package paths, except those of pkg.
+// Object kinds are one of "var", "field", "func", "const", "type", "package",
+// "builtin", "nil" and "label".
+//
+// The file is re-parsed and re-type-checked to resolve types and positions.
+// Errors of go/types are ignored, so types of some nodes may be missing if the
+// generated code is ill-typed.
+func ExportJSON(dst io.Writer, pkg *Package, testingFile bool) (err error) {
+	fset := token.NewFileSet()
+	var files [2]*ast.File
+	for i, name := range [2]string{"gop_autogen.go", "gop_autogen_test.go"} {
+		testing := i == 1
+		if testing && !testingFile {
+			break
+		}
+		var b bytes.Buffer
+		if err = WriteTo(&b, pkg, testing); err != nil {
+			return
+		}
+		if files[i], err = parser.ParseFile(fset, name, b.Bytes(), parser.ParseComments); err != nil {
+			return
+		}
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := &types.Config{
+		Importer: pkgImporter(pkg),
+		Error:    func(err error) {},
+	}
+	checkFiles := files[:1]
+	if testingFile {
+		checkFiles = files[:]
+	}
+	checked, _ := conf.Check(pkg.Types.Path(), fset, checkFiles, info)
+	f := files[getInTestingFile(testingFile)]
+	qf := types.RelativeTo(checked)
+	dumper := &astDumper{onNode: func(node ast.Node, dump *astNode) {
+		dump.fields = append(dump.fields,
+			astField{"Pos", jsonPos(fset, node.Pos())}, astField{"End", jsonPos(fset, node.End())})
+		if expr, ok := node.(ast.Expr); ok {
+			if tv, ok := info.Types[expr]; ok && tv.Type != nil {
+				dump.fields = append(dump.fields, astField{"TypeOf", types.TypeString(tv.Type, qf)})
+				if tv.Value != nil {
+					dump.fields = append(dump.fields, astField{"ConstValue", tv.Value.ExactString()})
+				}
+			}
+		}
+		if id, ok := node.(*ast.Ident); ok {
+			if o, def := info.Defs[id], true; o != nil || info.Uses[id] != nil {
+				if o == nil {
+					o, def = info.Uses[id], false
+				}
+				dump.fields = append(dump.fields, astField{"Object", jsonObject(o, def, qf)})
+			}
+		}
+	}}
+	var b bytes.Buffer
+	b.WriteString(`{"Version":` + strconv.Itoa(JSONVersion))
+	b.WriteString(`,"Package":` + strconv.Quote(pkg.Types.Name()))
+	b.WriteString(`,"File":` + strconv.Quote(fset.Position(f.Pos()).Filename))
+	b.WriteString(`,"AST":`)
+	writeJSON(&b, dumper.dump(reflect.ValueOf(f)))
+	b.WriteString("}\n")
+	_, err = dst.Write(b.Bytes())
+	return
+}
+
+func jsonPos(fset *token.FileSet, pos token.Pos) []interface{} {
+	position := fset.Position(pos)
+	return []interface{}{int64(position.Line), int64(position.Column)}
+}
+
+func jsonObject(o types.Object, def bool, qf types.Qualifier) *astNode {
+	kind := objectKind(o)
+	ret := &astNode{fields: []astField{{"Kind", kind}}}
+	if typ := o.Type(); typ != nil && kind != "package" && kind != "builtin" && kind != "label" {
+		ret.fields = append(ret.fields, astField{"Type", types.TypeString(typ, qf)})
+	}
+	if def {
+		ret.fields = append(ret.fields, astField{"Def", true})
+	}
+	return ret
+}
+
+func objectKind(o types.Object) (kind string) {
+	switch v := o.(type) {
+	case *types.Var:
+		if kind = "var"; v.IsField() {
+			kind = "field"
+		}
+	case *types.Func, *TemplateFunc:
+		kind = "func"
+	case *types.Const:
+		kind = "const"
+	case *types.TypeName:
+		switch v.Type().(type) {
+		case *overloadFuncType, *instructionType:
+			kind = "builtin"
+		default:
+			kind = "type"
+		}
+	case *types.PkgName:
+		kind = "package"
+	case *types.Builtin:
+		kind = "builtin"
+	case *types.Nil:
+		kind = "nil"
+	case *types.Label:
+		kind = "label"
+	}
+	return
+}
+
+// pkgImporter returns an importer which imports packages that pkg imports.
+func pkgImporter(pkg *Package) types.Importer {
+	return importerFunc(func(path string) (*types.Package, error) {
+		for i := range pkg.files {
+			if ref, ok := pkg.files[i].importPkgs[path]; ok && ref.Types != nil {
+				return ref.Types, nil
+			}
+		}
+		return nil, errors.New("package not imported: " + path)
+	})
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// ----------------------------------------------------------------------------
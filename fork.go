@@ -0,0 +1,220 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+)
+
+// ----------------------------------------------------------------------------
+
+// Fork returns a fork of the package, which builds bodies of funcs of the
+// package in another goroutine. So bodies of funcs of a large package can be
+// built concurrently, each by a fork of the package:
+//
+//	forks := make([]*gox.Package, len(fns))
+//	for i, fn := range fns {
+//		forks[i] = pkg.Fork()
+//		wg.Add(1)
+//		go func(fork *gox.Package, fn *gox.Func) {
+//			defer wg.Done()
+//			fn.BodyStart(fork).
+//				...
+//				End()
+//		}(forks[i], fn)
+//	}
+//	wg.Wait()
+//	pkg.Join(forks...)
+//
+// A fork has its own CodeBuilder, imports and temporaries, which are merged
+// into the package by Join. But it shares the package scope, which isn't
+// synchronized: funcs, types and other package-level objects should be
+// declared before the package is forked, and the package shouldn't be changed
+// until its forks are joined. Helper types (eg. Option structs) instantiated
+// by forks are shared by them (see OptionalStruct and ResultStruct), and they
+// are added to the package scope by Join. Note that hooks of Config (eg.
+// HandleErr, Progress and LoadPkgs) may be called concurrently. The default
+// LoadPkgs is goroutine-safe.
+func (p *Package) Fork() *Package {
+	files := make([]*file, len(p.files))
+	for i, f := range p.files {
+		files[i] = &file{importPkgs: make(map[string]*PkgRef), tag: f.tag}
+	}
+	fork := &Package{
+		PkgRef:     PkgRef{Types: p.Types},
+		files:      files,
+		conf:       p.conf,
+		modPath:    p.modPath,
+		prefix:     p.prefix,
+		opr:        p.opr,
+		Fset:       p.Fset,
+		builtin:    p.builtin,
+		shared:     p.shared,
+		utBigInt:   p.utBigInt,
+		utBigRat:   p.utBigRat,
+		utBigFlt:   p.utBigFlt,
+		sizes:      p.sizes,
+		loadPkgs:   p.loadPkgs,
+		autoPrefix: p.autoPrefix,
+		curFile:    p.curFile,
+		options:    p.options,
+		results:    p.results,
+		parent:     p,
+	}
+	fork.cb.init(fork)
+	// scopes of func bodies built by the fork are children of its own scope,
+	// instead of the package scope
+	fork.cb.current.scope = types.NewScope(p.Types.Scope(), token.NoPos, token.NoPos, "fork")
+	if pr := p.cb.progress; pr != nil { // the time budget starts when p is created
+		fork.cb.progress = &progress{report: pr.report, interval: pr.interval, budget: pr.budget, deadline: pr.deadline}
+	}
+	return fork
+}
+
+// Join merges forks of the package (see Fork) into it, after bodies of funcs
+// built by the forks are ended. Imports and temporaries of the forks are added
+// to the package, and errors recorded by CodeBuilders of the forks in tolerant
+// mode are added to the CodeBuilder of the package (see CodeBuilder.Errors).
+func (p *Package) Join(forks ...*Package) {
+	for _, fork := range forks {
+		if fork.parent != p {
+			panic("Join: not a fork of the package")
+		}
+		for i, f := range fork.files {
+			idx := i
+			if i >= 2 { // tagged files may be created in different orders
+				idx = p.taggedFile(f.tag)
+			}
+			to := p.files[idx]
+			to.decls = append(to.decls, f.decls...)
+			to.removedExprs = to.removedExprs || f.removedExprs
+			for _, pkgPath := range f.allPkgPaths {
+				to.joinImport(p, f.importPkgs[pkgPath])
+			}
+			if f.pkgBig != nil && to.pkgBig == nil {
+				to.pkgBig = to.importPkgs[f.pkgBig.path]
+			}
+		}
+		for obj, decl := range fork.objDecls {
+			p.setDecl(obj, decl)
+		}
+		for obj, data := range fork.objData {
+			p.SetDeclData(obj, data)
+		}
+		for v, t := range fork.temps {
+			if p.temps == nil {
+				p.temps = make(map[*types.Var]*TempInfo)
+			}
+			p.temps[v] = t
+		}
+		for name := range fork.tmpNames {
+			p.tempNameUsed(name)
+		}
+		p.bigConsts = p.bigConsts || fork.bigConsts
+		p.fwdRefs = append(p.fwdRefs, fork.fwdRefs...)
+		p.cb.errs = append(p.cb.errs, fork.cb.errs...)
+		p.joinObjs(fork)
+		fork.parent = nil
+	}
+}
+
+// forkObj is a package-level object declared by a fork, which is added to the
+// package scope when the fork is joined.
+type forkObj struct {
+	obj  types.Object
+	fork *Package
+}
+
+// insertObj inserts a package-level object obj into the package scope, or
+// returns the object with the same name if it exists. The package scope is
+// shared by forks (see Fork), so objects declared by a fork (eg. helper types,
+// see instType) are kept by the root package, until the fork is joined. The
+// lock of the root package must be held if p is a fork.
+func (p *Package) insertObj(obj types.Object) types.Object {
+	if p.parent == nil {
+		return p.Types.Scope().Insert(obj)
+	}
+	if o := p.lookupObj(obj.Name()); o != nil {
+		return o
+	}
+	root := p.root()
+	root.forkObjs = append(root.forkObjs, forkObj{obj, p})
+	return nil
+}
+
+// lookupObj looks up a package-level object by name, including objects
+// declared by forks which aren't joined (see insertObj).
+func (p *Package) lookupObj(name string) types.Object {
+	if o := p.Types.Scope().Lookup(name); o != nil {
+		return o
+	}
+	for _, fo := range p.root().forkObjs {
+		if fo.obj.Name() == name {
+			return fo.obj
+		}
+	}
+	return nil
+}
+
+// joinObjs adds package-level objects declared by fork to the package scope, or
+// to objects declared by p if p is a fork too.
+func (p *Package) joinObjs(fork *Package) {
+	root := p.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	objs := root.forkObjs[:0]
+	for _, fo := range root.forkObjs {
+		if fo.fork == fork {
+			if p == root {
+				p.Types.Scope().Insert(fo.obj)
+				continue
+			}
+			fo.fork = p
+		}
+		objs = append(objs, fo)
+	}
+	root.forkObjs = objs
+}
+
+// root returns the package which p is forked from (see Fork), or p itself if
+// it isn't a fork.
+func (p *Package) root() *Package {
+	for p.parent != nil {
+		p = p.parent
+	}
+	return p
+}
+
+// joinImport merges ref, a package imported by a fork, into imports of p.
+func (p *file) joinImport(this *Package, ref *PkgRef) {
+	to, ok := p.importPkgs[ref.path]
+	if !ok {
+		ref.pkg, ref.file = this, p
+		p.importPkgs[ref.path] = ref
+		p.allPkgPaths = append(p.allPkgPaths, ref.path)
+		if ref.Types == nil {
+			p.delayPkgPaths = append(p.delayPkgPaths, ref.path)
+		}
+		return
+	}
+	if to.Types == nil && ref.Types != nil { // loaded by the fork
+		to.ID, to.Types, to.IllTyped, to.pkgf, to.docs = ref.ID, ref.Types, ref.IllTyped, ref.pkgf, ref.docs
+		p.delayPkgPaths = removePkgPath(p.delayPkgPaths, ref.path)
+	}
+	to.nameRefs = append(to.nameRefs, ref.nameRefs...)
+	to.isForceUsed = to.isForceUsed || ref.isForceUsed
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,263 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gox/internal/go/format"
+	"github.com/goplus/gox/internal/go/printer"
+)
+
+// ----------------------------------------------------------------------------
+
+// FormatOptions is a Printer which writes a file as Go code in the style of
+// the destination repository, instead of the canonical gofmt style. If it is
+// specified by Config.Format, it is used by WriteTo (and WriteFile, etc.) to
+// write a package in GoSyntax.
+type FormatOptions struct {
+	// TabWidth is the width of a tab. If TabWidth is 0, 8 is used.
+	TabWidth int
+
+	// SpaceIndent is to indent by TabWidth spaces instead of a tab.
+	SpaceIndent bool
+
+	// GroupImports is to sort imports, and to group them into imports of the
+	// standard library and other imports, which are separated by a blank line
+	// (as goimports does).
+	GroupImports bool
+
+	// MaxLineLen is the max width of a line (tabs are TabWidth wide). If a
+	// line is longer, args of a call, elements of a composite literal or params
+	// of a func on it are broken one per line (the outermost list first), as
+	// long as it is still longer. If MaxLineLen is 0, lines aren't broken.
+	MaxLineLen int
+}
+
+// Print writes f as Go code with the options.
+func (p *FormatOptions) Print(dst io.Writer, f *ast.File) error {
+	cfg := format.Config()
+	if p.TabWidth > 0 {
+		cfg.Tabwidth = p.TabWidth
+	}
+	if p.SpaceIndent {
+		cfg.Mode &^= printer.TabIndent
+	}
+	fset := token.NewFileSet()
+	if p.GroupImports {
+		f = groupImports(fset, f)
+	}
+	if p.MaxLineLen <= 0 {
+		return format.NodeWith(dst, fset, f, &cfg)
+	}
+	var b bytes.Buffer
+	if err := format.NodeWith(&b, fset, f, &cfg); err != nil {
+		return err
+	}
+	src := b.Bytes()
+	for {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		breaks := lineBreaks(fset, f, longLines(src, cfg.Tabwidth, p.MaxLineLen))
+		if breaks == nil {
+			_, err = dst.Write(src)
+			return err
+		}
+		src = breakLines(src, breaks)
+		if f, err = parser.ParseFile(fset, "", src, parser.ParseComments); err != nil {
+			return err
+		}
+		b.Reset()
+		if err = format.NodeWith(&b, fset, f, &cfg); err != nil { // reindent broken lines
+			return err
+		}
+		src = b.Bytes()
+	}
+}
+
+// longLines returns lines of src which are longer than maxLen.
+func longLines(src []byte, tabWidth, maxLen int) map[int]bool {
+	var lines map[int]bool
+	for i, line := range bytes.Split(src, []byte{'\n'}) {
+		width := 0
+		for _, c := range string(line) {
+			if c == '\t' {
+				width += tabWidth - width%tabWidth
+			} else {
+				width++
+			}
+		}
+		if width > maxLen {
+			if lines == nil {
+				lines = make(map[int]bool)
+			}
+			lines[i+1] = true
+		}
+	}
+	return lines
+}
+
+// lineBreaks returns offsets where src of f (parsed in fset) is broken by
+// breakLines, to break the outermost single-line list (args, elements or
+// params) on each line of lines. It returns nil if there is none.
+func lineBreaks(fset *token.FileSet, f *ast.File, lines map[int]bool) (breaks []lineBreak) {
+	if lines == nil {
+		return
+	}
+	tf := fset.File(f.Pos())
+	ast.Inspect(f, func(n ast.Node) bool {
+		var opening, closing token.Pos
+		var list []ast.Node
+		switch v := n.(type) {
+		case *ast.CallExpr:
+			opening, closing = v.Lparen, v.Rparen
+			for _, arg := range v.Args {
+				list = append(list, arg)
+			}
+			if v.Ellipsis.IsValid() && len(list) > 0 {
+				list[len(list)-1] = ellipsisArg{list[len(list)-1], v.Ellipsis}
+			}
+		case *ast.CompositeLit:
+			opening, closing = v.Lbrace, v.Rbrace
+			for _, elt := range v.Elts {
+				list = append(list, elt)
+			}
+		case *ast.FuncType:
+			if v.Params == nil {
+				return true
+			}
+			opening, closing = v.Params.Opening, v.Params.Closing
+			for _, field := range v.Params.List {
+				list = append(list, field)
+			}
+		default:
+			return true
+		}
+		line := tf.Line(opening)
+		if len(list) == 0 || !lines[line] || tf.Line(closing) != line {
+			return true
+		}
+		delete(lines, line) // break the outermost list only
+		breaks = append(breaks, lineBreak{tf.Offset(opening) + 1, false})
+		for _, x := range list {
+			breaks = append(breaks, lineBreak{tf.Offset(x.End()), true})
+		}
+		return true
+	})
+	return
+}
+
+// ellipsisArg is the last arg of a call with `...`, which ends after it.
+type ellipsisArg struct {
+	ast.Node
+	ellipsis token.Pos
+}
+
+func (p ellipsisArg) End() token.Pos {
+	return p.ellipsis + 3
+}
+
+type lineBreak struct {
+	offset int
+	comma  bool // a comma follows the offset (it is inserted if it doesn't)
+}
+
+// breakLines inserts a newline at each offset of breaks into src.
+func breakLines(src []byte, breaks []lineBreak) []byte {
+	sort.Slice(breaks, func(i, j int) bool {
+		return breaks[i].offset < breaks[j].offset
+	})
+	ret := make([]byte, 0, len(src)+2*len(breaks))
+	last := 0
+	for _, brk := range breaks {
+		off := brk.offset
+		ret = append(ret, src[last:off]...)
+		if brk.comma {
+			if src[off] == ',' {
+				off++
+			}
+			ret = append(ret, ',')
+		}
+		ret = append(ret, '\n')
+		last = off
+	}
+	return append(ret, src[last:]...)
+}
+
+// groupImports returns a copy of f whose imports are sorted and grouped. The
+// blank line between groups is printed by positions of import specs, which are
+// lines of a fake file in fset.
+func groupImports(fset *token.FileSet, f *ast.File) *ast.File {
+	if len(f.Decls) == 0 {
+		return f
+	}
+	decl, ok := f.Decls[0].(*ast.GenDecl)
+	if !ok || decl.Tok != token.IMPORT {
+		return f
+	}
+	specs := make([]*ast.ImportSpec, len(decl.Specs))
+	for i, spec := range decl.Specs {
+		specs[i] = spec.(*ast.ImportSpec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		stdi, stdj := isStdImport(specs[i]), isStdImport(specs[j])
+		if stdi != stdj {
+			return stdi
+		}
+		return specs[i].Path.Value < specs[j].Path.Value
+	})
+	lines := make([]int, 2*len(specs)+1)
+	for i := range lines {
+		lines[i] = i
+	}
+	file := fset.AddFile("", -1, len(lines))
+	file.SetLines(lines)
+	newSpecs := make([]ast.Spec, len(specs))
+	line := 0
+	for i, spec := range specs {
+		if i > 0 && isStdImport(specs[i-1]) != isStdImport(spec) {
+			line++ // a blank line between groups
+		}
+		pos := file.Pos(line)
+		spec := &ast.ImportSpec{Path: &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: spec.Path.Value}}
+		if name := specs[i].Name; name != nil {
+			spec.Name = &ast.Ident{NamePos: pos, Name: name.Name}
+		}
+		newSpecs[i] = spec
+		line++
+	}
+	ret := *f
+	ret.Decls = append([]ast.Decl{&ast.GenDecl{Tok: token.IMPORT, Specs: newSpecs}}, f.Decls[1:]...)
+	return &ret
+}
+
+func isStdImport(spec *ast.ImportSpec) bool {
+	path, _ := strconv.Unquote(spec.Path.Value)
+	if pos := strings.IndexByte(path, '/'); pos >= 0 {
+		path = path[:pos]
+	}
+	return !strings.Contains(path, ".")
+}
+
+// ----------------------------------------------------------------------------
@@ -19,6 +19,9 @@ import (
 	"go/token"
 	"go/types"
 	"log"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/goplus/gox/internal"
 )
@@ -50,13 +53,239 @@ func NewTuple(x ...*Param) *Tuple {
 	return types.NewTuple(x...)
 }
 
+// ParamRenamer is called by CopySignature to choose the name of the i-th
+// parameter, whose original name is name (which may be empty or "_").
+type ParamRenamer = func(i int, name string) string
+
+// CopySignature returns a copy of sig whose receiver, parameters and results
+// are owned by pkg, eg. to declare a wrapper of an imported function. Names of
+// parameters are chosen by renamer. If renamer is nil, unnamed (or blank)
+// parameters are named argN (with trailing underscores if the name is used),
+// so that they can be passed to the wrapped function. Named results are
+// renamed the same way if their names are used by parameters.
+func CopySignature(pkg *Package, sig *types.Signature, renamer ParamRenamer) *types.Signature {
+	params := sig.Params()
+	n := params.Len()
+	used := make(map[string]bool, n)
+	if renamer == nil {
+		for i := 0; i < n; i++ {
+			used[params.At(i).Name()] = true
+		}
+		renamer = func(i int, name string) string {
+			if name == "" || name == "_" {
+				name = "arg" + strconv.Itoa(i)
+				for used[name] {
+					name += "_"
+				}
+			}
+			return name
+		}
+	}
+	vars := make([]*types.Var, n)
+	for i := 0; i < n; i++ {
+		v := params.At(i)
+		name := renamer(i, v.Name())
+		used[name] = true
+		vars[i] = types.NewParam(v.Pos(), pkg.Types, name, v.Type())
+	}
+	results := sig.Results()
+	rets := make([]*types.Var, results.Len())
+	for i := range rets {
+		v := results.At(i)
+		name := v.Name()
+		if name != "" && name != "_" {
+			for used[name] {
+				name += "_"
+			}
+		}
+		rets[i] = types.NewParam(v.Pos(), pkg.Types, name, v.Type())
+	}
+	var recv *types.Var
+	if v := sig.Recv(); v != nil {
+		recv = types.NewParam(v.Pos(), pkg.Types, v.Name(), v.Type())
+	}
+	return types.NewSignature(recv, types.NewTuple(vars...), types.NewTuple(rets...), sig.Variadic())
+}
+
+// SignatureBuilder builds a function signature step by step:
+//
+//	sig := pkg.NewSignatureBuilder().
+//		Param("format", types.Typ[types.String]).
+//		Variadic("args", types.NewInterfaceType(nil, nil)).
+//		Result("n", types.Typ[types.Int]).Result("err", gox.TyError)
+//	fn := sig.NewFunc("Printf")
+//
+// All parameters are owned by the package which creates the builder.
+type SignatureBuilder struct {
+	pkg      *Package
+	recv     *Param
+	params   []*Param
+	results  []*Param
+	variadic bool
+}
+
+// NewSignatureBuilder creates a SignatureBuilder of a function without
+// receiver, parameters and results.
+func (p *Package) NewSignatureBuilder() *SignatureBuilder {
+	return &SignatureBuilder{pkg: p}
+}
+
+// Recv sets the receiver of the function.
+func (p *SignatureBuilder) Recv(name string, typ types.Type) *SignatureBuilder {
+	p.recv = p.pkg.NewParam(token.NoPos, name, typ)
+	return p
+}
+
+// Param appends a parameter. It panics if the variadic parameter has been
+// appended.
+func (p *SignatureBuilder) Param(name string, typ types.Type) *SignatureBuilder {
+	if p.variadic {
+		log.Panicln("SignatureBuilder: parameter after the variadic parameter -", name)
+	}
+	p.params = append(p.params, p.pkg.NewParam(token.NoPos, name, typ))
+	return p
+}
+
+// Variadic appends the variadic parameter `name ...elem` (whose type is
+// []elem), which should be the last parameter.
+func (p *SignatureBuilder) Variadic(name string, elem types.Type) *SignatureBuilder {
+	p.Param(name, types.NewSlice(elem))
+	p.variadic = true
+	return p
+}
+
+// Result appends a result. Either all results are named, or none of them is.
+func (p *SignatureBuilder) Result(name string, typ types.Type) *SignatureBuilder {
+	if n := len(p.results); n > 0 && (p.results[n-1].Name() == "") != (name == "") {
+		log.Panicln("SignatureBuilder: mixed named and unnamed results -", name)
+	}
+	p.results = append(p.results, p.pkg.NewParam(token.NoPos, name, typ))
+	return p
+}
+
+// Signature returns the signature built.
+func (p *SignatureBuilder) Signature() *types.Signature {
+	return types.NewSignature(p.recv, types.NewTuple(p.params...), types.NewTuple(p.results...), p.variadic)
+}
+
+// FuncType returns the ast function type of the signature built, and the ast
+// field list of its receiver (nil if it has no receiver). Types of other
+// packages are imported into the current file of the package.
+func (p *SignatureBuilder) FuncType() (recv *ast.FieldList, ft *ast.FuncType) {
+	if p.recv != nil {
+		recv = toRecv(p.pkg, p.recv)
+	}
+	return recv, toFuncType(p.pkg, p.Signature())
+}
+
+// NewFunc declares a function (or a method if the receiver is set) named name
+// by the signature built. See Package.NewFunc.
+func (p *SignatureBuilder) NewFunc(name string) *Func {
+	return p.pkg.NewFunc(p.recv, name, types.NewTuple(p.params...), types.NewTuple(p.results...), p.variadic)
+}
+
+// NewDelegates declares methods of the struct type t which delegate to the
+// embedded field named embedded, eg. to override some methods of the embedded
+// field explicitly and forward the others. If methods are given, only these
+// methods are delegated. Otherwise all exported methods of the embedded field
+// are delegated, except the ones already declared by t. The methods are
+// declared with pointer receivers.
+func (p *Package) NewDelegates(t *types.Named, embedded string, methods ...string) []*Func {
+	struc, ok := getUnderlying(p, t).(*types.Struct)
+	if !ok {
+		log.Panicln("NewDelegates: not a struct type -", t)
+	}
+	var fld *types.Var
+	for i, n := 0, struc.NumFields(); i < n; i++ {
+		if v := struc.Field(i); v.Embedded() && v.Name() == embedded {
+			fld = v
+			break
+		}
+	}
+	if fld == nil {
+		log.Panicln("NewDelegates: no embedded field -", embedded)
+	}
+	typ := fld.Type()
+	if _, ok := typ.(*types.Pointer); !ok && !types.IsInterface(typ) {
+		typ = types.NewPointer(typ) // the embedded field of *t is addressable
+	}
+	mset := types.NewMethodSet(typ)
+	var delegates []*types.Func
+	if methods == nil {
+		for i, n := 0, mset.Len(); i < n; i++ {
+			m := mset.At(i).Obj().(*types.Func)
+			if m.Exported() && !hasMethod(t, m.Name()) {
+				delegates = append(delegates, m)
+			}
+		}
+	} else {
+	next:
+		for _, name := range methods {
+			for i, n := 0, mset.Len(); i < n; i++ {
+				if m := mset.At(i).Obj().(*types.Func); m.Name() == name {
+					delegates = append(delegates, m)
+					continue next
+				}
+			}
+			log.Panicln("NewDelegates: no method -", embedded+"."+name)
+		}
+	}
+	fns := make([]*Func, len(delegates))
+	for i, m := range delegates {
+		fn, err := p.newDelegate(t, embedded, m)
+		if err != nil {
+			panic(err)
+		}
+		fns[i] = fn
+	}
+	return fns
+}
+
+// newDelegate declares the method m on *t, which delegates to the field named
+// field of t (see NewDelegates and NewForwardMethods). Its signature is copied
+// from m by CopySignature.
+func (p *Package) newDelegate(t *types.Named, field string, m *types.Func) (*Func, error) {
+	sig := CopySignature(p, m.Type().(*types.Signature), nil)
+	recv := types.NewParam(token.NoPos, p.Types, "", types.NewPointer(t))
+	sig = types.NewSignature(recv, sig.Params(), sig.Results(), sig.Variadic())
+	fn, err := p.NewFuncWith(token.NoPos, m.Name(), sig, nil)
+	if err != nil {
+		return nil, err
+	}
+	sig = fn.Type().(*types.Signature)
+	cb := fn.BodyStart(p).Val(sig.Recv()).MemberVal(field).MemberVal(m.Name())
+	params := sig.Params()
+	for i, n := 0, params.Len(); i < n; i++ {
+		cb.Val(params.At(i))
+	}
+	cb.CallWith(params.Len(), sig.Variadic(), false)
+	if sig.Results().Len() > 0 {
+		cb.Return(1)
+	} else {
+		cb.EndStmt()
+	}
+	cb.End()
+	return fn, nil
+}
+
+func hasMethod(t *types.Named, name string) bool {
+	for i, n := 0, t.NumMethods(); i < n; i++ {
+		if t.Method(i).Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ----------------------------------------------------------------------------
 
 // Func type
 type Func struct {
 	*types.Func
-	decl *ast.FuncDecl
-	old  funcBodyCtx
+	decl    *ast.FuncDecl
+	old     funcBodyCtx
+	file    int // index of the file where fn is declared (see BodyStart)
+	oldFile int
 }
 
 // BodyStart func
@@ -74,6 +303,9 @@ func (p *Func) BodyStart(pkg *Package) *CodeBuilder {
 		}
 		log.Printf("%v%v%v %v\n", tag, name, recv, sig)
 	}
+	if p.decl != nil { // the body is built in the file where fn is declared
+		p.oldFile, pkg.curFile = pkg.curFile, p.file
+	}
 	return pkg.cb.startFuncBody(p, &p.old)
 }
 
@@ -94,6 +326,10 @@ func (p *Func) End(cb *CodeBuilder) {
 		if recv := t.Recv(); recv != nil {
 			fn.Recv = toRecv(pkg, recv)
 		}
+		pkg.curFile = p.oldFile
+		if pkg.conf.ReuseElems && cb.current.fn == nil && cb.current.outer == nil && cb.stk.Len() == 0 {
+			pkg.elems.Reset() // no func body is open and no element is in use
+		}
 	}
 }
 
@@ -121,7 +357,6 @@ func (p *Package) NewFuncWith(
 		panic("no func name")
 	}
 	cb := p.cb
-	fn := types.NewFunc(pos, p.Types, name, sig)
 	if recv := sig.Recv(); recv != nil { // add method to this type
 		var t *types.Named
 		var ok bool
@@ -145,20 +380,131 @@ func (p *Package) NewFuncWith(
 			return nil, cb.newCodePosErrorf(
 				getRecv(recvTypePos), "invalid receiver type %v (%v is a pointer type)", typ, typ)
 		}
+		if recvName := recv.Name(); recvName == "" { // auto-pick a receiver name
+			recvName = p.recvName(t, sig)
+			recv = types.NewParam(recv.Pos(), recv.Pkg(), recvName, recv.Type())
+			sig = types.NewSignature(recv, sig.Params(), sig.Results(), sig.Variadic())
+		} else if recvName != "_" {
+			if p.Types.Scope().Lookup(recvName) != nil {
+				return nil, cb.newCodePosErrorf(
+					recv.Pos(), "receiver name %s shadows a package-level identifier", recvName)
+			}
+		}
+		fn := types.NewFunc(pos, p.Types, name, sig)
 		t.AddMethod(fn)
-	} else if name == "init" { // init is not a normal func
+		return p.newFuncDecl(fn), nil
+	}
+	switch name {
+	case "init": // init is not a normal func
 		if sig.Params() != nil || sig.Results() != nil {
 			return nil, cb.newCodePosError(
 				pos, "func init must have no arguments and no return values")
 		}
-	} else {
-		p.Types.Scope().Insert(fn)
+	case "main":
+		if p.IsMain() && (sig.Params() != nil || sig.Results() != nil) {
+			return nil, cb.newCodePosError(
+				pos, "func main must have no arguments and no return values")
+		}
+	}
+	fn := types.NewFunc(pos, p.Types, name, sig)
+	if name != "init" {
+		p.insertObj(fn)
 	}
+	return p.newFuncDecl(fn), nil
+}
 
+func (p *Package) newFuncDecl(fn *types.Func) *Func {
 	decl := &ast.FuncDecl{}
-	idx := p.testingFile
+	idx := p.curFile
 	p.files[idx].decls = append(p.files[idx].decls, decl)
-	return &Func{Func: fn, decl: decl}, nil
+	p.setDecl(fn, decl)
+	return &Func{Func: fn, decl: decl, file: idx}
+}
+
+// DeleteFunc deletes the declaration of fn, eg. to update a long-lived package
+// in a REPL or a language server without rebuilding it. It returns false if fn
+// isn't declared. Note that go/types can't delete objects, so fn is still in
+// the package scope (or the method set of its receiver type). Use ReplaceFunc
+// to declare it again.
+func (p *Package) DeleteFunc(fn *Func) bool {
+	for i := range p.files {
+		f := p.files[i]
+		for j, decl := range f.decls {
+			if decl == fn.decl {
+				f.decls = append(f.decls[:j:j], f.decls[j+1:]...)
+				f.removedExprs = true
+				delete(p.objDecls, fn.Func)
+				delete(p.objData, fn.Func)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReplaceFunc discards the body of fn and starts a new body, eg. to update a
+// function of a long-lived package. The signature of fn is unchanged. If fn is
+// deleted by DeleteFunc, it is declared again (in the current file).
+func (p *Package) ReplaceFunc(fn *Func) *CodeBuilder {
+	if fn.decl == nil {
+		panic("ReplaceFunc: can't replace a closure")
+	}
+	found := false
+	for i := range p.files {
+		f := p.files[i]
+		for _, decl := range f.decls {
+			if decl == fn.decl {
+				f.removedExprs, found = true, true
+				fn.file = i
+			}
+		}
+	}
+	if !found {
+		idx := p.curFile
+		p.files[idx].decls = append(p.files[idx].decls, fn.decl)
+		p.setDecl(fn.Func, fn.decl)
+		fn.file = idx
+	}
+	fn.decl.Body = nil
+	return fn.BodyStart(p)
+}
+
+// recvName chooses a receiver name for a method of type t: by default it is
+// the lower-cased first letter of the type name, renamed if it collides with
+// a parameter/result name or a package-level identifier.
+func (p *Package) recvName(t *types.Named, sig *types.Signature) string {
+	var name string
+	if recvName := p.conf.RecvName; recvName != nil {
+		name = recvName(t)
+	} else if tname := t.Obj().Name(); tname != "" {
+		r, _ := utf8.DecodeRuneInString(tname)
+		name = string(unicode.ToLower(r))
+	}
+	if name == "" || name == "_" {
+		name = "p"
+	}
+	ret := name
+	for i := 1; p.recvNameUsed(ret, sig); i++ {
+		ret = name + strconv.Itoa(i)
+	}
+	return ret
+}
+
+func (p *Package) recvNameUsed(name string, sig *types.Signature) bool {
+	if tupleHasName(sig.Params(), name) || tupleHasName(sig.Results(), name) {
+		return true
+	}
+	return p.Types.Scope().Lookup(name) != nil ||
+		p.builtin.Scope().Lookup(name) != nil || types.Universe.Lookup(name) != nil
+}
+
+func tupleHasName(t *types.Tuple, name string) bool {
+	for i, n := 0, t.Len(); i < n; i++ {
+		if t.At(i).Name() == name {
+			return true
+		}
+	}
+	return false
 }
 
 type closureType = token.Pos
@@ -179,6 +525,18 @@ func (p *Func) isInline() bool {
 
 // ----------------------------------------------------------------------------
 
+// DefaultMaxOverloadFuncs is the default of Config.MaxOverloadFuncs, which is
+// the max number of funcs of an overload func (or method) of a Go+ package,
+// named Name__0, ..., Name__9, Name__a, ..., Name__z.
+const DefaultMaxOverloadFuncs = 36
+
+func (p *Config) maxOverloadFuncs() int {
+	if p.MaxOverloadFuncs > 0 {
+		return p.MaxOverloadFuncs
+	}
+	return DefaultMaxOverloadFuncs
+}
+
 func NewOverloadFunc(pos token.Pos, pkg *types.Package, name string, funcs ...types.Object) *types.TypeName {
 	return types.NewTypeName(pos, pkg, name, &overloadFuncType{funcs})
 }
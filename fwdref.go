@@ -0,0 +1,101 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"log"
+	"strings"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+type forwardRef struct {
+	name string
+	typ  types.Type
+	err  *CodeError // where the reference is (Msg is set if it is unresolved)
+}
+
+// UnresolvedRefsError is returned by ResolveForwardRefs if some forward
+// references (see CodeBuilder.ForwardRef) aren't declared by the package, or
+// are declared with other types.
+type UnresolvedRefsError struct {
+	Errs []*CodeError
+}
+
+func (p *UnresolvedRefsError) Error() string {
+	msgs := make([]string, len(p.Errs))
+	for i, err := range p.Errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ForwardRef references the package-level func, var or const name, which may
+// be declared later (eg. by a single-pass front-end over mutually recursive
+// functions). typ is the type the reference is used as, so it can be called,
+// assigned and so on before name is declared. References are checked by
+// ResolveForwardRefs, which is called when the package is written.
+//
+// If name is already declared, ForwardRef is the same as Val(obj).
+func (p *CodeBuilder) ForwardRef(name string, typ types.Type, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("ForwardRef", name, typ)
+	}
+	pkg := p.pkg
+	if obj := pkg.Types.Scope().Lookup(name); obj != nil {
+		return p.Val(obj, src...)
+	}
+	srcExpr := getSrc(src)
+	var err *CodeError
+	if srcExpr != nil {
+		pos := p.nodePosition(srcExpr)
+		err = p.newCodeError(&pos, "")
+	} else {
+		err = p.newCodeError(nil, "")
+	}
+	pkg.fwdRefs = append(pkg.fwdRefs, &forwardRef{name: name, typ: typ, err: err})
+	p.stk.Push(&internal.Elem{Val: ident(name), Type: typ, Src: srcExpr})
+	return p
+}
+
+// ResolveForwardRefs checks forward references (see CodeBuilder.ForwardRef):
+// each of them must be declared in the package scope with the type it is used
+// as. It returns an *UnresolvedRefsError listing references which aren't.
+func (p *Package) ResolveForwardRefs() error {
+	var errs []*CodeError
+	for _, ref := range p.fwdRefs {
+		obj := p.Types.Scope().Lookup(ref.name)
+		switch {
+		case obj == nil:
+			ref.err.Msg = fmt.Sprintf("undefined: %s", ref.name)
+		case !types.Identical(obj.Type(), ref.typ):
+			ref.err.Msg = fmt.Sprintf(
+				"%s is declared as type %v, but referenced as type %v", ref.name, obj.Type(), ref.typ)
+		default:
+			continue
+		}
+		errs = append(errs, ref.err)
+	}
+	if errs != nil {
+		return &UnresolvedRefsError{Errs: errs}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
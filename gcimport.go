@@ -0,0 +1,156 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+// ----------------------------------------------------------------------------
+
+// ExportDataLookup returns a reader of the compiled export data (an object or
+// archive file) of the package named by pkgPath.
+type ExportDataLookup = func(pkgPath string) (io.ReadCloser, error)
+
+type exportDataLoader struct {
+	lookup  ExportDataLookup
+	fset    *token.FileSet
+	imports map[string]*types.Package
+	mutex   sync.Mutex
+}
+
+// NewLoadPkgsExport returns a LoadPkgsFunc which imports types of packages from
+// their compiled export data (see gcexportdata), instead of loading packages
+// by go/packages. It is much faster for large dependency graphs, because
+// neither package metadata of dependencies nor source files are loaded.
+//
+// If lookup is nil, export data are found by `go list -export`, which builds
+// packages into the build cache if needed. Loaded packages are cached by the
+// returned function, so it can be shared by packages (even concurrently).
+//
+// Doc comments (see Config.LoadDocs) aren't available, and changes of loaded
+// packages aren't detected.
+func NewLoadPkgsExport(lookup ExportDataLookup) LoadPkgsFunc {
+	p := &exportDataLoader{
+		lookup:  lookup,
+		fset:    token.NewFileSet(),
+		imports: make(map[string]*types.Package),
+	}
+	return p.load
+}
+
+func (p *exportDataLoader) load(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var unloaded []string
+	for _, pkgPath := range pkgPaths {
+		if pkg, ok := p.imports[pkgPath]; !ok || !pkg.Complete() {
+			unloaded = append(unloaded, pkgPath)
+		}
+	}
+	lookup := p.lookup
+	if lookup == nil && len(unloaded) > 0 {
+		files, err := goListExport(at.conf, unloaded)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		lookup = func(pkgPath string) (io.ReadCloser, error) {
+			file, ok := files[pkgPath]
+			if !ok || file == "" {
+				return nil, fmt.Errorf("no export data for %q", pkgPath)
+			}
+			return os.Open(file)
+		}
+	}
+	nerr := 0
+	for _, pkgPath := range unloaded {
+		if err := p.read(lookup, pkgPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			nerr++
+		}
+	}
+	if nerr > 0 {
+		return nerr
+	}
+	for _, pkgPath := range pkgPaths {
+		if pkg, ok := importPkgs[pkgPath]; ok {
+			pkg.ID = pkgPath
+			pkg.Types = p.imports[pkgPath]
+		}
+	}
+	return 0
+}
+
+func (p *exportDataLoader) read(lookup ExportDataLookup, pkgPath string) error {
+	f, err := lookup(pkgPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pkg, err := readExportData(f, p.fset, p.imports, pkgPath)
+	if err != nil {
+		return err
+	}
+	initGopPkg(pkg)
+	return nil
+}
+
+// readExportData reads types of the package named by pkgPath from its export
+// data f into imports.
+func readExportData(f io.Reader, fset *token.FileSet, imports map[string]*types.Package, pkgPath string) (*types.Package, error) {
+	r, err := gcexportdata.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading export data for %q: %v", pkgPath, err)
+	}
+	return gcexportdata.Read(r, fset, imports, pkgPath)
+}
+
+// goListExport returns export data files of the packages named by pkgPaths,
+// which are built by `go list -export` if needed.
+func goListExport(conf *Config, pkgPaths []string) (map[string]string, error) {
+	args := append([]string{"list", "-export", "-f", "{{.ImportPath}}\t{{.Export}}"}, conf.buildFlags()...)
+	args = append(args, "--")
+	cmd := exec.Command("go", append(args, pkgPaths...)...)
+	cmd.Dir = conf.dir()
+	cmd.Env = conf.environ()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	files := make(map[string]string, len(pkgPaths))
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if pos := strings.IndexByte(line, '\t'); pos > 0 {
+			files[line[:pos]] = line[pos+1:]
+		}
+	}
+	return files, nil
+}
+
+// ----------------------------------------------------------------------------
@@ -14,10 +14,12 @@
 package gox_test
 
 import (
+	"go/ast"
 	"go/constant"
 	"go/token"
 	"go/types"
 	"math/big"
+	"strconv"
 	"testing"
 
 	"github.com/goplus/gox"
@@ -146,6 +148,37 @@ var h builtin.Gop_bigrat = builtin.Gop_bigrat_Cast__1(g)
 `)
 }
 
+func TestBigConv(t *testing.T) {
+	pkg := newGopMainPackage()
+	big := pkg.Import("github.com/goplus/gox/internal/builtin")
+	foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(nil, nil))
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	recv := pkg.NewParam(token.NoPos, "p", foo)
+	pkg.NewFunc(recv, "Gop_Rcast", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val(0).Return(1).
+		End()
+	pkg.NewVar(token.NoPos, foo, "a")
+	pkg.CB().NewVarStart(nil, "b").
+		Val(1).Conv(big.Ref("Gop_bigint").Type()).EndInit(1)
+	pkg.CB().NewVarStart(nil, "c").
+		Val(ctxRef(pkg, "a")).Conv(types.Typ[types.Int]).EndInit(1)
+	domTest(t, pkg, `package main
+
+import builtin "github.com/goplus/gox/internal/builtin"
+
+type foo struct {
+}
+
+func (p foo) Gop_Rcast() int {
+	return 0
+}
+
+var a foo
+var b = builtin.Gop_bigint_Cast__1(1)
+var c = a.Gop_Rcast()
+`)
+}
+
 func TestUntypedBigIntAdd(t *testing.T) {
 	pkg := newGopMainPackage()
 	pkg.CB().NewVarStart(nil, "a").
@@ -406,7 +439,7 @@ func TestUntypedBigRatAdd6(t *testing.T) {
 import builtin "github.com/goplus/gox/internal/builtin"
 
 var a builtin.Gop_bigrat
-var b = builtin.Gop_bigrat_Init__0(100) + a
+var b = builtin.Gop_bigrat_Init__0(100).Gop_Add(a)
 `)
 }
 
@@ -483,3 +516,71 @@ func main() {
 }
 
 // ----------------------------------------------------------------------------
+
+func TestBigIntCompare(t *testing.T) {
+	pkg := newGopMainPackage()
+	mbig := pkg.Import("github.com/goplus/gox/internal/builtin")
+	pkg.NewVar(token.NoPos, mbig.Ref("Gop_bigint").Type(), "a")
+	pkg.CB().NewVarStart(nil, "b").
+		Val(ctxRef(pkg, "a")).Val(100).BinaryOp(token.LSS).
+		EndInit(1)
+	pkg.CB().NewVarStart(nil, "c").
+		Val(100).Val(ctxRef(pkg, "a")).BinaryOp(token.LSS).
+		EndInit(1)
+	pkg.CB().NewVarStart(nil, "d").
+		Val(100).Val(ctxRef(pkg, "a")).BinaryOp(token.NEQ).
+		EndInit(1)
+	pkg.CB().NewVarStart(nil, "e").
+		Val(ctxRef(pkg, "a")).Val(ctxRef(pkg, "a")).BinaryOp(token.GEQ).
+		EndInit(1)
+	domTest(t, pkg, `package main
+
+import builtin "github.com/goplus/gox/internal/builtin"
+
+var a builtin.Gop_bigint
+var b = a.Gop_LT(builtin.Gop_bigint_Init__0(100))
+var c = builtin.Gop_bigint_Init__0(100).Gop_LT(a)
+var d = builtin.Gop_bigint_Init__0(100).Gop_NE(a)
+var e = a.Gop_GE(a)
+`)
+}
+
+func TestUntypedBigConst(t *testing.T) {
+	var consts []string
+	conf := &gox.Config{
+		Fset:       gblFset,
+		LoadPkgs:   gblLoadPkgs,
+		ModPath:    "github.com/goplus/gox",
+		NewBuiltin: newGopBuiltinDefault,
+		Prefix:     gopNamePrefix,
+		UntypedBigConst: func(pkg *gox.Package, v constant.Value, typ *types.Named) ast.Expr {
+			consts = append(consts, v.String())
+			return &ast.CallExpr{
+				Fun:  ast.NewIdent("bigconst"),
+				Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(v.String())}},
+			}
+		},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	mbig := pkg.Import("github.com/goplus/gox/internal/builtin")
+	pkg.NewVar(token.NoPos, mbig.Ref("Gop_bigint").Type(), "a")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		VarRef(ctxRef(pkg, "a")).
+		UntypedBigInt(big.NewInt(1)).Val(65).BinaryOp(token.SHL).UnaryOp(token.SUB).
+		UntypedBigInt(big.NewInt(1)).BinaryOp(token.ADD).
+		Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+import builtin "github.com/goplus/gox/internal/builtin"
+
+var a builtin.Gop_bigint
+
+func main() {
+	a = builtin.Gop_bigint_Init__1(bigconst("-36893488147419103231"))
+}
+`)
+	if len(consts) != 1 {
+		t.Fatal("TestUntypedBigConst: intermediate constants are materialized -", consts)
+	}
+}
@@ -14,11 +14,16 @@
 package gox
 
 import (
+	"bytes"
+	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"io"
 	"log"
 	"os"
+	"path"
+	"strconv"
 
 	"github.com/goplus/gox/internal/go/format"
 )
@@ -27,18 +32,161 @@ import (
 
 // ASTFile func
 func ASTFile(pkg *Package, testingFile bool) *ast.File {
-	idx := getInTestingFile(testingFile)
-	return &ast.File{Name: ident(pkg.Types.Name()), Decls: pkg.files[idx].getDecls(pkg)}
+	return pkg.ASTFile(testingFile)
 }
 
-// WriteTo func
-func WriteTo(dst io.Writer, pkg *Package, testingFile bool) (err error) {
+// ASTFile returns the in-progress ast.File of the normal (or testing) file.
+//
+// The returned file is copy-on-write at the top level: its Decls slice is
+// owned by the caller, so adding, removing or reordering decls doesn't affect
+// the package. All other nodes are shared with the package and keep their
+// identity between calls, so they may be rewritten in place before
+// WriteTo/WriteFile is called.
+//
+// Imports of the returned file can be managed by astutil (eg. AddNamedImport
+// and DeleteImport): imports added to (or deleted from) Imports of the file
+// returned last are added to (or deleted from) the package file when it is
+// written (or ASTFile is called again). In the returned file, an import is
+// unnamed if its name is the package name (eg. `import "fmt"`), as astutil
+// expects.
+func (p *Package) ASTFile(testingFile bool) *ast.File {
+	return p.astFileOf(p.files[getInTestingFile(testingFile)])
+}
+
+// astFileOf returns the ast.File of file for the user (see ASTFile), whose
+// imports are synced by syncImports.
+func (p *Package) astFileOf(file *file) *ast.File {
+	f := p.astFile(file)
+	for _, spec := range f.Imports {
+		if spec.Name == nil {
+			continue
+		}
+		pkgPath, _ := strconv.Unquote(spec.Path.Value)
+		if ref, ok := file.importPkgs[pkgPath]; ok && ref.Types != nil && ref.Types.Name() == spec.Name.Name {
+			spec.Name = nil
+		}
+	}
+	file.astf, file.astImports = f, append([]*ast.ImportSpec(nil), f.Imports...)
+	return f
+}
+
+func (p *Package) astFile(file *file) *ast.File {
+	decls := file.getDecls(p)
+	f := &ast.File{Name: ident(p.Types.Name()), Decls: make([]ast.Decl, 0, len(decls))}
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name == nil {
+			continue // func whose body isn't ended, eg. generation is interrupted
+		}
+		f.Decls = append(f.Decls, decl)
+	}
+	if len(decls) > 0 {
+		if decl, ok := decls[0].(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			f.Imports = make([]*ast.ImportSpec, len(decl.Specs))
+			for i, spec := range decl.Specs {
+				f.Imports[i] = spec.(*ast.ImportSpec)
+			}
+		}
+	}
+	return f
+}
+
+// DeclOf returns the top-level declaration of obj, which is a func, type, var
+// or const declared by p. It is the same node as in the file returned by
+// ASTFile, so tools can post-process it (eg. to instrument a func) before the
+// file is printed. DeclOf returns nil if obj isn't declared by p, isn't
+// top-level, or is deleted by DeleteFunc.
+//
+// Note that a var or const is recorded when its value is initialized, and
+// specs of a var block (see NewVarDefs) share the same declaration.
+func (p *Package) DeclOf(obj types.Object) ast.Decl {
+	return p.objDecls[obj]
+}
+
+// SetDeclData attaches user data to a declared object (eg. a func, type, var or
+// const), eg. the node of the front-end IR which declares it, so an embedding
+// compiler can map objects back to its own IR without a side table.
+func (p *Package) SetDeclData(obj types.Object, data interface{}) {
+	if p.objData == nil {
+		p.objData = make(map[types.Object]interface{})
+	}
+	p.objData[obj] = data
+}
+
+// DeclData returns user data attached to obj by SetDeclData, eg. data of the
+// func where a CodeError occurs is DeclData(err.Func.Func). It returns nil if
+// there is no such data.
+func (p *Package) DeclData(obj types.Object) interface{} {
+	return p.objData[obj]
+}
+
+// Syntax represents the syntax in which a package is written.
+type Syntax int
+
+const (
+	// GoSyntax writes a package as a Go file.
+	GoSyntax Syntax = iota
+
+	// GopSyntax writes a package as a Go+ file:
+	//   - The package clause is omitted for a main package.
+	//   - Import names are omitted if they are the same as the last element of
+	//     the import paths, eg. `import "fmt"`.
+	//   - The body of func main (of a main package) is written as statements at
+	//     the end of the file, instead of a func declaration.
+	GopSyntax
+)
+
+// Printer is a backend which writes a file built by gox in some form. It can be
+// GoSyntax, GopSyntax, JSONPrinter, SExprPrinter or an user defined one.
+type Printer interface {
+	Print(dst io.Writer, f *ast.File) error
+}
+
+// Print writes f in the syntax.
+func (p Syntax) Print(dst io.Writer, f *ast.File) error {
 	fset := token.NewFileSet()
-	return format.Node(dst, fset, ASTFile(pkg, testingFile))
+	if p == GopSyntax {
+		return writeGopTo(dst, fset, f)
+	}
+	return format.Node(dst, fset, f)
+}
+
+// WriteTo func
+func WriteTo(dst io.Writer, pkg *Package, testingFile bool, syntax ...Syntax) (err error) {
+	return WriteToWith(dst, pkg, testingFile, pkg.printer(syntax))
+}
+
+// printer returns the printer of the syntax (GoSyntax by default), which is
+// Config.Format for GoSyntax if it is specified.
+func (p *Package) printer(syntax []Syntax) Printer {
+	if syntax != nil && syntax[0] != GoSyntax {
+		return syntax[0]
+	}
+	if p.conf.Format != nil {
+		return p.conf.Format
+	}
+	return GoSyntax
+}
+
+// WriteToWith writes the normal (or testing) file of pkg by printer.
+//
+// If printer writes Go code (a Syntax or FormatOptions), a header is written
+// above the package clause: the generated code comment (see Config.GeneratedBy)
+// and the build constraint of the file (see SetBuildConstraint), which is
+// written in both `//go:build` and `// +build` forms for Go 1.16.
+//
+// It fails if any forward reference is unresolved (see ResolveForwardRefs).
+func WriteToWith(dst io.Writer, pkg *Package, testingFile bool, printer Printer) (err error) {
+	if err = pkg.ResolveForwardRefs(); err != nil {
+		return
+	}
+	if err = pkg.writeHeader(dst, pkg.files[getInTestingFile(testingFile)], printer); err != nil {
+		return
+	}
+	return printer.Print(dst, pkg.astFile(pkg.files[getInTestingFile(testingFile)]))
 }
 
 // WriteFile func
-func WriteFile(file string, pkg *Package, testingFile bool) (err error) {
+func WriteFile(file string, pkg *Package, testingFile bool, syntax ...Syntax) (err error) {
 	if debugWriteFile {
 		log.Println("WriteFile", file, testingFile)
 	}
@@ -47,7 +195,58 @@ func WriteFile(file string, pkg *Package, testingFile bool) (err error) {
 		return
 	}
 	defer f.Close()
-	return WriteTo(f, pkg, testingFile)
+	return WriteTo(f, pkg, testingFile, syntax...)
+}
+
+func writeGopTo(dst io.Writer, fset *token.FileSet, f *ast.File) (err error) {
+	isMain := f.Name.Name == "main"
+	decls := f.Decls[:0]
+	var stmts []ast.Stmt
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT { // the import decl is rebuilt by ASTFile
+				for _, spec := range d.Specs {
+					spec := spec.(*ast.ImportSpec)
+					if spec.Name != nil && spec.Name.Name == importBaseName(spec.Path.Value) {
+						spec.Name = nil
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if isMain && d.Recv == nil && d.Name.Name == "main" && d.Body != nil {
+				stmts = d.Body.List
+				continue
+			}
+		}
+		decls = append(decls, decl)
+	}
+	var b bytes.Buffer
+	if !isMain {
+		fmt.Fprintf(&b, "package %s\n\n", f.Name.Name)
+	}
+	if len(decls) > 0 {
+		if err = format.Node(&b, fset, decls); err != nil {
+			return
+		}
+		b.WriteString("\n")
+	}
+	if len(stmts) > 0 {
+		if len(decls) > 0 {
+			b.WriteString("\n")
+		}
+		if err = format.Node(&b, fset, stmts); err != nil {
+			return
+		}
+		b.WriteString("\n")
+	}
+	_, err = dst.Write(b.Bytes())
+	return
+}
+
+func importBaseName(quotedPath string) string {
+	pkgPath, _ := strconv.Unquote(quotedPath)
+	return path.Base(pkgPath)
 }
 
 // ----------------------------------------------------------------------------
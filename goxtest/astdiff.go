@@ -0,0 +1,173 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+)
+
+// ----------------------------------------------------------------------------
+
+// NodeDiff represents the first difference of two syntax trees.
+type NodeDiff struct {
+	Path     string // path of the differing node, eg. `Decls[1].Body.List[0]`
+	Expected string
+	Got      string
+}
+
+func (p *NodeDiff) String() string {
+	return fmt.Sprintf("%s:\n\texpected: %s\n\tgot: %s", p.Path, p.Expected, p.Got)
+}
+
+// DiffSource parses expected and got as Go files and returns the first
+// difference of their syntax trees, or nil if they are structurally equal.
+func DiffSource(expected, got string) (*NodeDiff, error) {
+	fset := token.NewFileSet()
+	x, err := parser.ParseFile(fset, "expected.go", expected, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	y, err := parser.ParseFile(fset, "got.go", got, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	return DiffNode(x, y), nil
+}
+
+// DiffNode compares two syntax trees structurally and returns the first
+// difference, or nil if they are equal. Positions, resolved objects and scopes
+// are ignored, and comments are compared by their text.
+func DiffNode(expected, got ast.Node) *NodeDiff {
+	var d differ
+	if d.diff("", reflect.ValueOf(expected), reflect.ValueOf(got)) {
+		return nil
+	}
+	return d.ret
+}
+
+var (
+	tyPos          = reflect.TypeOf(token.NoPos)
+	tyObject       = reflect.TypeOf((*ast.Object)(nil))
+	tyScope        = reflect.TypeOf((*ast.Scope)(nil))
+	tyCommentGroup = reflect.TypeOf((*ast.CommentGroup)(nil))
+	tyFile         = reflect.TypeOf(ast.File{})
+)
+
+type differ struct {
+	ret *NodeDiff
+}
+
+func (p *differ) fail(path string, x, y reflect.Value) bool {
+	p.ret = &NodeDiff{Path: path, Expected: nodeString(x), Got: nodeString(y)}
+	return false
+}
+
+func (p *differ) diff(path string, x, y reflect.Value) bool {
+	if x.IsValid() != y.IsValid() {
+		return p.fail(path, x, y)
+	} else if !x.IsValid() {
+		return true
+	}
+	if x.Type() != y.Type() {
+		return p.fail(path, x, y)
+	}
+	switch t := x.Type(); t {
+	case tyPos, tyObject, tyScope:
+		return true
+	case tyCommentGroup:
+		if x.Interface().(*ast.CommentGroup).Text() != y.Interface().(*ast.CommentGroup).Text() {
+			return p.fail(path, x, y)
+		}
+		return true
+	}
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			if x.IsNil() != y.IsNil() {
+				return p.fail(path, x, y)
+			}
+			return true
+		}
+		return p.diff(path, x.Elem(), y.Elem())
+	case reflect.Struct:
+		t := x.Type()
+		for i, n := 0, t.NumField(); i < n; i++ {
+			name := t.Field(i).Name
+			if t == tyFile && (name == "Imports" || name == "Unresolved" || name == "Comments") {
+				continue // they are duplicated with other fields
+			}
+			if !p.diff(joinPath(path, name), x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		n, ny := x.Len(), y.Len()
+		if ny > n {
+			n = ny
+		}
+		for i := 0; i < n; i++ {
+			var xi, yi reflect.Value
+			if i < x.Len() {
+				xi = x.Index(i)
+			}
+			if i < y.Len() {
+				yi = y.Index(i)
+			}
+			if !p.diff(fmt.Sprintf("%s[%d]", path, i), xi, yi) {
+				return false
+			}
+		}
+		return true
+	default:
+		if x.Interface() != y.Interface() {
+			return p.fail(path, x, y)
+		}
+		return true
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func nodeString(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<missing>"
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() || v.Kind() == reflect.Interface && v.IsNil() {
+		return "<nil>"
+	}
+	switch x := v.Interface().(type) {
+	case *ast.CommentGroup:
+		return fmt.Sprintf("%q", x.Text())
+	case ast.Node:
+		var b bytes.Buffer
+		if err := printer.Fprint(&b, token.NewFileSet(), x); err == nil {
+			return b.String()
+		}
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,146 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// CheckError represents the failures found by Check.
+type CheckError struct {
+	Errors []error  // errors reported by go/parser or go/types
+	Diffs  []string // objects whose types recorded by gox differ from go/types
+}
+
+func (p *CheckError) Error() string {
+	msgs := make([]string, 0, len(p.Errors)+len(p.Diffs))
+	for _, err := range p.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(append(msgs, p.Diffs...), "\n")
+}
+
+// Check re-parses the generated file of pkg by go/parser and re-type-checks it
+// by go/types, and then cross-checks the types of package-level objects (and
+// methods) recorded by gox against what go/types infers. Soft errors of
+// go/types (eg. unused variables or imports) are ignored.
+//
+// Imported packages are loaded from source, so the generated package can
+// import standard packages only.
+func Check(pkg *gox.Package) error {
+	var b bytes.Buffer
+	if err := gox.WriteTo(&b, pkg, false); err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "gop_autogen.go", b.Bytes(), 0)
+	if err != nil {
+		return &CheckError{Errors: []error{err}}
+	}
+	ret := new(CheckError)
+	conf := &types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			if e, ok := err.(types.Error); ok && e.Soft {
+				return
+			}
+			ret.Errors = append(ret.Errors, err)
+		},
+	}
+	checked, _ := conf.Check(pkg.Types.Path(), fset, []*ast.File{f}, nil)
+	if len(ret.Errors) == 0 {
+		ret.Diffs = diffScope(pkg.Types, checked)
+	}
+	if len(ret.Errors) > 0 || len(ret.Diffs) > 0 {
+		return ret
+	}
+	return nil
+}
+
+func diffScope(gox, checked *types.Package) (diffs []string) {
+	qgox, qchecked := qualifier(gox), qualifier(checked)
+	scope := gox.Scope()
+	names := scope.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		o := scope.Lookup(name)
+		co := checked.Scope().Lookup(name)
+		if co == nil {
+			diffs = append(diffs, fmt.Sprintf("%s: not found by go/types", name))
+			continue
+		}
+		if tn, ok := o.(*types.TypeName); ok {
+			if _, ok := co.(*types.TypeName); !ok {
+				diffs = append(diffs, fmt.Sprintf("%s: gox %v, go/types %v", name, o, co))
+				continue
+			}
+			diffs = diffType(diffs, name, tn.Type().Underlying(), co.Type().Underlying(), qgox, qchecked)
+			if t, ok := tn.Type().(*types.Named); ok && !tn.IsAlias() {
+				diffs = diffMethods(diffs, t, co.Type().(*types.Named), qgox, qchecked)
+			}
+			continue
+		}
+		diffs = diffType(diffs, name, o.Type(), co.Type(), qgox, qchecked)
+	}
+	return
+}
+
+func diffMethods(diffs []string, t, ct *types.Named, qgox, qchecked types.Qualifier) []string {
+	methods := make(map[string]*types.Func, ct.NumMethods())
+	for i, n := 0, ct.NumMethods(); i < n; i++ {
+		m := ct.Method(i)
+		methods[m.Name()] = m
+	}
+	for i, n := 0, t.NumMethods(); i < n; i++ {
+		m := t.Method(i)
+		name := t.Obj().Name() + "." + m.Name()
+		cm, ok := methods[m.Name()]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: not found by go/types", name))
+			continue
+		}
+		diffs = diffType(diffs, name, m.Type(), cm.Type(), qgox, qchecked)
+	}
+	return diffs
+}
+
+func diffType(diffs []string, name string, t, ct types.Type, qgox, qchecked types.Qualifier) []string {
+	if s, cs := types.TypeString(t, qgox), types.TypeString(ct, qchecked); s != cs {
+		diffs = append(diffs, fmt.Sprintf("%s: gox %s, go/types %s", name, s, cs))
+	}
+	return diffs
+}
+
+func qualifier(this *types.Package) types.Qualifier {
+	return func(pkg *types.Package) string {
+		if pkg == this {
+			return ""
+		}
+		return pkg.Path()
+	}
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,105 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// BuildFunc builds a package from the input file of a corpus case.
+type BuildFunc func(file string, input []byte) (*gox.Package, error)
+
+// Corpus is a directory-based regression suite. Each subdirectory of Dir is a
+// case:
+//
+//	<Dir>/<case>/<Input>      input of the case, consumed by Build
+//	<Dir>/<case>/out.expect   expected Go file generated from the input
+//
+// The input format is decided by Build, eg. a Go+ source file or a builder
+// script. Directories without an Input file are ignored.
+type Corpus struct {
+	Dir   string    // root directory of the corpus
+	Input string    // file name of inputs, eg. `in.gop`
+	Build BuildFunc // builds a package from an input
+
+	// Update writes the generated Go files to out.expect instead of
+	// comparing them.
+	Update bool
+}
+
+// ExpectFile is the file name of expected outputs of a corpus case.
+const ExpectFile = "out.expect"
+
+// Run runs all cases of the corpus as subtests of t.
+func (p *Corpus) Run(t *testing.T) {
+	entries, err := os.ReadDir(p.Dir)
+	if err != nil {
+		t.Fatal("Corpus.Run:", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(p.Dir, entry.Name())
+		file := filepath.Join(dir, p.Input)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		t.Run(entry.Name(), func(t *testing.T) {
+			p.runCase(t, dir, file)
+		})
+	}
+}
+
+func (p *Corpus) runCase(t *testing.T, dir, file string) {
+	input, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := p.Build(file, input)
+	if err != nil {
+		t.Fatal("Build failed:", err)
+	}
+	var b bytes.Buffer
+	if err = gox.WriteTo(&b, pkg, false); err != nil {
+		t.Fatal("gox.WriteTo failed:", err)
+	}
+	result := b.String()
+	expectFile := filepath.Join(dir, ExpectFile)
+	if p.Update {
+		if err = os.WriteFile(expectFile, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(expectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != string(expected) {
+		if diff, err := DiffSource(string(expected), result); err == nil && diff != nil {
+			t.Fatalf("\nDiff at %v\nResult:\n%s\nExpected:\n%s\n", diff, result, expected)
+		}
+		t.Fatalf("\nResult:\n%s\nExpected:\n%s\n", result, expected)
+	}
+}
+
+// ----------------------------------------------------------------------------
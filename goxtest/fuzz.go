@@ -0,0 +1,332 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// Fuzz is the entry point of go-fuzz (github.com/dvyukov/go-fuzz). It builds a
+// package from data by BuildOps, and panics if gox panics or the generated
+// package doesn't pass Check.
+func Fuzz(data []byte) int {
+	pkg := BuildOps(data)
+	if err := Check(pkg); err != nil {
+		panic(err)
+	}
+	return 1
+}
+
+// BuildOps builds `func main` of a new package by a sequence of builder
+// operations driven by data. The operations are always valid: data only
+// chooses one of the operations allowed by the current state of the builder
+// (see validOps), so BuildOps should never panic, and the generated package
+// should always be type-checked without errors.
+func BuildOps(data []byte) *gox.Package {
+	pkg := gox.NewPackage("", "main", nil)
+	p := &opsBuilder{data: data, pkg: pkg, blocks: []*opsBlock{{}}}
+	p.cb = pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	for len(p.data) > 0 {
+		ops := p.validOps()
+		ops[p.choose(len(ops))](p)
+	}
+	for len(p.blocks) > 0 {
+		p.endBlock()
+	}
+	return pkg
+}
+
+// ----------------------------------------------------------------------------
+
+type opsKind int
+
+const (
+	opsInt opsKind = iota
+	opsString
+	opsBool
+	opsKinds
+)
+
+var opsTypes = [opsKinds]types.Type{
+	opsInt:    types.Typ[types.Int],
+	opsString: types.Typ[types.String],
+	opsBool:   types.Typ[types.Bool],
+}
+
+const (
+	opsMaxDepth  = 4 // max depth of expressions
+	opsMaxBlocks = 8 // max depth of blocks
+)
+
+type opsBlockKind int
+
+const (
+	opsBlockFunc opsBlockKind = iota
+	opsBlockPlain
+	opsBlockIf
+	opsBlockElse
+	opsBlockFor
+)
+
+type opsBlock struct {
+	kind opsBlockKind
+	vars []*types.Var
+}
+
+type opsBuilder struct {
+	data   []byte
+	pkg    *gox.Package
+	cb     *gox.CodeBuilder
+	blocks []*opsBlock
+	nvar   int
+	depth  int // depth of the expression being built
+}
+
+type opsFunc = func(p *opsBuilder)
+
+func (p *opsBuilder) choose(n int) int {
+	if len(p.data) == 0 {
+		return 0
+	}
+	b := int(p.data[0])
+	p.data = p.data[1:]
+	return b % n
+}
+
+// validOps returns statements allowed by the current state of the builder.
+func (p *opsBuilder) validOps() []opsFunc {
+	ops := []opsFunc{(*opsBuilder).defineVar, (*opsBuilder).println}
+	if len(p.vars(opsInt))+len(p.vars(opsString))+len(p.vars(opsBool)) > 0 {
+		ops = append(ops, (*opsBuilder).assign)
+	}
+	if len(p.vars(opsInt)) > 0 {
+		ops = append(ops, (*opsBuilder).assignOp, (*opsBuilder).incDec)
+	}
+	if len(p.blocks) < opsMaxBlocks {
+		ops = append(ops, (*opsBuilder).block, (*opsBuilder).ifStmt, (*opsBuilder).forStmt)
+	}
+	if top := p.blocks[len(p.blocks)-1]; top.kind == opsBlockIf {
+		ops = append(ops, (*opsBuilder).elseStmt)
+	}
+	if len(p.blocks) > 1 {
+		ops = append(ops, (*opsBuilder).endBlock)
+	}
+	return ops
+}
+
+// vars returns all visible variables of the specified kind.
+func (p *opsBuilder) vars(kind opsKind) (vars []*types.Var) {
+	for _, b := range p.blocks {
+		for _, v := range b.vars {
+			if v.Type() == opsTypes[kind] {
+				vars = append(vars, v)
+			}
+		}
+	}
+	return
+}
+
+func (p *opsBuilder) defineVar() {
+	kind := opsKind(p.choose(int(opsKinds)))
+	name := fmt.Sprintf("v%d", p.nvar)
+	p.nvar++
+	p.cb.DefineVarStart(token.NoPos, name)
+	p.expr(kind)
+	p.cb.EndInit(1)
+	top := p.blocks[len(p.blocks)-1]
+	top.vars = append(top.vars, p.cb.Scope().Lookup(name).(*types.Var))
+}
+
+func (p *opsBuilder) println() {
+	n := p.choose(4)
+	p.cb.Val(p.pkg.Builtin().Ref("println"))
+	for i := 0; i < n; i++ {
+		p.expr(opsKind(p.choose(int(opsKinds))))
+	}
+	p.cb.Call(n).EndStmt()
+}
+
+func (p *opsBuilder) assign() {
+	var vars []*types.Var
+	var kinds []opsKind
+	for kind := opsInt; kind < opsKinds; kind++ {
+		for _, v := range p.vars(kind) {
+			vars, kinds = append(vars, v), append(kinds, kind)
+		}
+	}
+	i := p.choose(len(vars))
+	p.cb.VarRef(vars[i])
+	p.expr(kinds[i])
+	p.cb.Assign(1)
+}
+
+var opsAssignOps = []token.Token{token.ADD_ASSIGN, token.SUB_ASSIGN, token.AND_ASSIGN, token.OR_ASSIGN, token.XOR_ASSIGN}
+
+func (p *opsBuilder) assignOp() {
+	vars := p.vars(opsInt)
+	p.cb.VarRef(vars[p.choose(len(vars))])
+	p.expr(opsInt)
+	p.cb.AssignOp(opsAssignOps[p.choose(len(opsAssignOps))])
+}
+
+func (p *opsBuilder) incDec() {
+	vars := p.vars(opsInt)
+	op := token.INC
+	if p.choose(2) == 1 {
+		op = token.DEC
+	}
+	p.cb.VarRef(vars[p.choose(len(vars))]).IncDec(op)
+}
+
+func (p *opsBuilder) block() {
+	p.cb.Block()
+	p.blocks = append(p.blocks, &opsBlock{kind: opsBlockPlain})
+}
+
+func (p *opsBuilder) ifStmt() {
+	p.cb.If()
+	p.expr(opsBool)
+	p.cb.Then()
+	p.blocks = append(p.blocks, &opsBlock{kind: opsBlockIf})
+}
+
+func (p *opsBuilder) elseStmt() {
+	p.cb.Else()
+	p.blocks[len(p.blocks)-1] = &opsBlock{kind: opsBlockElse}
+}
+
+func (p *opsBuilder) forStmt() {
+	p.cb.For()
+	p.expr(opsBool)
+	p.cb.Then()
+	p.blocks = append(p.blocks, &opsBlock{kind: opsBlockFor})
+}
+
+func (p *opsBuilder) endBlock() {
+	p.cb.End()
+	p.blocks = p.blocks[:len(p.blocks)-1]
+}
+
+// ----------------------------------------------------------------------------
+
+var (
+	opsIntOps  = []token.Token{token.ADD, token.SUB, token.AND, token.OR, token.XOR}
+	opsCmpOps  = []token.Token{token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ}
+	opsStrings = []string{"", "a", "Hello", "世界"}
+)
+
+// expr pushes an expression of the specified kind. Operands of integer
+// expressions are small enough, so that constant expressions never overflow.
+func (p *opsBuilder) expr(kind opsKind) {
+	vars := p.vars(kind)
+	var exprs []opsFunc
+	if p.depth < opsMaxDepth {
+		switch kind {
+		case opsInt:
+			exprs = []opsFunc{(*opsBuilder).intBinary, (*opsBuilder).intNeg, (*opsBuilder).strLen}
+		case opsString:
+			exprs = []opsFunc{(*opsBuilder).strConcat, (*opsBuilder).strConcatN}
+		default:
+			exprs = []opsFunc{(*opsBuilder).intCmp, (*opsBuilder).strCmp, (*opsBuilder).boolLogic, (*opsBuilder).boolNot}
+		}
+	}
+	n := 1 + len(vars) + len(exprs)
+	switch i := p.choose(n); {
+	case i == 0:
+		p.literal(kind)
+	case i <= len(vars):
+		p.cb.Val(vars[i-1])
+	default:
+		p.depth++
+		exprs[i-1-len(vars)](p)
+		p.depth--
+	}
+}
+
+func (p *opsBuilder) literal(kind opsKind) {
+	switch kind {
+	case opsInt:
+		p.cb.Val(p.choose(256))
+	case opsString:
+		p.cb.Val(opsStrings[p.choose(len(opsStrings))])
+	default:
+		p.cb.Val(p.choose(2) == 1)
+	}
+}
+
+func (p *opsBuilder) intBinary() {
+	p.expr(opsInt)
+	p.expr(opsInt)
+	p.cb.BinaryOp(opsIntOps[p.choose(len(opsIntOps))])
+}
+
+func (p *opsBuilder) intNeg() {
+	p.expr(opsInt)
+	p.cb.UnaryOp(token.SUB)
+}
+
+func (p *opsBuilder) strLen() {
+	p.cb.Val(p.pkg.Builtin().Ref("len"))
+	p.expr(opsString)
+	p.cb.Call(1)
+}
+
+func (p *opsBuilder) strConcat() {
+	p.expr(opsString)
+	p.expr(opsString)
+	p.cb.BinaryOp(token.ADD)
+}
+
+func (p *opsBuilder) strConcatN() {
+	n := p.choose(4)
+	for i := 0; i < n; i++ {
+		p.expr(opsString)
+	}
+	p.cb.StringConcat(n)
+}
+
+func (p *opsBuilder) intCmp() {
+	p.expr(opsInt)
+	p.expr(opsInt)
+	p.cb.BinaryOp(opsCmpOps[p.choose(len(opsCmpOps))])
+}
+
+func (p *opsBuilder) strCmp() {
+	p.expr(opsString)
+	p.expr(opsString)
+	p.cb.BinaryOp(opsCmpOps[p.choose(len(opsCmpOps))])
+}
+
+func (p *opsBuilder) boolLogic() {
+	p.expr(opsBool)
+	p.expr(opsBool)
+	op := token.LAND
+	if p.choose(2) == 1 {
+		op = token.LOR
+	}
+	p.cb.BinaryOp(op)
+}
+
+func (p *opsBuilder) boolNot() {
+	p.expr(opsBool)
+	p.cb.UnaryOp(token.NOT)
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest_test
+
+import (
+	"testing"
+
+	"github.com/goplus/gox/goxtest"
+)
+
+func FuzzBuildOps(f *testing.F) {
+	for _, seed := range opsSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := goxtest.Check(goxtest.BuildOps(data)); err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+	})
+}
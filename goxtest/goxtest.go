@@ -0,0 +1,143 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package goxtest verifies packages generated by gox end-to-end: it writes a
+// package to a temporary module and checks it by the go command.
+package goxtest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// Failure represents a failed go command.
+type Failure struct {
+	Cmd    string // go command, eg. `go vet`
+	Output string // combined output of the go command
+	Err    error  // error returned by exec.Cmd.Run
+}
+
+func (p *Failure) Error() string {
+	return fmt.Sprintf("%s failed: %v\n%s", p.Cmd, p.Err, p.Output)
+}
+
+// Module is a temporary module holding a generated package.
+//
+// The generated package can import standard packages only, because the
+// temporary module has no requirements.
+type Module struct {
+	Dir string
+}
+
+// NewModule writes pkg (and its testing file if it isn't empty) into a new
+// temporary module. Call Close to remove it.
+func NewModule(pkg *gox.Package) (mod *Module, err error) {
+	dir, err := os.MkdirTemp("", "goxtest")
+	if err != nil {
+		return
+	}
+	mod = &Module{Dir: dir}
+	defer func() {
+		if err != nil {
+			mod.Close()
+			mod = nil
+		}
+	}()
+	gomod := "module goxtest\n\ngo 1.16\n"
+	if err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0644); err != nil {
+		return
+	}
+	if err = gox.WriteFile(filepath.Join(dir, "gop_autogen.go"), pkg, false); err != nil {
+		return
+	}
+	if f := gox.ASTFile(pkg, true); len(f.Decls) > 0 {
+		err = gox.WriteFile(filepath.Join(dir, "gop_autogen_test.go"), pkg, true)
+	}
+	return
+}
+
+// Close removes the temporary module.
+func (p *Module) Close() error {
+	return os.RemoveAll(p.Dir)
+}
+
+// Vet runs `go vet` on the module.
+func (p *Module) Vet() error {
+	_, err := p.goCmd("vet", ".")
+	return err
+}
+
+// Build runs `go build` on the module.
+func (p *Module) Build() error {
+	_, err := p.goCmd("build", "-o", os.DevNull, ".")
+	return err
+}
+
+// Run runs `go run` on the module and returns its stdout.
+func (p *Module) Run(args ...string) (stdout string, err error) {
+	return p.goCmd("run", append([]string{"."}, args...)...)
+}
+
+func (p *Module) goCmd(op string, args ...string) (stdout string, err error) {
+	var out, output bytes.Buffer
+	cmd := exec.Command("go", append([]string{op}, args...)...)
+	cmd.Dir = p.Dir
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	if op == "run" {
+		cmd.Stdout, cmd.Stderr = &out, &output
+	} else {
+		cmd.Stdout, cmd.Stderr = &output, &output
+	}
+	if err = cmd.Run(); err != nil {
+		return "", &Failure{Cmd: "go " + op, Output: output.String(), Err: err}
+	}
+	return out.String(), nil
+}
+
+// ----------------------------------------------------------------------------
+
+// Build writes pkg to a temporary module and runs `go vet` and `go build` on it.
+func Build(pkg *gox.Package) error {
+	mod, err := NewModule(pkg)
+	if err != nil {
+		return err
+	}
+	defer mod.Close()
+	if err = mod.Vet(); err != nil {
+		return err
+	}
+	return mod.Build()
+}
+
+// Run writes pkg to a temporary module, runs `go vet` and then `go run` on it.
+// It returns stdout of the program.
+func Run(pkg *gox.Package, args ...string) (stdout string, err error) {
+	mod, err := NewModule(pkg)
+	if err != nil {
+		return
+	}
+	defer mod.Close()
+	if err = mod.Vet(); err != nil {
+		return
+	}
+	return mod.Run(args...)
+}
+
+// ----------------------------------------------------------------------------
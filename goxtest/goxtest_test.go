@@ -0,0 +1,163 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest_test
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/goplus/gox"
+	"github.com/goplus/gox/goxtest"
+)
+
+func TestRun(t *testing.T) {
+	pkg := gox.NewPackage("", "main", nil)
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val("Hello, world").Call(1).EndStmt().
+		End()
+	stdout, err := goxtest.Run(pkg)
+	if err != nil {
+		t.Fatal("Run:", err)
+	}
+	if stdout != "Hello, world\n" {
+		t.Fatal("Run:", stdout)
+	}
+	if err = goxtest.Build(pkg); err != nil {
+		t.Fatal("Build:", err)
+	}
+}
+
+func TestVetFailure(t *testing.T) {
+	pkg := gox.NewPackage("", "main", nil)
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Printf")).Val("%d\n").Val("Hi").Call(2).EndStmt().
+		End()
+	err := goxtest.Build(pkg)
+	failure, ok := err.(*goxtest.Failure)
+	if !ok {
+		t.Fatal("Build: not a vet failure -", err)
+	}
+	if failure.Cmd != "go vet" || !strings.Contains(failure.Output, "Printf format %d") {
+		t.Fatal("Build:", failure)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	pkg := gox.NewPackage("", "main", nil)
+	fmt := pkg.Import("fmt")
+	foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(foo))
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	pkg.NewFunc(recv, "String", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val("foo").Return(1).
+		End()
+	pkg.CB().NewVarStart(nil, "a").Val(1).Val(2).BinaryOp(token.ADD).EndInit(1)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "x").Val(1).EndInit(1).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "a")).Call(1).EndStmt().
+		End()
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("Check:", err)
+	}
+}
+
+func TestCheckDiff(t *testing.T) {
+	pkg := gox.NewPackage("", "main", nil)
+	pkg.CB().NewVarStart(nil, "a").Val(1).EndInit(1)
+	// rewrite `var a = 1` to `var a int64 = 1`, so a is an int to gox but an int64 to go/types.
+	spec := pkg.ASTFile(false).Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
+	spec.Type = ast.NewIdent("int64")
+	err := goxtest.Check(pkg)
+	e, ok := err.(*goxtest.CheckError)
+	if !ok || len(e.Diffs) != 1 || e.Diffs[0] != "a: gox int, go/types int64" {
+		t.Fatal("Check:", err)
+	}
+}
+
+func ctxRef(pkg *gox.Package, name string) gox.Ref {
+	_, o := pkg.CB().Scope().LookupParent(name, token.NoPos)
+	return o
+}
+
+func TestDiffSource(t *testing.T) {
+	diff, err := goxtest.DiffSource(`package main
+
+// foo func
+func foo(a int) {
+	println(a + 1)
+}
+`, `package main
+
+// foo func
+func foo(a int) { println(a - 1) }
+`)
+	if err != nil {
+		t.Fatal("DiffSource:", err)
+	}
+	if diff == nil || diff.Path != "Decls[0].Body.List[0].X.Args[0].Op" ||
+		diff.Expected != "+" || diff.Got != "-" {
+		t.Fatal("DiffSource:", diff)
+	}
+	diff, err = goxtest.DiffSource("package main\n\nvar a, b int\n", "package main\n\nvar a int\n")
+	if err != nil {
+		t.Fatal("DiffSource:", err)
+	}
+	if diff == nil || diff.Path != "Decls[0].Specs[0].Names[1]" ||
+		diff.Expected != "b" || diff.Got != "<missing>" {
+		t.Fatal("DiffSource:", diff)
+	}
+	diff, err = goxtest.DiffSource("package main\n\nvar a = 1\n", "package main\n\nvar (\n\ta = 1\n)\n")
+	if err != nil || diff != nil {
+		t.Fatal("DiffSource:", diff, err)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	corpus := &goxtest.Corpus{
+		Dir:   "testdata/corpus",
+		Input: "in.txt",
+		Build: func(file string, input []byte) (*gox.Package, error) {
+			pkg := gox.NewPackage("", "main", nil)
+			fmt := pkg.Import("fmt")
+			msg := strings.TrimSuffix(string(input), "\n")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(fmt.Ref("Println")).Val(msg).Call(1).EndStmt().
+				End()
+			return pkg, nil
+		},
+	}
+	corpus.Run(t)
+}
+
+var opsSeeds = []string{
+	"",
+	"00", // constant `false && false`
+	"\x00\x01\x02\x03\x04\x05\x06\x07",
+	"\x00\x00\x07\x01\x00\x02\x10\x03\x09\x04",
+	"\x05\x01\x03\x00\x06\x00\x02\x07\x01\x00\x01\x08\x02\x03\x09\x02",
+	"\x06\x03\x02\x01\x00\x05\x04\x08\x07\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13",
+}
+
+func TestBuildOps(t *testing.T) {
+	for _, seed := range opsSeeds {
+		if err := goxtest.Check(goxtest.BuildOps([]byte(seed))); err != nil {
+			t.Fatalf("%q: %v", seed, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package goxtest
+
+import (
+	"github.com/goplus/gox"
+	"github.com/goplus/gox/script"
+)
+
+// ----------------------------------------------------------------------------
+
+// ScriptError represents an error of a builder script (see script.Error).
+type ScriptError = script.Error
+
+// BuildScript creates a package and runs a builder script against it (see
+// script.Build).
+//
+// BuildScript is a BuildFunc, so builder scripts can be used as inputs of a
+// Corpus.
+func BuildScript(file string, src []byte) (*gox.Package, error) {
+	return script.Build(file, src)
+}
+
+// RunScript runs a builder script against pkg (see script.Run).
+func RunScript(pkg *gox.Package, file string, src []byte) error {
+	return script.Run(pkg, file, src)
+}
+
+// ----------------------------------------------------------------------------
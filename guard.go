@@ -0,0 +1,156 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------------
+
+// NewGuardedAccessors declares a getter and a setter on *typ of the unexported
+// field named field of typ, which are safe for concurrent use. The field is
+// guarded by the field named mutex of typ, a sync.Mutex or sync.RWMutex (a
+// getter locks a sync.RWMutex by RLock):
+//
+//	func (c *C) Name() string {
+//		c.mu.Lock()
+//		defer c.mu.Unlock()
+//		return c.name
+//	}
+//	func (c *C) SetName(v string) {
+//		c.mu.Lock()
+//		defer c.mu.Unlock()
+//		c.name = v
+//	}
+//
+// If mutex is empty, the field must be an int32, int64, uint32, uint64 or
+// uintptr, which is accessed by functions of sync/atomic instead:
+//
+//	func (c *C) Hits() int64 {
+//		return atomic.LoadInt64(&c.hits)
+//	}
+//	func (c *C) SetHits(v int64) {
+//		atomic.StoreInt64(&c.hits, v)
+//	}
+//
+// or of an atomic type (eg. atomic.Value), whose Load and Store methods are
+// called.
+//
+// The getter is named by the capitalized field name, and the setter is named
+// by the getter name prefixed with Set.
+func (p *Package) NewGuardedAccessors(typ *types.Named, field, mutex string) (get, set *Func, err error) {
+	name := typ.Obj().Name()
+	pos := typ.Obj().Pos()
+	lookup := func(t types.Type, name string) types.Object {
+		obj, _, _ := types.LookupFieldOrMethod(t, true, p.Types, name)
+		return obj
+	}
+	fld, ok := lookup(typ, field).(*types.Var)
+	if !ok || !fld.IsField() {
+		return nil, nil, p.cb.newCodePosErrorf(pos, "%s.%s undefined (type %s has no field %s)", name, field, name, field)
+	}
+	r, size := utf8.DecodeRuneInString(field)
+	if !unicode.IsLower(r) {
+		return nil, nil, p.cb.newCodePosErrorf(pos, "can't declare accessors of %s.%s, which isn't a lowercase field", name, field)
+	}
+	getName := string(unicode.ToUpper(r)) + field[size:]
+	setName := "Set" + getName
+	for _, mname := range []string{getName, setName} {
+		if lookup(typ, mname) != nil {
+			return nil, nil, p.cb.newCodePosErrorf(pos, "type %s has field or method %s already", name, mname)
+		}
+	}
+	var tyVal types.Type // type of the field value
+	var rlock, runlock string
+	var atomicFn string // suffix of functions of sync/atomic to access the field
+	if mutex != "" {
+		mu, ok := lookup(typ, mutex).(*types.Var)
+		if !ok || !mu.IsField() {
+			return nil, nil, p.cb.newCodePosErrorf(pos, "%s.%s undefined (type %s has no field %s)", name, mutex, name, mutex)
+		}
+		ptr := types.NewPointer(mu.Type())
+		if lookup(ptr, "Lock") == nil || lookup(ptr, "Unlock") == nil {
+			return nil, nil, p.cb.newCodePosErrorf(pos, "%s.%s (type %v) isn't a mutex", name, mutex, mu.Type())
+		}
+		rlock, runlock = "Lock", "Unlock"
+		if lookup(ptr, "RLock") != nil && lookup(ptr, "RUnlock") != nil {
+			rlock, runlock = "RLock", "RUnlock"
+		}
+		tyVal = fld.Type()
+	} else if t, ok := fld.Type().(*types.Basic); ok && atomicFuncs[t.Kind()] != "" {
+		atomicFn, tyVal = atomicFuncs[t.Kind()], t
+	} else {
+		ptr := types.NewPointer(fld.Type())
+		load, _ := lookup(ptr, "Load").(*types.Func)
+		store, _ := lookup(ptr, "Store").(*types.Func)
+		if load == nil || store == nil ||
+			load.Type().(*types.Signature).Results().Len() != 1 || store.Type().(*types.Signature).Params().Len() != 1 {
+			return nil, nil, p.cb.newCodePosErrorf(pos, "%s.%s (type %v) isn't atomic", name, field, fld.Type())
+		}
+		tyVal = load.Type().(*types.Signature).Results().At(0).Type()
+	}
+	recv := types.NewParam(token.NoPos, p.Types, "", types.NewPointer(typ))
+	ret := types.NewParam(token.NoPos, p.Types, "", tyVal)
+	if get, err = p.NewFuncWith(token.NoPos, getName, types.NewSignature(recv, nil, NewTuple(ret), false), nil); err != nil {
+		return
+	}
+	v := types.NewParam(token.NoPos, p.Types, "v", tyVal)
+	if set, err = p.NewFuncWith(token.NoPos, setName, types.NewSignature(recv, NewTuple(v), nil, false), nil); err != nil {
+		return
+	}
+	this := get.Type().(*types.Signature).Recv()
+	cb := get.BodyStart(p)
+	if mutex != "" {
+		cb.Val(this).MemberVal(mutex).MemberVal(rlock).Call(0).EndStmt()
+		cb.Val(this).MemberVal(mutex).MemberVal(runlock).Call(0).Defer()
+		cb.Val(this).MemberVal(field)
+	} else if atomicFn != "" {
+		cb.Val(p.Import("sync/atomic").Ref("Load" + atomicFn)).
+			Val(this).MemberVal(field).UnaryOp(token.AND).Call(1)
+	} else {
+		cb.Val(this).MemberVal(field).MemberVal("Load").Call(0)
+	}
+	cb.Return(1).End()
+	sig := set.Type().(*types.Signature)
+	this, arg := sig.Recv(), sig.Params().At(0)
+	cb = set.BodyStart(p)
+	if mutex != "" {
+		cb.Val(this).MemberVal(mutex).MemberVal("Lock").Call(0).EndStmt()
+		cb.Val(this).MemberVal(mutex).MemberVal("Unlock").Call(0).Defer()
+		cb.Val(this).MemberRef(field).Val(arg).Assign(1)
+	} else if atomicFn != "" {
+		cb.Val(p.Import("sync/atomic").Ref("Store" + atomicFn)).
+			Val(this).MemberVal(field).UnaryOp(token.AND).Val(arg).Call(2).EndStmt()
+	} else {
+		cb.Val(this).MemberVal(field).MemberVal("Store").Val(arg).Call(1).EndStmt()
+	}
+	cb.End()
+	return
+}
+
+// atomicFuncs are suffixes of functions of sync/atomic (eg. LoadInt64) which
+// access integers of the kinds.
+var atomicFuncs = map[types.BasicKind]string{
+	types.Int32:   "Int32",
+	types.Int64:   "Int64",
+	types.Uint32:  "Uint32",
+	types.Uint64:  "Uint64",
+	types.Uintptr: "Uintptr",
+}
+
+// ----------------------------------------------------------------------------
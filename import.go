@@ -14,13 +14,17 @@
 package gox
 
 import (
+	"crypto/sha1"
 	"fmt"
 	"go/ast"
 	"go/token"
 	"go/types"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/tools/go/packages"
@@ -45,6 +49,7 @@ type PkgRef struct {
 
 	file *file // to import packages anywhere
 	pkg  *Package
+	path string // import path
 
 	pkgf *pkgFingerp
 
@@ -57,6 +62,8 @@ type PkgRef struct {
 
 	isUsed   bool
 	nameRefs []*ast.Ident // for internal use
+
+	docs map[string]string // doc comments (see Config.LoadDocs)
 }
 
 type pkgFingerp struct {
@@ -103,20 +110,51 @@ func (p *PkgRef) Ref(name string) Ref {
 	return p.Types.Scope().Lookup(name)
 }
 
+// Doc returns the doc comment of an object (eg. "Println"), or a method or a
+// field of a type (eg. "Buffer.Write"). It returns the doc comment of the
+// package if name is empty. Doc comments are available only if the package is
+// loaded with Config.LoadDocs.
+func (p *PkgRef) Doc(name string) string {
+	p.EnsureImported()
+	return p.docs[name]
+}
+
 // MarkForceUsed marks this package is force-used.
 func (p *PkgRef) MarkForceUsed() {
 	p.isForceUsed = true
 }
 
-// EnsureImported ensures this package is imported.
+// EnsureImported ensures this package is imported. Package.Import doesn't load
+// the package until EnsureImported is called (eg. by Ref), see also
+// Config.LazyImport.
 func (p *PkgRef) EnsureImported() {
 	if p.Types == nil {
-		p.file.endImport(p.pkg, p.inTestingFile)
+		p.file.endImport(p.pkg, p)
 	}
 }
 
+// adopt imports pkg (eg. the package of an object referenced by Val) instead
+// of loading this package by its path.
+func (p *PkgRef) adopt(pkg *types.Package) {
+	p.ID = pkg.Path()
+	p.Types = pkg
+	p.file.delayPkgPaths = removePkgPath(p.file.delayPkgPaths, p.path)
+}
+
 // ----------------------------------------------------------------------------
 
+// LoadGoPkgsShared is the default LoadPkgsFunc. It loads the Go packages named
+// by the given pkgPaths (and their dependencies) by a Loader shared by packages
+// whose load configs (ModRoot, Dir, Env, GoEnv, TargetPlatform, BuildFlags,
+// LoadMode and LoadDocs) are the same, so that each package is loaded only
+// once in this process. At most maxSharedLoaders loaders are kept, and the
+// least recently used one is dropped first. Positions of loaded packages are
+// in the FileSet of the shared loader, instead of Config.Fset. Use NewLoader
+// to control the lifetime and the FileSet of loaded packages.
+func LoadGoPkgsShared(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
+	return sharedLoaders.get(at.conf).Load(at, importPkgs, pkgPaths...)
+}
+
 // LoadGoPkgs loads and returns the Go packages named by the given pkgPaths.
 func LoadGoPkgs(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
 	conf := at.InternalGetLoadConfig()
@@ -148,11 +186,16 @@ func LoadGoPkg(at *Package, imports map[string]*PkgRef, loadPkg *packages.Packag
 	pkg, ok := imports[loadPkg.PkgPath]
 	pkgTypes := loadPkg.Types
 	initGopPkg(pkgTypes)
+	var docs map[string]string
+	if loadPkg.Syntax != nil {
+		docs = loadDocs(loadPkg.Syntax)
+	}
 	if ok {
 		if pkg.ID == "" {
 			pkg.ID = loadPkg.ID
 			pkg.Types = pkgTypes
 			pkg.IllTyped = loadPkg.IllTyped
+			pkg.docs = docs
 		}
 	} else {
 		pkg = &PkgRef{
@@ -160,14 +203,88 @@ func LoadGoPkg(at *Package, imports map[string]*PkgRef, loadPkg *packages.Packag
 			Types:    pkgTypes,
 			IllTyped: loadPkg.IllTyped,
 			pkg:      at,
+			docs:     docs,
 		}
 		imports[loadPkg.PkgPath] = pkg
 	}
-	if loadPkg.Module != nil && loadPkg.Module.Path == at.modPath {
+	if mod := loadPkg.Module; mod != nil && (mod.Path == at.modPath || isLocalReplace(mod)) {
 		pkg.pkgf = &pkgFingerp{files: fileList(loadPkg), updated: true}
 	}
 }
 
+// isLocalReplace reports whether mod is replaced by a directory in go.mod of
+// the current module, whose source files may be changed as the module's.
+func isLocalReplace(mod *packages.Module) bool {
+	return mod.Replace != nil && mod.Replace.Version == ""
+}
+
+// loadDocs returns doc comments of a package (see PkgRef.Doc).
+func loadDocs(files []*ast.File) map[string]string {
+	docs := make(map[string]string)
+	add := func(name string, doc *ast.CommentGroup) {
+		if doc != nil {
+			docs[name] = doc.Text()
+		}
+	}
+	for _, f := range files {
+		add("", f.Doc)
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				name := d.Name.Name
+				if d.Recv != nil && len(d.Recv.List) == 1 {
+					name = recvTypeName(d.Recv.List[0].Type) + "." + name
+				}
+				add(name, d.Doc)
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch v := spec.(type) {
+					case *ast.TypeSpec:
+						if v.Doc != nil || len(d.Specs) > 1 {
+							add(v.Name.Name, v.Doc)
+						} else {
+							add(v.Name.Name, d.Doc)
+						}
+						if t, ok := v.Type.(*ast.StructType); ok {
+							for _, field := range t.Fields.List {
+								for _, fname := range field.Names {
+									add(v.Name.Name+"."+fname.Name, field.Doc)
+								}
+							}
+						}
+					case *ast.ValueSpec:
+						doc := v.Doc
+						if doc == nil && len(d.Specs) == 1 {
+							doc = d.Doc
+						}
+						for _, name := range v.Names {
+							add(name.Name, doc)
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+func recvTypeName(typ ast.Expr) string {
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr: // generic type
+			typ = t.X
+		case *ast.ParenExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
 func fileList(loadPkg *packages.Package) []string {
 	return loadPkg.GoFiles
 }
@@ -233,6 +350,9 @@ func initGopPkg(pkg *types.Package) {
 }
 
 func overloadFuncs(off int, items []types.Object) []types.Object {
+	if len(items) > DefaultMaxOverloadFuncs {
+		log.Panicln("too many overload functions:", len(items), ">", DefaultMaxOverloadFuncs)
+	}
 	fns := make([]types.Object, len(items))
 	for _, item := range items {
 		idx := toIndex(item.Name()[off])
@@ -290,6 +410,7 @@ retry:
 				pkg.ID = loadPkg.ID
 				pkg.Types = &typs // clone *types.Package instance
 				pkg.IllTyped = loadPkg.IllTyped
+				pkg.docs = loadPkg.docs
 			}
 		} else {
 			unimportedPaths = append(unimportedPaths, pkgPath)
@@ -334,6 +455,83 @@ func OpenLoadPkgsCached(
 	return &LoadPkgsCached{imports: imports, pkgsLoad: load, cacheFile: file}
 }
 
+// cacheDirLoader loads packages by a LoadPkgsCached which is backed by a cache
+// file in Config.CacheDir, and saves the cache file if new packages are loaded.
+type cacheDirLoader struct {
+	cached *LoadPkgsCached
+	dir    string
+	mutex  sync.Mutex
+}
+
+var (
+	cacheDirLoaders     = make(map[string]*cacheDirLoader)
+	cacheDirLoaderMutex sync.Mutex
+)
+
+// loadPkgsCacheDir returns the loader of conf.CacheDir. The cache file is keyed
+// by the load config, the Go version and fingerprints of go.mod and go.sum of
+// the current module, so that it is reloaded if dependencies are changed.
+func loadPkgsCacheDir(conf *Config) LoadPkgsFunc {
+	key := fmt.Sprintf("%s\x00%s\x00%v\x00%v\x00%v\x00%d\x00%v\x00%s",
+		conf.ModPath, conf.dir(), conf.Env, conf.goEnv(), conf.BuildFlags, conf.LoadMode, conf.LoadDocs, runtime.Version())
+	if modFiles := findModFiles(conf.dir()); modFiles != nil {
+		key += "\x00" + calcFingerp(modFiles)
+	}
+	file := filepath.Join(conf.CacheDir, fmt.Sprintf("pkgs-%x.cache", sha1.Sum([]byte(key))))
+	cacheDirLoaderMutex.Lock()
+	defer cacheDirLoaderMutex.Unlock()
+	p, ok := cacheDirLoaders[file]
+	if !ok {
+		p = &cacheDirLoader{cached: OpenLoadPkgsCached(file, nil), dir: conf.CacheDir}
+		cacheDirLoaders[file] = p
+	}
+	return p.load
+}
+
+func (p *cacheDirLoader) load(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	loaded := true
+	for _, pkgPath := range pkgPaths {
+		if _, ok := p.cached.imported(pkgPath); !ok {
+			loaded = false
+			break
+		}
+	}
+	if nerr := p.cached.Load(at, importPkgs, pkgPaths...); nerr > 0 {
+		return nerr
+	}
+	if !loaded {
+		if err := p.save(); err != nil {
+			log.Println("[WARN] save pkgs cache failed:", err)
+		}
+	}
+	return 0
+}
+
+// save saves the cache file. A failure of saving the cache (eg. a type which
+// can't be persisted) isn't fatal, so it is reported as an error.
+func (p *cacheDirLoader) save() (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	if err = os.MkdirAll(p.dir, 0755); err != nil {
+		return
+	}
+	return p.cached.Save()
+}
+
+// findModFiles returns go.mod and go.sum of the module which dir belongs to.
+func findModFiles(dir string) []string {
+	root, err := FindModRoot(dir)
+	if err != nil {
+		return nil
+	}
+	return []string{filepath.Join(root, "go.mod"), filepath.Join(root, "go.sum")}
+}
+
 // ----------------------------------------------------------------------------
 
 const (
@@ -341,16 +539,44 @@ const (
 	loadModes = loadTypes | packages.NeedName | packages.NeedModule | packages.NeedFiles
 )
 
+// LoadMode controls how imported packages are loaded.
+type LoadMode int
+
+const (
+	// LoadDefault loads types of packages from export data, and remembers
+	// source files of packages of the current module to detect changes.
+	LoadDefault LoadMode = iota
+
+	// LoadExportData loads types of packages from export data only. It is
+	// faster than LoadDefault, but changes of packages of the current module
+	// aren't detected by cached loaders.
+	LoadExportData
+
+	// LoadSource type-checks packages from source. It is slower, but works
+	// if export data isn't available.
+	LoadSource
+)
+
 // InternalGetLoadConfig is a internal function. don't use it.
 func (p *Package) InternalGetLoadConfig() *packages.Config {
 	conf := p.conf
+	mode := loadModes
+	switch conf.LoadMode {
+	case LoadExportData:
+		mode = loadTypes | packages.NeedName
+	case LoadSource:
+		mode |= packages.NeedSyntax
+	}
+	if conf.LoadDocs {
+		mode |= packages.NeedSyntax
+	}
 	return &packages.Config{
-		Mode:       loadModes,
+		Mode:       mode,
 		Context:    conf.Context,
 		Logf:       conf.Logf,
-		Dir:        conf.Dir,
-		Env:        conf.Env,
-		BuildFlags: conf.BuildFlags,
+		Dir:        conf.dir(),
+		Env:        conf.environ(),
+		BuildFlags: conf.buildFlags(),
 		Fset:       conf.Fset,
 		ParseFile:  conf.ParseFile,
 	}
@@ -358,11 +584,11 @@ func (p *Package) InternalGetLoadConfig() *packages.Config {
 
 // Import func
 func (p *Package) Import(pkgPath string) *PkgRef {
-	return p.files[p.testingFile].importPkg(p, pkgPath, p.testingFile != 0)
+	return p.files[p.curFile].importPkg(p, pkgPath, p.InTestingFile())
 }
 
 func (p *Package) big() *PkgRef {
-	return p.files[p.testingFile].big(p, p.testingFile != 0)
+	return p.files[p.curFile].big(p, p.InTestingFile())
 }
 
 // ----------------------------------------------------------------------------
@@ -375,9 +601,26 @@ type autoNames struct {
 	idx     int
 }
 
+// importedBy returns the imported package which x refers to, or nil if x
+// doesn't refer to an imported package.
+func (p *Package) importedBy(x *ast.Ident) *PkgRef {
+	for i := range p.files {
+		for _, ref := range p.files[i].importPkgs {
+			for _, nameRef := range ref.nameRefs {
+				if nameRef == x {
+					return ref
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (p *Package) autoName() string {
 	p.autoIdx++
-	return p.autoPrefix + strconv.Itoa(p.autoIdx)
+	name := p.autoPrefix + strconv.Itoa(p.autoIdx)
+	p.tempNameUsed(name)
+	return name
 }
 
 func (p *Package) newAutoNames() *autoNames {
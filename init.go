@@ -0,0 +1,340 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+
+	"github.com/goplus/gox/internal/go/format"
+)
+
+// ----------------------------------------------------------------------------
+
+// NewInit creates a `func init()`. A package can have multiple init funcs.
+func (p *Package) NewInit() *Func {
+	return p.NewFunc(nil, "init", nil, nil, false)
+}
+
+// ResolveInitCycles finds package-level vars whose initializers refer to
+// themselves (eg. `var a = f()` where func f refers to a). Such vars cause an
+// initialization cycle error, so their initializers are moved into a generated
+// init func:
+//
+//	var a int
+//	func init() {
+//		a = f()
+//	}
+//
+// Vars whose initializers refer to the moved vars (eg. `var b = a`) are moved
+// too, so that they don't see zero values. The moved initializers are in
+// dependency order (or in declaration order if they are independent), and the
+// generated init func is placed before other init funcs.
+//
+// Other vars are left untouched because Go initializes package-level vars in
+// dependency order, not in declaration order. It returns names of the vars
+// moved. References are resolved by type-checking the package, so local names
+// which shadow objects of the package, fields and methods are told apart as
+// the compiler does.
+func (p *Package) ResolveInitCycles() (names []string) {
+	deps, specRefs := p.initDeps()
+	var cyclic []string
+	moved := make(map[*ast.ValueSpec]bool)
+	for i := range p.files {
+		for _, spec := range varSpecs(p.files[i].decls) {
+			if spec.Values != nil && initCycle(deps, spec.Names) {
+				moved[spec] = true
+				for _, name := range spec.Names {
+					cyclic = append(cyclic, name.Name)
+				}
+			}
+		}
+	}
+	if cyclic == nil {
+		return
+	}
+	for i := range p.files { // vars which refer to the moved vars are moved too
+		for j, spec := range varSpecs(p.files[i].decls) {
+			if spec.Values != nil && !moved[spec] && referAny(deps, specRefs[i][j], cyclic) {
+				moved[spec] = true
+			}
+		}
+	}
+	for i := range p.files {
+		f := p.files[i]
+		var specs []initSpec
+		for j, spec := range varSpecs(f.decls) {
+			if moved[spec] {
+				specs = append(specs, initSpec{names: spec.Names, values: spec.Values, refs: specRefs[i][j]})
+			}
+		}
+		if specs == nil {
+			continue
+		}
+		for _, decl := range f.decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.VAR {
+				continue
+			}
+			kept := make([]ast.Spec, 0, len(d.Specs))
+			for _, spec := range d.Specs {
+				spec := spec.(*ast.ValueSpec)
+				if !moved[spec] {
+					kept = append(kept, spec)
+					continue
+				}
+				spec.Values = nil
+				if spec.Type != nil {
+					kept = append(kept, spec)
+					continue
+				}
+				for _, name := range spec.Names { // var a, b = f() => var a T1; var b T2
+					if name.Name == "_" {
+						continue
+					}
+					typ := p.Types.Scope().Lookup(name.Name).Type()
+					kept = append(kept, &ast.ValueSpec{Names: []*ast.Ident{name}, Type: toType(p, typ)})
+				}
+			}
+			d.Specs = kept
+		}
+		stmts := make([]ast.Stmt, 0, len(specs))
+		for _, spec := range sortInitSpecs(deps, specs) {
+			lhs := make([]ast.Expr, len(spec.names))
+			for i, name := range spec.names {
+				lhs[i] = ident(name.Name)
+				if name.Name != "_" {
+					names = append(names, name.Name)
+				}
+			}
+			stmts = append(stmts, &ast.AssignStmt{Lhs: lhs, Tok: token.ASSIGN, Rhs: spec.values})
+		}
+		if debugInstr {
+			log.Println("ResolveInitCycles", names)
+		}
+		fn := &ast.FuncDecl{
+			Name: ident("init"),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: stmts},
+		}
+		f.decls = insertInit(f.decls, fn)
+	}
+	return
+}
+
+// initSpec is a var spec whose initializer is moved into an init func.
+type initSpec struct {
+	names  []*ast.Ident
+	values []ast.Expr
+	refs   []string // references of values (see initDeps)
+}
+
+// initDeps returns references of package-level vars, funcs and methods (whose
+// keys are like "T.m") of the package to each other, as the compiler finds
+// them to order initialization, and references of initializers of var specs
+// of each file (see varSpecs). The package is type-checked from its printed
+// source to resolve references, so type errors are ignored.
+func (p *Package) initDeps() (deps map[string][]string, specRefs [][][]string) {
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(p.files))
+	for i := range p.files {
+		var b bytes.Buffer
+		if err := format.Node(&b, token.NewFileSet(), p.astFile(p.files[i])); err != nil {
+			log.Panicln("ResolveInitCycles:", err)
+		}
+		f, err := parser.ParseFile(fset, "", b.Bytes(), 0)
+		if err != nil {
+			log.Panicln("ResolveInitCycles:", err)
+		}
+		files = append(files, f)
+	}
+	info := &types.Info{Uses: make(map[*ast.Ident]types.Object)}
+	conf := &types.Config{Importer: pkgImporter(p), Error: func(err error) {}}
+	checked, _ := conf.Check(p.Types.Path(), fset, files, info)
+	refs := func(node ast.Node) (keys []string) {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if key := initKey(checked, info.Uses[id]); key != "" {
+					keys = append(keys, key)
+				}
+			}
+			return true
+		})
+		return
+	}
+	deps = make(map[string][]string)
+	specRefs = make([][][]string, len(files))
+	for i, f := range files {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Body != nil {
+				if d.Recv != nil {
+					deps[recvTypeName(d.Recv.List[0].Type)+"."+d.Name.Name] = refs(d.Body)
+				} else if d.Name.Name != "init" {
+					deps[d.Name.Name] = refs(d.Body)
+				}
+			}
+		}
+		for _, spec := range varSpecs(f.Decls) {
+			var keys []string
+			for _, val := range spec.Values {
+				keys = append(keys, refs(val)...)
+			}
+			for _, name := range spec.Names {
+				if name.Name != "_" {
+					deps[name.Name] = keys
+				}
+			}
+			specRefs[i] = append(specRefs[i], keys)
+		}
+	}
+	return
+}
+
+// initKey returns the key of obj in initDeps, or "" if obj isn't a var, func
+// or (non-interface) method declared at the package level of pkg.
+func initKey(pkg *types.Package, obj types.Object) string {
+	if obj == nil || obj.Pkg() != pkg {
+		return ""
+	}
+	switch o := obj.(type) {
+	case *types.Var:
+		if o.Parent() == pkg.Scope() {
+			return o.Name()
+		}
+	case *types.Func:
+		recv := o.Type().(*types.Signature).Recv()
+		if recv == nil {
+			return o.Name()
+		}
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if named, ok := t.(*types.Named); ok && !types.IsInterface(named) {
+			return named.Obj().Name() + "." + o.Name()
+		}
+	}
+	return ""
+}
+
+// varSpecs returns specs of var decls of decls.
+func varSpecs(decls []ast.Decl) (specs []*ast.ValueSpec) {
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.VAR {
+			for _, spec := range d.Specs {
+				specs = append(specs, spec.(*ast.ValueSpec))
+			}
+		}
+	}
+	return
+}
+
+// sortInitSpecs sorts specs in dependency order: a spec is placed after specs
+// it refers to, unless they refer to each other (ie. they are in a cycle).
+// Otherwise, specs are in declaration order.
+func sortInitSpecs(deps map[string][]string, specs []initSpec) []initSpec {
+	names := func(spec initSpec) []string {
+		ret := make([]string, 0, len(spec.names))
+		for _, name := range spec.names {
+			if name.Name != "_" {
+				ret = append(ret, name.Name)
+			}
+		}
+		return ret
+	}
+	before := func(x, y initSpec) bool { // x must be initialized before y
+		return referAny(deps, y.refs, names(x)) && !referAny(deps, x.refs, names(y))
+	}
+	sorted := make([]initSpec, 0, len(specs))
+	done := make([]bool, len(specs))
+	for len(sorted) < len(specs) {
+		for i, spec := range specs {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for j, other := range specs {
+				if !done[j] && j != i && before(other, spec) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted, done[i] = append(sorted, spec), true
+				break
+			}
+		}
+	}
+	return sorted
+}
+
+// insertInit inserts fn before the first init func, so that it is executed
+// before init funcs written by users.
+func insertInit(decls []ast.Decl, fn *ast.FuncDecl) []ast.Decl {
+	idx := len(decls)
+	for i, decl := range decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d.Recv == nil && d.Name.Name == "init" {
+			idx = i
+			break
+		}
+	}
+	decls = append(decls, nil)
+	copy(decls[idx+1:], decls[idx:])
+	decls[idx] = fn
+	return decls
+}
+
+// initCycle checks if any of names refers to itself via its dependencies.
+func initCycle(deps map[string][]string, names []*ast.Ident) bool {
+	for _, name := range names {
+		if name.Name != "_" && refer(deps, name.Name, name.Name, make(map[string]bool)) {
+			return true
+		}
+	}
+	return false
+}
+
+// referAny checks if any of refs is, or refers to, any of targets via their
+// dependencies.
+func referAny(deps map[string][]string, refs []string, targets []string) bool {
+	for _, ref := range refs {
+		for _, target := range targets {
+			if ref == target || refer(deps, ref, target, make(map[string]bool)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func refer(deps map[string][]string, from, name string, visited map[string]bool) bool {
+	for _, dep := range deps[from] {
+		if dep == name {
+			return true
+		}
+		if !visited[dep] {
+			visited[dep] = true
+			if refer(deps, dep, name, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
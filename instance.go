@@ -0,0 +1,70 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// ----------------------------------------------------------------------------
+
+// instType returns the helper type (eg. an Option struct type) generated by
+// gen for the type argument typ. Instantiations are cached in m and keyed by
+// type identity, so a helper is generated once for identical types, even if
+// they are different type objects (eg. two []int types created separately).
+//
+// A helper is always declared in the normal file, so that it is shared by the
+// normal file and the testing file. Helpers are shared by forks of a package
+// (see Fork), so they are instantiated under the lock of the root package.
+func (p *Package) instType(m *typeutil.Map, typ types.Type, gen func() interface{}) interface{} {
+	root := p.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	if inst := m.At(typ); inst != nil {
+		return inst
+	}
+	old := p.curFile
+	p.curFile = 0
+	defer func() { p.curFile = old }()
+	inst := gen()
+	m.Set(typ, inst)
+	return inst
+}
+
+// instTypeName returns an unused name of a type generated for typ, eg. the name
+// of Option struct type of int is OptionInt.
+func (p *Package) instTypeName(prefix string, typ types.Type) string {
+	var name string
+	switch t := typ.(type) {
+	case *types.Basic:
+		name = t.Name()
+	case *types.Named:
+		name = t.Obj().Name()
+	}
+	if name != "" {
+		name = prefix + strings.ToUpper(name[:1]) + name[1:]
+	} else {
+		name = prefix
+	}
+	for i, base := 1, name; p.lookupObj(name) != nil; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	return name
+}
+
+// ----------------------------------------------------------------------------
@@ -39,6 +39,11 @@ const (
 
 var config = printer.Config{Mode: printerMode, Tabwidth: tabWidth}
 
+// Config returns the printer config of canonical gofmt style.
+func Config() printer.Config {
+	return config
+}
+
 const parserMode = parser.ParseComments
 
 // Node formats node in canonical gofmt style and writes the result to dst.
@@ -53,6 +58,12 @@ const parserMode = parser.ParseComments
 // and return a formatting error, for instance due to an incorrect AST.
 //
 func Node(dst io.Writer, fset *token.FileSet, node interface{}) error {
+	return NodeWith(dst, fset, node, &config)
+}
+
+// NodeWith is like Node, but node is printed by cfg instead of the printer
+// config of canonical gofmt style.
+func NodeWith(dst io.Writer, fset *token.FileSet, node interface{}, cfg *printer.Config) error {
 	// Determine if we have a complete source file (file != nil).
 	var file *ast.File
 	var cnode *printer.CommentedNode
@@ -71,7 +82,7 @@ func Node(dst io.Writer, fset *token.FileSet, node interface{}) error {
 		// Make a copy of the AST because ast.SortImports is destructive.
 		// TODO(gri) Do this more efficiently.
 		var buf bytes.Buffer
-		err := config.Fprint(&buf, fset, file)
+		err := cfg.Fprint(&buf, fset, file)
 		if err != nil {
 			return err
 		}
@@ -89,7 +100,7 @@ func Node(dst io.Writer, fset *token.FileSet, node interface{}) error {
 		}
 	}
 
-	return config.Fprint(dst, fset, node)
+	return cfg.Fprint(dst, fset, node)
 }
 
 // Source formats src in canonical gofmt style and returns the result
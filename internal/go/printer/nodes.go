@@ -319,6 +319,37 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 	}
 }
 
+// MultiLine is the position of the closing token of a call (Rparen) or a
+// composite literal (Rbrace), whose args (or elements) are printed one per line
+// with a terminating comma. It isn't a valid position of any file, and the end
+// position of the call (or composite literal) is token.NoPos.
+const MultiLine token.Pos = -1
+
+// multiLineList prints list one entry per line with a terminating comma (see
+// MultiLine). If ellipsis is true, the last entry is followed by `...`.
+func (p *printer) multiLineList(list []ast.Expr, depth int, ellipsis bool) {
+	if len(list) == 0 {
+		return
+	}
+	p.print(indent)
+	for i, x := range list {
+		p.print(newline)
+		if pair, ok := x.(*ast.KeyValueExpr); ok && len(list) > 1 {
+			// use a column for the key such that consecutive entries can align
+			p.expr(pair.Key)
+			p.print(token.COLON, vtab)
+			p.expr(pair.Value)
+		} else {
+			p.expr0(x, depth)
+		}
+		if ellipsis && i == len(list)-1 {
+			p.print(token.ELLIPSIS)
+		}
+		p.print(token.COMMA)
+	}
+	p.print(unindent, formfeed)
+}
+
 func (p *printer) parameters(fields *ast.FieldList) {
 	p.print(fields.Opening, token.LPAREN)
 	if len(fields.List) > 0 {
@@ -889,16 +920,21 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 			wasIndented = p.possibleSelectorExpr(x.Fun, token.HighestPrec, depth)
 		}
 		p.print(x.Lparen, token.LPAREN)
-		if x.Ellipsis.IsValid() {
-			p.exprList(x.Lparen, x.Args, depth, 0, x.Ellipsis, false)
-			p.print(x.Ellipsis, token.ELLIPSIS)
-			if x.Rparen.IsValid() && p.lineFor(x.Ellipsis) < p.lineFor(x.Rparen) {
-				p.print(token.COMMA, formfeed)
-			}
+		if x.Rparen == MultiLine {
+			p.multiLineList(x.Args, depth, x.Ellipsis.IsValid())
+			p.print(token.RPAREN)
 		} else {
-			p.exprList(x.Lparen, x.Args, depth, commaTerm, x.Rparen, false)
+			if x.Ellipsis.IsValid() {
+				p.exprList(x.Lparen, x.Args, depth, 0, x.Ellipsis, false)
+				p.print(x.Ellipsis, token.ELLIPSIS)
+				if x.Rparen.IsValid() && p.lineFor(x.Ellipsis) < p.lineFor(x.Rparen) {
+					p.print(token.COMMA, formfeed)
+				}
+			} else {
+				p.exprList(x.Lparen, x.Args, depth, commaTerm, x.Rparen, false)
+			}
+			p.print(x.Rparen, token.RPAREN)
 		}
-		p.print(x.Rparen, token.RPAREN)
 		if wasIndented {
 			p.print(unindent)
 		}
@@ -910,6 +946,12 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		}
 		p.level++
 		p.print(x.Lbrace, token.LBRACE)
+		if x.Rbrace == MultiLine {
+			p.multiLineList(x.Elts, 1, false)
+			p.print(token.RBRACE)
+			p.level--
+			break
+		}
 		p.exprList(x.Lbrace, x.Elts, 1, commaTerm, x.Rbrace, x.Incomplete)
 		// do not insert extra line break following a /*-style comment
 		// before the closing '}' as it might break the code if there
@@ -1079,7 +1121,12 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 	}
 	var line int
 	i := 0
+	blankLine := false
 	for _, s := range list {
+		if s == BlankLine {
+			blankLine = i > 0 // a blank line at the beginning of a block is dropped
+			continue
+		}
 		// ignore empty statements (was issue 3466)
 		if _, isEmpty := s.(*ast.EmptyStmt); !isEmpty {
 			// nindent == 0 only for lists of switch/select case clauses;
@@ -1087,7 +1134,11 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 			if len(p.output) > 0 {
 				// only print line break if we are not at the beginning of the output
 				// (i.e., we are not printing only a partial program)
-				p.linebreak(p.lineFor(s.Pos()), 1, ignore, i == 0 || nindent == 0 || p.linesFrom(line) > 0)
+				min := 1
+				if blankLine {
+					min, blankLine = 2, false
+				}
+				p.linebreak(p.lineFor(s.Pos()), min, ignore, i == 0 || nindent == 0 || p.linesFrom(line) > 0)
 			}
 			p.recordLine(&line)
 			p.stmt(s, nextIsRBrace && i == len(list)-1)
@@ -1414,6 +1465,10 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.setComment(s.Comments)
 		p.stmt(s.Stmt, nextIsRBrace)
 
+	case *LineStmt:
+		p.print(&ast.Ident{Name: s.Directive}, blank)
+		p.stmt(s.Stmt, nextIsRBrace)
+
 	default:
 		panic("unreachable")
 	}
@@ -1425,6 +1480,21 @@ type CommentedStmt struct {
 	ast.Stmt
 }
 
+// LineStmt represents a statement with a line directive, which is printed at
+// the same line before the statement, eg. `/*line foo.gop:10:2*/ x := 1`.
+type LineStmt struct {
+	Directive string
+	ast.Stmt
+}
+
+// BlankLine is an empty statement which is printed as a blank line separating
+// statements around it. It is dropped at the beginning or end of a block.
+var BlankLine ast.Stmt = &ast.EmptyStmt{Implicit: true}
+
+// BlankLineDecl is an empty declaration which is printed as a blank line
+// separating declarations around it.
+var BlankLineDecl ast.Decl = &ast.BadDecl{}
+
 // ----------------------------------------------------------------------------
 // Declarations
 
@@ -1820,7 +1890,12 @@ func declToken(decl ast.Decl) (tok token.Token) {
 
 func (p *printer) declList(list []ast.Decl) {
 	tok := token.ILLEGAL
+	blankLine := false
 	for _, d := range list {
+		if d == BlankLineDecl {
+			blankLine = true
+			continue
+		}
 		prev := tok
 		tok = declToken(d)
 		// If the declaration token changed (e.g., from CONST to TYPE)
@@ -1834,7 +1909,7 @@ func (p *printer) declList(list []ast.Decl) {
 			// only print line break if we are not at the beginning of the output
 			// (i.e., we are not printing only a partial program)
 			min := 1
-			if prev != tok || getDoc(d) != nil {
+			if prev != tok || getDoc(d) != nil || blankLine {
 				min = 2
 			}
 			// start a new section if the next declaration is a function
@@ -1842,6 +1917,7 @@ func (p *printer) declList(list []ast.Decl) {
 			p.linebreak(p.lineFor(d.Pos()), min, ignore, tok == token.FUNC && p.numLines(d) > 1)
 		}
 		p.decl(d)
+		blankLine = false
 	}
 }
 
@@ -28,6 +28,54 @@ type Elem struct {
 	Type types.Type
 	CVal constant.Value
 	Src  ast.Node
+	Data interface{} // user data (see CodeBuilder.SetData)
+}
+
+const (
+	elemBlockSize = 64
+	maxElemBlocks = 64 // max number of blocks kept by Reset for reuse
+)
+
+// An ElemAllocator allocates Elems from a pool of blocks, to reduce allocations
+// (and GC pressure) when generating large packages. Elems are reused after
+// Reset, which is called at the end of each top-level func if it's enabled
+// by Config.ReuseElems (see Func.End): an Elem must not be used after then.
+//
+// An ElemAllocator isn't goroutine-safe. Each Package (including each fork
+// of a package) has its own allocator.
+type ElemAllocator struct {
+	blocks [][]Elem
+	n      int // number of Elems allocated since the last Reset
+}
+
+// New allocates an Elem whose value is v.
+func (p *ElemAllocator) New(v Elem) *Elem {
+	i, j := p.n/elemBlockSize, p.n%elemBlockSize
+	if i == len(p.blocks) {
+		p.blocks = append(p.blocks, make([]Elem, elemBlockSize))
+	}
+	e := &p.blocks[i][j]
+	*e = v
+	p.n++
+	return e
+}
+
+// Reset frees all Elems allocated by New, so that they are reused. Freed Elems
+// are cleared, so that values they refer to can be collected by GC.
+func (p *ElemAllocator) Reset() {
+	for i := 0; i*elemBlockSize < p.n; i++ {
+		block := p.blocks[i]
+		if n := p.n - i*elemBlockSize; n < elemBlockSize {
+			block = block[:n]
+		}
+		for j := range block {
+			block[j] = Elem{}
+		}
+	}
+	if len(p.blocks) > maxElemBlocks {
+		p.blocks = p.blocks[:maxElemBlocks]
+	}
+	p.n = 0
 }
 
 // A Stack represents a FILO container.
@@ -0,0 +1,339 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ----------------------------------------------------------------------------
+
+// A Loader loads Go packages (see Config.LoadPkgs) and caches them. Packages
+// loaded by a loader are in one type universe: each package is loaded only
+// once, and its dependencies are shared by packages which import them, even
+// if they are loaded separately (eg. in LazyImport mode). Types are read from
+// export data, or type-checked from source in LoadSource mode (or if export
+// data can't be read, as go/packages does).
+//
+// Packages of the current module (or of modules replaced by local directories)
+// are reloaded with packages importing them, if their source files are
+// changed, unless they are loaded in LoadExportData mode.
+//
+// A Loader is goroutine-safe. Packages are listed (by `go list`) concurrently,
+// and then imported into the universe one by one.
+type Loader struct {
+	fset    *token.FileSet
+	conf    Config                    // load config (see NewLoader)
+	sizes   types.Sizes               // to type-check packages from source
+	imports map[string]*types.Package // the type universe
+	pkgs    map[string]*loaderPkg     // loaded packages by import path
+	mutex   sync.Mutex
+}
+
+type loaderPkg struct {
+	ref  PkgRef
+	deps []string // import paths of packages imported by the package
+}
+
+// NewLoader creates a Loader which loads packages by the load config of conf
+// (ModRoot, Dir, Env, GoEnv, TargetPlatform, BuildFlags, LoadMode, LoadDocs and
+// ParseFile), regardless of configs of packages which call it. Positions of
+// loaded packages are in conf.Fset, or in a new FileSet if it is nil (see
+// Loader.Fset).
+func NewLoader(conf *Config) *Loader {
+	if conf == nil {
+		conf = &Config{}
+	}
+	p := &Loader{
+		fset:    conf.Fset,
+		conf:    *conf,
+		imports: make(map[string]*types.Package),
+		pkgs:    make(map[string]*loaderPkg),
+	}
+	if p.fset == nil {
+		p.fset = token.NewFileSet()
+	}
+	p.sizes = (&Platform{GOARCH: conf.getenv("GOARCH")}).Sizes()
+	return p
+}
+
+// Fset returns the FileSet of positions of packages loaded by the loader.
+func (p *Loader) Fset() *token.FileSet {
+	return p.fset
+}
+
+// Load is a LoadPkgsFunc which loads the Go packages named by pkgPaths (and
+// their dependencies) by the loader.
+func (p *Loader) Load(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
+	for {
+		p.mutex.Lock()
+		p.dropChanged()
+		var unloaded []string
+		for _, pkgPath := range pkgPaths {
+			if _, ok := p.pkgs[pkgPath]; !ok {
+				unloaded = append(unloaded, pkgPath)
+			}
+		}
+		if unloaded == nil {
+			for _, pkgPath := range pkgPaths {
+				if pkg, ok := importPkgs[pkgPath]; ok {
+					ref := &p.pkgs[pkgPath].ref
+					pkg.ID, pkg.Types, pkg.IllTyped, pkg.docs = ref.ID, ref.Types, ref.IllTyped, ref.docs
+				}
+			}
+			p.mutex.Unlock()
+			return 0
+		}
+		p.mutex.Unlock()
+
+		loadPkgs, err := packages.Load(p.loadConfig(at), unloaded...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if n := packages.PrintErrors(loadPkgs); n > 0 {
+			return n
+		}
+		p.mutex.Lock()
+		nerr := 0
+		for _, loadPkg := range loadPkgs {
+			nerr += p.load(loadPkg)
+		}
+		p.mutex.Unlock()
+		if nerr > 0 {
+			return nerr
+		}
+	}
+}
+
+func (p *Loader) loadConfig(at *Package) *packages.Config {
+	conf := &p.conf
+	mode := packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedCompiledGoFiles
+	switch conf.LoadMode {
+	case LoadExportData:
+		mode |= packages.NeedExportsFile
+	case LoadSource:
+		mode |= packages.NeedFiles | packages.NeedModule
+	default:
+		mode |= packages.NeedExportsFile | packages.NeedFiles | packages.NeedModule
+	}
+	return &packages.Config{
+		Mode:       mode,
+		Context:    at.conf.Context,
+		Logf:       at.conf.Logf,
+		Dir:        conf.dir(),
+		Env:        conf.environ(),
+		BuildFlags: conf.buildFlags(),
+	}
+}
+
+// load imports loadPkg (and its dependencies first) into the universe of the
+// loader, and returns the number of errors.
+func (p *Loader) load(loadPkg *packages.Package) (nerr int) {
+	pkgPath := loadPkg.PkgPath
+	if _, ok := p.pkgs[pkgPath]; ok {
+		return
+	}
+	pkg := &loaderPkg{
+		ref:  PkgRef{ID: loadPkg.ID, IllTyped: len(loadPkg.Errors) > 0},
+		deps: make([]string, 0, len(loadPkg.Imports)),
+	}
+	for _, impPkg := range loadPkg.Imports {
+		if nerr += p.load(impPkg); nerr == 0 {
+			pkg.deps = append(pkg.deps, impPkg.PkgPath)
+			pkg.ref.IllTyped = pkg.ref.IllTyped || p.pkgs[impPkg.PkgPath].ref.IllTyped
+		}
+	}
+	if nerr > 0 {
+		return
+	}
+	if debugImport {
+		log.Println("==> Import", pkgPath, loadPkg.Module)
+	}
+	var files []*ast.File
+	var err error
+	if p.conf.LoadMode == LoadSource || p.conf.LoadDocs {
+		if files, err = p.parseFiles(loadPkg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if pkgPath == "unsafe" {
+		pkg.ref.Types = types.Unsafe
+	} else if p.conf.LoadMode == LoadSource {
+		pkg.ref.Types = p.check(loadPkg, files, &pkg.ref.IllTyped)
+	} else if pkg.ref.Types, err = p.read(loadPkg); err != nil { // type-check from source instead
+		if debugImport {
+			log.Println("==> Import", pkgPath, "from source:", err)
+		}
+		if files == nil {
+			if files, err = p.parseFiles(loadPkg); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		pkg.ref.Types = p.check(loadPkg, files, &pkg.ref.IllTyped)
+	}
+	p.imports[pkgPath] = pkg.ref.Types
+	initGopPkg(pkg.ref.Types)
+	if p.conf.LoadDocs {
+		pkg.ref.docs = loadDocs(files)
+	}
+	mod := loadPkg.Module
+	if p.conf.LoadMode != LoadExportData && mod != nil && (mod.Main || isLocalReplace(mod)) {
+		files := fileList(loadPkg)
+		pkg.ref.pkgf = &pkgFingerp{files: files, fingerp: calcFingerp(files), updated: true}
+	}
+	p.pkgs[pkgPath] = pkg
+	return
+}
+
+// read reads types of loadPkg from its export data.
+func (p *Loader) read(loadPkg *packages.Package) (*types.Package, error) {
+	if loadPkg.ExportFile == "" {
+		return nil, fmt.Errorf("no export data for %q", loadPkg.PkgPath)
+	}
+	f, err := os.Open(loadPkg.ExportFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readExportData(f, p.fset, p.imports, loadPkg.PkgPath)
+}
+
+// check type-checks loadPkg from its source files. Type errors are ignored
+// (but illTyped is set), as go/packages does.
+func (p *Loader) check(loadPkg *packages.Package, files []*ast.File, illTyped *bool) *types.Package {
+	conf := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if impPkg, ok := loadPkg.Imports[path]; ok {
+				return p.imports[impPkg.PkgPath], nil
+			}
+			return nil, fmt.Errorf("can't find import: %q", path)
+		}),
+		Sizes: p.sizes,
+		Error: func(err error) {
+			*illTyped = true
+		},
+	}
+	pkg, _ := conf.Check(loadPkg.PkgPath, p.fset, files, nil)
+	return pkg
+}
+
+// parseFiles parses source files (processed by cgo) of loadPkg with comments.
+func (p *Loader) parseFiles(loadPkg *packages.Package) ([]*ast.File, error) {
+	files := make([]*ast.File, 0, len(loadPkg.CompiledGoFiles))
+	for _, filename := range loadPkg.CompiledGoFiles {
+		var f *ast.File
+		var err error
+		if parse := p.conf.ParseFile; parse != nil {
+			var src []byte
+			if src, err = ioutil.ReadFile(filename); err == nil {
+				f, err = parse(p.fset, filename, src)
+			}
+		} else {
+			f, err = parser.ParseFile(p.fset, filename, nil, parser.AllErrors|parser.ParseComments)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// dropChanged drops packages whose source files are changed, and packages
+// which import them, so that they are reloaded.
+func (p *Loader) dropChanged() {
+	dropped := make(map[string]bool)
+	for pkgPath, pkg := range p.pkgs {
+		if f := pkg.ref.pkgf; f != nil && calcFingerp(f.files) != f.fingerp {
+			dropped[pkgPath] = true
+		}
+	}
+	for more := len(dropped) > 0; more; {
+		more = false
+		for pkgPath, pkg := range p.pkgs {
+			if dropped[pkgPath] {
+				continue
+			}
+			for _, dep := range pkg.deps {
+				if dropped[dep] {
+					dropped[pkgPath], more = true, true
+					break
+				}
+			}
+		}
+	}
+	for pkgPath := range dropped {
+		delete(p.pkgs, pkgPath)
+		delete(p.imports, pkgPath)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// maxSharedLoaders is the max number of loaders kept by LoadGoPkgsShared.
+const maxSharedLoaders = 8
+
+type sharedLoaderCache struct {
+	loaders map[string]*Loader
+	keys    []string // keys of loaders, the least recently used first
+	mutex   sync.Mutex
+}
+
+var sharedLoaders = &sharedLoaderCache{loaders: make(map[string]*Loader)}
+
+// get returns the shared loader of the load config of conf.
+func (p *sharedLoaderCache) get(conf *Config) *Loader {
+	key := fmt.Sprintf("%s\x00%v\x00%v\x00%v\x00%d\x00%v",
+		conf.dir(), conf.Env, conf.goEnv(), conf.BuildFlags, conf.LoadMode, conf.LoadDocs)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, k := range p.keys {
+		if k == key {
+			p.keys = append(append(p.keys[:i:i], p.keys[i+1:]...), key)
+			return p.loaders[key]
+		}
+	}
+	loader := NewLoader(&Config{
+		ModRoot:        conf.ModRoot,
+		Dir:            conf.Dir,
+		Env:            conf.Env,
+		GoEnv:          conf.GoEnv,
+		TargetPlatform: conf.TargetPlatform,
+		BuildFlags:     conf.BuildFlags,
+		LoadMode:       conf.LoadMode,
+		LoadDocs:       conf.LoadDocs,
+	})
+	if len(p.keys) == maxSharedLoaders {
+		delete(p.loaders, p.keys[0])
+		p.keys = p.keys[1:]
+	}
+	p.loaders[key] = loader
+	p.keys = append(p.keys, key)
+	return loader
+}
+
+// ----------------------------------------------------------------------------
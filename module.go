@@ -0,0 +1,200 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+
+// Platform is a platform which generated code targets (see
+// Config.TargetPlatform). An empty GOOS or GOARCH means that of the host.
+type Platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+// Sizes returns sizes of types of the gc compiler for the platform. It returns
+// sizes of the host if p is nil.
+func (p *Platform) Sizes() types.Sizes {
+	arch := runtime.GOARCH
+	if p != nil && p.GOARCH != "" {
+		arch = p.GOARCH
+	}
+	if sizes := types.SizesFor("gc", arch); sizes != nil {
+		return sizes
+	}
+	log.Panicln("Sizes: unsupported GOARCH -", arch)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// FindModRoot returns the root directory of the module which dir belongs to,
+// ie. the nearest directory containing go.mod among dir and its parents. If
+// dir is empty, the current directory is used. See Config.ModRoot.
+func FindModRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", fmt.Errorf("go.mod not found in %s or any parent directory", dir)
+		}
+		d = parent
+	}
+}
+
+// readModPath returns the module path declared by go.mod in root.
+func readModPath(root string) (string, error) {
+	gomod := filepath.Join(root, "go.mod")
+	data, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		modPath := fields[1]
+		if modPath[0] == '"' || modPath[0] == '`' {
+			if modPath, err = strconv.Unquote(modPath); err != nil {
+				break
+			}
+		}
+		return modPath, nil
+	}
+	return "", fmt.Errorf("%s: no module declaration", gomod)
+}
+
+// dir returns the directory in which to load packages: Dir, or ModRoot if Dir
+// is empty.
+func (p *Config) dir() string {
+	if p.Dir == "" {
+		return p.ModRoot
+	}
+	return p.Dir
+}
+
+// goEnv returns Go environment variables to load packages with: GOOS and
+// GOARCH of TargetPlatform overridden by GoEnv.
+func (p *Config) goEnv() map[string]string {
+	target := p.TargetPlatform
+	if target == nil || (target.GOOS == "" && target.GOARCH == "") {
+		return p.GoEnv
+	}
+	goEnv := make(map[string]string, len(p.GoEnv)+2)
+	if target.GOOS != "" {
+		goEnv["GOOS"] = target.GOOS
+	}
+	if target.GOARCH != "" {
+		goEnv["GOARCH"] = target.GOARCH
+	}
+	for k, v := range p.GoEnv {
+		goEnv[k] = v
+	}
+	return goEnv
+}
+
+// environ returns the environment to load packages with: Env (or the current
+// environment) overridden by GoEnv (see goEnv). It returns nil to use the
+// current environment.
+func (p *Config) environ() []string {
+	env := p.Env
+	goEnv := p.goEnv()
+	if len(goEnv) == 0 {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	keys := make([]string, 0, len(goEnv))
+	for k := range goEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env = env[:len(env):len(env)] // later values take precedence
+	for _, k := range keys {
+		env = append(env, k+"="+goEnv[k])
+	}
+	return env
+}
+
+// getenv returns the value of the environment variable key in the environment
+// to load packages with (see environ).
+func (p *Config) getenv(key string) string {
+	if v, ok := p.goEnv()[key]; ok {
+		return v
+	}
+	if p.Env == nil {
+		return os.Getenv(key)
+	}
+	for i := len(p.Env) - 1; i >= 0; i-- {
+		if kv := p.Env[i]; strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// buildFlags returns the build flags to load packages with: BuildFlags, and
+// -mod=vendor if the current module has a vendor directory and the -mod flag
+// isn't specified (see GoEnv).
+func (p *Config) buildFlags() []string {
+	flags := p.BuildFlags
+	if hasModFlag(flags) || hasModFlag(strings.Fields(p.getenv("GOFLAGS"))) || p.getenv("GO111MODULE") == "off" {
+		return flags
+	}
+	root := p.ModRoot
+	if root == "" {
+		root, _ = FindModRoot(p.dir())
+	}
+	if root == "" {
+		return flags
+	}
+	if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err != nil {
+		return flags
+	}
+	return append(flags[:len(flags):len(flags)], "-mod=vendor")
+}
+
+func hasModFlag(flags []string) bool {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "-mod=") || strings.HasPrefix(flag, "--mod=") {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
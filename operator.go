@@ -0,0 +1,135 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// Operators which have no token of their own.
+const (
+	OpIndex    = token.LBRACK // a[i], see CodeBuilder.Index
+	OpSetIndex = token.RBRACK // a[i] = v, see CodeBuilder.IndexRef
+	OpSlice    = token.COLON  // a[lo:hi], see CodeBuilder.Slice
+	OpConv     = token.TYPE   // T(a), see CodeBuilder.Conv
+	OpRange    = token.RANGE  // for range a, see CodeBuilder.ForRange
+)
+
+// OperatorResolver resolves operators on values of user defined types to
+// methods of the types. By default, operator methods are named by the Prefix
+// of Config, eg. Gop_Add of `+`. Other DSLs can supply their own naming
+// conventions by Config.OperatorResolver.
+type OperatorResolver interface {
+	// ResolveOp returns the method of type x which implements the operator op,
+	// or nil if there is no such method.
+	//
+	// For a binary operator (and OpIndex, OpSetIndex), y is the type of the
+	// other operand (the index). If right is true, x is the type of the right
+	// operand, so the method is called as `b.Method(a)` for `a op b` (eg.
+	// Gop_Radd). For an unary operator (including `<-`), OpSlice and OpRange,
+	// y is nil. For OpConv, y is the type which x is converted to.
+	ResolveOp(op token.Token, x, y types.Type, right bool) *types.Func
+}
+
+type defaultOpResolver struct {
+	prefix string
+}
+
+func (p *defaultOpResolver) ResolveOp(op token.Token, x, y types.Type, right bool) *types.Func {
+	switch op {
+	case OpRange:
+		return findMethod(x, "Gop_Enum")
+	case OpConv:
+		if t, ok := x.(*types.Named); ok {
+			return rcastMethod(t, p.prefix+"Rcast", y)
+		}
+		return nil
+	case OpIndex, OpSetIndex, OpSlice:
+		if t, ok := x.(*types.Pointer); ok {
+			x = t.Elem()
+		}
+	}
+	t, ok := x.(*types.Named)
+	if !ok {
+		return nil
+	}
+	name := opMethodName(op, y == nil)
+	if name == "" {
+		return nil
+	}
+	if right { // Add => Radd
+		name = "R" + strings.ToLower(name[:1]) + name[1:]
+	}
+	return lookupMethod(t, p.prefix+name)
+}
+
+// opMethodName returns the method name of op without prefix, or "" if op isn't
+// an operator which can be overloaded.
+func opMethodName(op token.Token, unary bool) string {
+	switch op {
+	case OpIndex:
+		return "Index"
+	case OpSetIndex:
+		return "SetIndex"
+	case OpSlice:
+		return "Slice"
+	}
+	if unary {
+		if int(op) < len(unaryOps) {
+			return unaryOps[op]
+		}
+	} else if int(op) < len(binaryOps) && binaryOps[op] != "" {
+		return binaryOps[op]
+	} else if int(op) < len(assignOps) {
+		return assignOps[op]
+	}
+	return ""
+}
+
+func rcastMethod(t *types.Named, name string, typ types.Type) *types.Func {
+	for i, n := 0, t.NumMethods(); i < n; i++ {
+		method := t.Method(i)
+		if mname := method.Name(); mname != name && !strings.HasPrefix(mname, name+"__") {
+			continue
+		}
+		sig := method.Type().(*types.Signature)
+		if sig.Params().Len() == 0 && sig.Results().Len() == 1 &&
+			types.Identical(sig.Results().At(0).Type(), typ) {
+			return method
+		}
+	}
+	return nil
+}
+
+// opMethod returns the method which implements op on x (see OperatorResolver).
+func (p *Package) opMethod(op token.Token, x, y types.Type, right bool) *types.Func {
+	return p.opr.ResolveOp(op, x, y, right)
+}
+
+// otherOperand returns the type of args[1] of a binary operator, or nil if the
+// operator is unary.
+func otherOperand(args []*internal.Elem) types.Type {
+	if len(args) > 1 {
+		return args[1].Type
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
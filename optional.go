@@ -0,0 +1,215 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"log"
+)
+
+// ----------------------------------------------------------------------------
+
+// An OptionalLowering is a strategy to lower optional values (a value of type
+// T, or no value) to Go. See Config.Optional.
+//
+// A lowering must evaluate each operand exactly once and in source order, even
+// if an operand isn't used by the lowered expression (eg. the value of an
+// optional pair whose IsSome is required).
+type OptionalLowering interface {
+	// Types returns the Go types which represent an optional typ.
+	Types(pkg *Package, typ types.Type) []types.Type
+
+	// Some converts the value (of typ) at the stack top to an optional value.
+	Some(cb *CodeBuilder, typ types.Type)
+
+	// None pushes an optional value of typ which has no value.
+	None(cb *CodeBuilder, typ types.Type)
+
+	// IsSome converts the optional value (of typ) at the stack top to a bool
+	// value which reports whether it has a value.
+	IsSome(cb *CodeBuilder, typ types.Type)
+
+	// Value converts the optional value (of typ) at the stack top to its value.
+	Value(cb *CodeBuilder, typ types.Type)
+}
+
+var (
+	// OptionalPointer lowers an optional T to *T, and no value to nil.
+	OptionalPointer OptionalLowering = optionalPointer{}
+
+	// OptionalPair lowers an optional T to a pair of values (T, bool), like
+	// `v, ok := m[key]`. An optional value takes two elements of the stack,
+	// so it can only be used as arguments, results or rhs of assignments.
+	OptionalPair OptionalLowering = optionalPair{}
+
+	// OptionalStruct lowers an optional T to a generated struct type
+	// `OptionT struct { Value T; Valid bool }`, which is declared once for
+	// each T in the package.
+	OptionalStruct OptionalLowering = optionalStruct{}
+)
+
+func (p *Package) optional() OptionalLowering {
+	if lowering := p.conf.Optional; lowering != nil {
+		return lowering
+	}
+	return OptionalPointer
+}
+
+// OptionalTypes returns the Go types which represent an optional typ.
+func (p *Package) OptionalTypes(typ types.Type) []types.Type {
+	return p.optional().Types(p, typ)
+}
+
+// OptionalSome converts the value (of typ) at the stack top to an optional
+// value.
+func (p *CodeBuilder) OptionalSome(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("OptionalSome", typ)
+	}
+	p.pkg.optional().Some(p, typ)
+	return p
+}
+
+// OptionalNone pushes an optional value of typ which has no value.
+func (p *CodeBuilder) OptionalNone(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("OptionalNone", typ)
+	}
+	p.pkg.optional().None(p, typ)
+	return p
+}
+
+// OptionalIsSome converts the optional value (of typ) at the stack top to a
+// bool value which reports whether it has a value.
+func (p *CodeBuilder) OptionalIsSome(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("OptionalIsSome", typ)
+	}
+	p.pkg.optional().IsSome(p, typ)
+	return p
+}
+
+// OptionalValue converts the optional value (of typ) at the stack top to its
+// value.
+func (p *CodeBuilder) OptionalValue(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("OptionalValue", typ)
+	}
+	p.pkg.optional().Value(p, typ)
+	return p
+}
+
+// ----------------------------------------------------------------------------
+
+type optionalPointer struct{}
+
+func (optionalPointer) Types(pkg *Package, typ types.Type) []types.Type {
+	return []types.Type{types.NewPointer(typ)}
+}
+
+func (optionalPointer) Some(cb *CodeBuilder, typ types.Type) {
+	/*
+		func(v typ) *typ {
+			return &v
+		}(x)
+	*/
+	pkg := cb.pkg
+	v := pkg.NewParam(token.NoPos, "v", typ)
+	ret := pkg.NewParam(token.NoPos, "", types.NewPointer(typ))
+	x := cb.stk.Pop()
+	cb.NewClosure(types.NewTuple(v), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(v).UnaryOp(token.AND).Return(1).
+		End()
+	cb.stk.Push(x)
+	cb.Call(1)
+}
+
+func (optionalPointer) None(cb *CodeBuilder, typ types.Type) {
+	cb.Typ(types.NewPointer(typ)).Val(nil).Call(1)
+}
+
+func (optionalPointer) IsSome(cb *CodeBuilder, typ types.Type) {
+	cb.Val(nil).BinaryOp(token.NEQ)
+}
+
+func (optionalPointer) Value(cb *CodeBuilder, typ types.Type) {
+	cb.Star()
+}
+
+// ----------------------------------------------------------------------------
+
+type optionalPair struct{}
+
+func (optionalPair) Types(pkg *Package, typ types.Type) []types.Type {
+	return []types.Type{typ, types.Typ[types.Bool]}
+}
+
+func (optionalPair) Some(cb *CodeBuilder, typ types.Type) {
+	cb.Val(true)
+}
+
+func (optionalPair) None(cb *CodeBuilder, typ types.Type) {
+	cb.ZeroLit(typ).Val(false)
+}
+
+func (p optionalPair) IsSome(cb *CodeBuilder, typ types.Type) {
+	cb.pickValue(p.Types(cb.pkg, typ), 1)
+}
+
+func (p optionalPair) Value(cb *CodeBuilder, typ types.Type) {
+	cb.pickValue(p.Types(cb.pkg, typ), 0)
+}
+
+// ----------------------------------------------------------------------------
+
+type optionalStruct struct{}
+
+func (optionalStruct) Types(pkg *Package, typ types.Type) []types.Type {
+	return []types.Type{pkg.optionType(typ)}
+}
+
+func (optionalStruct) Some(cb *CodeBuilder, typ types.Type) {
+	x := cb.stk.Pop()
+	cb.Val(0)
+	cb.stk.Push(x)
+	cb.Val(1).Val(true).StructLit(cb.pkg.optionType(typ), 4, true)
+}
+
+func (optionalStruct) None(cb *CodeBuilder, typ types.Type) {
+	cb.StructLit(cb.pkg.optionType(typ), 0, false)
+}
+
+func (optionalStruct) IsSome(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Valid")
+}
+
+func (optionalStruct) Value(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Value")
+}
+
+// optionType returns the Option struct type of typ, which is declared the
+// first time it is required.
+func (p *Package) optionType(typ types.Type) *types.Named {
+	return p.instType(p.options, typ, func() interface{} {
+		name := p.instTypeName("Option", typ)
+		fields := []*types.Var{
+			types.NewField(token.NoPos, p.Types, "Value", typ, false),
+			types.NewField(token.NoPos, p.Types, "Valid", types.Typ[types.Bool], false),
+		}
+		return p.NewType(name).InitType(p, types.NewStruct(fields, nil))
+	}).(*types.Named)
+}
+
+// ----------------------------------------------------------------------------
@@ -3,11 +3,20 @@ package gox
 import (
 	"context"
 	"go/ast"
+	"go/build"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"log"
+	"path"
 	"reflect"
 	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goplus/gox/internal"
+	"github.com/goplus/gox/internal/go/printer"
+	"golang.org/x/tools/go/types/typeutil"
 )
 
 type LoadPkgsFunc = func(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int
@@ -85,6 +94,12 @@ type Config struct {
 	// ModPath is the pkgPath of this module
 	ModPath string
 
+	// ModRoot is the root directory of this module, where go.mod is (see
+	// FindModRoot). If it isn't empty, imported packages are loaded in ModRoot
+	// unless Dir is specified, so that replace directives of go.mod are
+	// resolved, and ModPath is read from go.mod if it is empty.
+	ModRoot string
+
 	// Env is the environment to use when invoking the build system's query tool.
 	// If Env is nil, the current environment is used.
 	// As in os/exec's Cmd, only the last value in the slice for
@@ -95,6 +110,22 @@ type Config struct {
 	//
 	Env []string
 
+	// GoEnv is Go environment variables (eg. GOFLAGS, GOPATH, GO111MODULE,
+	// GOOS and GOARCH) to load packages with, which override those of Env (or
+	// the current environment, if Env is nil), so that loading packages doesn't
+	// depend on the host environment, eg. in hermetic builds. If the current
+	// module has a vendor directory (vendor/modules.txt), packages are loaded
+	// in vendor mode (-mod=vendor) unless the -mod flag is specified by
+	// BuildFlags or GOFLAGS.
+	GoEnv map[string]string
+
+	// TargetPlatform is the platform which generated code targets. If it isn't
+	// nil, packages are loaded for it (its GOOS and GOARCH are overridden by
+	// GoEnv), and sizes of types are of it, eg. the width of int to check
+	// constant overflows and results of unsafe.Sizeof and unsafe.Alignof. If
+	// it's nil, the host platform is targeted.
+	TargetPlatform *Platform
+
 	// BuildFlags is a list of command-line flags to be passed through to
 	// the build system's query tool.
 	BuildFlags []string
@@ -120,23 +151,159 @@ type Config struct {
 	// HandleErr is called to handle errors.
 	HandleErr func(err error)
 
+	// TolerantMode is to report type errors (eg. type mismatches of calls,
+	// operators and assignments) by HandleErr instead of panicking, so that
+	// a compiler can continue after the first error and report more errors.
+	// The type of an invalid expression is types.Typ[types.Invalid]. If
+	// HandleErr is nil, errors are recorded (see CodeBuilder.Errors).
+	TolerantMode bool
+
 	// NodeInterpreter is to interpret an ast.Node.
 	NodeInterpreter NodeInterpreter
 
-	// LoadPkgs is called to load all import packages.
+	// LazyImport is to load an imported package the first time it is
+	// referenced (see PkgRef.EnsureImported), instead of loading all imported
+	// packages together, so that packages which are imported but never
+	// referenced aren't loaded. Packages loaded separately share their
+	// dependencies if LoadPkgs loads them into one type universe (eg. the
+	// default LoadGoPkgsShared, a Loader or a loader made by NewLoadPkgsExport),
+	// so that a type of a dependency (eg. context.Context) is identical to the
+	// same type loaded with another package.
+	LazyImport bool
+
+	// LoadPkgs is called to load all import packages. If LoadPkgs is nil,
+	// LoadGoPkgsShared is used (or a cache of CacheDir, if it is specified).
 	LoadPkgs LoadPkgsFunc
 
+	// CacheDir is a directory to cache type information of loaded packages
+	// across processes. If it isn't empty and LoadPkgs is nil, packages are
+	// loaded from the cache file in CacheDir, unless their dependencies (see
+	// go.mod and go.sum) or the Go version are changed. It is useful to speed
+	// up repeated runs of a compiler, eg. in a Go+ REPL.
+	CacheDir string
+
+	// LoadMode controls how imported packages are loaded (see LoadMode).
+	LoadMode LoadMode
+
 	// LoadNamed is called to load a delay-loaded named type.
 	LoadNamed LoadNamedFunc
 
+	// LoadDocs is to retain doc comments of imported packages (see PkgRef.Doc
+	// and CodeBuilder.TopInfo). It makes loading packages slower because they
+	// are parsed from source.
+	LoadDocs bool
+
+	// Optional is the strategy to lower optional values (see CodeBuilder.OptionalSome).
+	// If Optional is nil, OptionalPointer is used.
+	Optional OptionalLowering
+
+	// Result is the strategy to lower results (see CodeBuilder.ResultOk). If
+	// Result is nil, ResultPair is used.
+	Result ResultLowering
+
+	// Format is the printer to write a package in GoSyntax, eg. to match the
+	// style conventions of the destination repository. If Format is nil, a
+	// package is written in the canonical gofmt style.
+	Format *FormatOptions
+
+	// GeneratedBy is the name of the generator. If it isn't empty, a comment
+	// `// Code generated by GeneratedBy; DO NOT EDIT.` is written at the top
+	// of each file.
+	GeneratedBy string
+
+	// Progress is called every ProgressInterval statements emitted
+	// (DefaultProgressInterval if ProgressInterval is 0), eg. to report progress of generation in an IDE.
+	// stmts is the number of statements emitted. If Progress returns an error,
+	// generation is interrupted (see InterruptedError). Progress may also
+	// block, eg. to yield to requests of higher priority.
+	Progress         func(stmts int) error
+	ProgressInterval int
+
+	// TimeBudget is a soft time budget of generation, which starts when the
+	// package is created. It is checked every ProgressInterval statements
+	// emitted, and generation is interrupted by ErrTimeBudget if it is
+	// exceeded (see InterruptedError).
+	TimeBudget time.Duration
+
+	// LineDirectives is to write a `/*line file:line:col*/` directive before
+	// each statement whose source position is set by CodeBuilder.SetStmtPos,
+	// so panics and debuggers in the generated Go code point back to the
+	// source (eg. a Go+ file).
+	LineDirectives bool
+
+	// StableTempNames is to renumber temporaries of each func (eg. `_gop_tmp1`
+	// declared by NewTempVar, `_autoGo_1` declared by ErrWrap, or labels of
+	// inline closures) from 1 in the order of their appearance when the func
+	// is written, instead of numbering them in the order of creation in the
+	// whole package. So golden outputs of tests don't change when temporaries
+	// are added to or removed from other funcs.
+	StableTempNames bool
+
+	// MaxInitStmts is the max number of init statements of an if, switch,
+	// type switch or for statement (no limit if it is 0). Go allows only one
+	// init statement, so the others are hoisted before the statement, in a
+	// block wrapping it if they declare names.
+	MaxInitStmts int
+
+	// Main controls whether the package is a main package (see MainKind and
+	// Package.IsMain).
+	Main MainKind
+
+	// MaxOverloadFuncs is the max number of funcs of an overload func (or
+	// method) which a call can be matched against (DefaultMaxOverloadFuncs if
+	// it is 0). Calling an overload func with more funcs is an error.
+	MaxOverloadFuncs int
+
+	// ReuseElems is to reuse elements of the stack after each top-level func
+	// ends, to reduce allocations when generating large packages. If it is
+	// true, an element (eg. returned by CodeBuilder.Get) must not be used
+	// after the top-level func where it's created is ended.
+	ReuseElems bool
+
 	// Prefix is name prefix.
 	Prefix string
 
-	// NewBuiltin is to create the builin package.
+	// OperatorResolver resolves operators on values of user defined types to
+	// methods. If it is nil, operator methods are named by Prefix (eg. Gop_Add).
+	OperatorResolver OperatorResolver
+
+	// RecvName is called to choose the receiver name of a method declared
+	// with an unnamed receiver. If RecvName is nil, the lower-cased first
+	// letter of the receiver type name is used.
+	RecvName func(typ *types.Named) string
+
+	// NewBuiltin is to create the builin package. If it is nil, the default
+	// builtin package is used, which is shared by packages (see Builtin).
 	NewBuiltin func(pkg PkgImporter, prefix string, conf *Config) *types.Package
 
 	// untyped bigint, untyped bigrat, untyped bigfloat
 	UntypedBigInt, UntypedBigRat, UntypedBigFloat *types.Named
+
+	// UntypedBigConst is called to convert an untyped bigint/bigrat constant v
+	// (typ is UntypedBigInt or UntypedBigRat) to an expression of typ. Untyped
+	// big constants are folded by go/constant, and are converted only if they
+	// remain in the generated code, ie. they reach a typed context (eg.
+	// `var a = 1r << 65`). If it is nil, `big.NewInt(v)` or `big.NewRat(a, b)`
+	// is generated.
+	UntypedBigConst func(pkg *Package, v constant.Value, typ *types.Named) ast.Expr
+
+	// DefaultType returns the default type of type t (eg. the concrete type of
+	// an untyped custom constant type), which is the type of a variable
+	// declared by a value of t (eg. `var a = v` or `a := v`). The value is
+	// converted to the default type. If DefaultType is nil or returns nil (or
+	// t), the default type is types.Default(t), or T_Default if t is a named
+	// type T which has it (see Default).
+	DefaultType func(t types.Type) types.Type
+
+	// AssignableTo is consulted before a value of type V is rejected as not
+	// assignable to type T, so a host compiler can declare custom types
+	// assignable (eg. a Go+ string slice to []string). If it returns true and
+	// expr isn't nil, it should convert expr to type T if required.
+	AssignableTo func(pkg *Package, V, T types.Type, expr *ast.Expr) bool
+
+	// ComparableTo is consulted before values of type V and T are rejected as
+	// not comparable (see the func ComparableTo).
+	ComparableTo func(pkg *Package, V, T types.Type) bool
 }
 
 // ----------------------------------------------------------------------------
@@ -148,6 +315,71 @@ type file struct {
 	delayPkgPaths []string // all delay-load pkgPaths
 	pkgBig        *PkgRef
 	removedExprs  bool
+	tag           string // build constraint of a tagged file, eg. "debug" or "!debug"
+
+	astf       *ast.File         // the file last returned by ASTFile (see syncImports)
+	astImports []*ast.ImportSpec // imports of astf when it's returned
+	addImports []*ast.ImportSpec // imports added to astf, eg. by astutil.AddNamedImport
+	delImports map[string]bool   // paths of imports deleted from astf
+}
+
+// syncImports applies imports added to (or deleted from) the file returned by
+// ASTFile, eg. by astutil.AddNamedImport or DeleteImport, to the file.
+func (p *file) syncImports() {
+	if p.astf == nil {
+		return
+	}
+	paths := make(map[string]*ast.ImportSpec, len(p.astf.Imports))
+	for _, spec := range p.astf.Imports {
+		pkgPath, _ := strconv.Unquote(spec.Path.Value)
+		paths[pkgPath] = spec
+	}
+	for _, spec := range p.astImports {
+		pkgPath, _ := strconv.Unquote(spec.Path.Value)
+		if _, ok := paths[pkgPath]; ok {
+			delete(paths, pkgPath)
+			continue
+		}
+		if p.delImports == nil {
+			p.delImports = make(map[string]bool)
+		}
+		p.delImports[pkgPath] = true
+		p.addImports = removeImportSpec(p.addImports, pkgPath)
+	}
+	for _, spec := range p.astf.Imports {
+		pkgPath, _ := strconv.Unquote(spec.Path.Value)
+		if _, ok := paths[pkgPath]; ok {
+			p.addImports = append(removeImportSpec(p.addImports, pkgPath), cloneImportSpec(spec))
+		}
+	}
+}
+
+// cloneImportSpec returns a copy of spec, which isn't changed by the caller
+// (eg. astutil) or printers.
+func cloneImportSpec(spec *ast.ImportSpec) *ast.ImportSpec {
+	ret := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: spec.Path.Value}}
+	if spec.Name != nil {
+		ret.Name = ident(spec.Name.Name)
+	}
+	return ret
+}
+
+func hasImportSpec(specs []ast.Spec, quotedPath string) bool {
+	for _, spec := range specs {
+		if spec.(*ast.ImportSpec).Path.Value == quotedPath {
+			return true
+		}
+	}
+	return false
+}
+
+func removeImportSpec(specs []*ast.ImportSpec, pkgPath string) []*ast.ImportSpec {
+	for i, spec := range specs {
+		if spec.Path.Value == strconv.Quote(pkgPath) {
+			return append(specs[:i:i], specs[i+1:]...)
+		}
+	}
+	return specs
 }
 
 func pkgPathNotFound(allPkgPaths []string, pkgPath string) bool {
@@ -161,9 +393,12 @@ func pkgPathNotFound(allPkgPaths []string, pkgPath string) bool {
 
 func (p *file) importPkg(this *Package, pkgPath string, testingFile bool) *PkgRef {
 	// TODO: canonical pkgPath
+	if build.IsLocalImport(pkgPath) { // relative to the importing package
+		pkgPath = path.Join(this.Types.Path(), pkgPath)
+	}
 	pkgImport, ok := p.importPkgs[pkgPath]
 	if !ok {
-		pkgImport = &PkgRef{pkg: this, file: p, inTestingFile: testingFile}
+		pkgImport = &PkgRef{pkg: this, file: p, path: pkgPath, inTestingFile: testingFile}
 		p.importPkgs[pkgPath] = pkgImport
 	}
 	if !ok || pkgPathNotFound(p.allPkgPaths, pkgPath) {
@@ -173,23 +408,45 @@ func (p *file) importPkg(this *Package, pkgPath string, testingFile bool) *PkgRe
 	return pkgImport
 }
 
-func (p *file) endImport(this *Package, testingFile bool) {
+// endImport loads the imported package ref. By default all delay-load packages
+// are loaded together. In LazyImport mode only ref is loaded, so a package
+// which is imported but never referenced isn't loaded.
+func (p *file) endImport(this *Package, ref *PkgRef) {
 	pkgPaths := p.delayPkgPaths
+	if this.conf.LazyImport {
+		pkgPaths = []string{ref.path}
+	}
 	if len(pkgPaths) == 0 {
 		return
 	}
 	if debugImport {
-		log.Println("==> LoadPkgs", pkgPaths, testingFile)
+		log.Println("==> LoadPkgs", pkgPaths, ref.inTestingFile)
 	}
 	if n := this.loadPkgs(this, p.importPkgs, pkgPaths...); n > 0 {
 		log.Panicf("total %d errors\n", n) // TODO: error message
 	}
-	p.delayPkgPaths = pkgPaths[:0]
+	if this.conf.LazyImport {
+		p.delayPkgPaths = removePkgPath(p.delayPkgPaths, ref.path)
+	} else {
+		p.delayPkgPaths = pkgPaths[:0]
+	}
+}
+
+func removePkgPath(pkgPaths []string, pkgPath string) []string {
+	for i, path := range pkgPaths {
+		if path == pkgPath {
+			return append(pkgPaths[:i], pkgPaths[i+1:]...)
+		}
+	}
+	return pkgPaths
 }
 
 func (p *file) markUsed(this *Package) {
 	if p.removedExprs {
 		// travel all ast nodes to mark used
+		for _, pkgImport := range p.importPkgs {
+			pkgImport.isUsed = false
+		}
 		p.markUsedBy(this, reflect.ValueOf(p.decls))
 		return
 	}
@@ -223,7 +480,7 @@ retry:
 			if sym, ok := x.(*ast.Ident); ok {
 				name := sym.Name
 				for _, at := range p.importPkgs {
-					if at.Types.Name() == name { // pkg.Object
+					if at.Types != nil && at.Types.Name() == name { // pkg.Object
 						at.markUsed(sym)
 					}
 				}
@@ -243,14 +500,22 @@ retry:
 }
 
 func (p *file) getDecls(this *Package) (decls []ast.Decl) {
+	this.materializeBigs(p)
+	if this.conf.StableTempNames {
+		this.renumberTemps(p.decls)
+	}
+	p.syncImports()
 	p.markUsed(this)
-	n := len(p.allPkgPaths)
+	n := len(p.allPkgPaths) + len(p.addImports)
 	if n == 0 {
 		return p.decls
 	}
 	specs := make([]ast.Spec, 0, n)
 	names := this.newAutoNames()
 	for _, pkgPath := range p.allPkgPaths {
+		if p.delImports[pkgPath] { // deleted from the file returned by ASTFile
+			continue
+		}
 		pkgImport := p.importPkgs[pkgPath]
 		if !pkgImport.isUsed { // unused
 			if pkgImport.isForceUsed { // force-used
@@ -262,8 +527,7 @@ func (p *file) getDecls(this *Package) (decls []ast.Decl) {
 			continue
 		}
 		pkgName, renamed := names.RequireName(pkgImport.Types.Name())
-		if renamed {
-			pkgImport.Types.SetName(pkgName)
+		if renamed { // Types is shared by packages, so only refs are renamed
 			for _, nameRef := range pkgImport.nameRefs {
 				nameRef.Name = pkgName
 			}
@@ -273,6 +537,11 @@ func (p *file) getDecls(this *Package) (decls []ast.Decl) {
 			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkgPath)},
 		})
 	}
+	for _, spec := range p.addImports {
+		if !hasImportSpec(specs, spec.Path.Value) { // not imported by the package
+			specs = append(specs, cloneImportSpec(spec))
+		}
+	}
 	if len(specs) == 0 {
 		return p.decls
 	}
@@ -294,23 +563,67 @@ func (p *file) big(this *Package, testingFile bool) *PkgRef {
 // Package type
 type Package struct {
 	PkgRef
-	cb          CodeBuilder
-	files       [2]file
-	conf        *Config
-	modPath     string
-	prefix      string
-	Fset        *token.FileSet
-	builtin     *types.Package
-	utBigInt    *types.Named
-	utBigRat    *types.Named
-	utBigFlt    *types.Named
-	loadPkgs    LoadPkgsFunc
-	autoPrefix  string
-	autoIdx     int
-	testingFile int
-}
-
-// NewPackage creates a new package.
+	cb         CodeBuilder
+	files      []*file // the normal file, the testing file and tagged files
+	conf       *Config
+	modPath    string
+	prefix     string
+	opr        OperatorResolver
+	Fset       *token.FileSet
+	builtin    *types.Package
+	shared     *types.Package // the shared builtin package (nil if builtin isn't shared)
+	utBigInt   *types.Named
+	utBigRat   *types.Named
+	utBigFlt   *types.Named
+	sizes      types.Sizes // sizes of types of the target platform
+	loadPkgs   LoadPkgsFunc
+	autoPrefix string
+	autoIdx    int
+	tmpIdx     int
+	curFile    int // index of the current file
+
+	elems    internal.ElemAllocator
+	options  *typeutil.Map                // Option struct types (see OptionalStruct)
+	results  *typeutil.Map                // Result struct types (see ResultStruct)
+	objDecls map[types.Object]ast.Decl    // top-level declarations (see DeclOf)
+	objData  map[types.Object]interface{} // user data of declarations (see SetDeclData)
+	temps    map[*types.Var]*TempInfo     // temporary variables (see TempInfo)
+	tmpNames map[string]bool              // names of temporaries (see Config.StableTempNames)
+	stables  map[*ast.BlockStmt]bool      // func bodies whose temporaries are renumbered
+
+	bigConsts bool          // if any untyped big constant is created (see UntypedBigInt)
+	fwdRefs   []*forwardRef // forward references (see CodeBuilder.ForwardRef)
+
+	parent   *Package   // the package which p is forked from (see Fork), or nil
+	mu       sync.Mutex // guards helper types instantiated by forks (see instType)
+	forkObjs []forkObj  // package-level objects declared by forks (see insertObj)
+}
+
+// setDecl records decl as the top-level declaration of obj.
+func (p *Package) setDecl(obj types.Object, decl ast.Decl) {
+	if p.objDecls == nil {
+		p.objDecls = make(map[types.Object]ast.Decl)
+	}
+	p.objDecls[obj] = decl
+}
+
+func (p *Package) newElem(v internal.Elem) *internal.Elem {
+	return p.elems.New(v)
+}
+
+// loadPkgsOf returns the LoadPkgsFunc of conf.
+func loadPkgsOf(conf *Config) LoadPkgsFunc {
+	if conf.LoadPkgs != nil {
+		return conf.LoadPkgs
+	}
+	if conf.CacheDir != "" {
+		return loadPkgsCacheDir(conf)
+	}
+	return LoadGoPkgsShared
+}
+
+// NewPackage creates a new package. If pkgPath is a relative path (eg. "." or
+// "./foo"), it's relative to the module root (see Config.ModPath and ModRoot).
 func NewPackage(pkgPath, name string, conf *Config) *Package {
 	if conf == nil {
 		conf = &Config{}
@@ -319,15 +632,21 @@ func NewPackage(pkgPath, name string, conf *Config) *Package {
 	if prefix == "" {
 		prefix = defaultNamePrefix
 	}
-	newBuiltin := conf.NewBuiltin
-	if newBuiltin == nil {
-		newBuiltin = newBuiltinDefault
+	modPath := conf.ModPath
+	if modPath == "" && conf.ModRoot != "" {
+		var err error
+		if modPath, err = readModPath(conf.ModRoot); err != nil {
+			log.Panicln("NewPackage:", err)
+		}
+	}
+	if conf.Main == MainPackage && name != "main" {
+		log.Panicln("NewPackage: main package must be named main, not", name)
 	}
-	loadPkgs := conf.LoadPkgs
-	if loadPkgs == nil {
-		loadPkgs = LoadGoPkgs
+	if build.IsLocalImport(pkgPath) && modPath != "" { // relative to the module root
+		pkgPath = path.Join(modPath, pkgPath)
 	}
-	files := [2]file{
+	loadPkgs := loadPkgsOf(conf)
+	files := []*file{
 		{importPkgs: make(map[string]*PkgRef)},
 		{importPkgs: make(map[string]*PkgRef)},
 	}
@@ -335,23 +654,135 @@ func NewPackage(pkgPath, name string, conf *Config) *Package {
 		Fset:       conf.Fset,
 		files:      files,
 		conf:       conf,
-		modPath:    conf.ModPath,
+		modPath:    modPath,
 		prefix:     prefix,
 		loadPkgs:   loadPkgs,
 		autoPrefix: "_auto" + prefix,
+		options:    new(typeutil.Map),
+		results:    new(typeutil.Map),
+	}
+	pkg.opr = conf.OperatorResolver
+	if pkg.opr == nil {
+		pkg.opr = &defaultOpResolver{prefix: prefix}
 	}
 	pkg.Types = types.NewPackage(pkgPath, name)
-	pkg.builtin = newBuiltin(pkg, prefix, conf)
+	if conf.NewBuiltin != nil {
+		pkg.builtin = conf.NewBuiltin(pkg, prefix, conf)
+	} else {
+		pkg.builtin = sharedBuiltin(prefix, conf)
+		pkg.shared = pkg.builtin
+	}
 	pkg.utBigInt = conf.UntypedBigInt
 	pkg.utBigRat = conf.UntypedBigRat
 	pkg.utBigFlt = conf.UntypedBigFloat
+	pkg.sizes = conf.TargetPlatform.Sizes()
 	pkg.cb.init(pkg)
 	return pkg
 }
 
-// Builtin returns the buitlin package.
+// MainKind controls whether a package is a main package (a program) or not (a
+// library), see Config.Main.
+type MainKind int
+
+const (
+	// MainByName means that a package is a main package if it is named main.
+	MainByName MainKind = iota
+
+	// MainPackage means that a package is a main package. It must be named
+	// main.
+	MainPackage
+
+	// NotMain means that a package isn't a main package, even if it is named
+	// main (eg. a file of a main package whose func main is in another file).
+	NotMain
+)
+
+// IsMain reports whether this is a main package (a program) or not (a library),
+// see Config.Main.
+func (p *Package) IsMain() bool {
+	switch p.conf.Main {
+	case MainPackage:
+		return true
+	case NotMain:
+		return false
+	}
+	return p.Types.Name() == "main"
+}
+
+// CheckMain checks that a main package declares the program entry func main,
+// and doesn't declare an exported Main (which can't be the entry). It does
+// nothing for library packages.
+func (p *Package) CheckMain() error {
+	if !p.IsMain() {
+		return nil
+	}
+	scope := p.Types.Scope()
+	if o := scope.Lookup("Main"); o != nil {
+		return p.cb.newCodePosError(o.Pos(), "cannot declare Main in the main package - the entry is func main")
+	}
+	switch o := scope.Lookup("main").(type) {
+	case nil:
+		return p.cb.newCodeError(nil, "function main is undeclared in the main package")
+	case *types.Func:
+		return nil
+	default:
+		return p.cb.newCodePosError(o.Pos(), "cannot declare main - must be func")
+	}
+}
+
+// Builtin returns the buitlin package of this package. The default builtin
+// package is shared by packages (see Config.NewBuiltin), so it is copied
+// before it is returned the first time, and changes of the returned package
+// (eg. objects inserted into its scope) don't affect other packages.
 func (p *Package) Builtin() *PkgRef {
-	return &PkgRef{Types: p.builtin, pkg: p}
+	return &PkgRef{Types: p.ownBuiltin(), pkg: p}
+}
+
+// InsertBuiltin inserts an object into the builtin package of this package.
+// As Builtin, other packages aren't affected. It returns the existing object
+// if the name is already used.
+func (p *Package) InsertBuiltin(obj types.Object) (alt types.Object) {
+	return p.ownBuiltin().Scope().Insert(obj)
+}
+
+// ownBuiltin returns the builtin package of this package, which is a copy of
+// the shared builtin package if it is shared.
+func (p *Package) ownBuiltin() *types.Package {
+	if p.builtin == p.shared {
+		builtin := types.NewPackage(p.shared.Path(), p.shared.Name())
+		scope, shared := builtin.Scope(), p.shared.Scope()
+		for _, name := range shared.Names() {
+			scope.Insert(shared.Lookup(name))
+		}
+		p.builtin = builtin
+	}
+	return p.builtin
+}
+
+func (p *Package) isBuiltin(pkg *types.Package) bool {
+	return pkg == p.builtin || (pkg == p.shared && pkg != nil)
+}
+
+// Sizes returns sizes of types of the target platform (see
+// Config.TargetPlatform).
+func (p *Package) Sizes() types.Sizes {
+	return p.sizes
+}
+
+// BlankLine adds an empty declaration to the current file, which is printed as
+// a blank line to separate declarations around it.
+func (p *Package) BlankLine() {
+	idx := p.curFile
+	p.files[idx].decls = append(p.files[idx].decls, printer.BlankLineDecl)
+}
+
+// BlankLineBetween adds a blank line (see BlankLine) if there are blank lines
+// between end and next in the source, eg. the end of the previous declaration
+// and the start of the next one.
+func (p *Package) BlankLineBetween(end, next token.Pos) {
+	if hasBlankLine(p.cb.position(end), p.cb.position(next)) {
+		p.BlankLine()
+	}
 }
 
 // CB returns the code builder.
@@ -361,13 +792,13 @@ func (p *Package) CB() *CodeBuilder {
 
 // SetInTestingFile sets inTestingFile or not.
 func (p *Package) SetInTestingFile(inTestingFile bool) (old bool) {
-	p.testingFile, old = getInTestingFile(inTestingFile), p.InTestingFile()
+	p.curFile, old = getInTestingFile(inTestingFile), p.InTestingFile()
 	return
 }
 
 // InTestingFile returns inTestingFile or not.
 func (p *Package) InTestingFile() bool {
-	return p.testingFile != 0
+	return p.curFile == 1
 }
 
 func getInTestingFile(inTestingFile bool) int {
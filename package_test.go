@@ -15,17 +15,27 @@ package gox_test
 
 import (
 	"bytes"
+	"errors"
 	"go/ast"
 	"go/constant"
+	"go/format"
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/goplus/gox"
+	"github.com/goplus/gox/goxtest"
+	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
 )
 
 const (
@@ -76,6 +86,9 @@ func domTestEx(t *testing.T, pkg *gox.Package, expected string, testingFile bool
 	}
 	result := b.String()
 	if result != expected {
+		if diff, err := goxtest.DiffSource(expected, result); err == nil && diff != nil {
+			t.Fatalf("\nDiff at %v\nResult:\n%s\nExpected:\n%s\n", diff, result, expected)
+		}
 		t.Fatalf("\nResult:\n%s\nExpected:\n%s\n", result, expected)
 	}
 }
@@ -236,6 +249,64 @@ func foo() {
 	os.Remove("_gop_autogen_test.go")
 }
 
+func TestNewTestFile(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	tf := pkg.NewTestFile()
+	test := tf.NewTest("Main")
+	test.BodyStart(pkg).
+		Val(ctxRef(pkg, "main")).Call(0).EndStmt().
+		Val(test.Type().(*types.Signature).Params().At(0)).MemberVal("Log").Val("done").Call(1).EndStmt().
+		End()
+	tf.NewExample("").BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val("Hello").Call(1).EndStmt().
+		Val(fmt.Ref("Println")).Val("").Call(1).EndStmt().
+		ExampleOutput("Hello\n\n", false).
+		End()
+	tf.NewExample("_unordered").BodyStart(pkg).
+		ExampleOutput("b\na", true).
+		End()
+	if pkg.InTestingFile() {
+		t.Fatal("NewTestFile: InTestingFile?")
+	}
+	domTest(t, pkg, `package main
+
+func main() {
+}
+`)
+	domTestEx(t, pkg, `package main
+
+import (
+	testing "testing"
+	fmt "fmt"
+)
+
+func TestMain(t *testing.T) {
+	main()
+	t.Log("done")
+}
+func Example() {
+	fmt.Println("Hello")
+	fmt.Println("")
+// Output:
+// Hello
+//
+}
+func Example_unordered() {
+// Unordered output:
+// b
+// a
+}
+`, true)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("NewTest: no error?")
+		}
+	}()
+	tf.NewTest("main")
+}
+
 func TestMake(t *testing.T) {
 	pkg := newMainPackage()
 	tySlice := types.NewSlice(types.Typ[types.Int])
@@ -510,6 +581,52 @@ func (p *foo) Print() {
 `)
 }
 
+func TestAutoRecvName(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(nil, nil))
+	bar := pkg.NewType("bar").InitType(pkg, types.Typ[types.Int])
+	f := pkg.NewParam(token.NoPos, "f", types.Typ[types.Int])
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "", foo), "A", nil, nil, false).BodyStart(pkg).End()
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "", types.NewPointer(foo)), "B", types.NewTuple(f), nil, false).
+		BodyStart(pkg).End()
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "", bar), "C", nil, nil, false).BodyStart(pkg).End()
+	screen := pkg.NewType("Écran").InitType(pkg, types.Typ[types.Int])
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "", screen), "D", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+type Foo struct {
+}
+type bar int
+
+func (f Foo) A() {
+}
+func (f1 *Foo) B(f int) {
+}
+func (b bar) C() {
+}
+
+type Écran int
+
+func (é Écran) D() {
+}
+`)
+}
+
+func TestRecvNameConf(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		RecvName: func(typ *types.Named) string { return "this" },
+	})
+	foo := pkg.NewType("foo").InitType(pkg, types.Typ[types.Int])
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "", foo), "Bar", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+type foo int
+
+func (this foo) Bar() {
+}
+`)
+}
+
 func TestAssignInterface(t *testing.T) {
 	pkg := newMainPackage()
 	foo := pkg.NewType("foo").InitType(pkg, types.Typ[types.Int])
@@ -685,6 +802,63 @@ func main() {
 `)
 }
 
+func TestRecvOrDone(t *testing.T) {
+	pkg := newMainPackage()
+	context := pkg.Import("context")
+	fmt := pkg.Import("fmt")
+	tyCh := types.NewChan(types.RecvOnly, types.Typ[types.Int])
+	ctx := pkg.NewParam(token.NoPos, "ctx", context.Ref("Context").Type())
+	ch := pkg.NewParam(token.NoPos, "ch", tyCh)
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	errRet := pkg.NewParam(token.NoPos, "", gox.TyError)
+	pkg.NewFunc(nil, "recv", types.NewTuple(ctx, ch), types.NewTuple(ret, errRet), false).BodyStart(pkg).
+		Val(ctx).Val(ch).RecvOrDone("v", func(cb *gox.CodeBuilder, v *types.Var) {
+			cb.Val(v).Val(nil).Return(2)
+		}, nil).
+		End()
+	pkg.NewFunc(nil, "wait", types.NewTuple(ch), nil, false).BodyStart(pkg).
+		Val(context.Ref("Background")).Call(0).Val(ch).RecvOrDone("", nil, func(cb *gox.CodeBuilder) {
+			cb.Val(fmt.Ref("Println")).Val("done").Call(1).EndStmt()
+		}).
+		Val(ch).Val(pkg.Import("time").Ref("Second")).RecvOrTimeout("v", func(cb *gox.CodeBuilder, v *types.Var) {
+			cb.Val(fmt.Ref("Println")).Val(v).Call(1).EndStmt()
+		}, func(cb *gox.CodeBuilder) {
+			cb.Val(fmt.Ref("Println")).Val("timeout").Call(1).EndStmt()
+		}).
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	context "context"
+	fmt "fmt"
+	time "time"
+)
+
+func recv(ctx context.Context, ch <-chan int) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case v := <-ch:
+		return v, nil
+	}
+}
+func wait(ch <-chan int) {
+	_autoGo_1 := context.Background()
+	select {
+	case <-_autoGo_1.Done():
+		fmt.Println("done")
+	case <-ch:
+	}
+	select {
+	case v := <-ch:
+		fmt.Println(v)
+	case <-time.After(time.Second):
+		fmt.Println("timeout")
+	}
+}
+`)
+}
+
 func TestStructLit(t *testing.T) {
 	pkg := newMainPackage()
 	fields := []*types.Var{
@@ -717,6 +891,64 @@ var c = struct {
 `)
 }
 
+func TestMultiLine(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "Size", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "Tags", types.NewSlice(types.Typ[types.String]), false),
+	}
+	typ := pkg.NewType("Config").InitType(pkg, types.NewStruct(fields, nil))
+	tySlice := types.NewSlice(types.Typ[types.String])
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVarStart(nil, "cfg").
+		Val(0).Val("foo").Val(1).Val(10).Val(2).Val("a").Val("b").SliceLit(tySlice, 2).
+		StructLit(typ, 6, true).MultiLine().UnaryOp(token.AND).EndInit(1).
+		NewVarStart(nil, "tags").Val("a").SliceLit(tySlice, 1).MultiLine().EndInit(1).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "cfg")).
+		Val(fmt.Ref("Sprint")).Val(1).Val(2).Call(2).MultiLine().
+		Call(2).MultiLine().EndStmt().
+		NewVarStart(nil, "more").
+		Val(pkg.Builtin().Ref("append")).Val(ctxRef(pkg, "tags")).Val(ctxRef(pkg, "tags")).
+		CallWith(2, true, false).MultiLine().EndInit(1).
+		Val(fmt.Ref("Println")).Call(0).MultiLine().EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+type Config struct {
+	Name string
+	Size int
+	Tags []string
+}
+
+func main() {
+	var cfg = &Config{
+		Name: "foo",
+		Size: 10,
+		Tags: []string{"a", "b"},
+	}
+	var tags = []string{
+		"a",
+	}
+	fmt.Println(
+		cfg,
+		fmt.Sprint(
+			1,
+			2,
+		),
+	)
+	var more = append(
+		tags,
+		tags...,
+	)
+	fmt.Println()
+}
+`)
+}
+
 func TestNamedStructLit(t *testing.T) {
 	pkg := newMainPackage()
 	fields := []*types.Var{
@@ -894,6 +1126,26 @@ func TestConst(t *testing.T) {
 	}
 }
 
+func TestConstLogicalOp(t *testing.T) {
+	pkg := newMainPackage()
+	cases := []struct {
+		op   token.Token
+		x, y bool
+		ret  bool
+	}{
+		{token.LAND, true, false, false},
+		{token.LAND, true, true, true},
+		{token.LOR, false, true, true},
+		{token.LOR, false, false, false},
+	}
+	for _, c := range cases {
+		tv := pkg.ConstStart().Val(c.x).Val(c.y).BinaryOp(c.op).EndConst()
+		if constant.Compare(tv.Value, token.NEQ, constant.MakeBool(c.ret)) {
+			t.Fatal("TestConstLogicalOp:", c.x, c.op, c.y, "!=", c.ret, ", it is", tv.Value)
+		}
+	}
+}
+
 func TestConstLenCap(t *testing.T) {
 	pkg := newMainPackage()
 	typ := types.NewArray(types.Typ[types.Int], 10)
@@ -938,6 +1190,44 @@ const y string = "Hello"
 `)
 }
 
+func TestBlankLine(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.CB().NewConstStart(nil, "a").Val(1).EndInit(1)
+	pkg.BlankLineBetween(position(1, 12), position(3, 1))
+	pkg.CB().NewConstStart(nil, "b").Val(2).EndInit(1)
+	pkg.BlankLineBetween(position(3, 12), position(4, 1))
+	pkg.CB().NewConstStart(nil, "c").Val(3).EndInit(1)
+	pkg.BlankLine()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		BlankLine().
+		NewVar(types.Typ[types.Int], "x").
+		BlankLineBetween(position(6, 10), position(7, 2)).
+		VarRef(ctxRef(pkg, "x")).Val(1).Assign(1).
+		BlankLine().
+		BlankLine().
+		If().Val(true).Then().
+		VarRef(ctxRef(pkg, "x")).Val(2).Assign(1).
+		BlankLine().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+const a = 1
+
+const b = 2
+const c = 3
+
+func main() {
+	var x int
+	x = 1
+
+	if true {
+		x = 2
+	}
+}
+`)
+}
+
 func TestVarDecl(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.CB().NewVarStart(nil, "n", "s").
@@ -1288,6 +1578,98 @@ func foo(format string, args ...interface {
 `)
 }
 
+func TestBlock(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").Val(1).EndInit(1).
+		Block().
+		/**/ DefineVarStart(token.NoPos, "a").Val("Hi").EndInit(1).
+		/**/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "a")).Call(1).Defer().
+		/**/ EndBlock().
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "a")).Call(1).EndStmt().
+		End()
+	func() {
+		defer func() {
+			if e := recover(); e != "EndBlock: current block is If, not a block statement" {
+				t.Fatal("EndBlock:", e)
+			}
+		}()
+		pkg := newMainPackage()
+		pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			If().Val(true).Then().
+			EndBlock()
+	}()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	a := 1
+	{
+		a := "Hi"
+		defer fmt.Println(a)
+	}
+	fmt.Println(a)
+}
+`)
+}
+
+func TestInsertAt(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	mark := cb.Mark()
+	cb.DefineVarStart(token.NoPos, "x").Val(1)
+	cb.InsertAt(mark).
+		NewVar(types.Typ[types.Int], "a").
+		VarRef(ctxRef(pkg, "a")).Val(2).Assign(1).
+		End()
+	cb.Val(ctxRef(pkg, "a")).BinaryOp(token.ADD)
+	cb.InsertAt(mark).
+		DefineVarStart(token.NoPos, "b").Val(3).EndInit(1).
+		End()
+	cb.Val(ctxRef(pkg, "b")).BinaryOp(token.ADD).EndInit(1).
+		Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "x")).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var a int
+	a = 2
+	b := 3
+	x := 1 + a + b
+	println(x)
+}
+`)
+}
+
+func TestNewTempVar(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "_gop_tmp1")
+	var tmp, tmp2 *types.Var
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewTempVar(types.Typ[types.String], &tmp).Val("Hi").Assign(1).
+		Block().
+		/**/ NewTempVar(types.Typ[types.Int], &tmp2).Val(ctxRef(pkg, "_gop_tmp1")).Assign(1).
+		/**/ Val(pkg.Builtin().Ref("println")).Val(tmp).Val(tmp2).Call(2).EndStmt().
+		/**/ EndBlock().
+		End()
+	domTest(t, pkg, `package main
+
+var _gop_tmp1 int
+
+func main() {
+	var _gop_tmp2 string
+	_gop_tmp2 = "Hi"
+	{
+		var _gop_tmp3 int
+		_gop_tmp3 = _gop_tmp1
+		println(_gop_tmp2, _gop_tmp3)
+	}
+}
+`)
+}
+
 func TestIf(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -1347,20 +1729,34 @@ retry:
 `)
 }
 
-func TestBreakContinue(t *testing.T) { // TODO: check invalid syntax
+func TestBreakContinue(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-		Label("retry").Break("").Continue("").
-		Break("retry").Continue("retry").
+		Label("retry").For().Then().
+		/**/ Break("").Continue("").
+		/**/ Switch().None().Then().
+		/**/ Val(true).Case(1).
+		/******/ Break("").Continue("").
+		/******/ Break("retry").Continue("retry").
+		/******/ End().
+		/**/ End().
+		End().
 		End()
 	domTest(t, pkg, `package main
 
 func main() {
 retry:
-	break
-	continue
-	break retry
-	continue retry
+	for {
+		break
+		continue
+		switch {
+		case true:
+			break
+			continue
+			break retry
+			continue retry
+		}
+	}
 }
 `)
 }
@@ -1494,16 +1890,16 @@ func main() {
 `)
 }
 
-func TestLabeledFor(t *testing.T) {
+func TestForNoCond(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-		Label("label").
-		/**/ For().DefineVarStart(0, "i").Val(0).EndInit(1). // for i := 0; i < 10; i=i+1 {
-		/******/ Val(ctxRef(pkg, "i")).Val(10).BinaryOp(token.LSS).Then().
-		/******/ Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
-		/******/ Break("label").
+		/**/ For().Then(). // for {
+		/******/ Val(pkg.Import("fmt").Ref("Println")).Val("Hi").Call(1).EndStmt().
+		/******/ Break("").
+		/**/ End().
+		/**/ For().DefineVarStart(0, "i").Val(0).EndInit(1).Then(). // for i := 0; ; i++ {
 		/******/ Post().
-		/******/ VarRef(ctxRef(pkg, "i")).Val(ctxRef(pkg, "i")).Val(1).BinaryOp(token.ADD).Assign(1).EndStmt().
+		/******/ VarRef(ctxRef(pkg, "i")).IncDec(token.INC).
 		/**/ End().
 		End()
 	domTest(t, pkg, `package main
@@ -1511,21 +1907,31 @@ func TestLabeledFor(t *testing.T) {
 import fmt "fmt"
 
 func main() {
-label:
-	for i := 0; i < 10; i = i + 1 {
-		fmt.Println(i)
-		break label
+	for {
+		fmt.Println("Hi")
+		break
+	}
+	for i := 0; ; i++ {
 	}
 }
 `)
 }
 
-func TestForRange(t *testing.T) {
+func TestWhile(t *testing.T) {
 	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-		DefineVarStart(0, "a").Val(1).Val(1.2).Val(3).SliceLit(nil, 3).EndInit(1).
-		/**/ ForRange("i").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos).
-		/******/ Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		DefineVarStart(0, "n").Val(10).EndInit(1).
+		/**/ While().Val(ctxRef(pkg, "n")).Val(0).BinaryOp(token.GTR).Then(). // for n > 0 {
+		/******/ VarRef(ctxRef(pkg, "n")).IncDec(token.DEC).
+		/**/ End().
+		/**/ While().DefineVarStart(0, "m").Val(ctxRef(pkg, "n")).Val(2).BinaryOp(token.MUL).EndInit(1).
+		/******/ Val(ctxRef(pkg, "m")).Val(100).BinaryOp(token.LSS).Then().
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "m")).Call(1).EndStmt().
+		/******/ VarRef(ctxRef(pkg, "n")).IncDec(token.INC).
+		/**/ End().
+		/**/ While().Then(). // for {
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "n")).Call(1).EndStmt().
 		/**/ End().
 		End()
 	domTest(t, pkg, `package main
@@ -1533,53 +1939,327 @@ func TestForRange(t *testing.T) {
 import fmt "fmt"
 
 func main() {
-	a := []float64{1, 1.2, 3}
-	for i := range a {
-		fmt.Println(i)
+	n := 10
+	for n > 0 {
+		n--
+	}
+	for {
+		m := n * 2
+		if !(m < 100) {
+			break
+		}
+		fmt.Println(m)
+		n++
+	}
+	for {
+		fmt.Println(n)
 	}
 }
 `)
 }
 
-func TestForRangeUDT(t *testing.T) {
+func TestDoWhile(t *testing.T) {
 	pkg := newMainPackage()
-	foo := pkg.Import("github.com/goplus/gox/internal/foo")
-	nodeSet := foo.Ref("NodeSet").Type()
-	v := pkg.NewParam(token.NoPos, "v", nodeSet)
-	pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
-		ForRange("_", "val").Val(v).RangeAssignThen(token.NoPos).
-		Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "val")).Call(1).EndStmt().
-		End().End()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "n").Val(10).EndInit(1).
+		/**/ DoWhile().
+		/******/ VarRef(ctxRef(pkg, "n")).IncDec(token.DEC).
+		/**/ DoCond().Val(ctxRef(pkg, "n")).Val(0).BinaryOp(token.GTR).
+		/**/ End().
+		/**/ DoWhile().
+		/******/ DefineVarStart(0, "n").Val(1).EndInit(1).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "n")).Call(1).EndStmt().
+		/**/ DoCond().Val(ctxRef(pkg, "n")).Val(0).BinaryOp(token.GTR).
+		/**/ End().
+		/**/ DoWhile().
+		/******/ SetComments(comment("\n// shadow n"), true).
+		/******/ DefineVarStart(0, "n").Val(1).EndInit(1).
+		/******/ VarRef(ctxRef(pkg, "n")).IncDec(token.INC).
+		/**/ DoCond().Val(ctxRef(pkg, "n")).Val(0).BinaryOp(token.GTR).
+		/**/ End().
+		/**/ DoWhile().
+		/******/ If().Val(ctxRef(pkg, "n")).Val(5).BinaryOp(token.LSS).Then().
+		/**********/ VarRef(ctxRef(pkg, "n")).IncDec(token.INC).
+		/**********/ Continue("").
+		/******/ End().
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "n")).Call(1).EndStmt().
+		/**/ DoCond().Val(ctxRef(pkg, "n")).Val(5).BinaryOp(token.LSS).
+		/**/ End().
+		Label("loop").
+		/**/ DoWhile().
+		/******/ For().Then().
+		/**********/ Continue("loop").
+		/******/ End().
+		/**/ DoCond().DefineVarStart(0, "m").Val(ctxRef(pkg, "n")).Val(2).BinaryOp(token.MUL).EndInit(1).
+		/******/ Val(ctxRef(pkg, "m")).Val(100).BinaryOp(token.LSS).
+		/**/ End().
+		End()
 	domTest(t, pkg, `package main
 
-import (
-	foo "github.com/goplus/gox/internal/foo"
-	fmt "fmt"
-)
+import fmt "fmt"
 
-func bar(v foo.NodeSet) {
-	for _gop_it := v.Gop_Enum(); ; {
-		var _gop_ok bool
-		_, val, _gop_ok := _gop_it.Next()
-		if !_gop_ok {
+func main() {
+	n := 10
+	for {
+		n--
+		if !(n > 0) {
 			break
 		}
-		fmt.Println(val)
+	}
+	for {
+		{
+			n := 1
+			fmt.Println(n)
+		}
+		if !(n > 0) {
+			break
+		}
+	}
+	for {
+		{
+// shadow n
+			n := 1
+			n++
+		}
+		if !(n > 0) {
+			break
+		}
+	}
+	for _autoGo_1 := true; _autoGo_1 || n < 5; _autoGo_1 = false {
+		if n < 5 {
+			n++
+			continue
+		}
+		fmt.Println(n)
+	}
+loop:
+	for _autoGo_2 := true; ; _autoGo_2 = false {
+		if !_autoGo_2 {
+			m := n * 2
+			if !(m < 100) {
+				break
+			}
+		}
+		for {
+			continue loop
+		}
 	}
 }
 `)
 }
 
-func TestForRangeUDT2(t *testing.T) {
+func TestLoopElse(t *testing.T) {
 	pkg := newMainPackage()
-	foo := pkg.Import("github.com/goplus/gox/internal/foo")
-	bar := foo.Ref("Bar").Type()
-	v := pkg.NewParam(token.NoPos, "v", types.NewPointer(bar))
-	pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
-		ForRange("_", "val").Val(v).RangeAssignThen(token.NoPos).
-		Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "val")).Call(1).EndStmt().
-		End().End()
-	domTest(t, pkg, `package main
+	fmt := pkg.Import("fmt")
+	pkg.NewVar(token.NoPos, types.NewSlice(types.Typ[types.Int]), "a")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ ForRange("i", "v").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos).
+		/******/ If().Val(ctxRef(pkg, "v")).Val(0).BinaryOp(token.EQL).Then().
+		/**********/ Break("").
+		/******/ End().
+		/******/ Switch().Val(ctxRef(pkg, "i")).Then().
+		/******/ Val(1).Case(1).
+		/**********/ Break("").
+		/**********/ End().
+		/******/ End().
+		/**/ LoopElse().
+		/******/ DefineVarStart(0, "s").Val("not found").EndInit(1).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "s")).Call(1).EndStmt().
+		/**/ End().
+		Label("loop").
+		/**/ For().Then().
+		/******/ Switch().None().Then().
+		/******/ Val(true).Case(1).
+		/**********/ Break("loop").
+		/**********/ End().
+		/******/ End().
+		/**/ LoopElse().
+		/******/ Val(fmt.Ref("Println")).Val("done").Call(1).EndStmt().
+		/**/ End().
+		/**/ For().Then().
+		/******/ Continue("").
+		/**/ LoopElse().
+		/******/ Val(fmt.Ref("Println")).Val("never").Call(1).EndStmt().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+var a []int
+
+func main() {
+	for i, v := range a {
+		if v == 0 {
+			goto _autoGo_1
+		}
+		switch i {
+		case 1:
+			break
+		}
+	}
+	{
+		s := "not found"
+		fmt.Println(s)
+	}
+_autoGo_1:
+	;
+loop:
+	for {
+		switch {
+		case true:
+			goto _autoGo_2
+		}
+	}
+	fmt.Println("done")
+_autoGo_2:
+	;
+	for {
+		continue
+	}
+	fmt.Println("never")
+}
+`)
+}
+
+func TestLabeledFor(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Label("label").
+		/**/ For().DefineVarStart(0, "i").Val(0).EndInit(1). // for i := 0; i < 10; i=i+1 {
+		/******/ Val(ctxRef(pkg, "i")).Val(10).BinaryOp(token.LSS).Then().
+		/******/ Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		/******/ Break("label").
+		/******/ Post().
+		/******/ VarRef(ctxRef(pkg, "i")).Val(ctxRef(pkg, "i")).Val(1).BinaryOp(token.ADD).Assign(1).EndStmt().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+label:
+	for i := 0; i < 10; i = i + 1 {
+		fmt.Println(i)
+		break label
+	}
+}
+`)
+}
+
+func TestMultiInitStmts(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ If().DefineVarStart(0, "x").Val(3).EndInit(1).
+		/******/ DefineVarStart(0, "y").Val(ctxRef(pkg, "x")).Val(1).BinaryOp(token.ADD).EndInit(1).
+		/******/ Val(ctxRef(pkg, "y")).Val(1).BinaryOp(token.GTR).Then().
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "y")).Call(1).EndStmt().
+		/**/ End().
+		/**/ For().NewVar(types.Typ[types.Int], "n").DefineVarStart(0, "i").Val(0).EndInit(1).
+		/******/ Val(ctxRef(pkg, "i")).Val(ctxRef(pkg, "n")).BinaryOp(token.LSS).Then().
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		/**/ End().
+		DefineVarStart(0, "k").Val(0).EndInit(1).
+		Label("L").
+		/**/ Switch().VarRef(ctxRef(pkg, "k")).Val(1).Assign(1).EndStmt().
+		/******/ VarRef(ctxRef(pkg, "k")).Val(ctxRef(pkg, "k")).Val(1).BinaryOp(token.ADD).Assign(1).EndStmt().
+		/******/ Val(ctxRef(pkg, "k")).Then().
+		/******/ Val(2).Case(1).
+		/**********/ Break("L").
+		/******/ End().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	{
+		x := 3
+		if y := x + 1; y > 1 {
+			fmt.Println(y)
+		}
+	}
+	{
+		var n int
+		for i := 0; i < n; {
+			fmt.Println(i)
+		}
+	}
+	k := 0
+	k = 1
+L:
+	switch k = k + 1; k {
+	case 2:
+		break L
+	}
+}
+`)
+}
+
+func TestForRange(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "a").Val(1).Val(1.2).Val(3).SliceLit(nil, 3).EndInit(1).
+		/**/ ForRange("i").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos).
+		/******/ Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	a := []float64{1, 1.2, 3}
+	for i := range a {
+		fmt.Println(i)
+	}
+}
+`)
+}
+
+func TestForRangeUDT(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.Import("github.com/goplus/gox/internal/foo")
+	nodeSet := foo.Ref("NodeSet").Type()
+	v := pkg.NewParam(token.NoPos, "v", nodeSet)
+	pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
+		ForRange("_", "val").Val(v).RangeAssignThen(token.NoPos).
+		Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "val")).Call(1).EndStmt().
+		End().End()
+	domTest(t, pkg, `package main
+
+import (
+	foo "github.com/goplus/gox/internal/foo"
+	fmt "fmt"
+)
+
+func bar(v foo.NodeSet) {
+	for _gop_it := v.Gop_Enum(); ; {
+		var _gop_ok bool
+		_, val, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(val)
+	}
+}
+`)
+}
+
+func TestForRangeUDT2(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.Import("github.com/goplus/gox/internal/foo")
+	bar := foo.Ref("Bar").Type()
+	v := pkg.NewParam(token.NoPos, "v", types.NewPointer(bar))
+	pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
+		ForRange("_", "val").Val(v).RangeAssignThen(token.NoPos).
+		Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "val")).Call(1).EndStmt().
+		End().End()
+	domTest(t, pkg, `package main
 
 import (
 	foo "github.com/goplus/gox/internal/foo"
@@ -1679,6 +2359,53 @@ func bar(v *foo.Foo2) {
 `)
 }
 
+func TestForRangeUDTInterface(t *testing.T) {
+	pkg := newMainPackage()
+	tyBool, tyInt := types.Typ[types.Bool], types.Typ[types.Int]
+	next := types.NewSignature(nil, nil, types.NewTuple(
+		pkg.NewParam(token.NoPos, "", tyInt), pkg.NewParam(token.NoPos, "", tyBool)), false)
+	iter := pkg.NewType("Iter").InitType(pkg, types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Next", next),
+	}, nil).Complete())
+	enum := types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", iter)), false)
+	coll := pkg.NewType("Coll").InitType(pkg, types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Gop_Enum", enum),
+	}, nil).Complete())
+	bag := pkg.NewType("Bag").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Coll", coll, true),
+	}, nil))
+	v := pkg.NewParam(token.NoPos, "v", bag)
+	pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
+		ForRange("val").Val(v).RangeAssignThen(token.NoPos).
+		Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "val")).Call(1).EndStmt().
+		End().End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+type Iter interface {
+	Next() (int, bool)
+}
+type Coll interface {
+	Gop_Enum() Iter
+}
+type Bag struct {
+	Coll
+}
+
+func bar(v Bag) {
+	for _gop_it := v.Gop_Enum(); ; {
+		var _gop_ok bool
+		val, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(val)
+	}
+}
+`)
+}
+
 func TestForRangeChan(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -1721,6 +2448,73 @@ func main() {
 `)
 }
 
+func TestForRangeString(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ ForRange("i", "c").Val("Hi").RangeAssignThen(token.NoPos).
+		/******/ Val(pkg.Import("fmt").Ref("Println")).Val(ctxRef(pkg, "i")).Val(ctxRef(pkg, "c")).Call(2).EndStmt().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	for i, c := range "Hi" {
+		fmt.Println(i, c)
+	}
+}
+`)
+}
+
+func TestForRangeEx(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "a").Val(1).Val(2).SliceLit(types.NewSlice(types.Typ[types.Int]), 2).EndInit(1).
+		/**/ ForRangeEx("x").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "x")).Call(1).EndStmt().
+		/**/ End().
+		/**/ ForRangeEx("i").Val(10).RangeAssignThen(token.NoPos).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		/**/ End().
+		/**/ ForRangeEx("i").Val(pkg.Builtin().Ref("len")).Val(ctxRef(pkg, "a")).Call(1).RangeAssignThen(token.NoPos).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+		/**/ End().
+		NewVarStart(types.Typ[types.Int64], "n").Val(3).EndInit(1).
+		/**/ ForRangeEx("n").Val(ctxRef(pkg, "n")).RangeAssignThen(token.NoPos).
+		/******/ VarRef(nil).Val(ctxRef(pkg, "n")).Assign(1).
+		/**/ End().
+		/**/ ForRangeEx("_").Val(ctxRef(pkg, "n")).RangeAssignThen(token.NoPos).
+		/******/ Val(fmt.Ref("Println")).Val("Hi").Call(1).EndStmt().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	a := []int{1, 2}
+	for _, x := range a {
+		fmt.Println(x)
+	}
+	for i := 0; i < 10; i++ {
+		fmt.Println(i)
+	}
+	for i, _gop_n := 0, len(a); i < _gop_n; i++ {
+		fmt.Println(i)
+	}
+	var n int64 = 3
+	for n, _gop_n := int64(0), n; n < _gop_n; n++ {
+		_ = n
+	}
+	for _autoGo_1, _gop_n := int64(0), n; _autoGo_1 < _gop_n; _autoGo_1++ {
+		fmt.Println("Hi")
+	}
+}
+`)
+}
+
 func TestForRangeArrayPointer(t *testing.T) {
 	pkg := newMainPackage()
 	v := pkg.NewParam(token.NoPos, "a", types.NewPointer(types.NewArray(types.Typ[types.Float64], 3)))
@@ -2284,6 +3078,118 @@ func main() {
 `)
 }
 
+func TestResolveInitCycles(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	a := pkg.NewVar(token.NoPos, tyInt, "a")
+	b := pkg.NewVar(token.NoPos, tyInt, "b")
+	d := pkg.NewVar(token.NoPos, tyInt, "d")
+	ret := pkg.NewParam(token.NoPos, "", tyInt)
+	pkg.NewFunc(nil, "f", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val(ctxRef(pkg, "a")).Val(1).BinaryOp(token.ADD).Return(1).
+		End()
+	pkg.NewFunc(nil, "g", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		DefineVarStart(0, "a").Val(2).EndInit(1).
+		Val(ctxRef(pkg, "a")).Return(1).
+		End()
+	foo := pkg.NewType("foo").InitType(pkg, tyInt)
+	pkg.NewSignatureBuilder().Recv("p", foo).Result("", tyInt).NewFunc("get").BodyStart(pkg).
+		Val(ctxRef(pkg, "d")).Return(1).
+		End()
+	pkg.CB().NewVarStart(nil, "c").Val(ctxRef(pkg, "b")).Val(1).BinaryOp(token.ADD).EndInit(1)
+	a.InitStart(pkg).Val(ctxRef(pkg, "f")).Call(0).EndInit(1)
+	b.InitStart(pkg).Val(ctxRef(pkg, "a")).EndInit(1)
+	pkg.CB().NewVarStart(nil, "e").Val(ctxRef(pkg, "g")).Call(0).EndInit(1)
+	d.InitStart(pkg).Typ(foo).Val(0).Call(1).MemberVal("get").Call(0).EndInit(1)
+	pkg.NewInit().BodyStart(pkg).
+		VarRef(ctxRef(pkg, "b")).Val(2).Assign(1).
+		End()
+	if names := pkg.ResolveInitCycles(); strings.Join(names, ",") != "a,b,d,c" {
+		t.Fatal("ResolveInitCycles:", names)
+	}
+	domTest(t, pkg, `package main
+
+var a int
+var b int
+var d int
+
+func f() int {
+	return a + 1
+}
+func g() int {
+	a := 2
+	return a
+}
+
+type foo int
+
+func (p foo) get() int {
+	return d
+}
+
+var c int
+var e = g()
+
+func init() {
+	a = f()
+	b = a
+	d = foo(0).get()
+	c = b + 1
+}
+func init() {
+	b = 2
+}
+`)
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+}
+
+func TestVarDefs(t *testing.T) {
+	pkg := newMainPackage()
+	defs := pkg.NewVarDefs()
+	defs.New(token.NoPos, types.Typ[types.Int], "a", "b")
+	defs.NewStart(token.NoPos, nil, "c").Val(1.2).EndInit(1)
+	defs.NewStart(token.NoPos, types.Typ[types.String], "d").Val("Hi").EndInit(1)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	local := pkg.NewVarDefs()
+	local.New(token.NoPos, types.Typ[types.Bool], "x")
+	local.NewStart(token.NoPos, nil, "y", "z").Val(ctxRef(pkg, "a")).Val(ctxRef(pkg, "d")).EndInit(2)
+	pkg.CB().End()
+	domTest(t, pkg, `package main
+
+var (
+	a, b int
+	c           = 1.2
+	d    string = "Hi"
+)
+
+func main() {
+	var (
+		x    bool
+		y, z = a, d
+	)
+}
+`)
+}
+
+func TestAssignSwap(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "a", "b").
+		VarRef(ctxRef(pkg, "a")).VarRef(ctxRef(pkg, "b")).
+		Val(ctxRef(pkg, "b")).Val(ctxRef(pkg, "a")).
+		Assign(2).
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var a, b int
+	a, b = b, a
+}
+`)
+}
+
 func TestOperator(t *testing.T) {
 	var a, b, c, d *goxVar
 	pkg := newMainPackage()
@@ -2427,9 +3333,64 @@ func foo(format string, args ...interface {
 `)
 }
 
-func TestCallInlineClosure(t *testing.T) {
+func TestErrWrap(t *testing.T) {
 	pkg := newMainPackage()
-	fmt := pkg.Import("fmt")
+	strconv, os := pkg.Import("strconv"), pkg.Import("os")
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	n := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	err := pkg.NewParam(token.NoPos, "", gox.TyError)
+	pkg.NewFunc(nil, "foo", gox.NewTuple(s), gox.NewTuple(n, err), false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "x").
+		Val(strconv.Ref("Atoi")).Val(s).Call(1).ErrWrap(false).
+		EndInit(1).
+		Val(os.Ref("Remove")).Val(s).Call(1).ErrWrap(false).EndStmt().
+		DefineVarStart(token.NoPos, "y").
+		Val(strconv.Ref("Atoi")).Val("1").Call(1).Val(-1).ErrWrap(true).
+		EndInit(1).
+		Val(ctxRef(pkg, "x")).Val(ctxRef(pkg, "y")).BinaryOp(token.ADD).Val(nil).Return(2).
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.Builtin().Ref("println")).
+		Val(ctxRef(pkg, "foo")).Val("2").Call(1).ErrAssert().
+		Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	strconv "strconv"
+	os "os"
+)
+
+func foo(s string) (int, error) {
+	_autoGo_1, _autoGo_2 := strconv.Atoi(s)
+	if _autoGo_2 != nil {
+		return 0, _autoGo_2
+	}
+	x := _autoGo_1
+	_autoGo_3 := os.Remove(s)
+	if _autoGo_3 != nil {
+		return 0, _autoGo_3
+	}
+	_autoGo_4, _autoGo_5 := strconv.Atoi("1")
+	if _autoGo_5 != nil {
+		_autoGo_4 = -1
+	}
+	y := _autoGo_4
+	return x + y, nil
+}
+func main() {
+	_autoGo_6, _autoGo_7 := foo("2")
+	if _autoGo_7 != nil {
+		panic(_autoGo_7)
+	}
+	println(_autoGo_6)
+}
+`)
+}
+
+func TestCallInlineClosure(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
 	ret := pkg.NewAutoParam("ret")
 	err := pkg.NewParam(token.NoPos, "", gox.TyError)
 	sig := gox.NewSignature(nil, nil, types.NewTuple(ret), false)
@@ -2654,6 +3615,3452 @@ func main() {
 `)
 }
 
+func TestMemberValEmbedded(t *testing.T) {
+	pkg := newMainPackage()
+	methods := []*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Close", types.NewSignature(nil, nil, nil, false)),
+	}
+	closer := pkg.NewType("closer").InitType(pkg, types.NewInterfaceType(methods, nil).Complete())
+	base := pkg.NewType("base").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+	}, nil))
+	foo := pkg.NewType("foo").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "base", types.NewPointer(base), true),
+		types.NewField(token.NoPos, pkg.Types, "closer", closer, true),
+	}, nil))
+	recv := pkg.NewParam(token.NoPos, "b", types.NewPointer(base))
+	pkg.NewFunc(recv, "Inc", nil, nil, false).BodyStart(pkg).End()
+	pc := pkg.NewParam(token.NoPos, "pc", types.NewPointer(closer))
+	pkg.NewFunc(nil, "bar", types.NewTuple(pc), nil, false).BodyStart(pkg).
+		NewVar(foo, "a").
+		Val(ctxRef(pkg, "a")).MemberVal("Inc").Call(0).EndStmt().
+		Val(ctxRef(pkg, "a")).MemberVal("Close").Call(0).EndStmt().
+		Val(ctxRef(pkg, "a")).MemberRef("x").Val(1).Assign(1).
+		Val(pc).MemberVal("Close").Call(0).EndStmt().
+		NewVarStart(nil, "f").Typ(types.NewPointer(base)).MemberVal("Inc").EndInit(1).
+		StructLit(base, 0, false).MemberVal("Inc").Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+type closer interface {
+	Close()
+}
+type base struct {
+	x int
+}
+type foo struct {
+	*base
+	closer
+}
+
+func (b *base) Inc() {
+}
+func bar(pc *closer) {
+	var a foo
+	a.Inc()
+	a.Close()
+	a.x = 1
+	(*pc).Close()
+	var f = (*base).Inc
+	(&base{}).Inc()
+}
+`)
+}
+
+func TestNewMainRun(t *testing.T) {
+	pkg := newMainPackage()
+	ret := pkg.NewParam(token.NoPos, "", gox.TyError)
+	run := pkg.NewFunc(nil, "run", nil, types.NewTuple(ret), false)
+	run.BodyStart(pkg).Val(nil).Return(1).End()
+	if _, err := pkg.NewMainRun(run.Func, 2); err != nil {
+		t.Fatal("NewMainRun:", err)
+	}
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	os "os"
+)
+
+func run() error {
+	return nil
+}
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+`)
+	if _, err := pkg.NewMainRun(ctxRef(pkg, "main"), 1); err == nil {
+		t.Fatal("NewMainRun: no error?")
+	}
+}
+
+func TestNewMainServe(t *testing.T) {
+	pkg := newMainPackage(true) // don't share the cache: context must be loaded with os/signal
+	context := pkg.Import("context")
+	pkg.Import("os/signal")
+	ctx := pkg.NewParam(token.NoPos, "ctx", context.Ref("Context").Type())
+	ret := pkg.NewParam(token.NoPos, "", gox.TyError)
+	serve := pkg.NewFunc(nil, "serve", types.NewTuple(ctx), types.NewTuple(ret), false)
+	serve.BodyStart(pkg).Val(nil).Return(1).End()
+	if _, err := pkg.NewMainServe(serve.Func, 1); err != nil {
+		t.Fatal("NewMainServe:", err)
+	}
+	domTest(t, pkg, `package main
+
+import (
+	context "context"
+	signal "os/signal"
+	os "os"
+	syscall "syscall"
+	fmt "fmt"
+)
+
+func serve(ctx context.Context) error {
+	return nil
+}
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(ctx)
+	}()
+	var err error
+	select {
+	case <-ctx.Done():
+		stop()
+		err = <-errc
+	case err = <-errc:
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`)
+}
+
+func TestConv(t *testing.T) {
+	pkg := newMainPackage()
+	tyIntPtr := pkg.NewType("IntPtr").InitType(pkg, types.NewPointer(types.Typ[types.Int]))
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "a").
+		NewVar(types.NewSlice(gox.TyByte), "b").
+		NewVar(tyIntPtr, "c").
+		NewVarStart(nil, "d").Val(ctxRef(pkg, "a")).Conv(types.Typ[types.Int64]).EndInit(1).
+		NewVarStart(nil, "e").Val(ctxRef(pkg, "b")).Conv(types.Typ[types.String]).EndInit(1).
+		NewVarStart(nil, "f").Val(ctxRef(pkg, "c")).Conv(types.NewPointer(types.Typ[types.Int])).EndInit(1).
+		NewVarStart(nil, "g").Val(1).Conv(gox.TyEmptyInterface).EndInit(1).
+		NewVarStart(nil, "h").Val(100).Conv(types.Typ[types.Int8]).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type IntPtr *int
+
+func main() {
+	var a int
+	var b []byte
+	var c IntPtr
+	var d = int64(a)
+	var e = string(b)
+	var f = (*int)(c)
+	var g = interface {
+	}(1)
+	var h = int8(100)
+}
+`)
+	cb := pkg.CB()
+	cb.Val(100).Conv(types.Typ[types.Int8])
+	if v := cb.Get(-1).CVal; v == nil || v.String() != "100" {
+		t.Fatal("Conv: constant not folded -", v)
+	}
+	cb.ResetStmt()
+}
+
+func TestConvString(t *testing.T) {
+	pkg := newMainPackage()
+	tyStr := pkg.NewType("Str").InitType(pkg, types.Typ[types.String])
+	tyBytes := types.NewSlice(gox.TyByte)
+	tyRunes := types.NewSlice(gox.TyRune)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	for _, c := range []struct {
+		name string
+		val  func(cb *gox.CodeBuilder)
+		typ  types.Type
+		cval string
+	}{
+		{"a", func(cb *gox.CodeBuilder) { cb.Val(65).Conv(types.Typ[types.String]) }, types.Typ[types.String], `"A"`},
+		{"b", func(cb *gox.CodeBuilder) { cb.Val('世').Conv(tyStr) }, tyStr, `"世"`},
+		{"c", func(cb *gox.CodeBuilder) { cb.Val(-1).Conv(types.Typ[types.String]) }, types.Typ[types.String], "\"\uFFFD\""},
+		{"d", func(cb *gox.CodeBuilder) { cb.Val("Hi").Conv(tyStr) }, tyStr, `"Hi"`},
+		{"e", func(cb *gox.CodeBuilder) { cb.Val("Hi").Conv(tyBytes) }, tyBytes, ""},
+		{"f", func(cb *gox.CodeBuilder) { cb.Val("héllo").Conv(tyStr).Conv(tyRunes) }, tyRunes, ""},
+		{"g", func(cb *gox.CodeBuilder) { cb.Val(ctxRef(pkg, "f")).Conv(types.Typ[types.String]) }, types.Typ[types.String], ""},
+	} {
+		cb.NewVarStart(nil, c.name)
+		c.val(cb)
+		ret := cb.Get(-1)
+		if ret.Type != c.typ {
+			t.Fatal("TestConvString:", c.name, "is of type", ret.Type)
+		}
+		if v := ret.CVal; c.cval == "" && v != nil || c.cval != "" && (v == nil || v.ExactString() != c.cval) {
+			t.Fatal("TestConvString:", c.name, "is", v)
+		}
+		cb.EndInit(1)
+	}
+	cb.End()
+	domTest(t, pkg, `package main
+
+type Str string
+
+func main() {
+	var a = string(65)
+	var b = Str('世')
+	var c = string(-1)
+	var d = Str("Hi")
+	var e = []byte("Hi")
+	var f = []rune(Str("héllo"))
+	var g = string(f)
+}
+`)
+}
+
+func TestCheckMain(t *testing.T) {
+	pkg := newMainPackage()
+	if !pkg.IsMain() {
+		t.Fatal("IsMain: false")
+	}
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	if err := pkg.CheckMain(); err != nil {
+		t.Fatal("CheckMain:", err)
+	}
+	lib := gox.NewPackage("", "foo", &gox.Config{ModPath: "github.com/goplus/gox"})
+	ret := lib.NewParam(token.NoPos, "", types.Typ[types.Int])
+	lib.NewFunc(nil, "main", nil, types.NewTuple(ret), false).BodyStart(lib).
+		Val(0).Return(1).
+		End()
+	if lib.IsMain() {
+		t.Fatal("IsMain: true")
+	}
+	if err := lib.CheckMain(); err != nil {
+		t.Fatal("CheckMain:", err)
+	}
+	domTest(t, lib, `package foo
+
+func main() int {
+	return 0
+}
+`)
+	file := gox.NewPackage("", "main", &gox.Config{Main: gox.NotMain})
+	file.NewFunc(nil, "foo", nil, nil, false).BodyStart(file).End()
+	if file.IsMain() || file.CheckMain() != nil {
+		t.Fatal("CheckMain: NotMain")
+	}
+	prog := gox.NewPackage("", "main", &gox.Config{Main: gox.MainPackage})
+	if !prog.IsMain() || prog.CheckMain() == nil {
+		t.Fatal("CheckMain: MainPackage")
+	}
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("NewPackage: no error")
+		}
+	}()
+	gox.NewPackage("", "foo", &gox.Config{Main: gox.MainPackage})
+}
+
+func TestASTFile(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val("Hello").Call(1).EndStmt().
+		End()
+	f1 := pkg.ASTFile(false)
+	f2 := pkg.ASTFile(false)
+	if len(f1.Decls) != 2 || f1.Decls[1] != f2.Decls[1] {
+		t.Fatal("ASTFile: decls not shared")
+	}
+	f1.Decls = append(f1.Decls[:1], &ast.GenDecl{Tok: token.VAR})
+	fn := f2.Decls[1].(*ast.FuncDecl)
+	fn.Name.Name = "hello"
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func hello() {
+	fmt.Println("Hello")
+}
+`)
+}
+
+func TestASTFileAstutil(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(strings.Ref("ToUpper")).Val("Hello").Call(1).Call(1).EndStmt().
+		End()
+	fset := token.NewFileSet()
+	f := pkg.ASTFile(false)
+	if len(f.Imports) != 2 || f.Imports[1] != f.Decls[0].(*ast.GenDecl).Specs[1] {
+		t.Fatal("ASTFile: imports mismatch -", len(f.Imports))
+	}
+	if !astutil.DeleteImport(fset, f, "strings") {
+		t.Fatal("DeleteImport failed")
+	}
+	if !astutil.AddNamedImport(fset, f, "", "os") || !astutil.AddNamedImport(fset, f, "_", "embed") {
+		t.Fatal("AddNamedImport failed")
+	}
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	"os"
+	_ "embed"
+)
+
+func main() {
+	fmt.Println(strings.ToUpper("Hello"))
+}
+`)
+	f = pkg.ASTFile(false)
+	if !astutil.DeleteImport(fset, f, "os") || !astutil.AddNamedImport(fset, f, "str", "strings") {
+		t.Fatal("astutil failed")
+	}
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	_ "embed"
+	str "strings"
+)
+
+func main() {
+	fmt.Println(strings.ToUpper("Hello"))
+}
+`)
+}
+
+func TestWriteGopSyntax(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	rand := pkg.Import("math/rand")
+	pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(rand.Ref("Int")).Call(0).Call(1).EndStmt().
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").Val(1).EndInit(1).
+		If().Val(ctxRef(pkg, "a")).Val(0).BinaryOp(token.GTR).Then().
+		/**/ Val(ctxRef(pkg, "foo")).Call(0).EndStmt().
+		End().
+		End()
+	var b bytes.Buffer
+	if err := gox.WriteTo(&b, pkg, false, gox.GopSyntax); err != nil {
+		t.Fatal("gox.WriteTo failed:", err)
+	}
+	if result := b.String(); result != `import (
+	"fmt"
+	"math/rand"
+)
+
+func foo() {
+	fmt.Println(rand.Int())
+}
+
+a := 1
+if a > 0 {
+	foo()
+}
+` {
+		t.Fatal("WriteTo GopSyntax:", result)
+	}
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	rand "math/rand"
+)
+
+func foo() {
+	fmt.Println(rand.Int())
+}
+func main() {
+	a := 1
+	if a > 0 {
+		foo()
+	}
+}
+`)
+}
+
+func TestWriteToWith(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(1).Val(2).BinaryOp(token.ADD).Call(1).EndStmt().
+		End()
+	var b bytes.Buffer
+	if err := gox.WriteToWith(&b, pkg, false, gox.SExprPrinter{}); err != nil {
+		t.Fatal("WriteToWith SExprPrinter:", err)
+	}
+	if result := b.String(); result != `(File main [(GenDecl import [(ImportSpec fmt "fmt")]) `+
+		`(FuncDecl main (FuncType (FieldList) (FieldList)) (BlockStmt [(ExprStmt (CallExpr (SelectorExpr fmt Println) [(BinaryExpr 1 + 2)]))]))])
+` {
+		t.Fatal("WriteToWith SExprPrinter:", result)
+	}
+	b.Reset()
+	if err := gox.WriteToWith(&b, pkg, false, gox.JSONPrinter{}); err != nil {
+		t.Fatal("WriteToWith JSONPrinter:", err)
+	}
+	if result := b.String(); !strings.HasPrefix(result, `{"Node":"File","Name":{"Node":"Ident","Name":"main"},`+
+		`"Decls":[{"Node":"GenDecl","Tok":"import","Specs":[{"Node":"ImportSpec","Name":{"Node":"Ident","Name":"fmt"},`) ||
+		!strings.Contains(result, `{"Node":"BinaryExpr","X":{"Node":"BasicLit","Kind":"INT","Value":"1"},"Op":"+",`) {
+		t.Fatal("WriteToWith JSONPrinter:", result)
+	}
+	b.Reset()
+	if err := gox.WriteToWith(&b, pkg, false, gox.JSONPrinter{Indent: "  "}); err != nil {
+		t.Fatal("WriteToWith JSONPrinter:", err)
+	}
+	if result := b.String(); !strings.HasPrefix(result, "{\n  \"Node\": \"File\",\n") {
+		t.Fatal("WriteToWith JSONPrinter:", result)
+	}
+	pkg = newMainPackage()
+	pkg.CB().NewVar(types.NewChan(types.SendOnly, types.Typ[types.Int]), "c")
+	b.Reset()
+	if err := gox.WriteToWith(&b, pkg, false, gox.JSONPrinter{}); err != nil ||
+		!strings.Contains(b.String(), `{"Node":"ChanType","Dir":1,"Value":{"Node":"Ident","Name":"int"}}`) {
+		t.Fatal("WriteToWith JSONPrinter:", b.String(), err)
+	}
+	b.Reset()
+	if err := gox.WriteToWith(&b, pkg, false, gox.SExprPrinter{}); err != nil ||
+		!strings.Contains(b.String(), `(ChanType 1 int)`) {
+		t.Fatal("WriteToWith SExprPrinter:", b.String(), err)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").Val(1).Val(2).BinaryOp(token.ADD).EndInit(1).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "a")).Call(1).EndStmt().
+		End()
+	var b bytes.Buffer
+	if err := gox.ExportJSON(&b, pkg, false); err != nil {
+		t.Fatal("ExportJSON:", err)
+	}
+	result := b.String()
+	if !strings.HasPrefix(result, `{"Version":1,"Package":"main","File":"gop_autogen.go","AST":{"Node":"File",`) {
+		t.Fatal("ExportJSON:", result)
+	}
+	for _, part := range []string{
+		`{"Node":"Ident","Name":"a","Pos":[6,2],"End":[6,3],"Object":{"Kind":"var","Type":"int","Def":true}}`,
+		`{"Node":"BinaryExpr","X":{"Node":"BasicLit","Kind":"INT","Value":"1","Pos":[6,7],"End":[6,8],` +
+			`"TypeOf":"untyped int","ConstValue":"1"},"Op":"+",`,
+		`"Pos":[6,7],"End":[6,12],"TypeOf":"int","ConstValue":"3"}`,
+		`{"Node":"Ident","Name":"a","Pos":[7,14],"End":[7,15],"TypeOf":"int","Object":{"Kind":"var","Type":"int"}}`,
+		`{"Node":"Ident","Name":"fmt","Pos":[7,2],"End":[7,5],"Object":{"Kind":"package"}}`,
+	} {
+		if !strings.Contains(result, part) {
+			t.Fatal("ExportJSON:", part, "not found in", result)
+		}
+	}
+}
+
+func TestSharedBuiltin(t *testing.T) {
+	pkg := newMainPackage()
+	pkg2 := newMainPackage()
+	if pkg.Builtin().Ref("println") != pkg2.Builtin().Ref("println") {
+		t.Fatal("builtin package isn't shared")
+	}
+	if pkg.Builtin().Types == pkg2.Builtin().Types {
+		t.Fatal("Builtin: builtin package isn't copied")
+	}
+	hi := types.NewFunc(token.NoPos, pkg.Builtin().Types, "hi", types.NewSignature(nil, nil, nil, false))
+	if pkg.Builtin().Types.Scope().Insert(hi) != nil || pkg2.Builtin().Ref("hi") != nil ||
+		newMainPackage().Builtin().Ref("hi") != nil {
+		t.Fatal("Builtin: builtin package of other packages is changed")
+	}
+	hello := types.NewFunc(token.NoPos, pkg.Builtin().Types, "hello", types.NewSignature(nil, nil, nil, false))
+	if pkg.InsertBuiltin(hello) != nil {
+		t.Fatal("InsertBuiltin failed")
+	}
+	if pkg2.Builtin().Ref("hello") != nil {
+		t.Fatal("InsertBuiltin: builtin package isn't copied")
+	}
+	if pkg.InsertBuiltin(hello) != hello {
+		t.Fatal("InsertBuiltin: hello is redeclared")
+	}
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.Builtin().Ref("hello")).Call(0).EndStmt().
+		NewVar(types.Typ[types.Int], "a").
+		VarRef(ctxRef(pkg, "a")).Val(ctxRef(pkg, "a")).Val(1).BinaryOp(token.ADD).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	hello()
+	var a int
+	a = a + 1
+}
+`)
+}
+
+func TestSharedBuiltinConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pkg := gox.NewPackage("", "main", &gox.Config{Fset: token.NewFileSet()})
+			if o := pkg.Builtin().Ref("println"); pkg.InsertBuiltin(o) != o {
+				t.Error("InsertBuiltin: println isn't found")
+			}
+			tyInts := types.NewSlice(types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(tyInts, "a").
+				VarRef(ctxRef(pkg, "a")).Val(pkg.Builtin().Ref("append")).Val(ctxRef(pkg, "a")).Val(1).Call(2).Assign(1).
+				Val(pkg.Builtin().Ref("println")).Val(pkg.Builtin().Ref("len")).Val(ctxRef(pkg, "a")).Call(1).
+				/**/ Val(2).BinaryOp(token.MUL).Call(1).EndStmt().
+				End()
+			var b bytes.Buffer
+			if err := gox.WriteTo(&b, pkg, false); err != nil {
+				t.Error("WriteTo:", err)
+				return
+			}
+			if b.String() != `package main
+
+func main() {
+	var a []int
+	a = append(a, 1)
+	println(len(a) * 2)
+}
+` {
+				t.Error("WriteTo:", b.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestScopeLookup(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.CB().NewVar(types.Typ[types.Int], "x", "y")
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.String], "x")
+	if scope, o := cb.Lookup("x"); scope != cb.Scope() || o.Type() != types.Typ[types.String] {
+		t.Fatal("Lookup x:", scope, o)
+	}
+	if scope, o := cb.Lookup("y"); scope != pkg.Types.Scope() || o.Type() != types.Typ[types.Int] {
+		t.Fatal("Lookup y:", scope, o)
+	}
+	if scope, o := cb.Lookup("append"); scope != pkg.Builtin().Types.Scope() || o == nil {
+		t.Fatal("Lookup append:", scope, o)
+	}
+	if scope, o := cb.Lookup("int"); scope != types.Universe || o == nil {
+		t.Fatal("Lookup int:", scope, o)
+	}
+	if scope, o := cb.Lookup("z"); scope != nil || o != nil {
+		t.Fatal("Lookup z:", scope, o)
+	}
+	var names []string
+	for _, o := range cb.VisibleObjects(false) {
+		names = append(names, o.Name()+" "+o.Type().String())
+	}
+	if strings.Join(names, ", ") != "x string, main func(), y int" {
+		t.Fatal("VisibleObjects:", names)
+	}
+	objs := cb.VisibleObjects(true)
+	var hasLen, hasOp bool
+	for _, o := range objs {
+		switch o.Name() {
+		case "len":
+			hasLen = o == pkg.Builtin().Ref("len")
+		case "Go_Add":
+			hasOp = true
+		}
+	}
+	if !hasLen || hasOp {
+		t.Fatal("VisibleObjects(true):", hasLen, hasOp)
+	}
+	cb.End()
+}
+
+func TestCheckpoint(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "a")
+	cp := cb.Checkpoint()
+	cb.VarRef(ctxRef(pkg, "a")).Val(1).Assign(1).
+		If().Val(ctxRef(pkg, "a")).Val(1).BinaryOp(token.GTR).Then().
+		/**/ NewVar(types.Typ[types.Int], "b").
+		/**/ Val(ctxRef(pkg, "a"))
+	cb.Rollback(cp).Rollback(cp)
+	if cb.InternalStack().Len() != 0 {
+		t.Fatal("Rollback: stack isn't restored")
+	}
+	cb.VarRef(ctxRef(pkg, "a")).Val(2).Assign(1)
+	mark := cb.Mark()
+	cp2 := cb.Checkpoint()
+	cb.NewVar(types.Typ[types.Int], "b").
+		If().Val(true).Then().
+		/**/ NewVar(types.Typ[types.Int], "c").
+		End()
+	cb.Rollback(cp2)
+	if scope := cb.Scope(); scope.Lookup("b") != nil || scope.NumChildren() != 0 || scope.Lookup("a") == nil {
+		t.Fatal("Rollback: declarations aren't undone")
+	}
+	cb.NewVar(types.Typ[types.Int], "b").
+		InsertAt(mark).NewVar(types.Typ[types.String], "s").End()
+	cb.End()
+	func() {
+		defer func() {
+			if e := recover(); e != "Rollback: checkpoint isn't in the current block" {
+				t.Fatal("Rollback:", e)
+			}
+		}()
+		pkg.CB().Rollback(cp)
+	}()
+	func() {
+		defer func() {
+			if e := recover(); e != "Rollback: can't undo package-level declarations" {
+				t.Fatal("Rollback:", e)
+			}
+		}()
+		pkg := newMainPackage()
+		cp := pkg.CB().Checkpoint()
+		pkg.CB().NewVar(types.Typ[types.Int], "g")
+		pkg.CB().Rollback(cp)
+	}()
+	domTest(t, pkg, `package main
+
+func main() {
+	var a int
+	a = 2
+	var s string
+	var b int
+}
+`)
+}
+
+func TestScopeSnapshot(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.CB().NewVar(types.NewPointer(types.Typ[types.Int]), "g")
+	x := pkg.NewParam(token.NoPos, "x", types.NewSlice(fmt.Ref("Stringer").Type()))
+	cb := pkg.NewFunc(nil, "foo", types.NewTuple(x), nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.String], "len")
+	var b bytes.Buffer
+	for _, entry := range cb.ScopeSnapshot() {
+		switch entry.Scope {
+		case gox.ScopeBuiltin, gox.ScopeUniverse:
+			switch entry.Name {
+			case "len", "Go_Add":
+				t.Fatal("ScopeSnapshot: unexpected builtin", entry.Name)
+			case "append", "int":
+				b.WriteString(entry.Name + " " + entry.Kind + " " + entry.Type + " " + string(entry.Scope) + "\n")
+			}
+		default:
+			b.WriteString(entry.Name + " " + entry.Kind + " " + entry.Type + " " + string(entry.Scope) + "\n")
+		}
+	}
+	cb.End()
+	if b.String() != `len var string local
+x var []fmt.Stringer param
+foo func func(x []fmt.Stringer) package
+g var *int package
+fmt package fmt import
+append builtin  builtin
+int type int universe
+` {
+		t.Fatal("ScopeSnapshot:", b.String())
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "a")
+	cp := cb.Checkpoint()
+	cb.Val(ctxRef(pkg, "a")).Val(1).BinaryOp(token.ADD).
+		Val(1).
+		If().Val("Hi")
+	if cb.StackLen() != 3 || cb.Peek(3) != nil || cb.Peek(-1) != nil || cb.Peek(2).CVal != nil {
+		t.Fatal("StackLen/Peek:", cb.StackLen())
+	}
+	if v := cb.Peek(0).CVal; v == nil || constant.StringVal(v) != "Hi" {
+		t.Fatal("Peek(0):", v)
+	}
+	if ret := cb.DebugString(); ret != `stack: 3 elements (1 in the current block)
+  -1: "Hi" (untyped string = "Hi")
+  --- block base
+  -2: 1 (untyped int = 1)
+  -3: a + 1 (int)
+` {
+		t.Fatal("DebugString:", ret)
+	}
+	cb.Rollback(cp).End()
+}
+
+func TestTopInfo(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{LoadDocs: true})
+	fmt := pkg.Import("fmt")
+	pkg.CB().NewConstStart(types.Typ[types.Int], "n").Val(2).EndInit(1)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	if cb.TopInfo() != nil {
+		t.Fatal("TopInfo: stack isn't empty")
+	}
+	cb.Val(fmt.Ref("Println"))
+	if info := cb.TopInfo(); info.Object != fmt.Ref("Println") || !strings.HasPrefix(info.Doc, "Println formats") {
+		t.Fatal("TopInfo fmt.Println:", info.Object, info.Doc)
+	}
+	cb.Val(ctxRef(pkg, "n"))
+	if info := cb.TopInfo(); info.Object != pkg.Types.Scope().Lookup("n") || info.CVal.String() != "2" {
+		t.Fatal("TopInfo n:", info.Object, info.CVal)
+	}
+	cb.Val(1).Val(2).BinaryOp(token.ADD)
+	if info := cb.TopInfo(); info.Object != nil || info.Doc != "" || info.CVal.String() != "3" {
+		t.Fatal("TopInfo 1 + 2:", info.Object, info.CVal)
+	}
+	cb.Call(2).EndStmt().End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+const n int = 2
+
+func main() {
+	fmt.Println(n, 1+2)
+}
+`)
+}
+
+func TestPkgRefDoc(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{LoadDocs: true})
+	errors := pkg.Import("errors")
+	if doc := errors.Doc("New"); !strings.HasPrefix(doc, "New returns an error") {
+		t.Fatal("Doc New:", doc)
+	}
+	if doc := errors.Doc(""); !strings.HasPrefix(doc, "Package errors implements") {
+		t.Fatal("Doc:", doc)
+	}
+	if doc := newMainPackage().Import("errors").Doc("New"); doc != "" {
+		t.Fatal("Doc New without LoadDocs:", doc)
+	}
+}
+
+func TestCopySignature(t *testing.T) {
+	pkg := newMainPackage()
+	other := types.NewPackage("foo", "foo")
+	params := types.NewTuple(
+		types.NewParam(token.NoPos, other, "", types.Typ[types.Int]),
+		types.NewParam(token.NoPos, other, "_", types.Typ[types.String]),
+		types.NewParam(token.NoPos, other, "arg0", types.NewSlice(types.Typ[types.Bool])))
+	results := types.NewTuple(types.NewParam(token.NoPos, other, "arg1", types.Typ[types.Int]))
+	sig := types.NewSignature(nil, params, results, true)
+	copied := gox.CopySignature(pkg, sig, nil)
+	if ret := copied.String(); ret != "func(arg0_ int, arg1 string, arg0 ...bool) (arg1_ int)" {
+		t.Fatal("CopySignature:", ret)
+	}
+	if copied.Params().At(0).Pkg() != pkg.Types || copied.Results().At(0).Pkg() != pkg.Types {
+		t.Fatal("CopySignature: params aren't owned by pkg")
+	}
+	copied = gox.CopySignature(pkg, sig, func(i int, name string) string {
+		return "p" + string(rune('a'+i))
+	})
+	if ret := copied.String(); ret != "func(pa int, pb string, pc ...bool) (arg1 int)" {
+		t.Fatal("CopySignature renamer:", ret)
+	}
+}
+
+func TestNewInterfaceFrom(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "a", foo)
+	precv := pkg.NewParam(token.NoPos, "p", types.NewPointer(foo))
+	v := pkg.NewParam(token.NoPos, "v", types.Typ[types.Int])
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	pkg.NewFunc(precv, "Set", types.NewTuple(v), nil, false).BodyStart(pkg).End()
+	pkg.NewFunc(recv, "String", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val("foo").Return(1).End()
+	pkg.NewFunc(precv, "reset", nil, nil, false).BodyStart(pkg).End()
+	pkg.NewInterfaceFrom("Setter", foo, func(m *types.Func) bool {
+		return m.Name() != "String"
+	})
+	iface := pkg.NewInterfaceFrom("FooIface", foo, nil)
+	if !types.Implements(types.NewPointer(foo), iface.Underlying().(*types.Interface)) {
+		t.Fatal("*Foo doesn't implement FooIface")
+	}
+	domTest(t, pkg, `package main
+
+type Foo struct {
+}
+
+func (p *Foo) Set(v int) {
+}
+func (a Foo) String() string {
+	return "foo"
+}
+func (p *Foo) reset() {
+}
+
+type Setter interface {
+	Set(v int)
+}
+type FooIface interface {
+	Set(v int)
+	String() string
+}
+`)
+}
+
+func TestNewDelegates(t *testing.T) {
+	pkg := newMainPackage()
+	tyString := types.Typ[types.String]
+	format := pkg.NewParam(token.NoPos, "format", tyString)
+	args := pkg.NewParam(token.NoPos, "args", types.NewSlice(gox.TyEmptyInterface))
+	n := pkg.NewParam(token.NoPos, "n", types.Typ[types.Int])
+	err := pkg.NewParam(token.NoPos, "err", types.Universe.Lookup("error").Type())
+	methods := []*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Do", types.NewSignature(
+			nil, types.NewTuple(format, args), types.NewTuple(n, err), true)),
+		types.NewFunc(token.NoPos, pkg.Types, "Done", types.NewSignature(nil, nil, nil, false)),
+		types.NewFunc(token.NoPos, pkg.Types, "Name", types.NewSignature(
+			nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyString)), false)),
+	}
+	doer := pkg.NewType("Doer").InitType(pkg, types.NewInterfaceType(methods, nil).Complete())
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Doer", doer, true),
+	}, nil))
+	pkg.NewFunc(pkg.NewParam(token.NoPos, "p", types.NewPointer(foo)), "Name", nil, methods[2].Type().(*types.Signature).Results(), false).
+		BodyStart(pkg).Val("foo").Return(1).End()
+	if fns := pkg.NewDelegates(foo, "Doer"); len(fns) != 2 {
+		t.Fatal("NewDelegates:", len(fns))
+	}
+	builder := pkg.Import("strings").Ref("Builder").Type()
+	bar := pkg.NewType("Bar").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Builder", types.NewPointer(builder), true),
+	}, nil))
+	pkg.NewDelegates(bar, "Builder", "WriteString", "Len")
+	domTest(t, pkg, `package main
+
+import strings "strings"
+
+type Doer interface {
+	Do(format string, args ...interface {
+	}) (n int, err error)
+	Done()
+	Name() string
+}
+type Foo struct {
+	Doer
+}
+
+func (p *Foo) Name() string {
+	return "foo"
+}
+func (f *Foo) Do(format string, args ...interface {
+}) (n int, err error) {
+	return f.Doer.Do(format, args...)
+}
+func (f *Foo) Done() {
+	f.Doer.Done()
+}
+
+type Bar struct {
+	*strings.Builder
+}
+
+func (b *Bar) WriteString(s string) (int, error) {
+	return b.Builder.WriteString(s)
+}
+func (b *Bar) Len() int {
+	return b.Builder.Len()
+}
+`)
+}
+
+func TestCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	conf := &gox.Config{Fset: token.NewFileSet(), ModPath: "github.com/goplus/gox", CacheDir: dir}
+	pkg := gox.NewPackage("", "main", conf)
+	utf8 := pkg.Import("unicode/utf8")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(utf8.Ref("RuneLen")).Val('x').Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import utf8 "unicode/utf8"
+
+func main() {
+	utf8.RuneLen('x')
+}
+`)
+	files, err := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if err != nil || len(files) != 1 {
+		t.Fatal("CacheDir: cache file not found -", files, err)
+	}
+	noLoad := func(cfg *packages.Config, patterns ...string) ([]*packages.Package, error) {
+		t.Fatal("CacheDir: load", patterns)
+		return nil, nil
+	}
+	cached := gox.OpenLoadPkgsCached(files[0], noLoad)
+	imports := map[string]*gox.PkgRef{"unicode/utf8": {}}
+	if cached.Load(pkg, imports, "unicode/utf8") != 0 ||
+		imports["unicode/utf8"].Types.Scope().Lookup("RuneLen") == nil {
+		t.Fatal("CacheDir: load from cache file failed")
+	}
+}
+
+func TestLoadGoPkgsSharedIdentity(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{Fset: token.NewFileSet()})
+	pkg.Import("context").Ref("Context")
+	pkg = gox.NewPackage("", "main", &gox.Config{Fset: token.NewFileSet()})
+	context, http := pkg.Import("context"), pkg.Import("net/http")
+	ctx := context.Ref("Context").Type()
+	req := http.Ref("Request").Type()
+	m, _, _ := types.LookupFieldOrMethod(types.NewPointer(req), false, nil, "Context")
+	if ret := m.Type().(*types.Signature).Results().At(0).Type(); !types.AssignableTo(ret, ctx) {
+		t.Fatal("LoadGoPkgsShared: can't assign", ret, "to", ctx)
+	}
+}
+
+func TestLazyImport(t *testing.T) {
+	var loaded []string
+	conf := &gox.Config{
+		Fset:       token.NewFileSet(),
+		LazyImport: true,
+		LoadPkgs: func(at *gox.Package, importPkgs map[string]*gox.PkgRef, pkgPaths ...string) int {
+			loaded = append(loaded, pkgPaths...)
+			return gox.LoadGoPkgsShared(at, importPkgs, pkgPaths...)
+		},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	fmt := pkg.Import("fmt")
+	pkg.Import("github.com/goplus/gox/internal/notexist")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val("Hello").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	fmt.Println("Hello")
+}
+`)
+	if len(loaded) != 1 || loaded[0] != "fmt" {
+		t.Fatal("LazyImport: loaded", loaded)
+	}
+}
+
+func TestLazyImportIdentity(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{Fset: token.NewFileSet(), LazyImport: true})
+	http := pkg.Import("net/http")
+	req := http.Ref("Request").Type()
+	ctx := pkg.Import("context").Ref("Context").Type() // loaded after net/http
+	m, _, _ := types.LookupFieldOrMethod(types.NewPointer(req), false, nil, "Context")
+	if ret := m.Type().(*types.Signature).Results().At(0).Type(); ret != ctx {
+		t.Fatal("LazyImport: not identical", ret, ctx)
+	}
+}
+
+func TestNewSumType(t *testing.T) {
+	pkg := newMainPackage()
+	tyFloat := types.Typ[types.Float64]
+	sum := pkg.NewSumType("Shape",
+		&gox.Variant{Name: "Circle", Fields: []*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "R", tyFloat, false),
+			types.NewField(token.NoPos, pkg.Types, "Ärea", tyFloat, false),
+		}},
+		&gox.Variant{Name: "Rect", Fields: []*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "W", tyFloat, false),
+			types.NewField(token.NoPos, pkg.Types, "Type", types.Typ[types.String], false),
+		}})
+	iface := sum.Type.Underlying().(*types.Interface)
+	for _, v := range sum.Variants {
+		if !types.Implements(v, iface) {
+			t.Fatal("NewSumType:", v, "doesn't implement", sum.Type)
+		}
+	}
+	domTest(t, pkg, `package main
+
+type Shape interface {
+	isShape()
+}
+type Circle struct {
+	R    float64
+	Ärea float64
+}
+
+func (c Circle) isShape() {
+}
+
+type Rect struct {
+	W    float64
+	Type string
+}
+
+func (r Rect) isShape() {
+}
+func NewCircle(r float64, ärea float64) Shape {
+	return Circle{R: r, Ärea: ärea}
+}
+func NewRect(w float64, type_ string) Shape {
+	return Rect{W: w, Type: type_}
+}
+func MatchShape(v Shape, onCircle func(Circle), onRect func(Rect)) {
+	switch x := v.(type) {
+	case Circle:
+		onCircle(x)
+	case Rect:
+		onRect(x)
+	}
+}
+`)
+}
+
+func newOptionalPackage(lowering gox.OptionalLowering) *gox.Package {
+	pkg := gox.NewPackage("", "main", &gox.Config{Optional: lowering, NodeInterpreter: nodeInterp{}})
+	tyInt := types.Typ[types.Int]
+	var results, params []*types.Var
+	for i, typ := range pkg.OptionalTypes(tyInt) {
+		results = append(results, pkg.NewParam(token.NoPos, "", typ))
+		params = append(params, pkg.NewParam(token.NoPos, "o"+strconv.Itoa(i), typ))
+	}
+	n := len(results)
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	pkg.NewFunc(nil, "some", types.NewTuple(x), types.NewTuple(results...), false).BodyStart(pkg).
+		Val(x).OptionalSome(tyInt).Return(n).
+		End()
+	pkg.NewFunc(nil, "none", nil, types.NewTuple(results...), false).BodyStart(pkg).
+		OptionalNone(tyInt).Return(n).
+		End()
+	cb := pkg.NewFunc(nil, "get", types.NewTuple(params...), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).
+		BodyStart(pkg).If()
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.OptionalIsSome(tyInt).Then()
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.OptionalValue(tyInt).Return(1).End().
+		Val(0).Return(1).
+		End()
+	return pkg
+}
+
+func TestOptionalPointer(t *testing.T) {
+	domTest(t, newOptionalPackage(nil), `package main
+
+func some(x int) *int {
+	return func(v int) *int {
+		return &v
+	}(x)
+}
+func none() *int {
+	return (*int)(nil)
+}
+func get(o0 *int) int {
+	if o0 != nil {
+		return *o0
+	}
+	return 0
+}
+`)
+}
+
+func TestOptionalPair(t *testing.T) {
+	domTest(t, newOptionalPackage(gox.OptionalPair), `package main
+
+func some(x int) (int, bool) {
+	return x, true
+}
+func none() (int, bool) {
+	return 0, false
+}
+func get(o0 int, o1 bool) int {
+	if o1 {
+		return o0
+	}
+	return 0
+}
+`)
+}
+
+func TestOptionalStruct(t *testing.T) {
+	domTest(t, newOptionalPackage(gox.OptionalStruct), `package main
+
+type OptionInt struct {
+	Value int
+	Valid bool
+}
+
+func some(x int) OptionInt {
+	return OptionInt{Value: x, Valid: true}
+}
+func none() OptionInt {
+	return OptionInt{}
+}
+func get(o0 OptionInt) int {
+	if o0.Valid {
+		return o0.Value
+	}
+	return 0
+}
+`)
+}
+
+func newResultPackage(lowering gox.ResultLowering) *gox.Package {
+	pkg := gox.NewPackage("", "main", &gox.Config{Result: lowering, NodeInterpreter: nodeInterp{}})
+	atoi := pkg.Import("strconv").Ref("Atoi")
+	tyInt := types.Typ[types.Int]
+	tyString := types.Typ[types.String]
+	var results, params []*types.Var
+	for i, typ := range pkg.ResultTypes(tyInt) {
+		results = append(results, pkg.NewParam(token.NoPos, "", typ))
+		params = append(params, pkg.NewParam(token.NoPos, "r"+strconv.Itoa(i), typ))
+	}
+	n := len(results)
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	pkg.NewFunc(nil, "ok", types.NewTuple(x), types.NewTuple(results...), false).BodyStart(pkg).
+		Val(x).ResultOk(tyInt).Return(n).
+		End()
+	err := pkg.NewParam(token.NoPos, "err", gox.TyError)
+	pkg.NewFunc(nil, "fail", types.NewTuple(err), types.NewTuple(results...), false).BodyStart(pkg).
+		Val(err).ResultErr(tyInt).Return(n).
+		End()
+	cb := pkg.NewFunc(nil, "get", types.NewTuple(params...), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).
+		BodyStart(pkg).If()
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.ResultIsOk(tyInt).Then()
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.ResultValue(tyInt).Return(1).End().
+		Val(0).Return(1).
+		End()
+	cb = pkg.NewFunc(nil, "errorOf", types.NewTuple(params...), types.NewTuple(pkg.NewParam(token.NoPos, "", gox.TyError)), false).
+		BodyStart(pkg)
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.ResultError(tyInt).Return(1).End()
+	s := pkg.NewParam(token.NoPos, "s", tyString)
+	pkg.NewFunc(nil, "parse", types.NewTuple(s), types.NewTuple(results...), false).BodyStart(pkg).
+		Val(atoi).Val(s).Call(1).ResultFromGo(tyInt).Return(1).
+		End()
+	rets := types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt), pkg.NewParam(token.NoPos, "", gox.TyError))
+	cb = pkg.NewFunc(nil, "unwrap", types.NewTuple(params...), rets, false).BodyStart(pkg)
+	for _, param := range params {
+		cb.Val(param)
+	}
+	cb.ResultToGo(tyInt).Return(n).End()
+	return pkg
+}
+
+func TestResultPair(t *testing.T) {
+	domTest(t, newResultPackage(nil), `package main
+
+import strconv "strconv"
+
+func ok(x int) (int, error) {
+	return x, nil
+}
+func fail(err error) (int, error) {
+	return 0, err
+}
+func get(r0 int, r1 error) int {
+	if r1 == nil {
+		return r0
+	}
+	return 0
+}
+func errorOf(r0 int, r1 error) error {
+	return r1
+}
+func parse(s string) (int, error) {
+	return strconv.Atoi(s)
+}
+func unwrap(r0 int, r1 error) (int, error) {
+	return r0, r1
+}
+`)
+}
+
+func TestResultStruct(t *testing.T) {
+	domTest(t, newResultPackage(gox.ResultStruct), `package main
+
+import strconv "strconv"
+
+type ResultInt struct {
+	Value int
+	Err   error
+}
+
+func NewResultInt(v int, err error) ResultInt {
+	return ResultInt{v, err}
+}
+func (r ResultInt) Unwrap() (int, error) {
+	return r.Value, r.Err
+}
+func ok(x int) ResultInt {
+	return ResultInt{Value: x}
+}
+func fail(err error) ResultInt {
+	return ResultInt{Err: err}
+}
+func get(r0 ResultInt) int {
+	if r0.Err == nil {
+		return r0.Value
+	}
+	return 0
+}
+func errorOf(r0 ResultInt) error {
+	return r0.Err
+}
+func parse(s string) ResultInt {
+	return NewResultInt(strconv.Atoi(s))
+}
+func unwrap(r0 ResultInt) (int, error) {
+	return r0.Unwrap()
+}
+`)
+}
+
+func TestResultStructLazy(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{Result: gox.ResultStruct, NodeInterpreter: nodeInterp{}})
+	tyInt := types.Typ[types.Int]
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	pkg.NewFunc(nil, "f", types.NewTuple(s), nil, false).BodyStart(pkg).
+		SetComments(comment("\n// parse s"), true).
+		DefineVarStart(token.NoPos, "r").
+		Val(pkg.Import("strconv").Ref("Atoi")).Val(s).Call(1).ResultFromGo(tyInt).EndInit(1).
+		Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "r")).MemberVal("Value").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import strconv "strconv"
+
+func f(s string) {
+// parse s
+	r := NewResultInt(strconv.Atoi(s))
+	println(r.Value)
+}
+
+type ResultInt struct {
+	Value int
+	Err   error
+}
+
+func NewResultInt(v int, err error) ResultInt {
+	return ResultInt{v, err}
+}
+func (r ResultInt) Unwrap() (int, error) {
+	return r.Value, r.Err
+}
+`)
+}
+
+func TestReuseElems(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{Result: gox.ResultStruct, ReuseElems: true, NodeInterpreter: nodeInterp{}})
+	tyInt := types.Typ[types.Int]
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	cb := pkg.NewFunc(nil, "f", types.NewTuple(x), nil, false).BodyStart(pkg).
+		Switch().Val(x).Then().
+		Val(1).Case(1)
+	cb.NewVar(pkg.ResultTypes(tyInt)[0], "r").
+		End().
+		End().
+		DefineVarStart(token.NoPos, "b").
+		Val(pkg.Import("strconv").Ref("ParseBool")).Val("true").Call(1).ResultFromGo(types.Typ[types.Bool]).EndInit(1).
+		Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "b")).MemberVal("Value").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import strconv "strconv"
+
+func f(x int) {
+	switch x {
+	case 1:
+		var r ResultInt
+	}
+	b := NewResultBool(strconv.ParseBool("true"))
+	println(b.Value)
+}
+
+type ResultInt struct {
+	Value int
+	Err   error
+}
+
+func NewResultInt(v int, err error) ResultInt {
+	return ResultInt{v, err}
+}
+func (r ResultInt) Unwrap() (int, error) {
+	return r.Value, r.Err
+}
+
+type ResultBool struct {
+	Value bool
+	Err   error
+}
+
+func NewResultBool(v bool, err error) ResultBool {
+	return ResultBool{v, err}
+}
+func (r ResultBool) Unwrap() (bool, error) {
+	return r.Value, r.Err
+}
+`)
+}
+
+func TestInstTypeShared(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{Optional: gox.OptionalStruct, NodeInterpreter: nodeInterp{}})
+	tyInts := types.NewSlice(types.Typ[types.Int])
+	pkg.SetInTestingFile(true)
+	x := pkg.NewParam(token.NoPos, "x", tyInts)
+	ret := pkg.NewParam(token.NoPos, "", pkg.OptionalTypes(tyInts)[0])
+	pkg.NewFunc(nil, "some", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(x).OptionalSome(tyInts).Return(1).
+		End()
+	pkg.SetInTestingFile(false)
+	tyInts2 := types.NewSlice(types.Typ[types.Int])
+	pkg.NewFunc(nil, "none", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		OptionalNone(tyInts2).Return(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Option struct {
+	Value []int
+	Valid bool
+}
+
+func none() Option {
+	return Option{}
+}
+`)
+	domTestEx(t, pkg, `package main
+
+func some(x []int) Option {
+	return Option{Value: x, Valid: true}
+}
+`, true)
+}
+
+func TestDeclOf(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	foo := pkg.NewType("foo").InitType(pkg, tyInt)
+	pkg.NewVar(token.NoPos, tyInt, "a")
+	pkg.NewVarStart(token.NoPos, nil, "b").Val("Hi").EndInit(1)
+	pkg.NewConstStart(token.NoPos, nil, "c").Val(1).EndInit(1)
+	fn := pkg.NewFunc(nil, "bar", nil, nil, false)
+	fn.BodyStart(pkg).NewVar(tyInt, "x").End()
+	scope := pkg.Types.Scope()
+	for _, name := range []string{"a", "b", "c"} {
+		if decl, ok := pkg.DeclOf(scope.Lookup(name)).(*ast.GenDecl); !ok || decl.Specs[0].(*ast.ValueSpec).Names[0].Name != name {
+			t.Fatal("DeclOf:", name, decl)
+		}
+	}
+	if decl, ok := pkg.DeclOf(foo.Obj()).(*ast.GenDecl); !ok || decl.Tok != token.TYPE {
+		t.Fatal("DeclOf foo:", decl)
+	}
+	if pkg.DeclOf(types.Universe.Lookup("int")) != nil {
+		t.Fatal("DeclOf int: not nil")
+	}
+	decl := pkg.DeclOf(fn.Func).(*ast.FuncDecl)
+	trace := &ast.ExprStmt{X: &ast.CallExpr{
+		Fun: ast.NewIdent("println"), Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"bar"`}},
+	}}
+	decl.Body.List = append([]ast.Stmt{trace}, decl.Body.List...)
+	domTest(t, pkg, `package main
+
+type foo int
+
+var a int
+var b = "Hi"
+
+const c = 1
+
+func bar() {
+	println("bar")
+	var x int
+}
+`)
+	pkg.DeleteFunc(fn)
+	if pkg.DeclOf(fn.Func) != nil {
+		t.Fatal("DeclOf bar: not nil after DeleteFunc")
+	}
+}
+
+func TestFuncVariants(t *testing.T) {
+	pkg := newMainPackage()
+	msg := pkg.NewParam(token.NoPos, "msg", types.Typ[types.String])
+	trace := pkg.NewFuncVariants("debug", nil, "trace", types.NewTuple(msg), nil, false)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(trace.On.Func).Val("Hi").Call(1).EndStmt().
+		End()
+	trace.On.BodyStart(pkg).
+		Val(pkg.Import("fmt").Ref("Println")).Val(msg).Call(1).EndStmt().
+		End()
+	trace.Off.BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+func main() {
+	trace("Hi")
+}
+`)
+	if tags := pkg.BuildTags(); len(tags) != 2 || tags[0] != "debug" || tags[1] != "!debug" {
+		t.Fatal("BuildTags:", tags)
+	}
+	var b bytes.Buffer
+	if err := gox.WriteTaggedTo(&b, pkg, "debug"); err != nil {
+		t.Fatal("WriteTaggedTo failed:", err)
+	}
+	if ret := b.String(); ret != `//go:build debug
+// +build debug
+
+package main
+
+import fmt "fmt"
+
+func trace(msg string) {
+	fmt.Println(msg)
+}
+` {
+		t.Fatal("WriteTaggedTo debug:", ret)
+	}
+	b.Reset()
+	if err := gox.WriteTaggedTo(&b, pkg, "!debug"); err != nil {
+		t.Fatal("WriteTaggedTo failed:", err)
+	}
+	if ret := b.String(); ret != `//go:build !debug
+// +build !debug
+
+package main
+
+func trace(msg string) {
+}
+` {
+		t.Fatal("WriteTaggedTo !debug:", ret)
+	}
+	if err := gox.WriteTaggedTo(&b, pkg, "release"); err == nil {
+		t.Fatal("WriteTaggedTo release: no error")
+	}
+}
+
+func TestFormatOptions(t *testing.T) {
+	format := &gox.FormatOptions{TabWidth: 4, SpaceIndent: true, GroupImports: true}
+	pkg := gox.NewPackage("", "main", &gox.Config{Format: format, NodeInterpreter: nodeInterp{}})
+	foo := pkg.Import("github.com/goplus/gox/internal/foo")
+	v := pkg.NewParam(token.NoPos, "v", foo.Ref("NodeSet").Type())
+	n := pkg.NewParam(token.NoPos, "n", types.Typ[types.Int])
+	pkg.NewFunc(nil, "bar", types.NewTuple(v, n), nil, false).BodyStart(pkg).
+		If().Val(n).Val(0).BinaryOp(token.GTR).Then().
+		Val(pkg.Import("strings").Ref("ToUpper")).Val("Hi").Call(1).EndStmt().
+		Val(pkg.Import("fmt").Ref("Println")).Val("Hi").Call(1).EndStmt().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+    fmt "fmt"
+    strings "strings"
+
+    foo "github.com/goplus/gox/internal/foo"
+)
+
+func bar(v foo.NodeSet, n int) {
+    if n > 0 {
+        strings.ToUpper("Hi")
+        fmt.Println("Hi")
+    }
+}
+`)
+}
+
+func TestFormatMaxLineLen(t *testing.T) {
+	format := &gox.FormatOptions{MaxLineLen: 40}
+	pkg := gox.NewPackage("", "main", &gox.Config{Format: format, NodeInterpreter: nodeInterp{}})
+	fmt := pkg.Import("fmt")
+	tyInt := types.Typ[types.Int]
+	tySlice := types.NewSlice(types.Typ[types.String])
+	params := types.NewTuple(
+		pkg.NewParam(token.NoPos, "first", tyInt),
+		pkg.NewParam(token.NoPos, "second", tyInt),
+		pkg.NewParam(token.NoPos, "arguments", tySlice))
+	pkg.NewFunc(nil, "foo", params, nil, true).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "first")).Val(ctxRef(pkg, "second")).
+		/**/ Val("Hello, world").Call(3).EndStmt().
+		VarRef(ctxRef(pkg, "arguments")).Val(pkg.Builtin().Ref("append")).
+		/**/ Val(ctxRef(pkg, "arguments")).Val(ctxRef(pkg, "arguments")).Call(2, true).Assign(1).
+		DefineVarStart(token.NoPos, "a").
+		/**/ Val("apple").Val("banana").Val("cherry").SliceLit(tySlice, 3).
+		/**/ Val("fig").SliceLit(tySlice, 1).
+		/**/ SliceLit(types.NewSlice(tySlice), 2).EndInit(1).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "a")).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func foo(
+	first int,
+	second int,
+	arguments ...string,
+) {
+	fmt.Println(
+		first,
+		second,
+		"Hello, world",
+	)
+	arguments = append(
+		arguments,
+		arguments...,
+	)
+	a := [][]string{
+		[]string{
+			"apple",
+			"banana",
+			"cherry",
+		},
+		[]string{"fig"},
+	}
+	fmt.Println(a)
+}
+`)
+}
+
+func TestFileHeader(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{GeneratedBy: "gop", NodeInterpreter: nodeInterp{}})
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	pkg.SetInTestingFile(true)
+	pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).End()
+	pkg.SetBuildConstraint(true, "linux && (amd64 || arm64)")
+	domTest(t, pkg, `// Code generated by gop; DO NOT EDIT.
+
+package main
+
+func main() {
+}
+`)
+	domTestEx(t, pkg, `// Code generated by gop; DO NOT EDIT.
+
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+func foo() {
+}
+`, true)
+	var b bytes.Buffer
+	if err := gox.WriteToWith(&b, pkg, false, gox.SExprPrinter{}); err != nil || strings.Contains(b.String(), "generated") {
+		t.Fatal("WriteToWith SExprPrinter:", b.String(), err)
+	}
+	pkg.SetBuildConstraint(false, "linux &&")
+	if err := gox.WriteTo(&b, pkg, false); err == nil {
+		t.Fatal("WriteTo: no error")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var reported []int
+	errStop := errors.New("stop")
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		ProgressInterval: 2,
+		Progress: func(stmts int) error {
+			reported = append(reported, stmts)
+			if stmts == 4 {
+				return errStop
+			}
+			return nil
+		},
+	})
+	println := pkg.Builtin().Ref("println")
+	cb := pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).
+		Val(println).Val(1).Call(1).EndStmt().
+		Val(println).Val(2).Call(1).EndStmt().
+		Val(println).Val(3).Call(1).EndStmt().
+		End()
+	if err := cb.Interrupted(); err != nil {
+		t.Fatal("Interrupted:", err)
+	}
+	cb = pkg.NewFunc(nil, "bar", nil, nil, false).BodyStart(pkg).
+		Val(println).Val(4).Call(1).EndStmt().
+		Val(println).Val(5).Call(1).EndStmt().
+		Val(println).Val(6).Call(1).EndStmt().
+		End()
+	e, ok := cb.Interrupted().(*gox.InterruptedError)
+	if !ok || !errors.Is(e, errStop) || e.Stmts != 4 || len(reported) != 2 {
+		t.Fatal("TestProgress:", e, reported)
+	}
+	e.Resume()
+	if err := cb.Interrupted(); err != nil {
+		t.Fatal("Resume:", err)
+	}
+	pkg.NewFunc(nil, "baz", nil, nil, false).BodyStart(pkg).
+		Val(println).Val(7).Call(1).EndStmt().
+		Val(println).Val(8).Call(1).EndStmt().
+		End()
+	if err := cb.Interrupted(); err != nil || len(reported) != 3 {
+		t.Fatal("TestProgress resumed:", err, reported)
+	}
+	domTest(t, pkg, `package main
+
+func foo() {
+	println(1)
+	println(2)
+	println(3)
+}
+func bar() {
+	println(4)
+	println(5)
+	println(6)
+}
+func baz() {
+	println(7)
+	println(8)
+}
+`)
+}
+
+func TestTimeBudget(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{TimeBudget: time.Nanosecond, ProgressInterval: 1})
+	time.Sleep(time.Millisecond)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.Builtin().Ref("println")).Val(1).Call(1).EndStmt().
+		Val(pkg.Builtin().Ref("println")).Val(2).Call(1).EndStmt().
+		End()
+	if e, ok := cb.Interrupted().(*gox.InterruptedError); !ok || e.Err != gox.ErrTimeBudget || e.Stmts != 1 {
+		t.Fatal("TestTimeBudget:", e)
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	pos2Positions = map[token.Pos]token.Position{}
+	pkg := gox.NewPackage("", "main", &gox.Config{LineDirectives: true, NodeInterpreter: nodeInterp{}})
+	println := pkg.Builtin().Ref("println")
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	pkg.NewFunc(nil, "foo", types.NewTuple(x), nil, false).BodyStart(pkg).
+		SetStmtPos(position(2, 2)).Val(println).Val(1).Call(1).EndStmt().
+		SetStmtPos(position(3, 2)).If().Val(x).Val(0).BinaryOp(token.GTR).Then().
+		/**/ SetStmtPos(position(4, 3)).Val(println).Val(x).Call(1).EndStmt().
+		/**/ Val(println).Val(0).Call(1).EndStmt().
+		End().
+		SetComments(comment("\n// done"), true).
+		SetStmtPos(position(6, 2)).Val(println).Val(2).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func foo(x int) {
+	/*line ./foo.gop:2:2*/ println(1)
+	/*line ./foo.gop:3:2*/ if x > 0 {
+		/*line ./foo.gop:4:3*/ println(x)
+		println(0)
+	}
+// done
+	/*line ./foo.gop:6:2*/ println(2)
+}
+`)
+}
+
+func TestUserData(t *testing.T) {
+	type node struct{ name string }
+	pkg := newMainPackage()
+	foo := pkg.NewFunc(nil, "foo", nil, nil, false)
+	pkg.SetDeclData(foo.Func, &node{"foo"})
+	cb := foo.BodyStart(pkg).Val(1).SetData(&node{"1"}).Val(2)
+	if v, ok := cb.Get(-2).Data.(*node); !ok || v.name != "1" || cb.Get(-1).Data != nil {
+		t.Fatal("TestUserData: element data", cb.Get(-2).Data, cb.Get(-1).Data)
+	}
+	defer func() {
+		err, ok := recover().(*gox.CodeError)
+		if !ok || err.Func == nil {
+			t.Fatal("TestUserData: no CodeError", err)
+		}
+		if v, ok := pkg.DeclData(err.Func.Func).(*node); !ok || v.name != "foo" {
+			t.Fatal("TestUserData: decl data", pkg.DeclData(err.Func.Func))
+		}
+		if pkg.DeclData(types.Universe.Lookup("int")) != nil {
+			t.Fatal("TestUserData: unexpected decl data")
+		}
+	}()
+	cb.Return(2, source("return 1, 2", 2, 5))
+}
+
+func TestTempInfo(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	f := pkg.NewParam(token.NoPos, "f", types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false))
+	var tmp1, tmp2, tmp3, tmp4 *types.Var
+	pkg.NewFunc(nil, "foo", types.NewTuple(x, f), nil, false).BodyStart(pkg).
+		NewTempVar(tyInt, &tmp1).Val(1).Val(2).BinaryOp(token.ADD).Assign(1).
+		NewTempVar(tyInt, &tmp2).Val(x).Assign(1).
+		NewTempVar(tyInt, &tmp3).Val(tmp2).Assign(1).
+		NewTempVar(tyInt, &tmp4).Val(f).Call(0).Assign(1).
+		VarRef(tmp4).Val(tmp1).Assign(1).
+		Val(pkg.Builtin().Ref("println")).Val(tmp1).Val(tmp3).Val(tmp4).Val(tmp2).UnaryOp(token.AND).Call(4).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func foo(x int, f func() int) {
+	var _gop_tmp1 int
+	_gop_tmp1 = 1 + 2
+	var _gop_tmp2 int
+	_gop_tmp2 = x
+	var _gop_tmp3 int
+	_gop_tmp3 = _gop_tmp2
+	var _gop_tmp4 int
+	_gop_tmp4 = f()
+	_gop_tmp4 = _gop_tmp1
+	println(_gop_tmp1, _gop_tmp3, _gop_tmp4, &_gop_tmp2)
+}
+`)
+	if info := pkg.TempInfo(tmp1); info == nil || !info.CanEliminate() {
+		t.Fatal("TestTempInfo: tmp1", info)
+	}
+	if info := pkg.TempInfo(tmp2); info == nil || info.CanEliminate() || !info.AddrTaken ||
+		len(info.Aliases) != 1 || info.Aliases[0] != x {
+		t.Fatal("TestTempInfo: tmp2", info)
+	}
+	if info := pkg.TempInfo(tmp3); info == nil || info.CanEliminate() ||
+		len(info.Aliases) != 2 || info.Aliases[0] != tmp2 || info.Aliases[1] != x {
+		t.Fatal("TestTempInfo: tmp3", info)
+	}
+	if info := pkg.TempInfo(tmp4); info == nil || info.CanEliminate() || !info.SideEffects || info.Assigns != 2 {
+		t.Fatal("TestTempInfo: tmp4", info)
+	}
+	if pkg.TempInfo(x) != nil {
+		t.Fatal("TestTempInfo: x isn't a temporary")
+	}
+}
+
+func TestCompareOpMethod(t *testing.T) {
+	pkg := newMainPackage()
+	typ := pkg.NewType("T").InitType(pkg, types.Typ[types.Int])
+	recv := pkg.NewParam(token.NoPos, "a", typ)
+	b := pkg.NewParam(token.NoPos, "b", typ)
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Bool])
+	pkg.NewFunc(recv, "Go_LT", types.NewTuple(b), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(true).Return(1).
+		End()
+	x := pkg.NewParam(token.NoPos, "x", typ)
+	pkg.NewFunc(nil, "foo", types.NewTuple(x), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "y").Val(1).Val(x).BinaryOp(token.LSS).EndInit(1).
+		NewVarStart(nil, "z").Val(1).Val(x).BinaryOp(token.EQL).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type T int
+
+func (a T) Go_LT(b T) bool {
+	return true
+}
+func foo(x T) {
+	var y = T(1).Go_LT(x)
+	var z = 1 == x
+}
+`)
+}
+
+func TestEvalOrder(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		Optional: gox.OptionalPair, Result: gox.ResultPair, NodeInterpreter: nodeInterp{}})
+	tyInt := types.Typ[types.Int]
+	tyBool := types.Typ[types.Bool]
+	f := pkg.NewParam(token.NoPos, "f", types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false))
+	g := pkg.NewParam(token.NoPos, "g", types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyBool)), false))
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	atoi := pkg.Import("strconv").Ref("Atoi")
+	pkg.NewFunc(nil, "foo", types.NewTuple(f, g, x), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").Val(x).Val(true).OptionalIsSome(tyInt).EndInit(1).
+		NewVarStart(nil, "b").Val(f).Call(0).Val(true).OptionalIsSome(tyInt).EndInit(1).
+		NewVarStart(nil, "c").Val(x).Val(g).Call(0).OptionalValue(tyInt).EndInit(1).
+		NewVarStart(nil, "d").Val(atoi).Val("1").Call(1).ResultIsOk(tyInt).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+import strconv "strconv"
+
+func foo(f func() int, g func() bool, x int) {
+	var a = true
+	var b = func(_ int, v bool) bool {
+		return v
+	}(f(), true)
+	var c = func(v int, _ bool) int {
+		return v
+	}(x, g())
+	var d = func(_ int, v error) error {
+		return v
+	}(strconv.Atoi("1")) == nil
+}
+`)
+}
+
+func TestROpMethod(t *testing.T) {
+	pkg := newMainPackage()
+	typ := pkg.NewType("Vec").InitType(pkg, types.NewArray(types.Typ[types.Float64], 2))
+	recv := pkg.NewParam(token.NoPos, "v", typ)
+	k := pkg.NewParam(token.NoPos, "k", types.Typ[types.Float64])
+	ret := pkg.NewParam(token.NoPos, "", typ)
+	pkg.NewFunc(recv, "Go_Rmul", types.NewTuple(k), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(recv).Return(1).
+		End()
+	x := pkg.NewParam(token.NoPos, "x", typ)
+	g := pkg.NewParam(token.NoPos, "g", types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", types.Typ[types.Float64])), false))
+	pkg.NewFunc(nil, "foo", types.NewTuple(x, g), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").Val(2).Val(x).BinaryOp(token.MUL).EndInit(1).
+		NewVarStart(nil, "b").Val(g).Call(0).Val(x).BinaryOp(token.MUL).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Vec [2]float64
+
+func (v Vec) Go_Rmul(k float64) Vec {
+	return v
+}
+func foo(x Vec, g func() float64) {
+	var a = x.Go_Rmul(2)
+	var b = x.Go_Rmul(g())
+}
+`)
+}
+
+func TestClassify(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	f := pkg.NewParam(token.NoPos, "f", types.NewSignature(nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false))
+	cb := pkg.NewFunc(nil, "foo", types.NewTuple(x, f), nil, false).BodyStart(pkg)
+	cb.Val(1).Val(2).BinaryOp(token.SHL)
+	if ret := cb.Classify(cb.Get(-1)); ret != gox.ExprConst {
+		t.Fatal("Classify 1 << 2:", ret)
+	}
+	cb.Val(x).Val(1).BinaryOp(token.ADD)
+	if ret := cb.Classify(cb.Get(-1)); ret != gox.ExprPure {
+		t.Fatal("Classify x + 1:", ret)
+	}
+	cb.Val(x).Val(f).Call(0).BinaryOp(token.ADD)
+	if ret := cb.Classify(cb.Get(-1)); ret != gox.ExprEffectful {
+		t.Fatal("Classify x + f():", ret)
+	}
+	cb.ResetStmt()
+	cb.End()
+}
+
+func TestIndexOpMethods(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	tyString := types.Typ[types.String]
+	typ := pkg.NewType("List").InitType(pkg, types.NewStruct(nil, nil))
+	ptr := types.NewPointer(typ)
+	recv := pkg.NewParam(token.NoPos, "l", ptr)
+	i := pkg.NewParam(token.NoPos, "i", tyInt)
+	v := pkg.NewParam(token.NoPos, "v", tyString)
+	lo := pkg.NewParam(token.NoPos, "lo", tyInt)
+	hi := pkg.NewParam(token.NoPos, "hi", tyInt)
+	pkg.NewFunc(recv, "Go_Index", types.NewTuple(i), types.NewTuple(pkg.NewParam(token.NoPos, "", tyString)), false).BodyStart(pkg).
+		Val("").Return(1).
+		End()
+	pkg.NewFunc(recv, "Go_SetIndex", types.NewTuple(i, v), nil, false).BodyStart(pkg).
+		End()
+	pkg.NewFunc(recv, "Go_Slice", types.NewTuple(lo, hi), types.NewTuple(pkg.NewParam(token.NoPos, "", ptr)), false).BodyStart(pkg).
+		Val(recv).Return(1).
+		End()
+	a := pkg.NewParam(token.NoPos, "a", ptr)
+	pkg.NewFunc(nil, "foo", types.NewTuple(a), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "x").Val(a).Val(1).Index(1, false).EndInit(1).
+		Val(a).Val(2).IndexRef(1).Val("hi").Assign(1).
+		NewVarStart(nil, "y").Val(a).Val(1).Val(3).Slice(false).EndInit(1).
+		NewVarStart(nil, "z").Val(a).None().Val(3).Slice(false).EndInit(1).
+		NewVarStart(nil, "w").Val(a).Val(1).None().Slice(false).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type List struct {
+}
+
+func (l *List) Go_Index(i int) string {
+	return ""
+}
+func (l *List) Go_SetIndex(i int, v string) {
+}
+func (l *List) Go_Slice(lo int, hi int) *List {
+	return l
+}
+func foo(a *List) {
+	var x = a.Go_Index(1)
+	a.Go_SetIndex(2, "hi")
+	var y = a.Go_Slice(1, 3)
+	var z = a.Go_Slice(0, 3)
+	var w = a.Go_Slice(1, -1)
+}
+`)
+}
+
+func TestAutoImportByObject(t *testing.T) {
+	foo := types.NewPackage("example.com/foo", "fmt")
+	x := types.NewVar(token.NoPos, foo, "X", types.Typ[types.Int])
+	foo.Scope().Insert(x)
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(x).Call(1).EndStmt().
+		Val(x).Val(1).BinaryOp(token.ADD).EndStmt().
+		End()
+	if pkg.Import("example.com/foo").Ref("X") != x {
+		t.Fatal("TestAutoImportByObject: object not identical")
+	}
+	if foo.Name() != "fmt" {
+		t.Fatal("TestAutoImportByObject: package renamed:", foo.Name())
+	}
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	fmt1 "example.com/foo"
+)
+
+func main() {
+	fmt.Println(fmt1.X)
+	fmt1.X + 1
+}
+`)
+}
+
+type pyOpResolver struct{}
+
+func (pyOpResolver) ResolveOp(op token.Token, x, y types.Type, right bool) *types.Func {
+	t, ok := x.(*types.Named)
+	if !ok {
+		return nil
+	}
+	var name string
+	switch op {
+	case token.ADD:
+		name = "__add__"
+		if right {
+			name = "__radd__"
+		}
+	case token.ARROW:
+		name = "__next__"
+	case gox.OpConv:
+		name = "__int__"
+	default:
+		return nil
+	}
+	for i, n := 0, t.NumMethods(); i < n; i++ {
+		if m := t.Method(i); m.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestOperatorResolver(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{OperatorResolver: pyOpResolver{}})
+	tyInt := types.Typ[types.Int]
+	typ := pkg.NewType("Num").InitType(pkg, tyInt)
+	newMethod := func(name string, params *types.Tuple, ret types.Type) {
+		recv := pkg.NewParam(token.NoPos, "n", typ)
+		pkg.NewFunc(recv, name, params, types.NewTuple(pkg.NewParam(token.NoPos, "", ret)), false).BodyStart(pkg).
+			Val(recv).Return(1).
+			End()
+	}
+	newMethod("__add__", types.NewTuple(pkg.NewParam(token.NoPos, "o", typ)), typ)
+	newMethod("__radd__", types.NewTuple(pkg.NewParam(token.NoPos, "k", tyInt)), typ)
+	newMethod("__next__", nil, typ)
+	recv := pkg.NewParam(token.NoPos, "n", typ)
+	pkg.NewFunc(recv, "__int__", nil, types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).BodyStart(pkg).
+		Val(0).Return(1).
+		End()
+	x := pkg.NewParam(token.NoPos, "x", typ)
+	pkg.NewFunc(nil, "foo", types.NewTuple(x), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").Val(x).Val(x).BinaryOp(token.ADD).EndInit(1).
+		NewVarStart(nil, "b").Val(1).Val(x).BinaryOp(token.ADD).EndInit(1).
+		NewVarStart(nil, "c").Val(x).UnaryOp(token.ARROW).EndInit(1).
+		NewVarStart(nil, "d").Val(x).Conv(tyInt).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Num int
+
+func (n Num) __add__(o Num) Num {
+	return n
+}
+func (n Num) __radd__(k int) Num {
+	return n
+}
+func (n Num) __next__() Num {
+	return n
+}
+func (n Num) __int__() int {
+	return 0
+}
+func foo(x Num) {
+	var a = x.__add__(x)
+	var b = x.__radd__(1)
+	var c = x.__next__()
+	var d = x.__int__()
+}
+`)
+}
+
+func TestCrossFileRefs(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	bytes := pkg.Import("bytes")
+	v := pkg.NewParam(token.NoPos, "strings", types.Typ[types.String])
+	foo := pkg.NewFunc(nil, "Foo", types.NewTuple(v), nil, false)
+	buf := pkg.NewType("Buf")
+	x := pkg.NewVarDefs()
+	x.New(token.NoPos, types.Typ[types.Int], "a")
+	pkg.SetInTestingFile(true)
+	foo.BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(v).Call(1).EndStmt().
+		End()
+	buf.InitType(pkg, types.NewPointer(bytes.Ref("Buffer").Type()))
+	x.NewStart(token.NoPos, nil, "b").Val(strings.Ref("Repeat")).Val("x").Val(2).Call(2).EndInit(1)
+	pkg.NewFunc(nil, "TestFoo", nil, nil, false).BodyStart(pkg).
+		Val(foo).Val(strings.Ref("ToUpper")).Val("x").Call(1).Call(1).EndStmt().
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "b")).Call(1).EndStmt().
+		End()
+	pkg.SetInTestingFile(false)
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	strings1 "strings"
+	bytes "bytes"
+)
+
+func Foo(strings string) {
+	fmt.Println(strings)
+}
+
+type Buf *bytes.Buffer
+
+var (
+	a int
+	b = strings1.Repeat("x", 2)
+)
+`)
+	domTestEx(t, pkg, `package main
+
+import (
+	strings1 "strings"
+	fmt "fmt"
+)
+
+func TestFoo() {
+	Foo(strings1.ToUpper("x"))
+	fmt.Println(b)
+}
+`, true)
+}
+
+func TestDefaultType(t *testing.T) {
+	var tyNum, tyUntypedNum types.Type
+	conf := &gox.Config{
+		Fset:     gblFset,
+		LoadPkgs: gblLoadPkgs,
+		DefaultType: func(typ types.Type) types.Type {
+			switch typ {
+			case types.Typ[types.UntypedFloat]:
+				return types.Typ[types.Float32]
+			case tyUntypedNum:
+				return tyNum
+			}
+			return nil
+		},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	tyNum = pkg.NewType("Num").InitType(pkg, types.Typ[types.Int])
+	tyUntypedNum = pkg.NewType("untypedNum").InitType(pkg, types.Typ[types.Int])
+	x := pkg.NewParam(token.NoPos, "x", tyUntypedNum)
+	ret := pkg.NewParam(token.NoPos, "", tyNum)
+	pkg.NewFunc(nil, "Num_Init", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(x).Conv(tyNum).Return(1).
+		End()
+	pkg.NewFunc(nil, "foo", types.NewTuple(pkg.NewParam(token.NoPos, "n", tyUntypedNum)), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").Val(1.5).EndInit(1).
+		DefineVarStart(token.NoPos, "b").Val(2).EndInit(1).
+		DefineVarStart(token.NoPos, "c").Val(ctxRef(pkg, "n")).EndInit(1).
+		NewVarStart(nil, "d").Val(ctxRef(pkg, "a")).Val(ctxRef(pkg, "c")).Conv(types.Typ[types.Float32]).
+		BinaryOp(token.ADD).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Num int
+type untypedNum int
+
+func Num_Init(x untypedNum) Num {
+	return Num(x)
+}
+func foo(n untypedNum) {
+	var a = float32(1.5)
+	b := 2
+	c := Num_Init(n)
+	var d = a + float32(c)
+}
+`)
+}
+
+func TestForwardRef(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt, tyBool := types.Typ[types.Int], types.Typ[types.Bool]
+	newPred := func(name string) (*gox.Func, *types.Var) {
+		n := pkg.NewParam(token.NoPos, "n", tyInt)
+		ret := pkg.NewParam(token.NoPos, "", tyBool)
+		return pkg.NewFunc(nil, name, types.NewTuple(n), types.NewTuple(ret), false), n
+	}
+	isEven, n := newPred("isEven")
+	sig := isEven.Type()
+	isEven.BodyStart(pkg).
+		Val(n).Val(0).BinaryOp(token.EQL).
+		ForwardRef("isOdd", sig).Val(n).Val(1).BinaryOp(token.SUB).Call(1).
+		BinaryOp(token.LOR).Return(1).
+		End()
+	if err := pkg.ResolveForwardRefs(); err == nil || err.Error() != "undefined: isOdd" {
+		t.Fatal("ResolveForwardRefs:", err)
+	}
+	isOdd, n := newPred("isOdd")
+	isOdd.BodyStart(pkg).
+		Val(n).Val(0).BinaryOp(token.NEQ).
+		ForwardRef("isEven", sig).Val(n).Val(1).BinaryOp(token.SUB).Call(1).
+		BinaryOp(token.LAND).Return(1).
+		End()
+	domTest(t, pkg, `package main
+
+func isEven(n int) bool {
+	return n == 0 || isOdd(n-1)
+}
+func isOdd(n int) bool {
+	return n != 0 && isEven(n-1)
+}
+`)
+}
+
+func TestUnresolvedForwardRefs(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVarStart(nil, "x").ForwardRef("a", types.Typ[types.Int], source("a", 2, 10)).EndInit(1).
+		NewVarStart(nil, "y").ForwardRef("b", types.Typ[types.Int], source("b", 3, 10)).EndInit(1).
+		End()
+	pkg.NewVar(token.NoPos, types.Typ[types.String], "b")
+	var b bytes.Buffer
+	err := gox.WriteTo(&b, pkg, false)
+	if _, ok := err.(*gox.UnresolvedRefsError); !ok || err.Error() != `./foo.gop:2:10 undefined: a
+./foo.gop:3:10 b is declared as type string, but referenced as type int` {
+		t.Fatal("TestUnresolvedForwardRefs:", err)
+	}
+}
+
+func TestAssignableComparableHooks(t *testing.T) {
+	var tyStrs, tyName types.Type
+	tyStrings := types.NewSlice(types.Typ[types.String])
+	conf := &gox.Config{
+		Fset:     gblFset,
+		LoadPkgs: gblLoadPkgs,
+		AssignableTo: func(pkg *gox.Package, V, T types.Type, expr *ast.Expr) bool {
+			if V != tyStrs || !types.Identical(T, tyStrings) {
+				return false
+			}
+			if expr != nil {
+				*expr = &ast.SelectorExpr{X: *expr, Sel: ast.NewIdent("items")}
+			}
+			return true
+		},
+		ComparableTo: func(pkg *gox.Package, V, T types.Type) bool {
+			return V == tyStrs && T == tyName
+		},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	strings := pkg.Import("strings")
+	items := types.NewField(token.NoPos, pkg.Types, "items", tyStrings, false)
+	tyStrs = pkg.NewType("Strs").InitType(pkg, types.NewStruct([]*types.Var{items}, nil))
+	tyName = pkg.NewType("Name").InitType(pkg, types.Typ[types.String])
+	if !gox.ComparableTo(pkg, tyStrs, tyName) || gox.ComparableTo(pkg, tyName, tyStrs) {
+		t.Fatal("ComparableTo: hook isn't consulted")
+	}
+	if !gox.ComparableTo(pkg, tyName, types.Typ[types.UntypedString]) {
+		t.Fatal("ComparableTo: Name and untyped string")
+	}
+	x := pkg.NewParam(token.NoPos, "x", tyStrs)
+	pkg.NewFunc(nil, "join", types.NewTuple(x), nil, false).BodyStart(pkg).
+		Val(strings.Ref("Join")).Val(x).Val(",").Call(2).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import strings "strings"
+
+type Strs struct {
+	items []string
+}
+type Name string
+
+func join(x Strs) {
+	strings.Join(x.items, ",")
+}
+`)
+}
+
+func TestTwoPassBuild(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	// pass one: declare types and func signatures
+	point := pkg.NewType("Point")
+	recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(point.Type()))
+	ret := pkg.NewParam(token.NoPos, "", tyInt)
+	sum := pkg.NewFunc(recv, "Sum", nil, types.NewTuple(ret), false)
+	main := pkg.NewFunc(nil, "main", nil, nil, false)
+	if err := pkg.EndDecls(); err == nil || err.Error() != `type Point isn't initialized
+func (*Point).Sum has no body
+func main has no body` {
+		t.Fatal("EndDecls:", err)
+	}
+	// pass two: initialize types and build bodies
+	main.BodyStart(pkg).
+		NewVar(point.Type(), "pt").
+		Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "pt")).UnaryOp(token.AND).MemberVal("Sum").Call(0).Call(1).EndStmt().
+		End()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "X", tyInt, false),
+		types.NewField(token.NoPos, pkg.Types, "Y", tyInt, false),
+	}
+	point.InitType(pkg, types.NewStruct(fields, nil))
+	sum.BodyStart(pkg).
+		Val(recv).MemberVal("X").Val(recv).MemberVal("Y").BinaryOp(token.ADD).Return(1).
+		End()
+	if err := pkg.EndDecls(); err != nil {
+		t.Fatal("EndDecls:", err)
+	}
+	domTest(t, pkg, `package main
+
+type Point struct {
+	X int
+	Y int
+}
+
+func (p *Point) Sum() int {
+	return p.X + p.Y
+}
+func main() {
+	var pt Point
+	println((&pt).Sum())
+}
+`)
+}
+
+func TestTryAPI(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "x").
+		Val(pkg.Builtin().Ref("println"))
+	x := ctxRef(pkg, "x")
+	if err := cb.TryVal(x); err != nil {
+		t.Fatal("TryVal:", err)
+	}
+	if err := cb.TryVal("hello"); err != nil {
+		t.Fatal("TryVal:", err)
+	}
+	if err := cb.TryBinaryOp(token.ADD); err == nil {
+		t.Fatal("TryBinaryOp: no error")
+	}
+	if n := cb.InternalStack().Len(); n != 3 {
+		t.Fatal("TryBinaryOp: stack isn't restored -", n)
+	}
+	if err := cb.TryMemberVal("foo"); err == nil {
+		t.Fatal("TryMemberVal: no error")
+	} else if _, ok := err.(*gox.CodeError); !ok {
+		t.Fatalf("TryMemberVal: %T %v", err, err)
+	}
+	if err := cb.TryThen(); err == nil || err.Error() != "use if..then or switch..then please" {
+		t.Fatal("TryThen:", err)
+	} else if _, ok := err.(*gox.PanicError); !ok {
+		t.Fatalf("TryThen: %T", err)
+	}
+	if err := cb.TryCall(2); err != nil {
+		t.Fatal("TryCall:", err)
+	}
+	if err := cb.TryEndStmt(); err != nil {
+		t.Fatal("TryEndStmt:", err)
+	}
+	if err := cb.TryEnd(); err != nil {
+		t.Fatal("TryEnd:", err)
+	}
+	domTest(t, pkg, `package main
+
+func main() {
+	var x int
+	println(x, "hello")
+}
+`)
+}
+
+func TestStringConcatAndSprintf(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.String], "x").
+		NewVar(types.Typ[types.String], "y")
+	x, y := ctxRef(pkg, "x"), ctxRef(pkg, "y")
+	cb.VarRef(y).
+		Val("Hello, ").Val("world").Val(x).Val("!").Val("!").StringConcat(5).
+		Assign(1).
+		VarRef(y).StringConcat(0).Assign(1).
+		VarRef(y).Val("a").Val("b").StringConcat(2).Assign(1).
+		VarRef(y).Val(x).Val(42).Sprintf("%s: %d", 2).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func main() {
+	var x string
+	var y string
+	y = "Hello, world" + x + "!!"
+	y = ""
+	y = "ab"
+	y = fmt.Sprintf("%s: %d", x, 42)
+}
+`)
+}
+
+func TestTypeConstructors(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	pkg.NewVar(token.NoPos, gox.NewSlice(tyInt), "a")
+	pkg.NewVar(token.NoPos, gox.NewMap(types.Typ[types.String], gox.NewSlice(tyInt)), "b")
+	pkg.NewVar(token.NoPos, gox.NewChan(types.SendOnly, gox.NewPointer(tyInt)), "c")
+	pkg.NewVar(token.NoPos, gox.NewArray(tyInt, 2), "d")
+	domTest(t, pkg, `package main
+
+var a []int
+var b map[string][]int
+var c chan<- *int
+var d [2]int
+`)
+	invalids := []func(){
+		func() { gox.NewSlice(types.Typ[types.UntypedInt]) },
+		func() { gox.NewPointer(types.Typ[types.UntypedNil]) },
+		func() { gox.NewMap(gox.NewSlice(tyInt), tyInt) },
+		func() { gox.NewMap(tyInt, types.Typ[types.UntypedString]) },
+	}
+	for i, invalid := range invalids {
+		func() {
+			defer func() {
+				if e := recover(); e == nil {
+					t.Fatal("TestTypeConstructors: no panic -", i)
+				}
+			}()
+			invalid()
+		}()
+	}
+}
+
+func TestTypeExpr(t *testing.T) {
+	pkg := newMainPackage()
+	textTmpl := pkg.Import("text/template").Ref("Template").Type()
+	htmlTmpl := pkg.Import("html/template").Ref("Template").Type()
+	exprs := []ast.Expr{
+		gox.TypeExpr(pkg, gox.NewMap(types.Typ[types.String], gox.NewPointer(textTmpl))),
+		gox.TypeExpr(pkg, gox.NewSlice(htmlTmpl)),
+		gox.TypeExpr(pkg, gox.NewChan(types.RecvOnly, types.Typ[types.Int])),
+	}
+	gox.ASTFile(pkg, false)
+	var b bytes.Buffer
+	for _, expr := range exprs {
+		format.Node(&b, gblFset, expr)
+		b.WriteByte('\n')
+	}
+	if ret := b.String(); ret != `map[string]*template.Template
+[]template1.Template
+<-chan int
+` {
+		t.Fatal("TestTypeExpr:", ret)
+	}
+}
+
+func TestSwitchBinarySearch(t *testing.T) {
+	pkg := newMainPackage()
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	cb := pkg.NewFunc(nil, "name", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Switch().Val(x).Then().SetSwitchHint(gox.SwitchBinarySearch)
+	for i := 7; i >= 0; i-- {
+		cb.Val(i * 10).Case(1).Val(strconv.Itoa(i * 10)).Return(1).End()
+	}
+	cb.Val(80).Val(90).Case(2).Val("80 or 90").Return(1).End().
+		End().
+		Val("").Return(1).
+		End()
+	pkg.NewFunc(nil, "sign", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Switch().Val(x).Then().SetSwitchHint(gox.SwitchBinarySearch).
+		Val(-1).Case(1).Val("-").Return(1).End().
+		Val(1).Case(1).Val("+").Return(1).End().
+		Case(0).Val("0").Return(1).End().
+		End().
+		End()
+	pkg.NewFunc(nil, "odd", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Switch().Val(x).Then().SetSwitchHint(gox.SwitchBinarySearch).
+		Val(1).Val(3).Case(2).Val("odd").Return(1).End().
+		Val(2).Case(1).Val("even").Return(1).End().
+		End().
+		Val("").Return(1).
+		End()
+	fmt := pkg.Import("fmt")
+	cb = pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Switch().Val(3).Then().SetSwitchHint(gox.SwitchBinarySearch).
+		Val(1).Case(1).End().
+		Val(2).Val(3).Case(2).
+		/**/ Val(fmt.Ref("Println")).Val("2 or 3").Call(1).EndStmt().
+		/**/ End().
+		Case(0).
+		/**/ Val(fmt.Ref("Println")).Val("default").Call(1).EndStmt().
+		/**/ End().
+		End().
+		Switch().Val(4).Then().SetSwitchHint(gox.SwitchBinarySearch).
+		Val(4).Case(1).Break("").End().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func name(x int) string {
+	{
+		_autoGo_1 := x
+		if _autoGo_1 < 50 {
+			if _autoGo_1 < 20 {
+				if _autoGo_1 == 0 {
+					return "0"
+				} else if _autoGo_1 == 10 {
+					return "10"
+				}
+			} else if _autoGo_1 == 20 {
+				return "20"
+			} else if _autoGo_1 == 30 {
+				return "30"
+			} else if _autoGo_1 == 40 {
+				return "40"
+			}
+		} else if _autoGo_1 < 70 {
+			if _autoGo_1 == 50 {
+				return "50"
+			} else if _autoGo_1 == 60 {
+				return "60"
+			}
+		} else if _autoGo_1 == 70 {
+			return "70"
+		} else if _autoGo_1 == 80 || _autoGo_1 == 90 {
+			return "80 or 90"
+		}
+	}
+	return ""
+}
+func sign(x int) string {
+	{
+		_autoGo_2 := x
+		if _autoGo_2 == -1 {
+			return "-"
+		} else if _autoGo_2 == 1 {
+			return "+"
+		}
+		{
+			return "0"
+		}
+	}
+}
+func odd(x int) string {
+	switch x {
+	case 1, 3:
+		return "odd"
+	case 2:
+		return "even"
+	}
+	return ""
+}
+func main() {
+	{
+		_autoGo_3 := 3
+		if _autoGo_3 == 1 {
+			goto _autoGo_4
+		} else if _autoGo_3 == 2 || _autoGo_3 == 3 {
+			fmt.Println("2 or 3")
+			goto _autoGo_4
+		}
+		{
+			fmt.Println("default")
+		}
+	_autoGo_4:
+	}
+	switch 4 {
+	case 4:
+		break
+	}
+}
+`)
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+}
+
+func TestSignatureBuilder(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	foo := pkg.NewType("foo").InitType(pkg, tyInt)
+	recv := pkg.NewSignatureBuilder().Recv("p", foo).Result("", foo)
+	recv.NewFunc("Val").BodyStart(pkg).Val(ctxRef(pkg, "p")).Return(1).End()
+	sig := pkg.NewSignatureBuilder().
+		Param("format", types.Typ[types.String]).
+		Variadic("args", tyInt).
+		Result("n", tyInt).Result("err", gox.TyError)
+	if s := sig.Signature(); !s.Variadic() || s.Params().Len() != 2 || s.Results().Len() != 2 {
+		t.Fatal("Signature:", s)
+	}
+	sig.NewFunc("printf").BodyStart(pkg).
+		Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "format")).Call(1).EndStmt().
+		Return(0).
+		End()
+	_, ft := sig.FuncType()
+	var b bytes.Buffer
+	format.Node(&b, gblFset, ft)
+	if ret := b.String(); ret != "func(format string, args ...int) (n int, err error)" {
+		t.Fatal("FuncType:", ret)
+	}
+	domTest(t, pkg, `package main
+
+type foo int
+
+func (p foo) Val() foo {
+	return p
+}
+func printf(format string, args ...int) (n int, err error) {
+	println(format)
+	return
+}
+`)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("TestSignatureBuilder: no error")
+		}
+	}()
+	pkg.NewSignatureBuilder().Result("n", tyInt).Result("", tyInt)
+}
+
+func TestAnonymousTypes(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	reader := pkg.Import("io").Ref("Reader").Type()
+	newStruct := func() *types.Struct {
+		return types.NewStruct([]*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "X", tyInt, false),
+			types.NewField(token.NoPos, pkg.Types, "Reader", reader, true),
+		}, []string{`json:"x"`, ""})
+	}
+	st := newStruct()
+	ret := types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt))
+	m := types.NewFunc(token.NoPos, pkg.Types, "M", types.NewSignature(nil, nil, ret, false))
+	it := types.NewInterfaceType([]*types.Func{m}, []types.Type{reader}).Complete()
+	v := pkg.NewParam(token.NoPos, "v", it)
+	s := pkg.NewParam(token.NoPos, "s", st)
+	pkg.NewFunc(nil, "f", types.NewTuple(v, s), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").Val(0).Val(1).StructLit(st, 2, true).EndInit(1).
+		NewVarStart(nil, "b").Typ(newStruct()).Val(s).Call(1).EndInit(1).
+		NewVarStart(nil, "c").Val(v).MemberVal("M").Call(0).EndInit(1).
+		NewVarStart(nil, "d").Val(s).MemberVal("Read").EndInit(1).
+		NewVarStart(reader, "e").Val(v).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+import io "io"
+
+func f(v interface {
+	io.Reader
+	M() int
+}, s struct {
+	X int "json:\"x\""
+	io.Reader
+}) {
+	var a = struct {
+		X int "json:\"x\""
+		io.Reader
+	}{X: 1}
+	var b = struct {
+		X int "json:\"x\""
+		io.Reader
+	}(s)
+	var c = v.M()
+	var d = s.Read
+	var e io.Reader = v
+}
+`)
+}
+
+func TestNewEnumFuncs(t *testing.T) {
+	newColor := func() (*gox.Package, *types.Named, []*types.Const) {
+		pkg := newMainPackage()
+		color := pkg.NewType("Color").InitType(pkg, types.Typ[types.Int])
+		var consts []*types.Const
+		for i, name := range []string{"Red", "Green", "Blue"} {
+			pkg.NewConstStart(token.NoPos, color, name).Val(i).EndInit(1)
+			consts = append(consts, pkg.Types.Scope().Lookup(name).(*types.Const))
+		}
+		pkg.NewConstStart(token.NoPos, color, "Default").Val(consts[0]).EndInit(1)
+		consts = append(consts, pkg.Types.Scope().Lookup("Default").(*types.Const))
+		return pkg, color, consts
+	}
+	pkg, color, consts := newColor()
+	if _, _, err := pkg.NewEnumFuncs(color, gox.EnumSwitch, consts...); err != nil {
+		t.Fatal("NewEnumFuncs:", err)
+	}
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+type Color int
+
+const Red Color = 0
+const Green Color = 1
+const Blue Color = 2
+const Default Color = Red
+
+func ParseColor(s string) (Color, error) {
+	switch s {
+	case "Red":
+		return Red, nil
+	case "Green":
+		return Green, nil
+	case "Blue":
+		return Blue, nil
+	case "Default":
+		return Default, nil
+	}
+	return 0, fmt.Errorf("invalid Color: %q", s)
+}
+func (v Color) String() string {
+	switch v {
+	case Red:
+		return "Red"
+	case Green:
+		return "Green"
+	case Blue:
+		return "Blue"
+	}
+	return fmt.Sprintf("Color(%d)", v)
+}
+`)
+	pkg, color, consts = newColor()
+	if _, _, err := pkg.NewEnumFuncs(color, gox.EnumMap, consts...); err != nil {
+		t.Fatal("NewEnumFuncs:", err)
+	}
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+type Color int
+
+const Red Color = 0
+const Green Color = 1
+const Blue Color = 2
+const Default Color = Red
+
+var _Color_values = map[string]Color{"Red": Red, "Green": Green, "Blue": Blue, "Default": Default}
+var _Color_names = map[Color]string{Red: "Red", Green: "Green", Blue: "Blue"}
+
+func ParseColor(s string) (Color, error) {
+	if v, ok := _Color_values[s]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid Color: %q", s)
+}
+func (v Color) String() string {
+	if s, ok := _Color_names[v]; ok {
+		return s
+	}
+	return fmt.Sprintf("Color(%d)", v)
+}
+`)
+	if _, _, err := pkg.NewEnumFuncs(color, gox.EnumMap, types.NewConst(token.NoPos, pkg.Types, "X", types.Typ[types.Int], constant.MakeInt64(1))); err == nil {
+		t.Fatal("NewEnumFuncs: no error")
+	}
+}
+
+func TestAssertImplements(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	stringer := types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "String", types.NewSignature(
+			nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "", types.Typ[types.String])), false)),
+	}, nil).Complete()
+	tyStringer := pkg.NewType("Stringer").InitType(pkg, stringer)
+	newType := func(name string, typ types.Type, ptrRecv bool) *types.Named {
+		named := pkg.NewType(name).InitType(pkg, typ)
+		var recv types.Type = named
+		if ptrRecv {
+			recv = types.NewPointer(named)
+		}
+		pkg.NewSignatureBuilder().Recv("p", recv).Result("", types.Typ[types.String]).
+			NewFunc("String").BodyStart(pkg).Val("").Return(1).End()
+		return named
+	}
+	foo := newType("foo", types.NewStruct(nil, nil), true)
+	bar := newType("bar", tyInt, false)
+	baz := newType("baz", types.NewArray(tyInt, 2), false)
+	qux := newType("qux", types.NewStruct(nil, nil), false)
+	for _, typ := range []types.Type{types.NewPointer(foo), bar, baz, qux} {
+		if err := pkg.AssertImplements(typ, tyStringer, true); err != nil {
+			t.Fatal("AssertImplements:", err)
+		}
+	}
+	if err := pkg.AssertImplements(foo, tyStringer, true); err == nil ||
+		err.Error() != "foo does not implement Stringer (method String has pointer receiver)" {
+		t.Fatal("AssertImplements:", err)
+	}
+	if err := pkg.AssertImplements(tyInt, tyStringer, true); err == nil ||
+		err.Error() != "int does not implement Stringer (missing method String)" {
+		t.Fatal("AssertImplements:", err)
+	}
+	if err := pkg.AssertImplements(foo, tyInt, false); err == nil || err.Error() != "int is not an interface" {
+		t.Fatal("AssertImplements:", err)
+	}
+	domTest(t, pkg, `package main
+
+type Stringer interface {
+	String() string
+}
+type foo struct {
+}
+
+func (p *foo) String() string {
+	return ""
+}
+
+type bar int
+
+func (p bar) String() string {
+	return ""
+}
+
+type baz [2]int
+
+func (p baz) String() string {
+	return ""
+}
+
+type qux struct {
+}
+
+func (p qux) String() string {
+	return ""
+}
+
+var _ Stringer = (*foo)(nil)
+var _ Stringer = bar(0)
+var _ Stringer = baz{}
+var _ Stringer = qux{}
+`)
+}
+
+func TestStableTempNames(t *testing.T) {
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		Fset: gblFset, LoadPkgs: gblLoadPkgs, NodeInterpreter: nodeInterp{}, StableTempNames: true})
+	strconv := pkg.Import("strconv")
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "_gop_tmp1")
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	n := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	err := pkg.NewParam(token.NoPos, "", gox.TyError)
+	var tmp *types.Var
+	pkg.NewFunc(nil, "foo", gox.NewTuple(s), gox.NewTuple(n, err), false).BodyStart(pkg).
+		NewTempVar(types.Typ[types.Int], &tmp).Val(ctxRef(pkg, "_gop_tmp1")).Assign(1).
+		Val(strconv.Ref("Atoi")).Val(s).Call(1).ErrWrap(false).Val(tmp).BinaryOp(token.ADD).
+		Val(nil).Return(2).
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewTempVar(types.Typ[types.String], &tmp).Val("2").Assign(1).
+		Val(pkg.Builtin().Ref("println")).
+		Val(ctxRef(pkg, "foo")).Val(tmp).Call(1).ErrAssert().
+		Call(1).EndStmt().
+		End()
+	expected := `package main
+
+import strconv "strconv"
+
+var _gop_tmp1 int
+
+func foo(s string) (int, error) {
+	var _gop_tmp2 int
+	_gop_tmp2 = _gop_tmp1
+	_autoGo_1, _autoGo_2 := strconv.Atoi(s)
+	if _autoGo_2 != nil {
+		return 0, _autoGo_2
+	}
+	return _autoGo_1 + _gop_tmp2, nil
+}
+func main() {
+	var _gop_tmp2 string
+	_gop_tmp2 = "2"
+	_autoGo_1, _autoGo_2 := foo(_gop_tmp2)
+	if _autoGo_2 != nil {
+		panic(_autoGo_2)
+	}
+	println(_autoGo_1)
+}
+`
+	domTest(t, pkg, expected)
+	domTest(t, pkg, expected) // renumbering is idempotent
+}
+
+func TestNewGuardedAccessors(t *testing.T) {
+	pkg := newMainPackage()
+	sync := pkg.Import("sync")
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "mu", sync.Ref("RWMutex").Type(), false),
+		types.NewField(token.NoPos, pkg.Types, "name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "hits", types.Typ[types.Int64], false),
+	}
+	tyC := pkg.NewType("Counter").InitType(pkg, types.NewStruct(fields, nil))
+	if _, _, err := pkg.NewGuardedAccessors(tyC, "name", "mu"); err != nil {
+		t.Fatal("NewGuardedAccessors:", err)
+	}
+	if _, _, err := pkg.NewGuardedAccessors(tyC, "hits", ""); err != nil {
+		t.Fatal("NewGuardedAccessors:", err)
+	}
+	domTest(t, pkg, `package main
+
+import (
+	sync "sync"
+	atomic "sync/atomic"
+)
+
+type Counter struct {
+	mu   sync.RWMutex
+	name string
+	hits int64
+}
+
+func (c *Counter) Name() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.name
+}
+func (c *Counter) SetName(v string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = v
+}
+func (c *Counter) Hits() int64 {
+	return atomic.LoadInt64(&c.hits)
+}
+func (c *Counter) SetHits(v int64) {
+	atomic.StoreInt64(&c.hits, v)
+}
+`)
+	for _, c := range []struct{ field, mutex, err string }{
+		{"name", "mu", "type Counter has field or method Name already"},
+		{"size", "mu", "Counter.size undefined (type Counter has no field size)"},
+		{"mu", "", "Counter.mu (type sync.RWMutex) isn't atomic"},
+		{"mu", "name", "Counter.name (type string) isn't a mutex"},
+	} {
+		if _, _, err := pkg.NewGuardedAccessors(tyC, c.field, c.mutex); err == nil || !strings.HasSuffix(err.Error(), c.err) {
+			t.Fatal("NewGuardedAccessors:", err)
+		}
+	}
+}
+
+func TestNewForwardMethods(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt, tyString := types.Typ[types.Int], types.Typ[types.String]
+	newSig := func(params, results []*types.Var, variadic bool) *types.Signature {
+		return types.NewSignature(nil, gox.NewTuple(params...), gox.NewTuple(results...), variadic)
+	}
+	reader := types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Read", newSig([]*types.Var{
+			pkg.NewParam(token.NoPos, "p", types.NewSlice(types.Typ[types.Byte])),
+		}, []*types.Var{
+			pkg.NewParam(token.NoPos, "n", tyInt), pkg.NewParam(token.NoPos, "err", gox.TyError),
+		}, false)),
+	}, nil).Complete()
+	tyReader := pkg.NewType("Reader").InitType(pkg, reader)
+	logger := types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, pkg.Types, "Close", newSig(nil, []*types.Var{
+			pkg.NewParam(token.NoPos, "", gox.TyError),
+		}, false)),
+		types.NewFunc(token.NoPos, pkg.Types, "Logf", newSig([]*types.Var{
+			pkg.NewParam(token.NoPos, "format", tyString),
+			pkg.NewParam(token.NoPos, "_", types.NewSlice(gox.TyEmptyInterface)),
+		}, nil, true)),
+	}, []types.Type{tyReader}).Complete()
+	tyLogger := pkg.NewType("Logger").InitType(pkg, logger)
+	fields := []*types.Var{types.NewField(token.NoPos, pkg.Types, "inner", tyLogger, false)}
+	tyW := pkg.NewType("W").InitType(pkg, types.NewStruct(fields, nil))
+	pkg.NewSignatureBuilder().Recv("w", types.NewPointer(tyW)).Result("", gox.TyError).
+		NewFunc("Close").BodyStart(pkg).Val(nil).Return(1).End()
+	methods, err := pkg.NewForwardMethods(tyW, "inner", tyLogger)
+	if err != nil || len(methods) != 2 {
+		t.Fatal("NewForwardMethods:", methods, err)
+	}
+	for _, c := range []struct {
+		field string
+		intf  types.Type
+		msg   string
+	}{
+		{"outer", tyLogger, "W.outer undefined (type W has no field outer)"},
+		{"inner", tyInt, "int is not an interface"},
+	} {
+		_, err := pkg.NewForwardMethods(tyW, c.field, c.intf)
+		if e, ok := err.(*gox.CodeError); !ok || e.Msg != c.msg {
+			t.Fatal("NewForwardMethods:", err)
+		}
+	}
+	if err := pkg.AssertImplements(types.NewPointer(tyW), tyLogger, false); err != nil {
+		t.Fatal("AssertImplements:", err)
+	}
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+	domTest(t, pkg, `package main
+
+type Reader interface {
+	Read(p []uint8) (n int, err error)
+}
+type Logger interface {
+	Reader
+	Close() error
+	Logf(format string, _ ...interface {
+	})
+}
+type W struct {
+	inner Logger
+}
+
+func (w *W) Close() error {
+	return nil
+}
+func (w *W) Logf(format string, arg1 ...interface {
+}) {
+	w.inner.Logf(format, arg1...)
+}
+func (w *W) Read(p []uint8) (n int, err error) {
+	return w.inner.Read(p)
+}
+`)
+}
+
+func TestFork(t *testing.T) {
+	foo := types.NewPackage("example.com/foo", "foo")
+	x := types.NewVar(token.NoPos, foo, "X", types.Typ[types.Int])
+	foo.Scope().Insert(x)
+	pkg := newMainPackage()
+	println := pkg.Import("fmt").Ref("Println")
+	fns := []*gox.Func{
+		pkg.NewFunc(nil, "f1", nil, nil, false),
+		pkg.NewFunc(nil, "f2", nil, nil, false),
+		pkg.NewFunc(nil, "f3", nil, nil, false),
+	}
+	forks := make([]*gox.Package, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		forks[i] = pkg.Fork()
+		wg.Add(1)
+		go func(i int, fork *gox.Package, fn *gox.Func) {
+			defer wg.Done()
+			var v *types.Var
+			cb := fn.BodyStart(fork).NewAutoVar(token.NoPos, "v", &v).
+				VarRef(v).Val(i).Assign(1).EndStmt().
+				Val(println).Val(v)
+			if i > 0 {
+				cb.Val(x).BinaryOp(token.ADD)
+			}
+			cb.Call(1).EndStmt().End()
+		}(i, forks[i], fn)
+	}
+	wg.Wait()
+	pkg.Join(forks...)
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	foo "example.com/foo"
+)
+
+func f1() {
+	var v int
+	v = 0
+	fmt.Println(v)
+}
+func f2() {
+	var v int
+	v = 1
+	fmt.Println(v + foo.X)
+}
+func f3() {
+	var v int
+	v = 2
+	fmt.Println(v + foo.X)
+}
+`)
+}
+
+// TestForkHelpers is meant to be run with -race: forks instantiate helper types
+// and load imports concurrently, while they look up package-level objects.
+func TestForkHelpers(t *testing.T) {
+	gox.SetDebug(0) // logging would synchronize the forks
+	defer gox.SetDebug(gox.DbgFlagAll &^ gox.DbgFlagSetDebug)
+	pkg := gox.NewPackage("", "main", &gox.Config{
+		Optional: gox.OptionalStruct, Result: gox.ResultStruct, NodeInterpreter: nodeInterp{}})
+	pkg.CB().NewVarStart(types.Typ[types.Int], "g").Val(1).EndInit(1)
+	tys := []types.Type{types.Typ[types.Int], types.Typ[types.String], types.NewSlice(types.Typ[types.Int])}
+	fns := make([]*gox.Func, 6)
+	forks := make([]*gox.Package, len(fns))
+	for i := range fns {
+		fns[i] = pkg.NewFunc(nil, "f"+strconv.Itoa(i), nil, nil, false)
+		forks[i] = pkg.Fork()
+	}
+	var wg sync.WaitGroup
+	for i := range fns {
+		wg.Add(1)
+		go func(i int, fork *gox.Package, fn *gox.Func) {
+			defer wg.Done()
+			typ := tys[i%len(tys)]
+			cb := fn.BodyStart(fork)
+			if i%2 == 0 {
+				cb.NewVarStart(nil, "s").Val(fork.Import("strconv").Ref("Itoa")).Val(ctxRef(fork, "g")).Call(1).EndInit(1)
+			} else {
+				cb.NewVarStart(nil, "s").Val(fork.Import("strings").Ref("Repeat")).Val("x").Val(ctxRef(fork, "g")).Call(2).EndInit(1)
+			}
+			cb.NewVarStart(nil, "o").OptionalNone(typ).EndInit(1).
+				NewVarStart(nil, "r").Val(nil).ResultErr(typ).EndInit(1).
+				VarRef(nil).Val(ctxRef(fork, "o")).Assign(1).EndStmt().
+				VarRef(nil).Val(ctxRef(fork, "r")).Assign(1).EndStmt().
+				VarRef(nil).Val(ctxRef(fork, "s")).Assign(1).EndStmt().
+				End()
+		}(i, forks[i], fns[i])
+	}
+	wg.Wait()
+	pkg.Join(forks...)
+	for _, typ := range tys {
+		if pkg.OptionalTypes(typ)[0] != forks[0].OptionalTypes(typ)[0] {
+			t.Fatal("TestForkHelpers: helper isn't shared -", typ)
+		}
+	}
+	if err := goxtest.Check(pkg); err != nil {
+		t.Fatal("goxtest.Check:", err)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	ws := gox.NewWorkspace(&gox.Config{LoadPkgs: gblLoadPkgs, NodeInterpreter: nodeInterp{}})
+	app := ws.NewPackage("example.com/app", "main")
+	lib := ws.NewPackage("example.com/lib", "lib")
+	if app.Fset != ws.Fset || lib.Fset != ws.Fset || ws.Package("example.com/lib") != lib {
+		t.Fatal("TestWorkspace: invalid package")
+	}
+	if pkgs := ws.Packages(); len(pkgs) != 2 || pkgs[0] != app || pkgs[1] != lib {
+		t.Fatal("TestWorkspace: invalid packages", pkgs)
+	}
+	libRef := app.Import("example.com/lib")
+	fmt := lib.Import("fmt")
+	hello := lib.NewFunc(nil, "Hello", gox.NewTuple(lib.NewParam(token.NoPos, "name", types.Typ[types.String])), nil, false)
+	hello.BodyStart(lib).Val(fmt.Ref("Println")).Val("Hello,").Val(hello.Type().(*types.Signature).Params().At(0)).Call(2).EndStmt().End()
+	if libRef.Ref("Hello") != hello.Func {
+		t.Fatal("TestWorkspace: object not identical")
+	}
+	app.NewFunc(nil, "main", nil, nil, false).BodyStart(app).
+		Val(libRef.Ref("Hello")).Val("world").Call(1).EndStmt().
+		End()
+	domTest(t, app, `package main
+
+import lib "example.com/lib"
+
+func main() {
+	lib.Hello("world")
+}
+`)
+	domTest(t, lib, `package lib
+
+import fmt "fmt"
+
+func Hello(name string) {
+	fmt.Println("Hello,", name)
+}
+`)
+}
+
+func TestModRoot(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "bar", "baz")
+	if err := os.MkdirAll(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	gomod := "// foo module\nmodule \"example.com/foo\" // comment\n\ngo 1.16\n"
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte(gomod), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if dir, err := gox.FindModRoot(sub); err != nil || dir != root {
+		t.Fatal("FindModRoot:", dir, err)
+	}
+	pkg := gox.NewPackage("./bar", "bar", &gox.Config{ModRoot: root, LoadPkgs: gblLoadPkgs, NodeInterpreter: nodeInterp{}})
+	if path := pkg.Types.Path(); path != "example.com/foo/bar" {
+		t.Fatal("NewPackage: path is", path)
+	}
+	if pkg.Import("./baz") != pkg.Import("example.com/foo/bar/baz") {
+		t.Fatal("Import: relative path isn't resolved")
+	}
+	self := types.NewPackage("example.com/foo/bar", "bar") // the package loaded as a dependency
+	tyT := types.NewNamed(types.NewTypeName(token.NoPos, self, "T", nil), types.Typ[types.Int], nil)
+	pkg.NewVar(token.NoPos, tyT, "x")
+	domTest(t, pkg, `package bar
+
+var x T
+`)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("NewPackage: no error?")
+		}
+	}()
+	gox.NewPackage("", "foo", &gox.Config{ModRoot: sub})
+}
+
+func TestGoEnv(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "vendor"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"go.mod": "module example.com/foo\n", "vendor/modules.txt": ""} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &gox.Config{
+		Dir: root, Env: []string{"GOPATH=/host", "GOFLAGS=-mod=mod"},
+		GoEnv: map[string]string{"GOPATH": "/gopath", "GOFLAGS": "-tags=foo", "GO111MODULE": "on"},
+	}
+	loadConf := gox.NewPackage("", "foo", conf).InternalGetLoadConfig()
+	env := strings.Join(loadConf.Env, " ")
+	if env != "GOPATH=/host GOFLAGS=-mod=mod GO111MODULE=on GOFLAGS=-tags=foo GOPATH=/gopath" {
+		t.Fatal("TestGoEnv: Env is", env)
+	}
+	if flags := loadConf.BuildFlags; len(flags) != 1 || flags[0] != "-mod=vendor" {
+		t.Fatal("TestGoEnv: BuildFlags is", flags)
+	}
+	conf.GoEnv["GOFLAGS"] = "-mod=readonly"
+	if flags := gox.NewPackage("", "foo", conf).InternalGetLoadConfig().BuildFlags; len(flags) != 0 {
+		t.Fatal("TestGoEnv: BuildFlags is", flags)
+	}
+}
+
+func TestTargetPlatform(t *testing.T) {
+	conf := &gox.Config{
+		Fset:            gblFset,
+		LoadPkgs:        gblLoadPkgs,
+		NodeInterpreter: nodeInterp{},
+		TargetPlatform:  &gox.Platform{GOOS: "linux", GOARCH: "386"},
+		GoEnv:           map[string]string{"GOOS": "windows"},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	if env := strings.Join(pkg.InternalGetLoadConfig().Env, " "); !strings.HasSuffix(env, " GOARCH=386 GOOS=windows") {
+		t.Fatal("TestTargetPlatform: Env is", env)
+	}
+	if n := pkg.Sizes().Sizeof(types.Typ[types.Int]); n != 4 {
+		t.Fatal("TestTargetPlatform: sizeof int is", n)
+	}
+	unsafe := pkg.Import("unsafe")
+	cb := pkg.CB()
+	cb.NewConstStart(nil, "n").
+		Val(unsafe.Ref("Sizeof")).Val(0).Call(1)
+	if v := cb.Get(-1).CVal; v == nil || v.String() != "4" {
+		t.Fatal("TestTargetPlatform: unsafe.Sizeof(0) is", v)
+	}
+	cb.EndInit(1)
+	cb.NewConstStart(nil, "a").
+		Val(unsafe.Ref("Alignof")).Typ(types.Typ[types.Int64]).Val(0).Call(1).Call(1).EndInit(1)
+	if v := pkg.Types.Scope().Lookup("a").(*types.Const).Val(); v.String() != "4" {
+		t.Fatal("TestTargetPlatform: unsafe.Alignof(int64(0)) is", v)
+	}
+	domTest(t, pkg, `package main
+
+import unsafe "unsafe"
+
+const n = unsafe.Sizeof(0)
+const a = unsafe.Alignof(int64(0))
+`)
+	defer func() {
+		if e, ok := recover().(*gox.CodeError); !ok || e.Msg != "constant 4294967296 overflows int" {
+			t.Fatal("TestTargetPlatform:", e)
+		}
+	}()
+	pkg.NewVarStart(token.NoPos, types.Typ[types.Int], "x").Val(1 << 32).EndInit(1)
+}
+
+func TestUnsafeBuiltins(t *testing.T) {
+	conf := &gox.Config{
+		Fset:            gblFset,
+		LoadPkgs:        gblLoadPkgs,
+		NodeInterpreter: nodeInterp{},
+		TargetPlatform:  &gox.Platform{GOOS: "linux", GOARCH: "386"},
+	}
+	pkg := gox.NewPackage("", "main", conf)
+	newField := func(name string, typ types.Type, embedded bool) *types.Var {
+		return types.NewField(token.NoPos, pkg.Types, name, typ, embedded)
+	}
+	e := pkg.NewType("E").InitType(pkg, types.NewStruct([]*types.Var{
+		newField("c", types.Typ[types.Int8], false),
+		newField("d", types.Typ[types.Int64], false),
+	}, nil))
+	u := pkg.NewType("U") // its size is unknown until it's initialized
+	x := pkg.NewType("X").InitType(pkg, types.NewStruct([]*types.Var{
+		newField("a", types.Typ[types.Int8], false),
+		newField("b", types.Typ[types.Float64], false),
+		newField("E", e, true),
+		newField("u", u.Type(), false),
+	}, nil))
+	unsafe := pkg.Import("unsafe")
+	pkg.NewVar(token.NoPos, x, "x")
+	offsetof := func(cb *gox.CodeBuilder, name string) {
+		cb.Val(unsafe.Ref("Offsetof")).Val(ctxRef(pkg, "x")).MemberVal(name).Call(1)
+	}
+	cb := pkg.CB()
+	for _, c := range []struct {
+		name string
+		val  func(cb *gox.CodeBuilder)
+		cval string
+	}{
+		{"b", func(cb *gox.CodeBuilder) { offsetof(cb, "b") }, "4"},
+		{"d", func(cb *gox.CodeBuilder) { offsetof(cb, "d") }, "16"},
+		{"s", func(cb *gox.CodeBuilder) { cb.Val(unsafe.Ref("Sizeof")).Val(ctxRef(pkg, "x")).MemberVal("E").Call(1) }, "12"},
+		{"u", func(cb *gox.CodeBuilder) { offsetof(cb, "u") }, ""},
+		{"v", func(cb *gox.CodeBuilder) { cb.Val(unsafe.Ref("Sizeof")).Val(ctxRef(pkg, "x")).Call(1) }, ""},
+	} {
+		cb.NewVarStart(nil, c.name)
+		c.val(cb)
+		if v := cb.Get(-1).CVal; c.cval == "" && v != nil || c.cval != "" && (v == nil || v.String() != c.cval) {
+			t.Fatal("TestUnsafeBuiltins:", c.name, "is", v)
+		}
+		cb.EndInit(1)
+	}
+	u.InitType(pkg, types.Typ[types.Int])
+	domTest(t, pkg, `package main
+
+import unsafe "unsafe"
+
+type E struct {
+	c int8
+	d int64
+}
+type U int
+type X struct {
+	a int8
+	b float64
+	E
+	u U
+}
+
+var x X
+var b = unsafe.Offsetof(x.b)
+var d = unsafe.Offsetof(x.d)
+var s = unsafe.Sizeof(x.E)
+var u = unsafe.Offsetof(x.u)
+var v = unsafe.Sizeof(x)
+`)
+}
+
+func TestReplaceFunc(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	foo := pkg.NewFunc(nil, "foo", nil, nil, false)
+	foo.BodyStart(pkg).Val(fmt.Ref("Println")).Val("Hi").Call(1).EndStmt().End()
+	bar := pkg.NewFunc(nil, "bar", nil, nil, false)
+	bar.BodyStart(pkg).Val(strings.Ref("ToUpper")).Val("Hi").Call(1).EndStmt().End()
+	domTest(t, pkg, `package main
+
+import (
+	fmt "fmt"
+	strings "strings"
+)
+
+func foo() {
+	fmt.Println("Hi")
+}
+func bar() {
+	strings.ToUpper("Hi")
+}
+`)
+	pkg.ReplaceFunc(foo).Val(ctxRef(pkg, "bar")).Call(0).EndStmt().End()
+	domTest(t, pkg, `package main
+
+import strings "strings"
+
+func foo() {
+	bar()
+}
+func bar() {
+	strings.ToUpper("Hi")
+}
+`)
+	if !pkg.DeleteFunc(bar) || pkg.DeleteFunc(bar) {
+		t.Fatal("DeleteFunc failed")
+	}
+	pkg.ReplaceFunc(foo).End()
+	domTest(t, pkg, `package main
+
+func foo() {
+}
+`)
+	pkg.ReplaceFunc(bar).Val(fmt.Ref("Println")).Val("Hello").Call(1).EndStmt().End()
+	domTest(t, pkg, `package main
+
+import fmt "fmt"
+
+func foo() {
+}
+func bar() {
+	fmt.Println("Hello")
+}
+`)
+}
+
+func TestLoadGoPkgsShared(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conf := &gox.Config{Fset: token.NewFileSet(), LoadMode: gox.LoadMode(i % 2)}
+			pkg := gox.NewPackage("", "main", conf)
+			fmt := pkg.Import("fmt")
+			strings := pkg.Import("strings")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(fmt.Ref("Println")).Val(strings.Ref("ToUpper")).Val("Hi").Call(1).Call(1).EndStmt().
+				End()
+			var b bytes.Buffer
+			if err := gox.WriteTo(&b, pkg, false); err != nil {
+				t.Error("WriteTo:", err)
+				return
+			}
+			if b.String() != `package main
+
+import (
+	fmt "fmt"
+	strings "strings"
+)
+
+func main() {
+	fmt.Println(strings.ToUpper("Hi"))
+}
+` {
+				t.Error("WriteTo:", b.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLoader(t *testing.T) {
+	loader := gox.NewLoader(nil)
+	conf := &gox.Config{Fset: token.NewFileSet(), LoadPkgs: loader.Load}
+	var wg sync.WaitGroup
+	refs := make([]*gox.PkgRef, 2)
+	for i, pkgPath := range []string{"context", "net/http"} {
+		wg.Add(1)
+		go func(i int, pkgPath string) { // loaded separately and concurrently
+			defer wg.Done()
+			refs[i] = gox.NewPackage("", "main", conf).Import(pkgPath)
+			refs[i].EnsureImported()
+		}(i, pkgPath)
+	}
+	wg.Wait()
+	ctx := refs[0].Ref("Context")
+	req := refs[1].Ref("Request").Type()
+	m, _, _ := types.LookupFieldOrMethod(types.NewPointer(req), false, nil, "Context")
+	if ret := m.Type().(*types.Signature).Results().At(0).Type(); ret != ctx.Type() {
+		t.Fatal("Loader: not identical", ret, ctx.Type())
+	}
+	if ctx.Pkg() != refs[0].Types {
+		t.Fatal("Loader: Types isn't the package of its objects")
+	}
+	if pos := loader.Fset().Position(ctx.Pos()); !strings.HasSuffix(pos.Filename, ".go") {
+		t.Fatal("Loader: position of Context -", pos)
+	}
+}
+
+func TestLoadPkgsExport(t *testing.T) {
+	const src = `package foo
+
+const N = 100
+
+func Add(a, b int) int {
+	return a + b
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.go", src, 0)
+	if err != nil {
+		t.Fatal("parser.ParseFile:", err)
+	}
+	foo, err := new(types.Config).Check("example.com/foo", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal("types.Check:", err)
+	}
+	var data bytes.Buffer
+	data.WriteString("go object test\n$$B\n")
+	if err = gcexportdata.Write(&data, fset, foo); err != nil {
+		t.Fatal("gcexportdata.Write:", err)
+	}
+	nlookup := 0
+	load := gox.NewLoadPkgsExport(func(pkgPath string) (io.ReadCloser, error) {
+		nlookup++
+		if pkgPath != "example.com/foo" {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(bytes.NewReader(data.Bytes())), nil
+	})
+	for i := 0; i < 2; i++ {
+		pkg := gox.NewPackage("", "main", &gox.Config{LoadPkgs: load})
+		foo := pkg.Import("example.com/foo")
+		pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			NewVar(types.Typ[types.Int], "x").
+			VarRef(ctxRef(pkg, "x")).Val(foo.Ref("Add")).Val(foo.Ref("N")).Val(1).Call(2).Assign(1).EndStmt().
+			End()
+		domTest(t, pkg, `package main
+
+import foo "example.com/foo"
+
+func main() {
+	var x int
+	x = foo.Add(foo.N, 1)
+}
+`)
+	}
+	if nlookup != 1 {
+		t.Fatal("export data should be cached:", nlookup)
+	}
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("TestLoadPkgsExport: no error?")
+		}
+	}()
+	pkg := gox.NewPackage("", "main", &gox.Config{LoadPkgs: load})
+	pkg.Import("example.com/bar").Ref("Bar")
+}
+
 // ----------------------------------------------------------------------------
 
 func TestSaveAndLoadPkgsCache(t *testing.T) {
@@ -487,6 +487,8 @@ type persistPkgRef struct {
 	Funcs   []persistFunc  `json:"funcs,omitempty"`
 	Files   []string       `json:"files,omitempty"`
 	Fingerp string         `json:"fingerp,omitempty"`
+
+	Docs map[string]string `json:"docs,omitempty"`
 }
 
 func toPersistPkg(pkg *PkgRef) *persistPkgRef {
@@ -532,6 +534,7 @@ func toPersistPkg(pkg *PkgRef) *persistPkgRef {
 		Types:   typs,
 		Funcs:   funcs,
 		Consts:  consts,
+		Docs:    pkg.docs,
 	}
 	if pkg.pkgf != nil {
 		ret.Fingerp = pkg.pkgf.getFingerp()
@@ -548,7 +551,7 @@ func fromPersistPkg(ctx *persistPkgCtx, pkg *persistPkgRef) *PkgRef {
 	if pkg.Fingerp != "" {
 		pkgf = &pkgFingerp{files: pkg.Files, fingerp: pkg.Fingerp}
 	}
-	ret := &PkgRef{ID: pkg.ID, Types: ctx.pkg, pkgf: pkgf}
+	ret := &PkgRef{ID: pkg.ID, Types: ctx.pkg, pkgf: pkgf, docs: pkg.Docs}
 	ctx.imports[pkg.PkgPath] = ret
 	for _, typ := range pkg.Types {
 		fromPersistTypeName(ctx, typ)
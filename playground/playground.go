@@ -0,0 +1,190 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package playground is a facade of gox for online playgrounds: it builds
+// packages from untrusted builder scripts (see script.Run) within
+// quotas, and never touches the filesystem or the network after it starts.
+package playground
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"sync"
+	"time"
+
+	"github.com/goplus/gox"
+	"github.com/goplus/gox/script"
+	"golang.org/x/tools/go/packages"
+)
+
+// ----------------------------------------------------------------------------
+
+// Quota limits resources used by a request.
+//
+// Go can't limit the CPU or memory used by a goroutine, so they are limited
+// indirectly: MaxScriptSize and MaxOps bound the work a request can do, and
+// Timeout bounds the time a caller waits for it. A request which is timed out
+// keeps running in the background until its (bounded) ops are done. Use
+// process-level limits (eg. cgroups) as the last line of defense.
+type Quota struct {
+	MaxScriptSize int           // max bytes of a script (0 means no limit)
+	MaxOps        int           // max builder ops of a script (0 means no limit)
+	MaxOutput     int           // max bytes of the generated code (0 means no limit)
+	Timeout       time.Duration // max time of a request (0 means no limit)
+}
+
+// Errors returned when a quota is exceeded.
+var (
+	ErrScriptTooLarge = errors.New("playground: script too large")
+	ErrTooManyOps     = errors.New("playground: too many builder ops")
+	ErrOutputTooLarge = errors.New("playground: output too large")
+	ErrTimeout        = errors.New("playground: timeout")
+)
+
+// Service builds packages from builder scripts. It is safe for concurrent use.
+type Service struct {
+	Quota Quota
+
+	fset    *token.FileSet
+	allowed map[string]bool
+	mutex   sync.Mutex // protects loadPkgs
+	load    gox.LoadPkgsFunc
+	frozen  bool
+}
+
+// NewService creates a service which allows scripts to import the packages
+// named by pkgPaths only. These packages (and their dependencies) are loaded
+// by NewService, and no more packages are loaded after it returns.
+func NewService(quota Quota, pkgPaths ...string) (svc *Service, err error) {
+	svc = &Service{
+		Quota:   quota,
+		fset:    token.NewFileSet(),
+		allowed: make(map[string]bool, len(pkgPaths)),
+	}
+	svc.load = gox.NewLoadPkgsCached(svc.loadGoPkgs)
+	for _, pkgPath := range pkgPaths {
+		svc.allowed[pkgPath] = true
+	}
+	if len(pkgPaths) > 0 {
+		pkg := svc.newPackage(context.Background())
+		if n := svc.loadPkgs(pkg, make(map[string]*gox.PkgRef), pkgPaths...); n > 0 {
+			return nil, fmt.Errorf("playground: failed to load %v", pkgPaths)
+		}
+	}
+	svc.frozen = true
+	return svc, nil
+}
+
+func (p *Service) loadGoPkgs(cfg *packages.Config, patterns ...string) ([]*packages.Package, error) {
+	if p.frozen {
+		return nil, fmt.Errorf("playground: can't load %v", patterns)
+	}
+	return packages.Load(cfg, patterns...)
+}
+
+func (p *Service) loadPkgs(at *gox.Package, importPkgs map[string]*gox.PkgRef, pkgPaths ...string) int {
+	for _, pkgPath := range pkgPaths {
+		if !p.allowed[pkgPath] {
+			panic(fmt.Errorf("playground: import %q is not allowed", pkgPath))
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.load(at, importPkgs, pkgPaths...)
+}
+
+func (p *Service) newPackage(ctx context.Context) *gox.Package {
+	conf := &gox.Config{
+		Fset:     p.fset,
+		LoadPkgs: p.loadPkgs,
+		Progress: func(stmts int) error {
+			return ctx.Err() // stop building if the request is canceled
+		},
+	}
+	return gox.NewPackage("", "main", conf)
+}
+
+// Build builds a main package from the script, and returns the generated Go
+// code. The request is canceled if ctx is done.
+func (p *Service) Build(ctx context.Context, script []byte) (code string, err error) {
+	quota := &p.Quota
+	if quota.MaxScriptSize > 0 && len(script) > quota.MaxScriptSize {
+		return "", ErrScriptTooLarge
+	}
+	if quota.MaxOps > 0 && countOps(script) > quota.MaxOps {
+		return "", ErrTooManyOps
+	}
+	if quota.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, quota.Timeout)
+		defer cancel()
+	}
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := p.build(ctx, script)
+		done <- result{code, err}
+	}()
+	select {
+	case ret := <-done:
+		return ret.code, ret.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", ErrTimeout
+		}
+		return "", ctx.Err()
+	}
+}
+
+func (p *Service) build(ctx context.Context, src []byte) (code string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if e, ok := e.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	pkg := p.newPackage(ctx)
+	if err = script.Run(pkg, "main.gox", src); err != nil {
+		return
+	}
+	var b bytes.Buffer
+	if err = gox.WriteTo(&b, pkg, false); err != nil {
+		return
+	}
+	if max := p.Quota.MaxOutput; max > 0 && b.Len() > max {
+		return "", ErrOutputTooLarge
+	}
+	return b.String(), nil
+}
+
+// countOps returns the number of builder ops of a script, that is, lines
+// except empty lines and comments.
+func countOps(script []byte) (n int) {
+	for _, line := range bytes.Split(script, []byte{'\n'}) {
+		if line = bytes.TrimSpace(line); len(line) > 0 && !bytes.HasPrefix(line, []byte("//")) {
+			n++
+		}
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
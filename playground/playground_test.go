@@ -0,0 +1,104 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package playground_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goplus/gox/playground"
+)
+
+const helloScript = `import fmt
+
+func main
+Val fmt.Println
+Val "Hello"
+Call 1
+EndStmt
+End
+`
+
+func TestService(t *testing.T) {
+	svc, err := playground.NewService(playground.Quota{MaxOps: 10}, "fmt")
+	if err != nil {
+		t.Fatal("NewService:", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, err := svc.Build(context.Background(), []byte(helloScript))
+			if err != nil {
+				t.Error("Build:", err)
+				return
+			}
+			if code != `package main
+
+import fmt "fmt"
+
+func main() {
+	fmt.Println("Hello")
+}
+` {
+				t.Error("Build:", code)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServiceQuota(t *testing.T) {
+	svc, err := playground.NewService(playground.Quota{}, "fmt")
+	if err != nil {
+		t.Fatal("NewService:", err)
+	}
+	ctx := context.Background()
+	script := []byte(helloScript)
+	svc.Quota = playground.Quota{MaxOps: 6}
+	if _, err = svc.Build(ctx, script); err != playground.ErrTooManyOps {
+		t.Fatal("Build MaxOps:", err)
+	}
+	svc.Quota = playground.Quota{MaxScriptSize: 10}
+	if _, err = svc.Build(ctx, script); err != playground.ErrScriptTooLarge {
+		t.Fatal("Build MaxScriptSize:", err)
+	}
+	svc.Quota = playground.Quota{MaxOutput: 10}
+	if _, err = svc.Build(ctx, script); err != playground.ErrOutputTooLarge {
+		t.Fatal("Build MaxOutput:", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	svc.Quota = playground.Quota{}
+	if _, err = svc.Build(canceled, []byte("func main\n")); err != context.Canceled {
+		if err != nil { // the build may complete before ctx.Done is selected
+			t.Fatal("Build canceled:", err)
+		}
+	}
+}
+
+func TestServiceImport(t *testing.T) {
+	svc, err := playground.NewService(playground.Quota{}, "fmt")
+	if err != nil {
+		t.Fatal("NewService:", err)
+	}
+	script := strings.Replace(helloScript, "fmt", "os", 1)
+	_, err = svc.Build(context.Background(), []byte(script))
+	if err == nil || !strings.Contains(err.Error(), `import "os" is not allowed`) {
+		t.Fatal("Build:", err)
+	}
+}
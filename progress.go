@@ -0,0 +1,116 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+
+// DefaultProgressInterval is the default of Config.ProgressInterval.
+const DefaultProgressInterval = 100
+
+var (
+	// ErrTimeBudget is the cause of an InterruptedError if generation takes
+	// longer than Config.TimeBudget.
+	ErrTimeBudget = errors.New("time budget exceeded")
+)
+
+// InterruptedError is returned by CodeBuilder.Interrupted if generation is
+// interrupted by Config.Progress or Config.TimeBudget. The CodeBuilder doesn't
+// stop by itself: its caller should check Interrupted (eg. after each
+// statement or declaration), and then either stop building, so that the
+// package is written as a partial result (declarations which are complete
+// before the interruption, eg. funcs whose bodies are ended), or continue
+// building after Resume.
+type InterruptedError struct {
+	Err   error // error returned by Config.Progress, or ErrTimeBudget
+	Stmts int   // number of statements emitted before the interruption
+
+	pr *progress
+}
+
+func (p *InterruptedError) Error() string {
+	return fmt.Sprintf("generation interrupted after %d statements: %v", p.Stmts, p.Err)
+}
+
+func (p *InterruptedError) Unwrap() error {
+	return p.Err
+}
+
+// Resume resumes the interrupted generation: Config.Progress is called again,
+// and the time budget (if any) restarts. It does nothing if the generation has
+// been resumed already.
+func (p *InterruptedError) Resume() {
+	if pr := p.pr; pr.interrupted == p {
+		pr.interrupted = nil
+		if pr.budget > 0 {
+			pr.deadline = time.Now().Add(pr.budget)
+		}
+	}
+}
+
+type progress struct {
+	report      func(stmts int) error
+	interval    int
+	budget      time.Duration
+	deadline    time.Time // zero if there is no time budget
+	stmts       int
+	interrupted *InterruptedError
+}
+
+func newProgress(conf *Config) *progress {
+	if conf.Progress == nil && conf.TimeBudget <= 0 {
+		return nil
+	}
+	p := &progress{report: conf.Progress, interval: conf.ProgressInterval, budget: conf.TimeBudget}
+	if p.interval <= 0 {
+		p.interval = DefaultProgressInterval
+	}
+	if p.budget > 0 {
+		p.deadline = time.Now().Add(p.budget)
+	}
+	return p
+}
+
+// emitted is called when a statement is emitted.
+func (p *progress) emitted() {
+	p.stmts++
+	if p.stmts%p.interval != 0 || p.interrupted != nil {
+		return
+	}
+	var err error
+	if !p.deadline.IsZero() && time.Now().After(p.deadline) {
+		err = ErrTimeBudget
+	} else if p.report != nil {
+		err = p.report(p.stmts)
+	}
+	if err != nil {
+		p.interrupted = &InterruptedError{Err: err, Stmts: p.stmts, pr: p}
+	}
+}
+
+// Interrupted returns an *InterruptedError if generation is interrupted (and
+// isn't resumed), or nil otherwise.
+func (p *CodeBuilder) Interrupted() error {
+	if pr := p.progress; pr != nil && pr.interrupted != nil {
+		return pr.interrupted
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
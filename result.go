@@ -0,0 +1,264 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"log"
+)
+
+// ----------------------------------------------------------------------------
+
+// A ResultLowering is a strategy to lower results (a value of type T, or an
+// error) to Go. See Config.Result.
+//
+// As an OptionalLowering, a lowering must evaluate each operand exactly once
+// and in source order.
+type ResultLowering interface {
+	// Types returns the Go types which represent a result of typ.
+	Types(pkg *Package, typ types.Type) []types.Type
+
+	// Ok converts the value (of typ) at the stack top to a result.
+	Ok(cb *CodeBuilder, typ types.Type)
+
+	// Err converts the error at the stack top to a result of typ.
+	Err(cb *CodeBuilder, typ types.Type)
+
+	// IsOk converts the result (of typ) at the stack top to a bool value which
+	// reports whether it isn't an error.
+	IsOk(cb *CodeBuilder, typ types.Type)
+
+	// Value converts the result (of typ) at the stack top to its value.
+	Value(cb *CodeBuilder, typ types.Type)
+
+	// Error converts the result (of typ) at the stack top to its error.
+	Error(cb *CodeBuilder, typ types.Type)
+
+	// FromGo converts the (T, error) values at the stack top (a call of a Go
+	// function) to a result of typ.
+	FromGo(cb *CodeBuilder, typ types.Type)
+
+	// ToGo converts the result (of typ) at the stack top to (T, error) values,
+	// eg. to return it from a Go function.
+	ToGo(cb *CodeBuilder, typ types.Type)
+}
+
+var (
+	// ResultPair lowers a result of T to a pair of values (T, error), which is
+	// the convention of Go. A result takes two elements of the stack (or one
+	// element, if it is a call of a Go function), so it can only be used as
+	// arguments, results or rhs of assignments.
+	ResultPair ResultLowering = resultPair{}
+
+	// ResultStruct lowers a result of T to a generated struct type
+	// `ResultT struct { Value T; Err error }`, which is declared once for each
+	// T in the package. Shims to convert from and to (T, error) are generated
+	// with it: `func NewResultT(v T, err error) ResultT` and
+	// `func (r ResultT) Unwrap() (T, error)`.
+	ResultStruct ResultLowering = resultStruct{}
+)
+
+func (p *Package) result() ResultLowering {
+	if lowering := p.conf.Result; lowering != nil {
+		return lowering
+	}
+	return ResultPair
+}
+
+// ResultTypes returns the Go types which represent a result of typ.
+func (p *Package) ResultTypes(typ types.Type) []types.Type {
+	return p.result().Types(p, typ)
+}
+
+// ResultOk converts the value (of typ) at the stack top to a result.
+func (p *CodeBuilder) ResultOk(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultOk", typ)
+	}
+	p.pkg.result().Ok(p, typ)
+	return p
+}
+
+// ResultErr converts the error at the stack top to a result of typ.
+func (p *CodeBuilder) ResultErr(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultErr", typ)
+	}
+	p.pkg.result().Err(p, typ)
+	return p
+}
+
+// ResultIsOk converts the result (of typ) at the stack top to a bool value
+// which reports whether it isn't an error.
+func (p *CodeBuilder) ResultIsOk(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultIsOk", typ)
+	}
+	p.pkg.result().IsOk(p, typ)
+	return p
+}
+
+// ResultValue converts the result (of typ) at the stack top to its value.
+func (p *CodeBuilder) ResultValue(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultValue", typ)
+	}
+	p.pkg.result().Value(p, typ)
+	return p
+}
+
+// ResultError converts the result (of typ) at the stack top to its error.
+func (p *CodeBuilder) ResultError(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultError", typ)
+	}
+	p.pkg.result().Error(p, typ)
+	return p
+}
+
+// ResultFromGo converts the (T, error) values at the stack top (a call of a Go
+// function) to a result of typ.
+func (p *CodeBuilder) ResultFromGo(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultFromGo", typ)
+	}
+	p.pkg.result().FromGo(p, typ)
+	return p
+}
+
+// ResultToGo converts the result (of typ) at the stack top to (T, error)
+// values.
+func (p *CodeBuilder) ResultToGo(typ types.Type) *CodeBuilder {
+	if debugInstr {
+		log.Println("ResultToGo", typ)
+	}
+	p.pkg.result().ToGo(p, typ)
+	return p
+}
+
+// ----------------------------------------------------------------------------
+
+type resultPair struct{}
+
+func (resultPair) Types(pkg *Package, typ types.Type) []types.Type {
+	return []types.Type{typ, TyError}
+}
+
+func (resultPair) Ok(cb *CodeBuilder, typ types.Type) {
+	cb.Val(nil)
+}
+
+func (resultPair) Err(cb *CodeBuilder, typ types.Type) {
+	err := cb.stk.Pop()
+	cb.ZeroLit(typ)
+	cb.stk.Push(err)
+}
+
+func (p resultPair) IsOk(cb *CodeBuilder, typ types.Type) {
+	cb.pickValue(p.Types(cb.pkg, typ), 1)
+	cb.Val(nil).BinaryOp(token.EQL)
+}
+
+func (p resultPair) Value(cb *CodeBuilder, typ types.Type) {
+	cb.pickValue(p.Types(cb.pkg, typ), 0)
+}
+
+func (p resultPair) Error(cb *CodeBuilder, typ types.Type) {
+	cb.pickValue(p.Types(cb.pkg, typ), 1)
+}
+
+func (resultPair) FromGo(cb *CodeBuilder, typ types.Type) {
+}
+
+func (resultPair) ToGo(cb *CodeBuilder, typ types.Type) {
+}
+
+// ----------------------------------------------------------------------------
+
+type resultStruct struct{}
+
+func (resultStruct) Types(pkg *Package, typ types.Type) []types.Type {
+	return []types.Type{pkg.resultType(typ).typ}
+}
+
+func (resultStruct) Ok(cb *CodeBuilder, typ types.Type) {
+	x := cb.stk.Pop()
+	cb.Val(0)
+	cb.stk.Push(x)
+	cb.StructLit(cb.pkg.resultType(typ).typ, 2, true)
+}
+
+func (resultStruct) Err(cb *CodeBuilder, typ types.Type) {
+	err := cb.stk.Pop()
+	cb.Val(1)
+	cb.stk.Push(err)
+	cb.StructLit(cb.pkg.resultType(typ).typ, 2, true)
+}
+
+func (resultStruct) IsOk(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Err").Val(nil).BinaryOp(token.EQL)
+}
+
+func (resultStruct) Value(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Value")
+}
+
+func (resultStruct) Error(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Err")
+}
+
+func (resultStruct) FromGo(cb *CodeBuilder, typ types.Type) {
+	ret := cb.stk.Pop()
+	cb.Val(cb.pkg.resultType(typ).ctor)
+	cb.stk.Push(ret)
+	cb.Call(1)
+}
+
+func (resultStruct) ToGo(cb *CodeBuilder, typ types.Type) {
+	cb.MemberVal("Unwrap").Call(0)
+}
+
+type resultType struct {
+	typ  *types.Named
+	ctor *types.Func
+}
+
+// resultType returns the Result struct type of typ, which is declared (with
+// its shims) the first time it is required.
+func (p *Package) resultType(typ types.Type) *resultType {
+	return p.instType(p.results, typ, func() interface{} {
+		name := p.instTypeName("Result", typ)
+		fields := []*types.Var{
+			types.NewField(token.NoPos, p.Types, "Value", typ, false),
+			types.NewField(token.NoPos, p.Types, "Err", TyError, false),
+		}
+		t := p.NewType(name).InitType(p, types.NewStruct(fields, nil))
+		var ctor *Func
+		p.cb.atPackageLevel(func() { // typ may be required in a func body
+			v := p.NewParam(token.NoPos, "v", typ)
+			err := p.NewParam(token.NoPos, "err", TyError)
+			ret := p.NewParam(token.NoPos, "", t)
+			ctor = p.NewFunc(nil, "New"+name, types.NewTuple(v, err), types.NewTuple(ret), false)
+			ctor.BodyStart(p).Val(v).Val(err).StructLit(t, 2, false).Return(1).End()
+			recv := p.NewParam(token.NoPos, "r", t)
+			rets := types.NewTuple(p.NewParam(token.NoPos, "", typ), p.NewParam(token.NoPos, "", TyError))
+			unwrap := p.NewFunc(recv, "Unwrap", nil, rets, false)
+			unwrap.BodyStart(p).Val(recv).MemberVal("Value").Val(recv).MemberVal("Err").Return(2).End()
+		})
+		return &resultType{typ: t, ctor: ctor.Func}
+	}).(*resultType)
+}
+
+// ----------------------------------------------------------------------------
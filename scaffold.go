@@ -0,0 +1,178 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+)
+
+// ----------------------------------------------------------------------------
+
+// NewMainRun generates the program entry of a main package which wraps the
+// function run (it must be a `func() error`):
+//
+//	func main() {
+//		if err := run(); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(exitCode)
+//		}
+//	}
+func (p *Package) NewMainRun(run types.Object, exitCode int) (*Func, error) {
+	sig, ok := run.Type().(*types.Signature)
+	if !ok || sig.Params().Len() != 0 || sig.Results().Len() != 1 ||
+		!types.Identical(sig.Results().At(0).Type(), TyError) {
+		return nil, p.cb.newCodePosErrorf(
+			run.Pos(), "cannot use %s (type %v) as type func() error in main", run.Name(), run.Type())
+	}
+	fn, err := p.NewFuncWith(token.NoPos, "main", types.NewSignature(nil, nil, nil, false), nil)
+	if err != nil {
+		return nil, err
+	}
+	cb := fn.BodyStart(p).
+		If().DefineVarStart(token.NoPos, "err").Val(run).Call(0).EndInit(1)
+	p.exitOnError(cb.Scope().Lookup("err"), exitCode).
+		End()
+	return fn, nil
+}
+
+// NewMainServe generates the program entry of a main package which runs the
+// function serve (it must be a `func(ctx context.Context) error`) until it
+// returns or an interrupt signal is received:
+//
+//	func main() {
+//		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+//		defer stop()
+//		errc := make(chan error, 1)
+//		go func() {
+//			errc <- serve(ctx)
+//		}()
+//		var err error
+//		select {
+//		case <-ctx.Done():
+//			stop()
+//			err = <-errc
+//		case err = <-errc:
+//		}
+//		if err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(exitCode)
+//		}
+//	}
+//
+// After the signal is received, serve is expected to return as soon as ctx is
+// done, so that the program can be shut down gracefully.
+//
+// Note that packages context and os/signal should be imported together (that
+// is, before any object of them is referenced) so that both of them refer to
+// the same context.Context type.
+func (p *Package) NewMainServe(serve types.Object, exitCode int) (*Func, error) {
+	context, signal := p.Import("context"), p.Import("os/signal")
+	os, syscall := p.Import("os"), p.Import("syscall")
+	tyContext := context.Ref("Context").Type()
+	sig, ok := serve.Type().(*types.Signature)
+	if !ok || sig.Params().Len() != 1 || !types.Identical(sig.Params().At(0).Type(), tyContext) ||
+		sig.Results().Len() != 1 || !types.Identical(sig.Results().At(0).Type(), TyError) {
+		return nil, p.cb.newCodePosErrorf(
+			serve.Pos(), "cannot use %s (type %v) as type func(context.Context) error in main",
+			serve.Name(), serve.Type())
+	}
+	fn, err := p.NewFuncWith(token.NoPos, "main", types.NewSignature(nil, nil, nil, false), nil)
+	if err != nil {
+		return nil, err
+	}
+	cb := fn.BodyStart(p).
+		DefineVarStart(token.NoPos, "ctx", "stop").
+		Val(signal.Ref("NotifyContext")).
+		Val(context.Ref("Background")).Call(0).Val(os.Ref("Interrupt")).Val(syscall.Ref("SIGTERM")).
+		Call(3).EndInit(1)
+	scope := cb.Scope()
+	ctx, stop := scope.Lookup("ctx"), scope.Lookup("stop")
+	cb.Val(stop).Call(0).Defer().
+		DefineVarStart(token.NoPos, "errc").
+		Val(p.builtin.Scope().Lookup("make")).Typ(types.NewChan(types.SendRecv, TyError)).Val(1).Call(2).
+		EndInit(1)
+	errc := scope.Lookup("errc")
+	cb.NewClosure(nil, nil, false).BodyStart(p).
+		Val(errc).Val(serve).Val(ctx).Call(1).Send().
+		End().Call(0).Go().
+		NewVar(TyError, "err")
+	errVar := scope.Lookup("err")
+	cb.Select().
+		Val(ctx).MemberVal("Done").Call(0).UnaryOp(token.ARROW).EndStmt().CommCase(1).
+		Val(stop).Call(0).EndStmt().
+		VarRef(errVar).Val(errc).UnaryOp(token.ARROW).Assign(1).
+		End().
+		VarRef(errVar).Val(errc).UnaryOp(token.ARROW).Assign(1).CommCase(1).
+		End().
+		End().
+		If()
+	p.exitOnError(errVar, exitCode).
+		End()
+	return fn, nil
+}
+
+// exitOnError generates the rest of an if statement started by cb.If():
+//
+//	if [init;] err != nil {
+//		fmt.Fprintln(os.Stderr, err)
+//		os.Exit(exitCode)
+//	}
+func (p *Package) exitOnError(errVar types.Object, exitCode int) *CodeBuilder {
+	fmt, os := p.Import("fmt"), p.Import("os")
+	return p.cb.Val(errVar).CompareNil(token.NEQ).Then().
+		Val(fmt.Ref("Fprintln")).Val(os.Ref("Stderr")).Val(errVar).Call(2).EndStmt().
+		Val(os.Ref("Exit")).Val(exitCode).Call(1).EndStmt().
+		End()
+}
+
+// ----------------------------------------------------------------------------
+
+// AssertImplements checks if the type T implements the interface type I. If
+// emit is true, it also emits a compile-time assertion, which is one of:
+//
+//	var _ I = (*X)(nil) // T is a pointer type *X
+//	var _ I = X{}       // T is a struct or array type X
+//	var _ I = X(v)      // otherwise, v is the zero value of the underlying type
+func (p *Package) AssertImplements(T, I types.Type, emit bool) error {
+	intf, ok := I.Underlying().(*types.Interface)
+	if !ok {
+		return p.cb.newCodeError(nil, fmt.Sprintf("%v is not an interface", I))
+	}
+	if m, wrongType := types.MissingMethod(T, intf, true); m != nil {
+		reason := "missing method " + m.Name()
+		if _, isPtr := T.(*types.Pointer); !isPtr && types.Implements(types.NewPointer(T), intf) {
+			reason = "method " + m.Name() + " has pointer receiver"
+		} else if wrongType {
+			reason = "wrong type for method " + m.Name()
+		}
+		return p.cb.newCodeError(nil, fmt.Sprintf("%v does not implement %v (%s)", T, I, reason))
+	}
+	if emit {
+		cb := p.NewVarStart(token.NoPos, I, "_")
+		switch getUnderlying(p, T).(type) {
+		case *types.Struct:
+			cb.StructLit(T, 0, false)
+		case *types.Array:
+			cb.ArrayLit(T, 0)
+		default:
+			cb.Typ(T).ZeroLit(T).Call(1)
+		}
+		cb.EndInit(1)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,414 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package script runs builder scripts, each line of which is a call of a
+// CodeBuilder method, against gox packages (see Run). It is used to reproduce
+// bugs of gox without a full Go+ program, and by online playgrounds.
+package script
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+
+	"github.com/goplus/gox"
+)
+
+// ----------------------------------------------------------------------------
+
+// Error represents an error of a builder script.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (p *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", p.File, p.Line, p.Err)
+}
+
+func (p *Error) Unwrap() error {
+	return p.Err
+}
+
+// Build creates a package and runs a builder script against it (see Run). If
+// the first line of the script is `package <name>`, it is the name of the
+// package, otherwise the package is named main.
+func Build(file string, script []byte) (*gox.Package, error) {
+	name := "main"
+	if line, _ := nextLine(script); bytes.HasPrefix(line, []byte("package ")) {
+		name = string(bytes.TrimSpace(line[8:]))
+	}
+	pkg := gox.NewPackage("", name, nil)
+	if err := Run(pkg, file, script); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Run runs a builder script against pkg, so that a bug of gox can be
+// reproduced without a full Go+ program. A script consists of lines, each of
+// which is a directive or a call of a CodeBuilder method:
+//
+//	import fmt
+//	func main
+//	Val fmt.Println
+//	Val "Hello, world"
+//	Call 1
+//	EndStmt
+//	End
+//
+// Directives are:
+//
+//	package <name>  checks the name of pkg (it should be the first line)
+//	import <path>   imports a package, which is referenced by its name
+//	func <name>     starts the body of `func <name>()`
+//
+// Arguments of a method are separated by spaces. They are converted according
+// to the types of the method parameters:
+//
+//	int, bool, string   Go literals (strings can also be names, eg. `ForRange k v`)
+//	token.Token         operators, eg. `BinaryOp +`
+//	types.Type          type expressions, eg. `NewVar []int a`
+//	interface{}         Go literals, nil or objects, eg. `Val fmt.Println`
+//
+// Parameters of type token.Pos and *gox.Package are implicit, and optional
+// parameters of type ast.Node are always omitted. Empty lines and `//`
+// comments are ignored.
+//
+// Run stops if generation is interrupted (see gox.InterruptedError).
+func Run(pkg *gox.Package, file string, script []byte) error {
+	ctx := &scriptCtx{pkg: pkg, imports: make(map[string]*gox.PkgRef)}
+	for lineno := 1; len(script) > 0; lineno++ {
+		var line []byte
+		line, script = nextLine(script)
+		err := ctx.exec(line)
+		if err == nil {
+			err = pkg.CB().Interrupted()
+		}
+		if err != nil {
+			return &Error{File: file, Line: lineno, Err: err}
+		}
+	}
+	return nil
+}
+
+func nextLine(script []byte) (line, next []byte) {
+	if pos := bytes.IndexByte(script, '\n'); pos >= 0 {
+		return script[:pos], script[pos+1:]
+	}
+	return script, nil
+}
+
+// ----------------------------------------------------------------------------
+
+type scriptToken struct {
+	tok token.Token
+	lit string
+}
+
+type scriptCtx struct {
+	pkg     *gox.Package
+	imports map[string]*gox.PkgRef
+	toks    []scriptToken
+}
+
+var (
+	tyPos     = reflect.TypeOf(token.NoPos)
+	tyToken   = reflect.TypeOf(token.ILLEGAL)
+	tyPackage = reflect.TypeOf((*gox.Package)(nil))
+	tyNode    = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	tyType    = reflect.TypeOf((*types.Type)(nil)).Elem()
+	tyAny     = reflect.TypeOf((*interface{})(nil)).Elem()
+	tyError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func (p *scriptCtx) exec(line []byte) (err error) {
+	if err = p.scan(line); err != nil || len(p.toks) == 0 {
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if e, ok := e.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	op := p.next()
+	switch op.lit {
+	case "package":
+		if name := p.next(); name.lit != p.pkg.Types.Name() {
+			return fmt.Errorf("package %s mismatches package %s", name.lit, p.pkg.Types.Name())
+		}
+	case "import":
+		path := p.next()
+		if path.tok == token.STRING {
+			path.lit, _ = strconv.Unquote(path.lit)
+		}
+		ref := p.pkg.Import(path.lit)
+		ref.EnsureImported()
+		p.imports[ref.Types.Name()] = ref
+	case "func":
+		name := p.next()
+		if name.tok != token.IDENT {
+			return fmt.Errorf("func name expected, found %v", name.lit)
+		}
+		p.pkg.NewFunc(nil, name.lit, nil, nil, false).BodyStart(p.pkg)
+	default:
+		if op.tok != token.IDENT {
+			return fmt.Errorf("method name expected, found %v", op.lit)
+		}
+		return p.call(op.lit)
+	}
+	return p.checkEOL()
+}
+
+func (p *scriptCtx) call(name string) error {
+	method := reflect.ValueOf(p.pkg.CB()).MethodByName(name)
+	if !method.IsValid() {
+		return fmt.Errorf("unknown method %s", name)
+	}
+	mt := method.Type()
+	n := mt.NumIn()
+	args := make([]reflect.Value, 0, n)
+	variadic := mt.IsVariadic()
+	if variadic {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		args = append(args, p.arg(mt.In(i)))
+	}
+	if variadic { // pass a nil slice if there is no variadic argument
+		t := mt.In(n)
+		vargs := reflect.Zero(t)
+		for t.Elem() != tyNode && len(p.toks) > 0 {
+			vargs = reflect.Append(vargs, p.arg(t.Elem()))
+		}
+		args = append(args, vargs)
+	}
+	if err := p.checkEOL(); err != nil {
+		return err
+	}
+	var rets []reflect.Value
+	if variadic {
+		rets = method.CallSlice(args)
+	} else {
+		rets = method.Call(args)
+	}
+	if n := len(rets); n > 0 && mt.Out(n-1) == tyError && !rets[n-1].IsNil() {
+		return rets[n-1].Interface().(error)
+	}
+	return nil
+}
+
+func (p *scriptCtx) arg(t reflect.Type) reflect.Value {
+	switch t {
+	case tyPos:
+		return reflect.ValueOf(token.NoPos)
+	case tyPackage:
+		return reflect.ValueOf(p.pkg)
+	case tyToken:
+		tok := p.next()
+		if !tok.tok.IsOperator() {
+			panic(fmt.Errorf("operator expected, found %v", tok.lit))
+		}
+		return reflect.ValueOf(tok.tok)
+	case tyType:
+		typ := p.typ()
+		if typ == nil {
+			return reflect.Zero(t)
+		}
+		return reflect.ValueOf(typ)
+	case tyAny:
+		v := p.val()
+		if v == nil {
+			return reflect.Zero(t)
+		}
+		return reflect.ValueOf(v)
+	}
+	switch t.Kind() {
+	case reflect.Int:
+		return reflect.ValueOf(p.intLit())
+	case reflect.Bool:
+		tok := p.next()
+		if tok.lit != "true" && tok.lit != "false" {
+			panic(fmt.Errorf("bool expected, found %v", tok.lit))
+		}
+		return reflect.ValueOf(tok.lit == "true")
+	case reflect.String:
+		tok := p.next()
+		switch tok.tok {
+		case token.STRING:
+			s, _ := strconv.Unquote(tok.lit)
+			return reflect.ValueOf(s)
+		case token.IDENT:
+			return reflect.ValueOf(tok.lit)
+		}
+		panic(fmt.Errorf("string expected, found %v", tok.lit))
+	}
+	panic(fmt.Errorf("unsupported parameter type %v", t))
+}
+
+func (p *scriptCtx) intLit() int {
+	tok, neg := p.next(), false
+	if tok.tok == token.SUB {
+		tok, neg = p.next(), true
+	}
+	if tok.tok != token.INT {
+		panic(fmt.Errorf("integer expected, found %v", tok.lit))
+	}
+	v, ok := constant.Int64Val(constant.MakeFromLiteral(tok.lit, token.INT, 0))
+	if !ok {
+		panic(fmt.Errorf("integer %v overflows", tok.lit))
+	}
+	if neg {
+		v = -v
+	}
+	return int(v)
+}
+
+func (p *scriptCtx) val() interface{} {
+	switch tok := p.peek(); tok.tok {
+	case token.INT, token.FLOAT, token.IMAG, token.CHAR, token.STRING:
+		p.next()
+		return &ast.BasicLit{Kind: tok.tok, Value: tok.lit}
+	case token.IDENT:
+		if tok.lit == "nil" {
+			p.next()
+			return nil
+		}
+		return p.object()
+	}
+	panic(fmt.Errorf("value expected, found %v", p.peek().lit))
+}
+
+// object parses `name` or `pkg.name`.
+func (p *scriptCtx) object() types.Object {
+	name := p.next()
+	if name.tok != token.IDENT {
+		panic(fmt.Errorf("name expected, found %v", name.lit))
+	}
+	if ref, ok := p.imports[name.lit]; ok && p.peek().tok == token.PERIOD {
+		p.next()
+		sel := p.next()
+		if o := ref.Ref(sel.lit); o != nil {
+			return o
+		}
+		panic(fmt.Errorf("undefined: %s.%s", name.lit, sel.lit))
+	}
+	if _, o := p.pkg.CB().Scope().LookupParent(name.lit, token.NoPos); o != nil {
+		return o
+	}
+	panic(fmt.Errorf("undefined: %s", name.lit))
+}
+
+// typ parses a type expression. It returns nil for `nil`.
+func (p *scriptCtx) typ() types.Type {
+	switch tok := p.peek(); tok.tok {
+	case token.MUL:
+		p.next()
+		return types.NewPointer(p.typ())
+	case token.LBRACK:
+		p.next()
+		if p.peek().tok == token.RBRACK {
+			p.next()
+			return types.NewSlice(p.typ())
+		}
+		n := p.intLit()
+		p.expect(token.RBRACK)
+		return types.NewArray(p.typ(), int64(n))
+	case token.MAP:
+		p.next()
+		p.expect(token.LBRACK)
+		key := p.typ()
+		p.expect(token.RBRACK)
+		return types.NewMap(key, p.typ())
+	case token.CHAN:
+		p.next()
+		return types.NewChan(types.SendRecv, p.typ())
+	case token.IDENT:
+		if tok.lit == "nil" {
+			p.next()
+			return nil
+		}
+		if o, ok := p.object().(*types.TypeName); ok {
+			return o.Type()
+		}
+		panic(fmt.Errorf("%s is not a type", tok.lit))
+	}
+	panic(fmt.Errorf("type expected, found %v", p.peek().lit))
+}
+
+func (p *scriptCtx) expect(tok token.Token) {
+	if t := p.next(); t.tok != tok {
+		panic(fmt.Errorf("%v expected, found %v", tok, t.lit))
+	}
+}
+
+func (p *scriptCtx) peek() scriptToken {
+	if len(p.toks) == 0 {
+		return scriptToken{token.EOF, "EOL"}
+	}
+	return p.toks[0]
+}
+
+func (p *scriptCtx) next() scriptToken {
+	tok := p.peek()
+	if len(p.toks) > 0 {
+		p.toks = p.toks[1:]
+	}
+	return tok
+}
+
+func (p *scriptCtx) checkEOL() error {
+	if len(p.toks) > 0 {
+		return fmt.Errorf("unexpected %v", p.toks[0].lit)
+	}
+	return nil
+}
+
+func (p *scriptCtx) scan(line []byte) error {
+	var s scanner.Scanner
+	var errs scanner.ErrorList
+	fset := token.NewFileSet()
+	f := fset.AddFile("", -1, len(line))
+	s.Init(f, line, func(pos token.Position, msg string) {
+		errs.Add(pos, msg)
+	}, 0)
+	p.toks = p.toks[:0]
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF || (tok == token.SEMICOLON && lit == "\n") {
+			break
+		}
+		if lit == "" {
+			lit = tok.String()
+		}
+		p.toks = append(p.toks, scriptToken{tok, lit})
+	}
+	if len(errs) > 0 {
+		return errors.New(errs[0].Msg)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
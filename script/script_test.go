@@ -0,0 +1,94 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package script_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goplus/gox"
+	"github.com/goplus/gox/script"
+)
+
+func TestScript(t *testing.T) {
+	pkg, err := script.Build("foo.gox", []byte(`package main
+
+import fmt
+
+// a := []int{1, 2}
+func main
+DefineVarStart a
+Val 1
+Val 2
+SliceLit []int 2
+EndInit 1
+ForRange _ x
+Val a
+RangeAssignThen
+If
+Val x
+Val 1
+BinaryOp >
+Then
+Val fmt.Println
+Val x
+Val "a"
+Call 2
+EndStmt
+End
+End
+End
+`))
+	if err != nil {
+		t.Fatal("Build:", err)
+	}
+	var b bytes.Buffer
+	if err = gox.WriteTo(&b, pkg, false); err != nil {
+		t.Fatal("gox.WriteTo:", err)
+	}
+	if b.String() != `package main
+
+import fmt "fmt"
+
+func main() {
+	a := []int{1, 2}
+	for _, x := range a {
+		if x > 1 {
+			fmt.Println(x, "a")
+		}
+	}
+}
+` {
+		t.Fatal("Build:", b.String())
+	}
+}
+
+func TestScriptError(t *testing.T) {
+	for _, c := range []struct {
+		script, msg string
+	}{
+		{"func main\nVal foo\n", "bar.gox:2: undefined: foo"},
+		{"func main\nFoo 1\n", "bar.gox:2: unknown method Foo"},
+		{"Call x\n", "bar.gox:1: integer expected, found x"},
+		{"NewVar int a 1\n", "bar.gox:1: string expected, found 1"},
+		{"func main\nEndStmt 1\n", "bar.gox:2: unexpected 1"},
+		{"func main\nVal 1\nVal \"x\"\nBinaryOp +\n", "bar.gox:4: "},
+	} {
+		_, err := script.Build("bar.gox", []byte(c.script))
+		if e, ok := err.(*script.Error); !ok || !strings.HasPrefix(e.Error(), c.msg) {
+			t.Fatal("Build:", c.script, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// RecvOrDone func: receives a value from a channel unless a context is done
+// (ctx and ch are on the top of the stack, and ctx is below ch):
+//
+//	select {
+//	case <-ctx.Done():
+//		return 0, ctx.Err()
+//	case v := <-ch:
+//		...
+//	}
+//
+// The body of the receive case is built by recv, where v is the received value
+// named name (or nil if name is empty, and then the case is `case <-ch:`). The
+// body of the done case is built by done. If done is nil, ctx.Err() is returned
+// with zero values of other results of the current function, whose last result
+// must be an error. If ctx isn't an identifier, it is assigned to an auto
+// variable first, so that it's evaluated once.
+func (p *CodeBuilder) RecvOrDone(name string, recv func(cb *CodeBuilder, v *types.Var), done func(cb *CodeBuilder)) *CodeBuilder {
+	if debugInstr {
+		log.Println("RecvOrDone", name)
+	}
+	ch := p.stk.Pop()
+	ctx := p.stk.Pop()
+	if done == nil {
+		if fn := p.current.fn; fn == nil || !lastIsError(fn.Type().(*types.Signature).Results()) {
+			src, pos := p.loadExpr(ctx.Src)
+			p.panicCodeErrorf(&pos, "can't return %s.Err() in a function whose last result isn't an error", src)
+		}
+	}
+	if _, ok := ctx.Val.(*ast.Ident); !ok {
+		tmp := p.pkg.autoName()
+		p.DefineVarStart(token.NoPos, tmp)
+		p.stk.Push(ctx)
+		p.EndInit(1)
+		ctx = toObject(p.pkg, p.current.scope.Lookup(tmp), ctx.Src)
+	}
+	p.Select()
+	p.Val(ctx).MemberVal("Done").Call(0).UnaryOp(token.ARROW).EndStmt().CommCase(1)
+	if done != nil {
+		done(p)
+	} else {
+		p.Val(ctx).MemberVal("Err").Call(0).ReturnErr(false)
+	}
+	p.End()
+	p.recvCase(ch, name, recv)
+	return p.End()
+}
+
+// RecvOrTimeout func: receives a value from a channel with a timeout (ch and
+// d, a time.Duration, are on the top of the stack, and ch is below d):
+//
+//	select {
+//	case v := <-ch:
+//		...
+//	case <-time.After(d):
+//		...
+//	}
+//
+// The body of the receive case is built by recv as RecvOrDone does, and the
+// body of the timeout case is built by timeout.
+func (p *CodeBuilder) RecvOrTimeout(name string, recv func(cb *CodeBuilder, v *types.Var), timeout func(cb *CodeBuilder)) *CodeBuilder {
+	if debugInstr {
+		log.Println("RecvOrTimeout", name)
+	}
+	d := p.stk.Pop()
+	ch := p.stk.Pop()
+	p.Select()
+	p.recvCase(ch, name, recv)
+	p.Val(p.pkg.Import("time").Ref("After")).Val(d).Call(1).UnaryOp(token.ARROW).EndStmt().CommCase(1)
+	if timeout != nil {
+		timeout(p)
+	}
+	p.End()
+	return p.End()
+}
+
+// recvCase builds the case `case name := <-ch:` (or `case <-ch:` if name is
+// empty) of a select statement, whose body is built by recv.
+func (p *CodeBuilder) recvCase(ch *internal.Elem, name string, recv func(cb *CodeBuilder, v *types.Var)) {
+	var v *types.Var
+	if name != "" && name != "_" {
+		p.DefineVarStart(token.NoPos, name)
+		p.Val(ch).UnaryOp(token.ARROW).EndInit(1)
+		v = p.current.scope.Lookup(name).(*types.Var)
+	} else {
+		p.Val(ch).UnaryOp(token.ARROW).EndStmt()
+	}
+	p.CommCase(1)
+	if recv != nil {
+		recv(p, v)
+	}
+	p.End()
+}
+
+// ----------------------------------------------------------------------------
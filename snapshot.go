@@ -0,0 +1,131 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/types"
+	"path"
+	"sort"
+)
+
+// ----------------------------------------------------------------------------
+
+// ScopeKind represents where an identifier is declared.
+type ScopeKind string
+
+const (
+	ScopeLocal    ScopeKind = "local"    // a local variable, constant, type or label
+	ScopeParam    ScopeKind = "param"    // a parameter, result or receiver of a func
+	ScopePackage  ScopeKind = "package"  // a package-level declaration
+	ScopeImport   ScopeKind = "import"   // an imported package
+	ScopeBuiltin  ScopeKind = "builtin"  // a builtin of the builtin package
+	ScopeUniverse ScopeKind = "universe" // a predeclared identifier of Go
+)
+
+// ScopeEntry represents an identifier visible at a builder position. It can be
+// serialized by encoding/json.
+type ScopeEntry struct {
+	Name  string
+	Kind  string // one of "var", "func", "const", "type", "package", "builtin" and "nil"
+	Type  string // type of the identifier (path of the package if Kind is "package")
+	Scope ScopeKind
+}
+
+// ScopeSnapshot returns all identifiers visible at the current position of the
+// code builder (innermost scope first, and then imports, builtins and
+// predeclared identifiers), for completion engines of DSLs built on gox.
+// Identifiers shadowed by inner scopes are excluded. Types are written in
+// qualified form with package names, except those of this package.
+//
+// Imports are sorted by name (and then by path). The name of an imported
+// package is its default name, which may be different from the final one
+// written by WriteTo (eg. if two packages have the same name).
+func (p *CodeBuilder) ScopeSnapshot() []ScopeEntry {
+	pkg := p.pkg
+	qf := func(at *types.Package) string {
+		if at == pkg.Types {
+			return ""
+		}
+		return at.Name()
+	}
+	params := make(map[types.Object]bool)
+	if fn := p.current.fn; fn != nil {
+		sig := fn.Type().(*types.Signature)
+		for _, vars := range [...]*types.Tuple{sig.Params(), sig.Results()} {
+			for i, n := 0, vars.Len(); i < n; i++ {
+				params[vars.At(i)] = true
+			}
+		}
+		if recv := sig.Recv(); recv != nil {
+			params[recv] = true
+		}
+	}
+	objs := p.VisibleObjects(true)
+	shadowed := make(map[string]bool, len(objs))
+	entries := make([]ScopeEntry, 0, len(objs))
+	var builtins []ScopeEntry
+	for _, o := range objs {
+		entry := ScopeEntry{Name: o.Name(), Kind: objectKind(o)}
+		switch o.Parent() {
+		case types.Universe:
+			entry.Scope = ScopeUniverse
+		case pkg.Types.Scope():
+			entry.Scope = ScopePackage
+		default:
+			if entry.Scope = ScopeLocal; params[o] {
+				entry.Scope = ScopeParam
+			}
+		}
+		if pkg.isBuiltin(o.Pkg()) {
+			entry.Scope = ScopeBuiltin
+		}
+		if entry.Kind != "builtin" && entry.Kind != "nil" {
+			entry.Type = types.TypeString(o.Type(), qf)
+		}
+		if entry.Scope == ScopeBuiltin || entry.Scope == ScopeUniverse {
+			builtins = append(builtins, entry)
+		} else {
+			shadowed[entry.Name] = true
+			entries = append(entries, entry)
+		}
+	}
+	var imports []ScopeEntry
+	for pkgPath, ref := range pkg.files[pkg.curFile].importPkgs {
+		name := path.Base(pkgPath)
+		if ref.Types != nil {
+			name = ref.Types.Name()
+		}
+		if !shadowed[name] {
+			imports = append(imports, ScopeEntry{Name: name, Kind: "package", Type: pkgPath, Scope: ScopeImport})
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Name != imports[j].Name {
+			return imports[i].Name < imports[j].Name
+		}
+		return imports[i].Type < imports[j].Type
+	})
+	for _, entry := range imports {
+		shadowed[entry.Name] = true
+	}
+	entries = append(entries, imports...)
+	for _, entry := range builtins {
+		if !shadowed[entry.Name] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ----------------------------------------------------------------------------
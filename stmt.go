@@ -15,17 +15,124 @@ package gox
 
 import (
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"log"
+	"sort"
 
 	"github.com/goplus/gox/internal"
+	"github.com/goplus/gox/internal/go/printer"
 )
 
 type controlFlow interface {
 	Then(cb *CodeBuilder)
 }
 
+// ----------------------------------------------------------------------------
+
+// initStmts splits init statements of an if, switch, type switch or for
+// statement into its init statement and the statements before it. Go allows
+// only one init statement, so the others are hoisted before the statement
+// (see emitInitStmt).
+func (p *CodeBuilder) initStmts(stmts []ast.Stmt, what string) (init ast.Stmt, prelude []ast.Stmt) {
+	n := len(stmts)
+	if n == 0 {
+		return
+	}
+	if max := p.pkg.conf.MaxInitStmts; max > 0 && n > max {
+		p.panicCodePosErrorf(p.current.pos, "%s has too many init statements (%d > %d)", what, n, max)
+	}
+	switch stmts[n-1].(type) {
+	case *ast.ExprStmt, *ast.SendStmt, *ast.IncDecStmt, *ast.AssignStmt:
+		return stmts[n-1], stmts[:n-1]
+	}
+	return nil, stmts
+}
+
+// emitInitStmt emits an if, switch, type switch or for statement after its
+// prelude statements (see initStmts). If they declare names, they are emitted
+// in a block wrapping the statement, so the names are still scoped to it.
+func (p *CodeBuilder) emitInitStmt(prelude []ast.Stmt, stmt ast.Stmt) {
+	switch {
+	case len(prelude) == 0:
+	case !hasDecl(prelude): // prelude statements are emitted (and tracked) already
+		p.current.stmts = append(p.current.stmts, prelude...)
+	case p.current.label != nil: // `break L` or `continue L` needs the label on stmt
+		p.panicCodePosErrorf(
+			p.stmtPos, "can't emit init statements of labeled statement %s", p.current.label.Label.Name)
+	default:
+		stmt = &ast.BlockStmt{List: append(prelude, stmt)}
+	}
+	p.emitStmt(stmt)
+}
+
+// hasDecl reports whether any of stmts declares names.
+func hasDecl(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if s, ok := stmt.(*printer.CommentedStmt); ok {
+			stmt = s.Stmt
+		}
+		if s, ok := stmt.(*printer.LineStmt); ok {
+			stmt = s.Stmt
+		}
+		switch s := stmt.(type) {
+		case *ast.DeclStmt, *ast.LabeledStmt:
+			return true
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+//
+// {
+//   ...
+// }
+//
+type blockStmt struct {
+	old codeBlockCtx
+}
+
+func (p *blockStmt) End(cb *CodeBuilder) {
+	stmts, flows := cb.endBlockStmt(p.old)
+	cb.current.flows |= flows
+	cb.emitStmt(&ast.BlockStmt{List: stmts})
+}
+
+// ----------------------------------------------------------------------------
+//
+// vblock: statements inserted at a mark
+//
+type vblockStmt struct {
+	mark *StmtMark
+	old  codeBlockCtx
+}
+
+func (p *vblockStmt) End(cb *CodeBuilder) {
+	stmts, flows := cb.endBlockStmt(p.old)
+	cb.current.flows |= flows
+	mark, n := p.mark, len(stmts)
+	if n == 0 {
+		return
+	}
+	all := cb.current.stmts
+	all = append(all, stmts...)
+	copy(all[mark.idx+n:], all[mark.idx:])
+	copy(all[mark.idx:], stmts)
+	cb.current.stmts = all
+	for v := cb.varDecl; v != nil; v = v.oldv { // statements started after the mark are moved
+		if v.scope == mark.scope && v.at >= mark.idx {
+			v.at += n
+		}
+	}
+	mark.idx += n
+}
+
 // ----------------------------------------------------------------------------
 //
 // if init; cond then
@@ -35,10 +142,11 @@ type controlFlow interface {
 // end
 //
 type ifStmt struct {
-	init ast.Stmt
-	cond ast.Expr
-	body *ast.BlockStmt
-	old  codeBlockCtx
+	init    ast.Stmt
+	prelude []ast.Stmt // extra init statements, emitted before the statement
+	cond    ast.Expr
+	body    *ast.BlockStmt
+	old     codeBlockCtx
 }
 
 func (p *ifStmt) Then(cb *CodeBuilder) {
@@ -47,14 +155,7 @@ func (p *ifStmt) Then(cb *CodeBuilder) {
 		panic("TODO: if statement condition is not a boolean expr")
 	}
 	p.cond = cond.Val
-	switch stmts := cb.clearBlockStmt(); len(stmts) {
-	case 0:
-		// nothing to do
-	case 1:
-		p.init = stmts[0]
-	default:
-		panic("TODO: if statement has too many init statements")
-	}
+	p.init, p.prelude = cb.initStmts(cb.clearBlockStmt(), "if statement")
 }
 
 func (p *ifStmt) Else(cb *CodeBuilder) {
@@ -75,7 +176,7 @@ func (p *ifStmt) End(cb *CodeBuilder) {
 	} else { // if without else
 		p.body = blockStmt
 	}
-	cb.emitStmt(&ast.IfStmt{Init: p.init, Cond: p.cond, Body: p.body, Else: el})
+	cb.emitInitStmt(p.prelude, &ast.IfStmt{Init: p.init, Cond: p.cond, Body: p.body, Else: el})
 }
 
 // ----------------------------------------------------------------------------
@@ -90,27 +191,25 @@ func (p *ifStmt) End(cb *CodeBuilder) {
 // end
 //
 type switchStmt struct {
-	init ast.Stmt
-	tag  *internal.Elem
-	old  codeBlockCtx
+	init    ast.Stmt
+	prelude []ast.Stmt // extra init statements, emitted before the statement
+	tag     *internal.Elem
+	cvals   [][]constant.Value // constant values of case exprs (nil if not constant)
+	hint    SwitchHint
+	old     codeBlockCtx
 }
 
 func (p *switchStmt) Then(cb *CodeBuilder) {
 	p.tag = cb.stk.Pop()
-	switch stmts := cb.clearBlockStmt(); len(stmts) {
-	case 0:
-		// nothing to do
-	case 1:
-		p.init = stmts[0]
-	default:
-		panic("TODO: switch statement has too many init statements")
-	}
+	p.init, p.prelude = cb.initStmts(cb.clearBlockStmt(), "switch statement")
 }
 
 func (p *switchStmt) Case(cb *CodeBuilder, n int) {
 	var list []ast.Expr
+	var cvals []constant.Value
 	if n > 0 {
 		list = make([]ast.Expr, n)
+		cvals = make([]constant.Value, n)
 		for i, arg := range cb.stk.GetArgs(n) {
 			if p.tag.Val != nil { // switch tag {...}
 				if !ComparableTo(cb.pkg, arg.Type, p.tag.Type) {
@@ -122,9 +221,11 @@ func (p *switchStmt) Case(cb *CodeBuilder, n int) {
 				}
 			}
 			list[i] = arg.Val
+			cvals[i] = arg.CVal
 		}
 		cb.stk.PopN(n)
 	}
+	p.cvals = append(p.cvals, cvals)
 	stmt := &caseStmt{list: list}
 	cb.startBlockStmt(stmt, "case statement", &stmt.old)
 }
@@ -133,8 +234,187 @@ func (p *switchStmt) End(cb *CodeBuilder) {
 	stmts, flows := cb.endBlockStmt(p.old)
 	cb.current.flows |= (flows &^ flowFlagBreak)
 
+	// a break statement or a label of the switch statement needs a real switch
+	if p.hint == SwitchBinarySearch && flows&flowFlagBreak == 0 && cb.current.label == nil {
+		if stmt := p.binarySearch(cb, stmts); stmt != nil {
+			cb.emitStmt(stmt)
+			return
+		}
+	}
 	body := &ast.BlockStmt{List: stmts}
-	cb.emitStmt(&ast.SwitchStmt{Init: p.init, Tag: p.tag.Val, Body: body})
+	cb.emitInitStmt(p.prelude, &ast.SwitchStmt{Init: p.init, Tag: p.tag.Val, Body: body})
+}
+
+// SwitchHint represents how a switch statement is emitted.
+type SwitchHint int
+
+const (
+	// SwitchDefault emits a switch statement.
+	SwitchDefault SwitchHint = iota
+
+	// SwitchBinarySearch emits a balanced if-chain which searches the tag in
+	// sorted case values, if the tag is an integer and all case values are
+	// integer constants, and the switch statement has no break, fallthrough
+	// or label:
+	//
+	//	{
+	//		_autoGo_1 := tag
+	//		if _autoGo_1 < 100 {
+	//			...
+	//		} else if _autoGo_1 == 100 {
+	//			...
+	//			goto _autoGo_2
+	//		} else ...
+	//		{
+	//			// the default case
+	//		}
+	//	_autoGo_2:
+	//	}
+	//
+	// The end label is emitted only if a case jumps over the default case. A
+	// case with several values is compared as `_autoGo_1 == x || _autoGo_1 == y`.
+	// Otherwise (eg. values of a case aren't adjacent in sorted order), a switch
+	// statement is emitted.
+	SwitchBinarySearch
+)
+
+type switchEntry struct {
+	val    constant.Value
+	expr   ast.Expr
+	clause int // index of the case clause
+}
+
+// switchLeafSize is the max number of entries compared one by one.
+const switchLeafSize = 4
+
+func (p *switchStmt) binarySearch(cb *CodeBuilder, stmts []ast.Stmt) ast.Stmt {
+	if p.tag.Val == nil || !isInteger(p.tag.Type) || len(stmts) != len(p.cvals) {
+		return nil
+	}
+	var entries []switchEntry
+	var dflt []ast.Stmt
+	clauses := make([]*ast.CaseClause, len(stmts))
+	for i, stmt := range stmts {
+		c, ok := stmt.(*ast.CaseClause)
+		if !ok {
+			return nil
+		}
+		if n := len(c.Body); n > 0 {
+			if br, ok := c.Body[n-1].(*ast.BranchStmt); ok && br.Tok == token.FALLTHROUGH {
+				return nil
+			}
+		}
+		if c.List == nil {
+			dflt = c.Body
+			continue
+		}
+		for j, v := range p.cvals[i] {
+			if v == nil || v.Kind() != constant.Int {
+				return nil
+			}
+			entries = append(entries, switchEntry{val: v, expr: c.List[j], clause: i})
+		}
+		clauses[i] = c
+	}
+	if entries == nil {
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return constant.Compare(entries[i].val, token.LSS, entries[j].val)
+	})
+	seen := make(map[int]bool, len(clauses))
+	for i, e := range entries { // a body can't be repeated, labels in it would be duplicated
+		if i > 0 && entries[i-1].clause == e.clause {
+			continue
+		}
+		if seen[e.clause] {
+			return nil
+		}
+		seen[e.clause] = true
+	}
+	pkg := cb.pkg
+	search := &switchSearch{pkg: pkg, tag: pkg.autoName(), clauses: clauses, dflt: len(dflt) > 0}
+	list := make([]ast.Stmt, 0, 5+len(p.prelude))
+	list = append(list, p.prelude...)
+	if p.init != nil {
+		list = append(list, p.init)
+	}
+	list = append(list,
+		&ast.AssignStmt{Lhs: []ast.Expr{ident(search.tag)}, Tok: token.DEFINE, Rhs: []ast.Expr{p.tag.Val}},
+		search.tree(entries))
+	if len(dflt) > 0 {
+		list = append(list, &ast.BlockStmt{List: dflt})
+		if search.end != "" {
+			list = append(list, &ast.LabeledStmt{Label: ident(search.end), Stmt: &ast.EmptyStmt{Implicit: true}})
+		}
+	}
+	return &ast.BlockStmt{List: list}
+}
+
+type switchSearch struct {
+	pkg     *Package
+	tag     string
+	end     string // the label after the default case ("" if no goto to it)
+	clauses []*ast.CaseClause
+	dflt    bool // the switch statement has a non-empty default case
+}
+
+func (p *switchSearch) tree(entries []switchEntry) ast.Stmt {
+	if len(entries) > switchLeafSize {
+		mid := len(entries) / 2
+		for mid > 0 && entries[mid-1].clause == entries[mid].clause { // don't split values of a case
+			mid--
+		}
+		if mid == 0 {
+			for mid = len(entries) / 2; mid < len(entries) && entries[mid-1].clause == entries[mid].clause; mid++ {
+			}
+		}
+		if mid < len(entries) {
+			return &ast.IfStmt{
+				Cond: &ast.BinaryExpr{
+					X: ident(p.tag), Op: token.LSS, Y: &ast.BasicLit{Kind: token.INT, Value: entries[mid].val.ExactString()},
+				},
+				Body: &ast.BlockStmt{List: []ast.Stmt{p.tree(entries[:mid])}},
+				Else: p.tree(entries[mid:]),
+			}
+		}
+	}
+	var first, last *ast.IfStmt
+	for i := 0; i < len(entries); {
+		clause := entries[i].clause
+		cond := ast.Expr(&ast.BinaryExpr{X: ident(p.tag), Op: token.EQL, Y: entries[i].expr})
+		for i++; i < len(entries) && entries[i].clause == clause; i++ {
+			eq := &ast.BinaryExpr{X: ident(p.tag), Op: token.EQL, Y: entries[i].expr}
+			cond = &ast.BinaryExpr{X: cond, Op: token.LOR, Y: eq}
+		}
+		body := p.clauses[clause].Body
+		if p.dflt && !isTerminating(body) {
+			if p.end == "" {
+				p.end = p.pkg.autoName()
+			}
+			body = append(body[:len(body):len(body)], &ast.BranchStmt{Tok: token.GOTO, Label: ident(p.end)})
+		}
+		stmt := &ast.IfStmt{Cond: cond, Body: &ast.BlockStmt{List: body}}
+		if last == nil {
+			first = stmt
+		} else {
+			last.Else = stmt
+		}
+		last = stmt
+	}
+	return first
+}
+
+// isTerminating reports whether the last statement of stmts is a return or
+// branch statement, after which no goto statement is needed.
+func isTerminating(stmts []ast.Stmt) bool {
+	if n := len(stmts); n > 0 {
+		switch stmts[n-1].(type) {
+		case *ast.ReturnStmt, *ast.BranchStmt:
+			return true
+		}
+	}
+	return false
 }
 
 type caseStmt struct {
@@ -204,28 +484,23 @@ func (p *commCase) End(cb *CodeBuilder) {
 // end
 //
 type typeSwitchStmt struct {
-	init  ast.Stmt
-	name  string
-	x     ast.Expr
-	xType *types.Interface
-	old   codeBlockCtx
+	init    ast.Stmt
+	prelude []ast.Stmt // extra init statements, emitted before the statement
+	name    string
+	x       ast.Expr
+	xType   *types.Interface
+	xTyp    types.Type // type of x (xType, or a named type of it)
+	old     codeBlockCtx
 }
 
 func (p *typeSwitchStmt) TypeAssertThen(cb *CodeBuilder) {
-	switch stmts := cb.clearBlockStmt(); len(stmts) {
-	case 0:
-		// nothing to do
-	case 1:
-		p.init = stmts[0]
-	default:
-		panic("TODO: type switch statement has too many init statements")
-	}
+	p.init, p.prelude = cb.initStmts(cb.clearBlockStmt(), "type switch statement")
 	x := cb.stk.Pop()
-	xType, ok := x.Type.(*types.Interface)
+	xType, ok := getUnderlying(cb.pkg, x.Type).(*types.Interface)
 	if !ok {
 		panic("TODO: can't type assert on non interface expr")
 	}
-	p.x, p.xType = x.Val, xType
+	p.x, p.xType, p.xTyp = x.Val, xType, x.Type
 }
 
 func (p *typeSwitchStmt) TypeCase(cb *CodeBuilder, n int) {
@@ -250,7 +525,7 @@ func (p *typeSwitchStmt) TypeCase(cb *CodeBuilder, n int) {
 
 	if p.name != "" {
 		if n != 1 { // default, or case with multi expr
-			typ = p.xType
+			typ = p.xTyp
 		}
 		name := types.NewParam(token.NoPos, cb.pkg.Types, p.name, typ)
 		cb.current.scope.Insert(name)
@@ -273,7 +548,7 @@ func (p *typeSwitchStmt) End(cb *CodeBuilder) {
 	} else {
 		assign = &ast.ExprStmt{X: x}
 	}
-	cb.emitStmt(&ast.TypeSwitchStmt{Init: p.init, Assign: assign, Body: body})
+	cb.emitInitStmt(p.prelude, &ast.TypeSwitchStmt{Init: p.init, Assign: assign, Body: body})
 }
 
 type typeCaseStmt struct {
@@ -294,11 +569,18 @@ func (p *typeCaseStmt) End(cb *CodeBuilder) {
 //   post
 // end
 //
+// while cond then
+//   body
+// end
+//
 type forStmt struct {
-	init ast.Stmt
-	cond ast.Expr
-	body *ast.BlockStmt
-	old  codeBlockCtx
+	init    ast.Stmt
+	prelude []ast.Stmt // extra init statements, emitted before the statement
+	cond    ast.Expr
+	body    *ast.BlockStmt
+	old     codeBlockCtx
+	while   bool       // while-style for statement (see CodeBuilder.While)
+	pre     []ast.Stmt // statements of a while-style for statement before body
 }
 
 func (p *forStmt) Then(cb *CodeBuilder) {
@@ -309,14 +591,17 @@ func (p *forStmt) Then(cb *CodeBuilder) {
 		}
 		p.cond = cond.Val
 	}
-	switch stmts := cb.clearBlockStmt(); len(stmts) {
-	case 0:
-		// nothing to do
-	case 1:
-		p.init = stmts[0]
-	default:
-		panic("TODO: for condition has too many init statements")
+	if p.while {
+		if p.pre = cb.clearBlockStmt(); len(p.pre) > 0 && p.cond != nil { // for { pre; if !cond { break }; body }
+			p.pre = append(p.pre, &ast.IfStmt{
+				Cond: &ast.UnaryExpr{Op: token.NOT, X: p.cond},
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+			})
+			p.cond = nil
+		}
+		return
 	}
+	p.init, p.prelude = cb.initStmts(cb.clearBlockStmt(), "for statement")
 }
 
 func (p *forStmt) Post(cb *CodeBuilder) {
@@ -334,9 +619,202 @@ func (p *forStmt) End(cb *CodeBuilder) {
 		}
 		post = stmts[0]
 	} else { // no post
-		p.body = &ast.BlockStmt{List: stmts}
+		p.body = &ast.BlockStmt{List: append(p.pre, stmts...)}
+	}
+	cb.emitInitStmt(p.prelude, &ast.ForStmt{Init: p.init, Cond: p.cond, Post: post, Body: p.body})
+}
+
+// ----------------------------------------------------------------------------
+//
+// doWhile
+//   body
+// doCond cond
+// end
+//
+type doWhileStmt struct {
+	body    []ast.Stmt
+	hasCond bool
+	old     codeBlockCtx
+}
+
+func (p *doWhileStmt) DoCond(cb *CodeBuilder) {
+	if p.hasCond {
+		panic("TODO: condition of do-while statement already exists")
+	}
+	p.body, p.hasCond = cb.clearBlockStmt(), true
+	// names declared in body are out of scope in cond (it's out of body in Go)
+	cb.current.scope = types.NewScope(p.old.scope, token.NoPos, token.NoPos, "do-while condition")
+	cb.current.base++ // cond is left on the stack at End
+}
+
+func (p *doWhileStmt) End(cb *CodeBuilder) {
+	if !p.hasCond {
+		panic("please use DoCond() in do-while statement")
+	}
+	cb.current.base--
+	cond := cb.stk.Pop()
+	if !types.AssignableTo(cond.Type, types.Typ[types.Bool]) {
+		panic("TODO: do-while statement condition is not a boolean expr")
+	}
+	stmts, flows := cb.endBlockStmt(p.old)
+	cb.current.flows |= (flows &^ (flowFlagBreak | flowFlagContinue))
+
+	var label string
+	if l := cb.current.label; l != nil {
+		label = l.Label.Name
+	}
+	continued := false
+	loopBranches(p.body, token.CONTINUE, label, false, func(*ast.BranchStmt) {
+		continued = true
+	})
+	notCond := &ast.IfStmt{
+		Cond: &ast.UnaryExpr{Op: token.NOT, X: cond.Val},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+	}
+	if !continued { // for { body; stmts; if !cond { break } }
+		body := p.body
+		if hasDecl(body) { // names declared in body can't be referred by cond
+			body = []ast.Stmt{&ast.BlockStmt{List: body}}
+		}
+		body = append(append(body, stmts...), notCond)
+		cb.emitStmt(&ast.ForStmt{Body: &ast.BlockStmt{List: body}})
+		return
+	}
+	// a continue statement checks cond, so body runs once by a flag:
+	// for once := true; once || cond; once = false { body }, or
+	// for once := true; ; once = false { if !once { stmts; if !cond { break } }; body }
+	once := ident(cb.pkg.autoName())
+	stmt := &ast.ForStmt{
+		Init: &ast.AssignStmt{Lhs: []ast.Expr{once}, Tok: token.DEFINE, Rhs: []ast.Expr{ident("true")}},
+		Post: &ast.AssignStmt{Lhs: []ast.Expr{once}, Tok: token.ASSIGN, Rhs: []ast.Expr{ident("false")}},
+		Body: &ast.BlockStmt{List: p.body},
+	}
+	if len(stmts) == 0 {
+		stmt.Cond = &ast.BinaryExpr{X: once, Op: token.LOR, Y: cond.Val}
+	} else {
+		check := &ast.IfStmt{
+			Cond: &ast.UnaryExpr{Op: token.NOT, X: once},
+			Body: &ast.BlockStmt{List: append(stmts, notCond)},
+		}
+		stmt.Body.List = append([]ast.Stmt{check}, p.body...)
+	}
+	cb.emitStmt(stmt)
+}
+
+// ----------------------------------------------------------------------------
+//
+// for init; cond then (or forRange ... rangeAssignThen)
+//   body
+// loopElse
+//   elseBody
+// end
+//
+type loopElseStmt struct {
+	end string // label after elseBody, which breaks of the loop go to
+	old codeBlockCtx
+}
+
+// start starts else body of the loop, the last statement emitted, and lowers
+// breaks of the loop to `goto end` (see CodeBuilder.LoopElse).
+func (p *loopElseStmt) start(cb *CodeBuilder) {
+	loop, label := cb.current.stmts[len(cb.current.stmts)-1], ""
+	for loop != nil {
+		switch s := loop.(type) {
+		case *printer.CommentedStmt:
+			loop = s.Stmt
+		case *printer.LineStmt:
+			loop = s.Stmt
+		case *ast.LabeledStmt:
+			loop, label = s.Stmt, s.Label.Name
+		case *ast.BlockStmt: // eg. a for statement with extra init statements
+			loop = s.List[len(s.List)-1]
+		case *ast.ForStmt, *ast.RangeStmt:
+			var body *ast.BlockStmt
+			if f, ok := s.(*ast.ForStmt); ok {
+				body = f.Body
+			} else {
+				body = s.(*ast.RangeStmt).Body
+			}
+			loopBranches(body.List, token.BREAK, label, false, func(br *ast.BranchStmt) {
+				if p.end == "" {
+					p.end = cb.pkg.autoName()
+				}
+				br.Tok, br.Label = token.GOTO, ident(p.end)
+			})
+			loop = nil
+		default:
+			panic("TODO: loop else of unknown loop statement")
+		}
+	}
+	if p.end != "" {
+		cb.current.flows |= flowFlagGoto
+	}
+	cb.startBlockStmt(p, "loop else statement", &p.old)
+}
+
+func (p *loopElseStmt) End(cb *CodeBuilder) {
+	stmts, flows := cb.endBlockStmt(p.old)
+	cb.current.flows |= flows
+	if hasDecl(stmts) { // `goto end` can't jump over declarations
+		cb.emitStmt(&ast.BlockStmt{List: stmts})
+	} else { // stmts are emitted (and tracked) already
+		cb.current.stmts = append(cb.current.stmts, stmts...)
+	}
+	if p.end != "" {
+		cb.emitStmt(&ast.LabeledStmt{Label: ident(p.end), Stmt: &ast.EmptyStmt{Implicit: true}})
+	}
+}
+
+// loopBranches calls f for each branch statement of token tok (a break or a
+// continue) in stmts, the body of a loop, which applies to the loop: either
+// it's labeled by label of the loop, or it's unlabeled and isn't in a nested
+// statement which it applies to (a loop, or a switch or select for a break).
+func loopBranches(stmts []ast.Stmt, tok token.Token, label string, nested bool, f func(*ast.BranchStmt)) {
+	for _, stmt := range stmts {
+		loopBranch(stmt, tok, label, nested, f)
+	}
+}
+
+func loopBranch(stmt ast.Stmt, tok token.Token, label string, nested bool, f func(*ast.BranchStmt)) {
+	switch s := stmt.(type) {
+	case *ast.BranchStmt:
+		if s.Tok == tok {
+			var name string // Break("") emits an empty label
+			if s.Label != nil {
+				name = s.Label.Name
+			}
+			if name == "" && !nested || name != "" && name == label {
+				f(s)
+			}
+		}
+	case *printer.CommentedStmt:
+		loopBranch(s.Stmt, tok, label, nested, f)
+	case *printer.LineStmt:
+		loopBranch(s.Stmt, tok, label, nested, f)
+	case *ast.LabeledStmt:
+		loopBranch(s.Stmt, tok, label, nested, f)
+	case *ast.BlockStmt:
+		loopBranches(s.List, tok, label, nested, f)
+	case *ast.IfStmt:
+		loopBranches(s.Body.List, tok, label, nested, f)
+		if s.Else != nil {
+			loopBranch(s.Else, tok, label, nested, f)
+		}
+	case *ast.ForStmt:
+		loopBranches(s.Body.List, tok, label, true, f)
+	case *ast.RangeStmt:
+		loopBranches(s.Body.List, tok, label, true, f)
+	case *ast.SwitchStmt:
+		loopBranches(s.Body.List, tok, label, nested || tok == token.BREAK, f)
+	case *ast.TypeSwitchStmt:
+		loopBranches(s.Body.List, tok, label, nested || tok == token.BREAK, f)
+	case *ast.SelectStmt:
+		loopBranches(s.Body.List, tok, label, nested || tok == token.BREAK, f)
+	case *ast.CaseClause:
+		loopBranches(s.Body, tok, label, nested, f)
+	case *ast.CommClause:
+		loopBranches(s.Body, tok, label, nested, f)
 	}
-	cb.emitStmt(&ast.ForStmt{Init: p.init, Cond: p.cond, Post: post, Body: p.body})
 }
 
 // ----------------------------------------------------------------------------
@@ -350,11 +828,14 @@ func (p *forStmt) End(cb *CodeBuilder) {
 // end
 //
 type forRangeStmt struct {
-	names []string
-	stmt  *ast.RangeStmt
-	old   codeBlockCtx
-	kvt   []types.Type
-	udt   int // 0: non-udt, 2: (elem,ok), 3: (key,elem,ok)
+	names  []string
+	stmt   *ast.RangeStmt
+	old    codeBlockCtx
+	kvt    []types.Type
+	udt    int        // 0: non-udt, 2: (elem,ok), 3: (key,elem,ok)
+	enum   *ast.Ident // Gop_Enum method of an udt (see OpRange)
+	ex     bool
+	forInt *ast.ForStmt // for i := 0; i < n; i++
 }
 
 func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
@@ -369,7 +850,11 @@ func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
 		}
 		x := cb.stk.Pop()
 		pkg, scope := cb.pkg, cb.current.scope
-		typs := p.getKeyValTypes(x.Type)
+		if p.ex && isInteger(x.Type) {
+			p.rangeInt(cb, pos, x)
+			return
+		}
+		typs := p.getKeyValTypes(cb.pkg, x.Type)
 		if typs == nil {
 			src, _ := cb.loadExpr(x.Src)
 			cb.panicCodePosErrorf(pos, "cannot range over %v (type %v)", src, x.Type)
@@ -379,6 +864,8 @@ func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
 				names[0], val = names[1], nil
 				names = names[:1]
 			}
+		} else if p.ex && len(names) == 1 { // for v := range XXX => for _, v := range XXX
+			names, val = []string{"_", names[0]}, ident(names[0])
 		}
 		for i, name := range names {
 			if name == "_" {
@@ -414,7 +901,7 @@ func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
 			Value: val.Val,
 			X:     x.Val,
 		}
-		typs := p.getKeyValTypes(x.Type)
+		typs := p.getKeyValTypes(cb.pkg, x.Type)
 		if typs == nil {
 			src, _ := cb.loadExpr(x.Src)
 			cb.panicCodePosErrorf(pos, "cannot range over %v (type %v)", src, x.Type)
@@ -430,7 +917,64 @@ func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
 	p.stmt.For = pos
 }
 
-func (p *forRangeStmt) getKeyValTypes(typ types.Type) []types.Type {
+// rangeInt generates `for i := 0; i < n; i++` for ForRangeEx over an integer n.
+// If n isn't an untyped or int constant, it is evaluated once, and i has the
+// (default) type T of n:
+//
+//	for i, _gop_n := T(0), n; i < _gop_n; i++
+//
+// If the variable is `_`, an auto-named variable is used instead.
+func (p *forRangeStmt) rangeInt(cb *CodeBuilder, pos token.Pos, x *internal.Elem) {
+	names := p.names
+	if len(names) != 1 {
+		cb.panicCodePosError(pos, "too many variables in range over integer")
+	}
+	pkg := cb.pkg
+	typ := types.Default(x.Type)
+	name := names[0]
+	if name == "_" {
+		name = pkg.autoName()
+	} else {
+		v := types.NewVar(token.NoPos, pkg.Types, name, typ)
+		if cb.current.scope.Insert(v) != nil {
+			log.Panicln("TODO: variable already defined -", name)
+		}
+	}
+	var zero ast.Expr = &ast.BasicLit{Kind: token.INT, Value: "0"}
+	isInt := types.Identical(typ, types.Typ[types.Int])
+	if !isInt {
+		zero = &ast.CallExpr{Fun: toType(pkg, typ), Args: []ast.Expr{zero}}
+	}
+	key, n := ident(name), x.Val
+	init := &ast.AssignStmt{
+		Lhs: []ast.Expr{key},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{zero},
+	}
+	if x.CVal == nil || !isInt {
+		n = identGopN
+		init.Lhs = append(init.Lhs, n)
+		init.Rhs = append(init.Rhs, x.Val)
+	}
+	p.forInt = &ast.ForStmt{
+		For:  pos,
+		Init: init,
+		Cond: &ast.BinaryExpr{X: key, Op: token.LSS, Y: n},
+		Post: &ast.IncDecStmt{X: key, Tok: token.INC},
+	}
+}
+
+func isInteger(typ types.Type) bool {
+	if t, ok := typ.Underlying().(*types.Basic); ok {
+		return t.Info()&types.IsInteger != 0
+	}
+	return false
+}
+
+func (p *forRangeStmt) getKeyValTypes(pkg *Package, typ types.Type) []types.Type {
+	if t, ok := typ.Underlying().(*types.Basic); ok && t.Info()&types.IsString != 0 {
+		return []types.Type{types.Typ[types.Int], types.Typ[types.Rune]}
+	}
 	switch t := typ.(type) {
 	case *types.Slice:
 		return []types.Type{types.Typ[types.Int], t.Elem()}
@@ -443,22 +987,36 @@ func (p *forRangeStmt) getKeyValTypes(typ types.Type) []types.Type {
 		case *types.Array:
 			return []types.Type{types.Typ[types.Int], e.Elem()}
 		case *types.Named:
-			if kv, ok := p.checkUdt(e); ok {
+			if kv, ok := p.checkUdt(pkg, t); ok {
 				return kv
 			}
 		}
 	case *types.Chan:
 		return []types.Type{t.Elem(), nil}
 	case *types.Named:
-		if kv, ok := p.checkUdt(t); ok {
+		if kv, ok := p.checkUdt(pkg, t); ok {
 			return kv
 		}
+		if u := t.Underlying(); u != typ {
+			return p.getKeyValTypes(pkg, u)
+		}
 	}
 	return nil
 }
 
-func (p *forRangeStmt) checkUdt(o *types.Named) ([]types.Type, bool) {
-	if m := findMethod(o, "Gop_Enum"); m != nil {
+// checkUdt checks if typ is an user defined collection type, that is, typ has
+// a Gop_Enum method (declared by itself, promoted from an embedded field, or
+// an interface method) in one of the forms:
+//
+//	Gop_Enum() Iter                        // Iter has `Next() (val V, ok bool)` or
+//	                                       // `Next() (key K, val V, ok bool)`
+//	Gop_Enum(callback func(key K, val V))  // or callback func(val V)
+//
+// Iter can be any type with the Next method, including interfaces. The method
+// is resolved by Config.OperatorResolver as OpRange.
+func (p *forRangeStmt) checkUdt(pkg *Package, typ types.Type) ([]types.Type, bool) {
+	if m := pkg.opMethod(OpRange, typ, nil, false); m != nil {
+		p.enum = ident(m.Name())
 		sig := m.Type().(*types.Signature)
 		enumRet := sig.Results()
 		params := sig.Params()
@@ -490,27 +1048,21 @@ func (p *forRangeStmt) checkUdt(o *types.Named) ([]types.Type, bool) {
 			return nil, false
 		}
 		if enumRet.Len() == 1 {
-			typ := enumRet.At(0).Type()
-			if t, ok := typ.(*types.Pointer); ok {
-				typ = t.Elem()
-			}
-			if it, ok := typ.(*types.Named); ok {
-				if next := findMethod(it, "Next"); next != nil {
-					ret := next.Type().(*types.Signature).Results()
-					typs := make([]types.Type, 2)
-					n := ret.Len()
-					switch n {
-					case 2: // elem, ok
-						typs[0] = ret.At(0).Type()
-					case 3: // key, elem, ok
-						typs[0], typs[1] = ret.At(0).Type(), ret.At(1).Type()
-					default:
-						return nil, false
-					}
-					if ret.At(n-1).Type() == types.Typ[types.Bool] {
-						p.udt = n
-						return typs, true
-					}
+			if next := findMethod(enumRet.At(0).Type(), "Next"); next != nil {
+				ret := next.Type().(*types.Signature).Results()
+				typs := make([]types.Type, 2)
+				n := ret.Len()
+				switch n {
+				case 2: // elem, ok
+					typs[0] = ret.At(0).Type()
+				case 3: // key, elem, ok
+					typs[0], typs[1] = ret.At(0).Type(), ret.At(1).Type()
+				default:
+					return nil, false
+				}
+				if ret.At(n-1).Type() == types.Typ[types.Bool] {
+					p.udt = n
+					return typs, true
 				}
 			}
 		}
@@ -518,11 +1070,12 @@ func (p *forRangeStmt) checkUdt(o *types.Named) ([]types.Type, bool) {
 	return nil, false
 }
 
-func findMethod(o *types.Named, name string) types.Object {
-	for i, n := 0, o.NumMethods(); i < n; i++ {
-		method := o.Method(i)
-		if method.Name() == name {
-			return method
+// findMethod finds the method name in the method set of typ (or *typ, if typ
+// isn't a pointer).
+func findMethod(typ types.Type, name string) *types.Func {
+	if o, _, _ := types.LookupFieldOrMethod(typ, true, nil, name); o != nil {
+		if m, ok := o.(*types.Func); ok {
+			return m
 		}
 	}
 	return nil
@@ -536,7 +1089,10 @@ func (p *forRangeStmt) End(cb *CodeBuilder) {
 	stmts, flows := cb.endBlockStmt(p.old)
 	cb.current.flows |= (flows &^ (flowFlagBreak | flowFlagContinue))
 
-	if n := p.udt; n == 0 {
+	if p.forInt != nil {
+		p.forInt.Body = &ast.BlockStmt{List: stmts}
+		cb.emitStmt(p.forInt)
+	} else if n := p.udt; n == 0 {
 		p.stmt.Body = &ast.BlockStmt{List: stmts}
 		cb.emitStmt(p.stmt)
 	} else if n > 0 {
@@ -565,7 +1121,7 @@ func (p *forRangeStmt) End(cb *CodeBuilder) {
 				Tok: token.DEFINE,
 				Rhs: []ast.Expr{
 					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{X: p.stmt.X, Sel: identGopEnum},
+						Fun: &ast.SelectorExpr{X: p.stmt.X, Sel: p.enum},
 					},
 				},
 			},
@@ -600,7 +1156,7 @@ func (p *forRangeStmt) End(cb *CodeBuilder) {
 		}
 		stmt := &ast.ExprStmt{
 			X: &ast.CallExpr{
-				Fun: &ast.SelectorExpr{X: p.stmt.X, Sel: identGopEnum},
+				Fun: &ast.SelectorExpr{X: p.stmt.X, Sel: p.enum},
 				Args: []ast.Expr{
 					&ast.FuncLit{
 						Type: &ast.FuncType{Params: &ast.FieldList{List: args}},
@@ -614,9 +1170,9 @@ func (p *forRangeStmt) End(cb *CodeBuilder) {
 }
 
 var (
-	identGopOk   = ident("_gop_ok")
-	identGopIt   = ident("_gop_it")
-	identGopEnum = ident("Gop_Enum")
+	identGopOk = ident("_gop_ok")
+	identGopIt = ident("_gop_it")
+	identGopN  = ident("_gop_n")
 )
 
 var (
@@ -0,0 +1,82 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// StringConcat func: concatenates n string operands on the top of the stack
+// (the first one is the deepest) into `a + b + ...`. Adjacent untyped string
+// constants are folded into one string literal at build time, so that
+//
+//	cb.Val("Hello, ").Val("world").Val(x).Val("!").StringConcat(4)
+//
+// generates `"Hello, world" + x + "!"`. An empty string is pushed if n is 0.
+func (p *CodeBuilder) StringConcat(n int) *CodeBuilder {
+	if debugInstr {
+		log.Println("StringConcat", n)
+	}
+	args := p.stk.GetArgs(n)
+	parts := make([]*internal.Elem, 0, n)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if isUntypedStringConst(arg) {
+			if last := len(parts) - 1; last >= 0 && isUntypedStringConst(parts[last]) {
+				val := constant.StringVal(parts[last].CVal) + constant.StringVal(arg.CVal)
+				parts[last] = toExpr(p.pkg, val, nil)
+				continue
+			}
+		}
+		parts = append(parts, arg)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, toExpr(p.pkg, "", nil))
+	}
+	p.stk.Ret(n, parts[0])
+	for _, part := range parts[1:] {
+		p.stk.Push(part)
+		p.BinaryOp(token.ADD)
+	}
+	return p
+}
+
+func isUntypedStringConst(v *internal.Elem) bool {
+	return v.CVal != nil && v.CVal.Kind() == constant.String && v.Type == types.Typ[types.UntypedString]
+}
+
+// Sprintf func: formats n operands on the top of the stack (the first one is
+// the deepest) by the specified format. It imports fmt, and generates
+// `fmt.Sprintf(format, args...)`.
+func (p *CodeBuilder) Sprintf(format string, n int) *CodeBuilder {
+	if debugInstr {
+		log.Println("Sprintf", format, n)
+	}
+	args := append([]*internal.Elem(nil), p.stk.GetArgs(n)...)
+	p.stk.PopN(n)
+	p.Val(p.pkg.Import("fmt").Ref("Sprintf")).Val(format)
+	for _, arg := range args {
+		p.stk.Push(arg)
+	}
+	return p.Call(n + 1)
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,122 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"go/types"
+	"log"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------------
+
+// Variant describes a variant of a sum type: a struct type named Name, whose
+// fields are Fields.
+type Variant struct {
+	Name   string
+	Fields []*types.Var
+}
+
+// SumType is a closed sum type declared by Package.NewSumType.
+type SumType struct {
+	Type     *types.Named   // the sealed interface
+	Variants []*types.Named // the struct types of variants
+	Ctors    []*Func        // constructors of variants
+	Match    *Func          // the exhaustive match helper
+}
+
+// NewSumType declares a closed sum type named name, eg. to compile algebraic
+// data types of a DSL to Go. For a sum type Shape with variants Circle and
+// Rect, it generates:
+//
+//   - a sealed interface Shape with an unexported marker method isShape;
+//   - struct types Circle and Rect which implement Shape;
+//   - constructors NewCircle and NewRect, whose parameters are the fields;
+//   - MatchShape(v Shape, onCircle func(Circle), onRect func(Rect)), which
+//     calls the handler of the variant of v. Because a handler is required
+//     for each variant, a match is exhaustive.
+func (p *Package) NewSumType(name string, variants ...*Variant) *SumType {
+	if debugInstr {
+		log.Println("NewSumType", name, len(variants))
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	marker := "is" + string(unicode.ToUpper(r)) + name[n:]
+	markerSig := types.NewSignature(nil, nil, nil, false)
+	methods := []*types.Func{types.NewFunc(token.NoPos, p.Types, marker, markerSig)}
+	iface := types.NewInterfaceType(methods, nil).Complete()
+	ret := &SumType{
+		Type:     p.NewType(name).InitType(p, iface),
+		Variants: make([]*types.Named, len(variants)),
+		Ctors:    make([]*Func, len(variants)),
+	}
+	for i, v := range variants {
+		t := p.NewType(v.Name).InitType(p, types.NewStruct(v.Fields, nil))
+		recv := types.NewParam(token.NoPos, p.Types, "", t)
+		p.NewFunc(recv, marker, nil, nil, false).BodyStart(p).End()
+		ret.Variants[i] = t
+	}
+	for i, v := range variants {
+		ret.Ctors[i] = p.newVariantCtor(ret.Type, ret.Variants[i], v)
+	}
+	ret.Match = p.newSumTypeMatch(ret, variants)
+	return ret
+}
+
+func (p *Package) newVariantCtor(sum, t *types.Named, v *Variant) *Func {
+	params := make([]*types.Var, len(v.Fields))
+	for i, fld := range v.Fields {
+		params[i] = p.NewParam(token.NoPos, paramNameOf(fld.Name()), fld.Type())
+	}
+	result := p.NewParam(token.NoPos, "", sum)
+	fn := p.NewFunc(nil, "New"+v.Name, types.NewTuple(params...), types.NewTuple(result), false)
+	cb := fn.BodyStart(p)
+	for i, param := range params {
+		cb.Val(i).Val(param)
+	}
+	cb.StructLit(t, len(params)<<1, true).Return(1).End()
+	return fn
+}
+
+func (p *Package) newSumTypeMatch(sum *SumType, variants []*Variant) *Func {
+	params := make([]*types.Var, len(variants)+1)
+	params[0] = p.NewParam(token.NoPos, "v", sum.Type)
+	for i, v := range variants {
+		handler := types.NewSignature(nil, types.NewTuple(p.NewParam(token.NoPos, "", sum.Variants[i])), nil, false)
+		params[i+1] = p.NewParam(token.NoPos, "on"+v.Name, handler)
+	}
+	fn := p.NewFunc(nil, "Match"+sum.Type.Obj().Name(), types.NewTuple(params...), nil, false)
+	cb := fn.BodyStart(p).TypeSwitch("x").Val(params[0]).TypeAssertThen()
+	for i, t := range sum.Variants {
+		cb.Typ(t).TypeCase(1)
+		_, x := cb.Lookup("x")
+		cb.Val(params[i+1]).Val(x).Call(1).EndStmt().End()
+	}
+	cb.End().End()
+	return fn
+}
+
+// paramNameOf returns a parameter name for a field: the lower-cased field name,
+// which is suffixed with "_" if it is a keyword.
+func paramNameOf(fldName string) string {
+	r, n := utf8.DecodeRuneInString(fldName)
+	name := string(unicode.ToLower(r)) + fldName[n:]
+	if token.Lookup(name).IsKeyword() {
+		name += "_"
+	}
+	return name
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,237 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+
+// TempInfo describes how a temporary variable declared by NewTempVar is used.
+// It is for simplification passes on the generated code, so that they never
+// merge or eliminate a temporary in a way which changes the observable
+// evaluation order or side effects.
+type TempInfo struct {
+	Var *types.Var
+
+	// Assigns is the number of assignments to the temporary.
+	Assigns int
+
+	// Aliases are variables read by values assigned to the temporary, eg. x of
+	// `_gop_tmp1 = x` or `_gop_tmp1 = &x`, and variables aliased by them if
+	// they are temporaries. A variable may be changed between the assignment
+	// and a use of the temporary, so the temporary can't be replaced by it.
+	Aliases []*types.Var
+
+	// SideEffects is if a value assigned to the temporary may have side effects
+	// (eg. it calls a func or receives from a channel).
+	SideEffects bool
+
+	// AddrTaken is if the address of the temporary is taken.
+	AddrTaken bool
+}
+
+// CanEliminate reports whether uses of the temporary can be replaced by the
+// value assigned to it, ie. it is assigned once by a value without side
+// effects, which doesn't alias any variable.
+func (p *TempInfo) CanEliminate() bool {
+	return p.Assigns == 1 && !p.AddrTaken && !p.SideEffects && len(p.Aliases) == 0
+}
+
+// TempInfo returns how the temporary variable v is used in statements emitted
+// so far. It returns nil if v isn't declared by NewTempVar.
+func (p *Package) TempInfo(v *types.Var) *TempInfo {
+	return p.temps[v]
+}
+
+func (p *CodeBuilder) newTemp(v *types.Var) {
+	pkg := p.pkg
+	if pkg.temps == nil {
+		pkg.temps = make(map[*types.Var]*TempInfo)
+	}
+	pkg.temps[v] = &TempInfo{Var: v}
+}
+
+// lookupTemp returns the temporary variable referenced by expr (nil if expr
+// isn't a temporary).
+func (p *CodeBuilder) lookupTemp(expr ast.Expr) *TempInfo {
+	if id, ok := expr.(*ast.Ident); ok {
+		if _, o := p.current.scope.LookupParent(id.Name, token.NoPos); o != nil {
+			if v, ok := o.(*types.Var); ok {
+				return p.pkg.temps[v]
+			}
+		}
+	}
+	return nil
+}
+
+// trackTemps records how temporaries are used by stmt. Statements of nested
+// blocks are tracked when they are emitted, so they are skipped.
+func (p *CodeBuilder) trackTemps(stmt ast.Stmt) {
+	if assign, ok := stmt.(*ast.AssignStmt); ok {
+		for i, lhs := range assign.Lhs {
+			if t := p.lookupTemp(lhs); t != nil {
+				t.Assigns++
+				if len(assign.Rhs) == len(assign.Lhs) {
+					p.trackTempValue(t, assign.Rhs[i])
+				} else {
+					for _, rhs := range assign.Rhs {
+						p.trackTempValue(t, rhs)
+					}
+				}
+			}
+		}
+	}
+	ast.Inspect(stmt, func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.CaseClause:
+			for _, e := range v.List {
+				ast.Inspect(e, func(node ast.Node) bool { return p.trackAddr(node) })
+			}
+			return false
+		case *ast.CommClause:
+			return false
+		}
+		return p.trackAddr(node)
+	})
+}
+
+func (p *CodeBuilder) trackAddr(node ast.Node) bool {
+	switch v := node.(type) {
+	case *ast.BlockStmt:
+		return false
+	case *ast.UnaryExpr:
+		if v.Op == token.AND {
+			if t := p.lookupTemp(v.X); t != nil {
+				t.AddrTaken = true
+			}
+		}
+	case *ast.CallExpr: // method calls may take the address of a temporary
+		if sel, ok := v.Fun.(*ast.SelectorExpr); ok {
+			if t := p.lookupTemp(sel.X); t != nil {
+				t.AddrTaken = true
+			}
+		}
+	}
+	return true
+}
+
+func (p *CodeBuilder) trackTempValue(t *TempInfo, val ast.Expr) {
+	ast.Inspect(val, func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			t.SideEffects = true
+		case *ast.UnaryExpr:
+			if v.Op == token.ARROW {
+				t.SideEffects = true
+			}
+		case *ast.SelectorExpr:
+			p.trackTempValue(t, v.X) // v.Sel isn't a variable
+			return false
+		case *ast.Ident:
+			p.trackAlias(t, v)
+		}
+		return true
+	})
+}
+
+func (p *CodeBuilder) trackAlias(t *TempInfo, id *ast.Ident) {
+	_, o := p.current.scope.LookupParent(id.Name, token.NoPos)
+	v, ok := o.(*types.Var)
+	if !ok || v == t.Var {
+		return
+	}
+	t.addAlias(v)
+	if src := p.pkg.temps[v]; src != nil { // aliases of a temporary are inherited
+		for _, alias := range src.Aliases {
+			t.addAlias(alias)
+		}
+	}
+}
+
+func (p *TempInfo) addAlias(v *types.Var) {
+	for _, alias := range p.Aliases {
+		if alias == v {
+			return
+		}
+	}
+	p.Aliases = append(p.Aliases, v)
+}
+
+// ----------------------------------------------------------------------------
+
+func (p *Package) tempNameUsed(name string) {
+	if p.conf.StableTempNames {
+		if p.tmpNames == nil {
+			p.tmpNames = make(map[string]bool)
+		}
+		p.tmpNames[name] = true
+	}
+}
+
+// renumberTemps renumbers temporaries of ended funcs of decls (see
+// Config.StableTempNames). Names of temporaries are local to a func, so each
+// func is renumbered from 1, eg. `_gop_tmp1` and `_autoGo_1`. A func is
+// renumbered only once, since its body never changes after it is ended.
+func (p *Package) renumberTemps(decls []ast.Decl) {
+	for _, decl := range decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name == nil || fn.Body == nil || p.stables[fn.Body] {
+			continue
+		}
+		if p.stables == nil {
+			p.stables = make(map[*ast.BlockStmt]bool)
+		}
+		p.stables[fn.Body] = true
+		var temps []*ast.Ident
+		used := make(map[string]bool) // names which aren't temporaries
+		ast.Inspect(fn, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok {
+				if p.tmpNames[id.Name] {
+					temps = append(temps, id)
+				} else {
+					used[id.Name] = true
+				}
+			}
+			return true
+		})
+		scope := p.Types.Scope()
+		renamed := make(map[string]string)
+		idx := make(map[string]int)
+		for _, id := range temps {
+			name, ok := renamed[id.Name]
+			if !ok {
+				prefix := strings.TrimRight(id.Name, "0123456789")
+				for {
+					idx[prefix]++
+					name = prefix + strconv.Itoa(idx[prefix])
+					if !used[name] && scope.Lookup(name) == nil {
+						break
+					}
+				}
+				renamed[id.Name] = name
+			}
+			id.Name = name
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
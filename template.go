@@ -160,13 +160,29 @@ func boundType(pkg *Package, arg, param types.Type, expr *ast.Expr) error {
 
 // Default returns the default "typed" type for an "untyped" type;
 // it returns the incoming type for all other types. The default type
-// for untyped nil is untyped nil.
+// for untyped nil is untyped nil. See also Config.DefaultType.
 //
 func Default(pkg *Package, t types.Type) types.Type {
 	return DefaultConv(pkg, t, nil)
 }
 
+// DefaultConv returns the default type of t as Default, and converts expr (if
+// it isn't nil) of type t to the default type.
 func DefaultConv(pkg *Package, t types.Type, expr *ast.Expr) types.Type {
+	if pkg != nil && pkg.conf.DefaultType != nil {
+		if typ := pkg.conf.DefaultType(t); typ != nil && typ != t {
+			if expr != nil {
+				x := *expr
+				if !AssignableConv(pkg, t, typ, expr) {
+					log.Panicln("==> DefaultConv failed:", t, typ)
+				}
+				if *expr == x { // eg. var a = float32(1.5)
+					*expr = toConvExpr(pkg, typ, x)
+				}
+			}
+			return typ
+		}
+	}
 	named, ok := t.(*types.Named)
 	if !ok {
 		return types.Default(t)
@@ -197,6 +213,9 @@ func AssignableTo(pkg *Package, V, T types.Type) bool {
 	return AssignableConv(pkg, V, T, nil)
 }
 
+// AssignableConv reports whether a value of type V is assignable to a variable
+// of type T, and converts expr (if it isn't nil) to type T, eg. by T_Init. If
+// it isn't assignable, Config.AssignableTo is consulted before rejecting.
 func AssignableConv(pkg *Package, V, T types.Type, expr *ast.Expr) bool {
 	V, T = realType(V), realType(T)
 	if v, ok := V.(*refType); ok { // ref type
@@ -206,6 +225,16 @@ func AssignableConv(pkg *Package, V, T types.Type, expr *ast.Expr) bool {
 			V = v.typ
 		}
 	}
+	if assignableConv(pkg, V, T, expr) {
+		return true
+	}
+	if pkg != nil && pkg.conf.AssignableTo != nil {
+		return pkg.conf.AssignableTo(pkg, V, T, expr)
+	}
+	return false
+}
+
+func assignableConv(pkg *Package, V, T types.Type, expr *ast.Expr) bool {
 	if types.AssignableTo(V, T) {
 		if t, ok := T.(*types.Basic); ok { // untyped type
 			vkind := V.(*types.Basic).Kind()
@@ -260,7 +289,20 @@ func assignable(pkg *Package, v types.Type, t *types.Named, expr *ast.Expr) bool
 	return false
 }
 
+// ComparableTo reports whether values of type V and T are comparable (eg. a
+// case value of type V and a switch tag of type T). If they aren't,
+// Config.ComparableTo is consulted before rejecting.
 func ComparableTo(pkg *Package, V, T types.Type) bool {
+	if comparableTo(pkg, V, T) {
+		return true
+	}
+	if pkg != nil && pkg.conf.ComparableTo != nil {
+		return pkg.conf.ComparableTo(pkg, V, T)
+	}
+	return false
+}
+
+func comparableTo(pkg *Package, V, T types.Type) bool {
 	V, T = types.Default(V), types.Default(T)
 	if V != T && getUnderlying(pkg, V) != getUnderlying(pkg, T) {
 		return false
@@ -278,11 +320,21 @@ func NewSignature(recv *types.Var, params, results *types.Tuple, variadic bool)
 
 // NewSlice returns a new slice type for the given element type.
 func NewSlice(elem types.Type) types.Type {
+	checkTypeArg(elem)
 	return types.NewSlice(elem)
 }
 
-// NewMap returns a new map for the given key and element types.
+// NewMap returns a new map for the given key and element types. It panics if
+// the key type is a slice, map or function type.
 func NewMap(key, elem types.Type) types.Type {
+	checkTypeArg(key)
+	checkTypeArg(elem)
+	if !isUnboundParam(key) {
+		switch key.Underlying().(type) {
+		case *types.Slice, *types.Map, *types.Signature:
+			panic(fmt.Sprintf("invalid map key type %v", key))
+		}
+	}
 	var t types.Type = types.NewMap(key, elem)
 	if isUnboundParam(key) || isUnboundParam(elem) {
 		t = &unboundProxyParam{real: t}
@@ -292,6 +344,7 @@ func NewMap(key, elem types.Type) types.Type {
 
 // NewChan returns a new channel type for the given direction and element type.
 func NewChan(dir types.ChanDir, elem types.Type) types.Type {
+	checkTypeArg(elem)
 	var t types.Type = types.NewChan(dir, elem)
 	if isUnboundParam(elem) {
 		t = &unboundProxyParam{real: t}
@@ -302,6 +355,7 @@ func NewChan(dir types.ChanDir, elem types.Type) types.Type {
 // NewArray returns a new array type for the given element type and length.
 // A negative length indicates an unknown length.
 func NewArray(elem types.Type, len int64) types.Type {
+	checkTypeArg(elem)
 	var t types.Type = types.NewArray(elem, len)
 	if isUnboundParam(elem) {
 		t = &unboundProxyParam{real: t}
@@ -311,6 +365,7 @@ func NewArray(elem types.Type, len int64) types.Type {
 
 // NewPointer returns a new pointer type for the given element (base) type.
 func NewPointer(elem types.Type) types.Type {
+	checkTypeArg(elem)
 	var t types.Type = types.NewPointer(elem)
 	if isUnboundParam(elem) {
 		t = &unboundProxyParam{real: t}
@@ -318,6 +373,15 @@ func NewPointer(elem types.Type) types.Type {
 	return t
 }
 
+// checkTypeArg panics if typ can't be used to construct a type (eg. an untyped
+// type), so that an invalid type is reported where it is constructed instead of
+// where it is converted into an ast type expression.
+func checkTypeArg(typ types.Type) {
+	if t, ok := typ.(*types.Basic); ok && (t.Info()&types.IsUntyped) != 0 {
+		panic("unexpected: untyped type")
+	}
+}
+
 func isUnboundParam(typ types.Type) bool {
 	switch t := typ.(type) {
 	case *unboundFuncParam:
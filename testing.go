@@ -0,0 +1,103 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goplus/gox/internal/go/printer"
+)
+
+// ----------------------------------------------------------------------------
+
+// TestFile is the testing file of a package (ie. the _test.go file written by
+// WriteFile(file, pkg, true)), where tests and examples of the package are
+// declared, eg. to translate spec or test DSLs of Go+.
+type TestFile struct {
+	pkg *Package
+}
+
+// NewTestFile returns the testing file of the package. Funcs declared by the
+// returned TestFile are added to the testing file, whatever the current file
+// of the package is (see SetInTestingFile).
+func (p *Package) NewTestFile() *TestFile {
+	return &TestFile{pkg: p}
+}
+
+// NewTest declares a test `func TestXxx(t *testing.T)`, where Xxx is name,
+// and imports "testing" in the testing file. The param t is the first param of
+// the returned func.
+func (p *TestFile) NewTest(name string) *Func {
+	return p.newTestFunc("Test", name, "t", "T")
+}
+
+// NewExample declares an example `func ExampleXxx()`, where Xxx is name (eg.
+// "", "Foo", "Foo_Bar" or "Foo_suffix"). The expected output of the example
+// can be specified by CodeBuilder.ExampleOutput at the end of its body.
+func (p *TestFile) NewExample(name string) *Func {
+	return p.newTestFunc("Example", name, "", "")
+}
+
+func (p *TestFile) newTestFunc(prefix, name, param, typ string) *Func {
+	if r, _ := utf8.DecodeRuneInString(name); unicode.IsLower(r) {
+		log.Panicf("New%s: invalid name %s%s - name starts with a lowercase letter", prefix, prefix, name)
+	}
+	pkg := p.pkg
+	old := pkg.SetInTestingFile(true)
+	defer pkg.SetInTestingFile(old)
+	var params *types.Tuple
+	if param != "" {
+		t := pkg.Import("testing").Ref(typ).Type()
+		params = types.NewTuple(pkg.NewParam(token.NoPos, param, types.NewPointer(t)))
+	}
+	return pkg.NewFunc(nil, prefix+name, params, nil, false)
+}
+
+// ExampleOutput ends the body of an example (see TestFile.NewExample) with
+// the comment of its expected output, which is compared with the standard
+// output of the example by `go test`:
+//
+//	// Output:
+//	// hello
+//
+// Output lines may be in any order if unordered is true.
+func (p *CodeBuilder) ExampleOutput(output string, unordered bool) *CodeBuilder {
+	if debugInstr {
+		log.Println("ExampleOutput", unordered)
+	}
+	text := "\n// Output:"
+	if unordered {
+		text = "\n// Unordered output:"
+	}
+	if output = strings.TrimSuffix(output, "\n"); output != "" {
+		for _, line := range strings.Split(output, "\n") {
+			if line != "" {
+				line = " " + line
+			}
+			text += "\n//" + line
+		}
+	}
+	p.current.stmts = append(p.current.stmts, &printer.CommentedStmt{
+		Comments: &ast.CommentGroup{List: []*ast.Comment{{Text: text}}}, Stmt: &ast.EmptyStmt{Implicit: true},
+	})
+	return p
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,165 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/goplus/gox/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// PanicError represents a panic of a code builder operation whose value isn't
+// an error (eg. a string passed to panic or log.Panicln).
+type PanicError struct {
+	Value interface{}
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprint(p.Value)
+}
+
+// Try calls fn, and returns the panic raised by fn as an error instead. A
+// *CodeError (or any other error) is returned as it is, and other panic values
+// are wrapped as *PanicError. If fn fails, the expression stack is restored to
+// its state before fn is called, so that the code builder can still be used.
+// Other states (eg. statements already emitted by fn) are not rolled back.
+func (p *CodeBuilder) Try(fn func(cb *CodeBuilder)) (err error) {
+	stk := append([]*internal.Elem(nil), p.stk.GetArgs(p.stk.Len())...)
+	defer func() {
+		if e := recover(); e != nil {
+			p.stk.SetLen(0)
+			p.stk.Ret(0, stk...)
+			switch v := e.(type) {
+			case error:
+				err = v
+			default:
+				err = &PanicError{Value: e}
+			}
+		}
+	}()
+	fn(p)
+	return nil
+}
+
+// TryVal is the panic-free version of Val.
+func (p *CodeBuilder) TryVal(v interface{}, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Val(v, src...) })
+}
+
+// TryVarRef is the panic-free version of VarRef.
+func (p *CodeBuilder) TryVarRef(ref interface{}, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.VarRef(ref, src...) })
+}
+
+// TryCall is the panic-free version of Call.
+func (p *CodeBuilder) TryCall(n int, ellipsis ...bool) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Call(n, ellipsis...) })
+}
+
+// TryCallWith is the panic-free version of CallWith.
+func (p *CodeBuilder) TryCallWith(n int, ellipsis, varFuncCall bool, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.CallWith(n, ellipsis, varFuncCall, src...) })
+}
+
+// TryMemberVal is the panic-free version of MemberVal.
+func (p *CodeBuilder) TryMemberVal(name string, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.MemberVal(name, src...) })
+}
+
+// TryMemberRef is the panic-free version of MemberRef.
+func (p *CodeBuilder) TryMemberRef(name string, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.MemberRef(name, src...) })
+}
+
+// TryIndex is the panic-free version of Index.
+func (p *CodeBuilder) TryIndex(nidx int, twoValue bool, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Index(nidx, twoValue, src...) })
+}
+
+// TryIndexRef is the panic-free version of IndexRef.
+func (p *CodeBuilder) TryIndexRef(nidx int, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.IndexRef(nidx, src...) })
+}
+
+// TrySlice is the panic-free version of Slice.
+func (p *CodeBuilder) TrySlice(slice3 bool, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Slice(slice3, src...) })
+}
+
+// TryStar is the panic-free version of Star.
+func (p *CodeBuilder) TryStar(src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Star(src...) })
+}
+
+// TryElem is the panic-free version of Elem.
+func (p *CodeBuilder) TryElem(src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Elem(src...) })
+}
+
+// TryConv is the panic-free version of Conv.
+func (p *CodeBuilder) TryConv(typ types.Type, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Conv(typ, src...) })
+}
+
+// TryBinaryOp is the panic-free version of BinaryOp.
+func (p *CodeBuilder) TryBinaryOp(op token.Token, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.BinaryOp(op, src...) })
+}
+
+// TryUnaryOp is the panic-free version of UnaryOp.
+func (p *CodeBuilder) TryUnaryOp(op token.Token, twoValue ...bool) error {
+	return p.Try(func(cb *CodeBuilder) { cb.UnaryOp(op, twoValue...) })
+}
+
+// TryAssign is the panic-free version of Assign.
+func (p *CodeBuilder) TryAssign(lhs int, rhs ...int) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Assign(lhs, rhs...) })
+}
+
+// TryAssignWith is the panic-free version of AssignWith.
+func (p *CodeBuilder) TryAssignWith(lhs, rhs int, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.AssignWith(lhs, rhs, src...) })
+}
+
+// TryReturn is the panic-free version of Return.
+func (p *CodeBuilder) TryReturn(n int, src ...ast.Node) error {
+	return p.Try(func(cb *CodeBuilder) { cb.Return(n, src...) })
+}
+
+// TryThen is the panic-free version of Then.
+func (p *CodeBuilder) TryThen() error {
+	return p.Try(func(cb *CodeBuilder) { cb.Then() })
+}
+
+// TryElse is the panic-free version of Else.
+func (p *CodeBuilder) TryElse() error {
+	return p.Try(func(cb *CodeBuilder) { cb.Else() })
+}
+
+// TryEndStmt is the panic-free version of EndStmt.
+func (p *CodeBuilder) TryEndStmt() error {
+	return p.Try(func(cb *CodeBuilder) { cb.EndStmt() })
+}
+
+// TryEnd is the panic-free version of End.
+func (p *CodeBuilder) TryEnd() error {
+	return p.Try(func(cb *CodeBuilder) { cb.End() })
+}
+
+// ----------------------------------------------------------------------------
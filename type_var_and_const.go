@@ -45,6 +45,7 @@ func (p *CodeBuilder) EndConst() types.TypeAndValue {
 type TypeDecl struct {
 	typ     *types.Named
 	typExpr *ast.Expr
+	file    int // index of the file where the type is declared
 }
 
 // Type returns the type.
@@ -58,7 +59,10 @@ func (p *TypeDecl) InitType(pkg *Package, typ types.Type) *types.Named {
 		log.Println("InitType", p.typ.Obj().Name(), typ)
 	}
 	p.typ.SetUnderlying(typ)
+	old := pkg.curFile
+	pkg.curFile = p.file // imports required by typ are added to the file of the type
 	*p.typExpr = toType(pkg, typ)
+	pkg.curFile = old
 	return p.typ
 }
 
@@ -79,6 +83,46 @@ func (p *Package) NewType(name string, pos ...token.Pos) *TypeDecl {
 	return p.doNewType(p.Types.Scope(), getPos(pos), name, nil, 0)
 }
 
+// MethodFilter is called by ExtractInterface to decide whether a method is
+// included in the extracted interface.
+type MethodFilter = func(m *types.Func) bool
+
+// ExtractInterface returns an interface which has the exported method set of
+// named (methods with pointer receivers and promoted methods are included),
+// eg. to generate a mock of a concrete type. If filter isn't nil, only methods
+// accepted by filter are included. Signatures of methods are owned by pkg.
+func ExtractInterface(pkg *Package, named *types.Named, filter MethodFilter) *types.Interface {
+	var typ types.Type = named
+	if _, ok := named.Underlying().(*types.Interface); !ok {
+		typ = types.NewPointer(named)
+	}
+	keepName := func(i int, name string) string {
+		return name
+	}
+	mset := types.NewMethodSet(typ)
+	methods := make([]*types.Func, 0, mset.Len())
+	for i, n := 0, mset.Len(); i < n; i++ {
+		m := mset.At(i).Obj().(*types.Func)
+		if !m.Exported() || (filter != nil && !filter(m)) {
+			continue
+		}
+		sig := CopySignature(pkg, m.Type().(*types.Signature), keepName)
+		sig = types.NewSignature(nil, sig.Params(), sig.Results(), sig.Variadic())
+		methods = append(methods, types.NewFunc(m.Pos(), pkg.Types, m.Name(), sig))
+	}
+	return types.NewInterfaceType(methods, nil).Complete()
+}
+
+// NewInterfaceFrom declares an interface type named name, whose methods are
+// extracted from named by ExtractInterface.
+func (p *Package) NewInterfaceFrom(
+	name string, named *types.Named, filter MethodFilter, pos ...token.Pos) *types.Named {
+	if debugInstr {
+		log.Println("NewInterfaceFrom", name, named)
+	}
+	return p.NewType(name, pos...).InitType(p, ExtractInterface(p, named, filter))
+}
+
 func getPos(pos []token.Pos) token.Pos {
 	if pos == nil {
 		return 0
@@ -89,14 +133,21 @@ func getPos(pos []token.Pos) token.Pos {
 func (p *Package) doNewType(
 	scope *types.Scope, pos token.Pos, name string, typ types.Type, alias token.Pos) *TypeDecl {
 	typName := types.NewTypeName(pos, p.Types, name, typ)
-	if scope.Insert(typName) != nil {
+	var old types.Object
+	if scope == p.Types.Scope() {
+		old = p.insertObj(typName)
+	} else {
+		old = scope.Insert(typName)
+	}
+	if old != nil {
 		log.Panicln("TODO: type already defined -", name)
 	}
 	spec := &ast.TypeSpec{Name: ident(name), Assign: alias}
 	decl := &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{spec}}
 	if scope == p.Types.Scope() {
-		idx := p.testingFile
+		idx := p.curFile
 		p.files[idx].decls = append(p.files[idx].decls, decl)
+		p.setDecl(typName, decl)
 	} else {
 		p.cb.emitStmt(&ast.DeclStmt{Decl: decl})
 	}
@@ -105,7 +156,7 @@ func (p *Package) doNewType(
 		typ = typ.Underlying() // typ.Underlying() may delay load and can be nil, it's reasonable
 	}
 	named := types.NewNamed(typName, typ, nil)
-	return &TypeDecl{typ: named, typExpr: &spec.Type}
+	return &TypeDecl{typ: named, typExpr: &spec.Type, file: p.curFile}
 }
 
 // ----------------------------------------------------------------------------
@@ -119,11 +170,19 @@ type ValueDecl struct {
 	vals  *[]ast.Expr
 	tok   token.Token
 	pos   token.Pos
-	at    int
+	at    int          // index of the statement started in block scope (-1 if none)
+	scope *types.Scope // block of the started statement
+	decl  *ast.GenDecl // top-level declaration (nil if it isn't top-level)
+	file  int          // index of the file of a top-level declaration
+
+	oldFile int
 }
 
 func (p *ValueDecl) InitStart(pkg *Package) *CodeBuilder {
 	p.oldv, pkg.cb.varDecl = pkg.cb.varDecl, p
+	if p.decl != nil { // values are built in the file where they are declared
+		p.oldFile, pkg.curFile = pkg.curFile, p.file
+	}
 	p.old = pkg.cb.startInitExpr(p)
 	return &pkg.cb
 }
@@ -137,6 +196,9 @@ func (p *ValueDecl) resetInit(cb *CodeBuilder) *ValueDecl {
 	if p.at >= 0 {
 		cb.commitStmt(p.at) // to support inline call, we must emitStmt at ResetInit stage
 	}
+	if p.decl != nil {
+		cb.pkg.curFile = p.oldFile
+	}
 	return p.oldv
 }
 
@@ -147,16 +209,23 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 	rets := cb.stk.GetArgs(arity)
 	if arity == 1 && n != 1 {
 		t, ok := rets[0].Type.(*types.Tuple)
-		if !ok || n != t.Len() {
-			panic("TODO: unmatched var/const define")
+		if !ok {
+			cb.panicCodePosErrorf(
+				p.pos, "assignment mismatch: %d variables but 1 values", n)
+		} else if n != t.Len() {
+			caller := cb.getCaller(rets[0].Src)
+			cb.panicCodePosErrorf(
+				p.pos, "assignment mismatch: %d variables but %v returns %d values", n, caller, t.Len())
 		}
 		*p.vals = []ast.Expr{rets[0].Val}
+		src := rets[0].Src
 		rets = make([]*internal.Elem, n)
 		for i := 0; i < n; i++ {
-			rets[i] = &internal.Elem{Type: t.At(i).Type()}
+			rets[i] = &internal.Elem{Type: t.At(i).Type(), Src: src}
 		}
 	} else if n != arity {
-		panic("TODO: unmatched var/const define")
+		cb.panicCodePosErrorf(
+			p.pos, "assignment mismatch: %d variables but %d values", n, arity)
 	} else {
 		values = make([]ast.Expr, arity)
 		for i, ret := range rets {
@@ -169,7 +238,7 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 	if typ != nil {
 		for i, ret := range rets {
 			if err := matchType(pkg, ret, typ, "assignment"); err != nil {
-				panic(err)
+				cb.handleTypeErr(err)
 			}
 			if values != nil { // ret.Val may be changed
 				values[i] = ret.Val
@@ -181,26 +250,43 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 			continue
 		}
 		if p.tok == token.CONST {
-			tv := rets[i]
-			if old := scope.Insert(types.NewConst(p.pos, pkg.Types, name, tv.Type, tv.CVal)); old != nil {
+			tv, ctyp := rets[i], typ
+			if ctyp == nil {
+				ctyp = tv.Type
+			}
+			obj := types.NewConst(p.pos, pkg.Types, name, ctyp, tv.CVal)
+			if old := scope.Insert(obj); old != nil {
 				oldpos := cb.position(old.Pos())
 				cb.panicCodePosErrorf(
 					p.pos, "%s redeclared in this block\n\tprevious declaration at %v", name, oldpos)
 			}
+			if p.decl != nil {
+				pkg.setDecl(obj, p.decl)
+			}
 		} else if typ == nil {
 			if values != nil {
 				expr = &values[i]
 			}
 			retType := DefaultConv(pkg, rets[i].Type, expr)
-			if old := scope.Insert(types.NewVar(p.pos, pkg.Types, name, retType)); old != nil {
+			if retType == types.Typ[types.UntypedNil] {
+				at := "variable declaration"
+				if p.tok == token.DEFINE {
+					at = "assignment"
+				}
+				cb.panicCodePosErrorf(p.pos, "use of untyped nil in %s", at)
+			}
+			obj := types.NewVar(p.pos, pkg.Types, name, retType)
+			if old := scope.Insert(obj); old != nil {
 				if p.tok != token.DEFINE {
 					oldpos := cb.position(old.Pos())
 					cb.panicCodePosErrorf(
 						p.pos, "%s redeclared in this block\n\tprevious declaration at %v", name, oldpos)
 				}
 				if err := matchType(pkg, rets[i], old.Type(), "assignment"); err != nil {
-					panic(err)
+					cb.handleTypeErr(err)
 				}
+			} else if p.decl != nil {
+				pkg.setDecl(obj, p.decl)
 			}
 		}
 	}
@@ -209,6 +295,9 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 	if p.at >= 0 {
 		cb.commitStmt(p.at) // to support inline call, we must emitStmt at EndInit stage
 	}
+	if p.decl != nil {
+		cb.pkg.curFile = p.oldFile
+	}
 	return p.oldv
 }
 
@@ -220,7 +309,7 @@ func (p *Package) newValueDecl(pos token.Pos, tok token.Token, typ types.Type, n
 		nameIdents := make([]ast.Expr, n)
 		for i, name := range names {
 			nameIdents[i] = ident(name)
-			if noNewVar && scope.Lookup(name) == nil {
+			if noNewVar && name != "_" && scope.Lookup(name) == nil {
 				noNewVar = false
 			}
 		}
@@ -230,18 +319,40 @@ func (p *Package) newValueDecl(pos token.Pos, tok token.Token, typ types.Type, n
 		}
 		stmt := &ast.AssignStmt{Tok: token.DEFINE, Lhs: nameIdents}
 		at := p.cb.startStmtAt(stmt)
-		return &ValueDecl{names: names, tok: tok, pos: pos, vals: &stmt.Rhs, at: at}
+		return &ValueDecl{names: names, tok: tok, pos: pos, vals: &stmt.Rhs, at: at, scope: scope}
 	}
 	// var a, b = expr
 	// const a, b = expr
-	nameIdents := make([]*ast.Ident, n)
+	at := -1
+	decl := &ast.GenDecl{Tok: tok}
+	if scope == p.Types.Scope() {
+		idx := p.curFile
+		p.files[idx].decls = append(p.files[idx].decls, decl)
+	} else {
+		at = p.cb.startStmtAt(&ast.DeclStmt{Decl: decl})
+	}
+	return p.newValueSpec(decl, at, pos, typ, names...)
+}
+
+func (p *Package) newValueSpec(
+	decl *ast.GenDecl, at int, pos token.Pos, typ types.Type, names ...string) *ValueDecl {
+	scope, tok := p.cb.current.scope, decl.Tok
+	var top *ast.GenDecl
+	if scope == p.Types.Scope() {
+		top = decl
+	}
+	nameIdents := make([]*ast.Ident, len(names))
 	for i, name := range names {
 		nameIdents[i] = ident(name)
 		if name == "_" { // skip underscore
 			continue
 		}
 		if typ != nil && tok == token.VAR {
-			scope.Insert(types.NewVar(pos, p.Types, name, typ))
+			obj := types.NewVar(pos, p.Types, name, typ)
+			scope.Insert(obj)
+			if top != nil {
+				p.setDecl(obj, top)
+			}
 		}
 	}
 	spec := &ast.ValueSpec{Names: nameIdents}
@@ -252,15 +363,10 @@ func (p *Package) newValueDecl(pos token.Pos, tok token.Token, typ types.Type, n
 			spec.Type = toType(p, typ)
 		}
 	}
-	at := -1
-	decl := &ast.GenDecl{Tok: tok, Specs: []ast.Spec{spec}}
-	if scope == p.Types.Scope() {
-		idx := p.testingFile
-		p.files[idx].decls = append(p.files[idx].decls, decl)
-	} else {
-		at = p.cb.startStmtAt(&ast.DeclStmt{Decl: decl})
-	}
-	return &ValueDecl{typ: typ, names: names, tok: tok, pos: pos, vals: &spec.Values, at: at}
+	decl.Specs = append(decl.Specs, spec)
+	return &ValueDecl{
+		typ: typ, names: names, tok: tok, pos: pos, vals: &spec.Values, at: at, scope: scope, decl: top,
+		file: p.curFile}
 }
 
 func (p *Package) NewConstStart(pos token.Pos, typ types.Type, names ...string) *CodeBuilder {
@@ -275,6 +381,53 @@ func (p *Package) NewVarStart(pos token.Pos, typ types.Type, names ...string) *C
 	return p.newValueDecl(pos, token.VAR, typ, names...).InitStart(p)
 }
 
+// VarDefs represents a parenthesized var declaration block:
+//
+//	var (
+//		a, b int
+//		c    = 1.2
+//	)
+type VarDefs struct {
+	decl *ast.GenDecl
+	pkg  *Package
+	file int // index of the file of a top-level block
+}
+
+// NewVarDefs starts a var declaration block in current scope. Specs of the
+// block are added by calling New or NewStart.
+func (p *Package) NewVarDefs() *VarDefs {
+	decl := &ast.GenDecl{Tok: token.VAR}
+	if p.cb.current.scope == p.Types.Scope() {
+		idx := p.curFile
+		p.files[idx].decls = append(p.files[idx].decls, decl)
+	} else {
+		p.cb.emitStmt(&ast.DeclStmt{Decl: decl})
+	}
+	return &VarDefs{decl: decl, pkg: p, file: p.curFile}
+}
+
+// New adds a var spec `names typ` to the block.
+func (p *VarDefs) New(pos token.Pos, typ types.Type, names ...string) *ValueDecl {
+	if typ == nil {
+		panic("VarDefs.New: typ is nil, use NewStart instead")
+	}
+	return p.newValueSpec(pos, typ, names...)
+}
+
+// NewStart adds a var spec `names [typ] = expr` to the block. typ can be nil.
+func (p *VarDefs) NewStart(pos token.Pos, typ types.Type, names ...string) *CodeBuilder {
+	return p.newValueSpec(pos, typ, names...).InitStart(p.pkg)
+}
+
+// newValueSpec adds a spec to the block in the file of the block.
+func (p *VarDefs) newValueSpec(pos token.Pos, typ types.Type, names ...string) *ValueDecl {
+	pkg := p.pkg
+	old := pkg.curFile
+	pkg.curFile = p.file
+	defer func() { pkg.curFile = old }()
+	return pkg.newValueSpec(p.decl, -1, pos, typ, names...)
+}
+
 // ----------------------------------------------------------------------------
 
 var (
@@ -289,7 +442,8 @@ var (
 
 // refType: &T
 type refType struct {
-	typ types.Type
+	typ      types.Type
+	setIndex []*internal.Elem // x and i of `x[i]` if x has a Gop_SetIndex method
 }
 
 func (p *refType) Underlying() types.Type {
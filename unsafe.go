@@ -0,0 +1,183 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log"
+)
+
+// ----------------------------------------------------------------------------
+
+// unsafeBuiltins are instructions of builtins of the unsafe package, whose
+// results are folded to constants by sizes of the target platform (see
+// Config.TargetPlatform) if sizes of their arguments are known. Otherwise,
+// they are called at runtime.
+var unsafeBuiltins = map[string]types.Object{
+	"Sizeof":   NewInstruction(token.NoPos, types.Unsafe, "Sizeof", unsafeSizeofInstr{}),
+	"Alignof":  NewInstruction(token.NoPos, types.Unsafe, "Alignof", unsafeAlignofInstr{}),
+	"Offsetof": NewInstruction(token.NoPos, types.Unsafe, "Offsetof", unsafeOffsetofInstr{}),
+}
+
+// unsafeBuiltin returns the instruction of the unsafe builtin named name, or
+// nil if it isn't supported.
+func unsafeBuiltin(pkg *Package, name string) types.Object {
+	o, ok := unsafeBuiltins[name]
+	if !ok {
+		return nil
+	}
+	if name == "Offsetof" { // offsets of fields selected by its arg are recorded
+		pkg.cb.offsetof++
+	}
+	return o
+}
+
+type unsafeSizeofInstr struct {
+}
+
+type unsafeAlignofInstr struct {
+}
+
+type unsafeOffsetofInstr struct {
+}
+
+// func unsafe.Sizeof(x ArbitraryType) uintptr
+func (p unsafeSizeofInstr) Call(pkg *Package, args []*Element, flags InstrFlags) (ret *Element, err error) {
+	if len(args) != 1 {
+		panic("TODO: unsafe.Sizeof() should have one parameter")
+	}
+	return unsafeCall(pkg, "Sizeof", args[0], pkg.sizes.Sizeof), nil
+}
+
+// func unsafe.Alignof(x ArbitraryType) uintptr
+func (p unsafeAlignofInstr) Call(pkg *Package, args []*Element, flags InstrFlags) (ret *Element, err error) {
+	if len(args) != 1 {
+		panic("TODO: unsafe.Alignof() should have one parameter")
+	}
+	return unsafeCall(pkg, "Alignof", args[0], pkg.sizes.Alignof), nil
+}
+
+// func unsafe.Offsetof(x.f ArbitraryType) uintptr
+func (p unsafeOffsetofInstr) Call(pkg *Package, args []*Element, flags InstrFlags) (ret *Element, err error) {
+	if len(args) != 1 {
+		panic("TODO: unsafe.Offsetof() should have one parameter")
+	}
+	cb := &pkg.cb
+	arg := args[0]
+	var off fieldOffset
+	sel, ok := arg.Val.(*ast.SelectorExpr)
+	if ok {
+		off, ok = cb.offsets[sel]
+		delete(cb.offsets, sel)
+	}
+	if cb.offsetof--; cb.offsetof <= 0 {
+		cb.offsetof, cb.offsets = 0, nil
+	}
+	if !ok || off.viaPtr {
+		src, pos := cb.loadExpr(arg.Src)
+		if !ok {
+			return nil, cb.newCodeError(&pos, fmt.Sprintf("invalid argument: %s is not a selector expression", src))
+		}
+		return nil, cb.newCodeError(&pos, fmt.Sprintf("invalid argument: field %s is embedded via a pointer", src))
+	}
+	ret = unsafeCall(pkg, "Offsetof", arg, nil)
+	if !off.unknown {
+		ret.CVal = constant.MakeInt64(off.offset)
+	}
+	return
+}
+
+// unsafeCall returns the call to the unsafe builtin named name, whose result is
+// folded by size (of the type of arg) if it isn't nil and the size is known.
+func unsafeCall(pkg *Package, name string, arg *Element, size func(T types.Type) int64) *Element {
+	if _, ok := arg.Type.(*TypeType); ok {
+		log.Panicf("TODO: unsafe.%s arg isn't an expression", name)
+	}
+	ret := &Element{
+		Val: &ast.CallExpr{
+			Fun:  toObjectExpr(pkg, types.Unsafe.Scope().Lookup(name)),
+			Args: []ast.Expr{arg.Val},
+		},
+		Type: types.Typ[types.Uintptr],
+	}
+	if typ := types.Default(arg.Type); size != nil && hasConstSize(typ) {
+		ret.CVal = constant.MakeInt64(size(typ))
+	}
+	return ret
+}
+
+// hasConstSize reports whether the size of typ is known at compile time, ie.
+// typ is a Go type which doesn't contain template params or types being built.
+func hasConstSize(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.Basic:
+		return t.Kind() != types.Invalid && t.Info()&types.IsUntyped == 0
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		return true
+	case *types.Array:
+		return hasConstSize(t.Elem())
+	case *types.Struct:
+		for i, n := 0, t.NumFields(); i < n; i++ {
+			if !hasConstSize(t.Field(i).Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Named:
+		return hasConstSize(t.Underlying())
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+
+// fieldOffset is the offset of a field selected by x.f relative to x, which is
+// recorded for unsafe.Offsetof (see CodeBuilder.recordOffset).
+type fieldOffset struct {
+	offset  int64
+	unknown bool // the offset isn't known at compile time (see hasConstSize)
+	viaPtr  bool // the field is promoted from a field embedded via a pointer
+}
+
+// recordOffset records the offset of the field i of struct o, which is selected
+// by sel. If promoted is true, sel selects a field promoted from the embedded
+// field i, and its offset in the embedded field is recorded already.
+func (p *CodeBuilder) recordOffset(sel *ast.SelectorExpr, o *types.Struct, i int, promoted bool) {
+	if p.offsets == nil {
+		p.offsets = make(map[*ast.SelectorExpr]fieldOffset)
+	}
+	var off fieldOffset
+	if promoted {
+		off = p.offsets[sel]
+		if _, ok := o.Field(i).Type().(*types.Pointer); ok {
+			off.viaPtr = true
+		}
+	}
+	fields := make([]*types.Var, i+1) // the offset depends on fields before it
+	for j := range fields {
+		if fields[j] = o.Field(j); !hasConstSize(fields[j].Type()) {
+			off.unknown = true
+		}
+	}
+	if !off.unknown {
+		off.offset += p.pkg.sizes.Offsetsof(fields)[i]
+	}
+	p.offsets[sel] = off
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,110 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gox
+
+import (
+	"go/token"
+	"log"
+)
+
+// ----------------------------------------------------------------------------
+
+// A Workspace is a set of packages which are generated together (eg. packages
+// of a multi-package Go+ project). Packages of a workspace share a FileSet and
+// a package loader, and import each other before they are written: importing
+// a package of the workspace refers to the package being generated, instead of
+// loading it by its path.
+type Workspace struct {
+	// Fset is the FileSet shared by packages of the workspace.
+	Fset *token.FileSet
+
+	conf     Config
+	loadPkgs LoadPkgsFunc
+	pkgs     map[string]*Package
+	paths    []string // paths of packages in the order they are created
+}
+
+// NewWorkspace creates a new workspace. Packages of the workspace are created
+// by conf (see Workspace.NewPackage). If conf.Fset is nil, a new FileSet is
+// created.
+func NewWorkspace(conf *Config) *Workspace {
+	if conf == nil {
+		conf = &Config{}
+	}
+	w := &Workspace{conf: *conf, pkgs: make(map[string]*Package)}
+	if w.conf.Fset == nil {
+		w.conf.Fset = token.NewFileSet()
+	}
+	w.Fset = w.conf.Fset
+	w.loadPkgs = loadPkgsOf(&w.conf)
+	w.conf.LoadPkgs = w.load
+	return w
+}
+
+// NewPackage creates a new package of the workspace. Packages should be
+// created before they are imported by other packages of the workspace, but
+// they can be declared in any order: objects of a package are resolved when
+// they are referenced (eg. by PkgRef.Ref).
+func (p *Workspace) NewPackage(pkgPath, name string) *Package {
+	if _, ok := p.pkgs[pkgPath]; ok {
+		log.Panicln("NewPackage: package exists -", pkgPath)
+	}
+	conf := p.conf
+	pkg := NewPackage(pkgPath, name, &conf)
+	p.pkgs[pkgPath] = pkg
+	p.paths = append(p.paths, pkgPath)
+	return pkg
+}
+
+// Package returns the package of the workspace whose path is pkgPath, or nil
+// if there is no such package.
+func (p *Workspace) Package(pkgPath string) *Package {
+	return p.pkgs[pkgPath]
+}
+
+// Packages returns packages of the workspace in the order they are created.
+func (p *Workspace) Packages() []*Package {
+	pkgs := make([]*Package, len(p.paths))
+	for i, path := range p.paths {
+		pkgs[i] = p.pkgs[path]
+	}
+	return pkgs
+}
+
+// load is the LoadPkgsFunc of packages of the workspace. Packages of the
+// workspace are imported directly, and others are loaded by the loader of the
+// workspace config.
+func (p *Workspace) load(at *Package, importPkgs map[string]*PkgRef, pkgPaths ...string) int {
+	others := make([]string, 0, len(pkgPaths))
+	for _, pkgPath := range pkgPaths {
+		pkg, ok := p.pkgs[pkgPath]
+		if !ok {
+			others = append(others, pkgPath)
+			continue
+		}
+		if ref, ok := importPkgs[pkgPath]; ok {
+			if pkg == at {
+				log.Panicln("import cycle not allowed:", pkgPath)
+			}
+			typs := *pkg.Types
+			ref.ID, ref.Types = pkgPath, &typs // clone *types.Package instance
+		}
+	}
+	if len(others) == 0 {
+		return 0
+	}
+	return p.loadPkgs(at, importPkgs, others...)
+}
+
+// ----------------------------------------------------------------------------